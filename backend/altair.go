@@ -0,0 +1,404 @@
+// Package altair ties the toolkit together: it discovers our public
+// endpoint with STUN, finds a peer through the signaling server and punches
+// a direct UDP connection to it.
+package altair
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/nat"
+	"github.com/saintparish4/altair/pkg/netutil"
+	"github.com/saintparish4/altair/pkg/punch"
+	"github.com/saintparish4/altair/pkg/relay"
+	"github.com/saintparish4/altair/pkg/signalclient"
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// DefaultSTUNServer is used when Config.STUNServer is empty
+const DefaultSTUNServer = "stun.l.google.com:19302"
+
+// DefaultSecondarySTUNServer is used for NAT detection when both STUN
+// servers are left empty
+const DefaultSecondarySTUNServer = "stun1.l.google.com:19302"
+
+// Config holds configuration for the client
+type Config struct {
+	// STUN server used to discover our public endpoint
+	STUNServer string
+
+	// Second STUN server, on a different IP, for NAT type detection in
+	// Session (optional; defaults to STUNServer, which can't tell
+	// symmetric NATs apart)
+	SecondarySTUNServer string
+
+	// Name shown to other peers in the room (optional)
+	DisplayName string
+
+	// Signaling client configuration (optional). OnOffer, OnAnswer and
+	// OnCandidate must be nil: connecting reads those from Messages.
+	Signaling *signalclient.Config
+
+	// Hole punching configuration (optional). LocalAddr, Conn and Mapping
+	// are set by the client.
+	Punch *punch.PuncherConfig
+
+	// TURN server used by Session when punching fails (optional; nil
+	// disables the fallback). Conn is set by the session.
+	Relay *relay.ClientConfig
+
+	// Ports to bind sockets from (optional), for firewalls that only allow
+	// a range. It also applies to the relay unless Relay sets its own.
+	PortRange *netutil.PortScanner
+
+	// Keepalive interval for a Session's punched connection. Zero uses
+	// punch.DefaultKeepAliveInterval; negative disables keepalives.
+	KeepAlive time.Duration
+
+	// Timeout for a whole ConnectViaRoom call, including waiting for a
+	// peer to join
+	Timeout time.Duration
+
+	// Called with a Status as ConnectViaRoom or a Session's Connect enters
+	// each phase (optional), so embedders can show progress and log it
+	// their own way. It runs on the connecting goroutine and must not block.
+	OnStatus func(Status)
+}
+
+// DefaultConfig returns a configuration with sensible defaults
+func DefaultConfig() *Config {
+	return &Config{
+		STUNServer:          DefaultSTUNServer,
+		SecondarySTUNServer: DefaultSecondarySTUNServer,
+		Timeout:             2 * time.Minute,
+	}
+}
+
+// Client connects to peers
+type Client struct {
+	config Config
+}
+
+// NewClient creates a client. A nil config uses DefaultConfig.
+func NewClient(config *Config) *Client {
+	defaults := DefaultConfig()
+	if config == nil {
+		config = defaults
+	}
+
+	c := &Client{config: *config}
+	if c.config.SecondarySTUNServer == "" {
+		c.config.SecondarySTUNServer = c.config.STUNServer
+	}
+	if c.config.STUNServer == "" {
+		c.config.STUNServer = defaults.STUNServer
+		if c.config.SecondarySTUNServer == "" {
+			c.config.SecondarySTUNServer = defaults.SecondarySTUNServer
+		}
+	}
+	if c.config.Timeout <= 0 {
+		c.config.Timeout = defaults.Timeout
+	}
+	return c
+}
+
+// ConnectViaRoom joins roomID on the signaling server at signalingURL and
+// connects to the first other peer in it. If the room is empty we wait for
+// a peer to join and offer to us; otherwise we offer to a peer already
+// there. Endpoints are exchanged as OFFER/ANSWER and then both sides punch.
+//
+// Start reading from the connection's NetConn promptly: the peer may finish
+// punching after us, and its PINGs are answered from there.
+func (c *Client) ConnectViaRoom(signalingURL, roomID string) (*punch.Connection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	defer cancel()
+	return c.ConnectViaRoomContext(ctx, signalingURL, roomID)
+}
+
+// ConnectViaRoomContext is like ConnectViaRoom but gives up when ctx is done
+func (c *Client) ConnectViaRoomContext(ctx context.Context, signalingURL, roomID string) (*punch.Connection, error) {
+	connection, err := c.connectViaRoom(ctx, signalingURL, roomID)
+	if err != nil {
+		c.notify(PhaseFailed, "", err)
+		return nil, err
+	}
+
+	c.notify(PhaseConnected, fmt.Sprintf("direct to %s", connection.RemoteAddr), nil)
+	return connection, nil
+}
+
+func (c *Client) connectViaRoom(ctx context.Context, signalingURL, roomID string) (*punch.Connection, error) {
+	c.notify(PhaseDiscovering, "", nil)
+
+	// Discover the public mapping of the socket we will punch from
+	conn, err := c.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	mapping, err := c.discover(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c.notify(PhaseSignaling, fmt.Sprintf("public endpoint %s", mapping.PublicAddr), nil)
+
+	peer, err := c.negotiate(ctx, signalingURL, roomID, mapping.PublicAddr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c.notify(PhasePunching, fmt.Sprintf("peer %s at %s", peer.peerID, peer.addr), nil)
+
+	return c.punch(ctx, conn, mapping, peer.addr)
+}
+
+// notify passes a status to the OnStatus hook, if set
+func (c *Client) notify(phase Phase, message string, err error) Status {
+	status := Status{Phase: phase, Message: message, Err: err, Time: time.Now()}
+	if c.config.OnStatus != nil {
+		c.config.OnStatus(status)
+	}
+	return status
+}
+
+// listen opens the IPv4 socket to discover and punch from
+func (c *Client) listen() (*net.UDPConn, error) {
+	var conn *net.UDPConn
+	var err error
+	if c.config.PortRange != nil {
+		conn, err = c.config.PortRange.ListenUDP("udp4", net.IPv4zero)
+	} else {
+		conn, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UDP socket: %w", err)
+	}
+	return conn, nil
+}
+
+// punch punches from conn to peerAddr, closing conn on failure
+func (c *Client) punch(ctx context.Context, conn *net.UDPConn, mapping *nat.Mapping, peerAddr *net.UDPAddr) (*punch.Connection, error) {
+	config := punch.DefaultPuncherConfig()
+	if c.config.Punch != nil {
+		copied := *c.config.Punch
+		config = &copied
+	}
+	config.LocalAddr = nil
+	config.Conn = conn
+	config.Mapping = mapping
+
+	puncher, err := punch.NewPuncher(config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The connection keeps using the puncher's socket, so the puncher is
+	// only closed on failure
+	connection, err := puncher.PunchHoleContext(ctx, &punch.PeerInfo{PublicAddr: peerAddr})
+	if err != nil {
+		puncher.Close()
+		return nil, fmt.Errorf("failed to punch to %s: %w", peerAddr, err)
+	}
+
+	return connection, nil
+}
+
+// discover finds conn's public address
+func (c *Client) discover(conn *net.UDPConn) (*nat.Mapping, error) {
+	client, err := stun.NewClient(&stun.ClientConfig{
+		ServerAddr: c.config.STUNServer,
+		Network:    "udp4",
+		Conn:       conn,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	endpoint, err := client.Discover()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover public endpoint: %w", err)
+	}
+
+	return &nat.Mapping{
+		LocalAddr:  conn.LocalAddr().(*net.UDPAddr),
+		PublicAddr: endpoint.PublicAddr,
+		Type:       nat.TypeUnknown,
+		DetectedAt: time.Now(),
+	}, nil
+}
+
+// connectSignaling connects to the signaling server. Handlers that divert
+// OFFER, ANSWER or CANDIDATE from Messages would leave negotiation waiting
+// forever, so they are rejected.
+func (c *Client) connectSignaling(signalingURL string) (*signalclient.Client, error) {
+	if config := c.config.Signaling; config != nil &&
+		(config.OnOffer != nil || config.OnAnswer != nil || config.OnCandidate != nil) {
+		return nil, fmt.Errorf("signaling OnOffer, OnAnswer and OnCandidate handlers are not supported: connecting reads those messages itself")
+	}
+	return signalclient.Connect(signalingURL, c.config.Signaling)
+}
+
+// negotiation is the outcome of exchanging endpoints with a peer
+type negotiation struct {
+	peerID    string
+	sessionID string
+	addr      *net.UDPAddr // The peer's public endpoint
+}
+
+// negotiate joins the room and exchanges public endpoints with one peer
+func (c *Client) negotiate(ctx context.Context, signalingURL, roomID string, public *net.UDPAddr) (*negotiation, error) {
+	client, err := c.connectSignaling(signalingURL)
+	if err != nil {
+		return nil, err
+	}
+	// The room is only needed until endpoints are exchanged
+	defer client.Close()
+
+	endpoint := protocol.Endpoint{IP: public.IP.String(), Port: public.Port}
+	peers, err := client.Join(roomID, c.config.DisplayName, &endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	c.notify(PhaseNegotiating, fmt.Sprintf("joined %s with %d other peers", roomID, len(peers)), nil)
+
+	return exchange(ctx, client, peers, endpoint)
+}
+
+// exchange swaps endpoints with a peer from the room we just joined. If
+// peers were already there we offer to the earliest; otherwise we wait for
+// a newcomer's offer.
+func exchange(ctx context.Context, client *signalclient.Client, peers []protocol.PeerInfo, endpoint protocol.Endpoint) (*negotiation, error) {
+	if len(peers) > 0 {
+		return offer(ctx, client, earliestPeer(peers), endpoint)
+	}
+	return awaitOffer(ctx, client, endpoint)
+}
+
+// offer sends our endpoint to peerID and waits for an accepting answer
+func offer(ctx context.Context, client *signalclient.Client, peerID string, endpoint protocol.Endpoint) (*negotiation, error) {
+	sessionID, err := signalclient.NewSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.SendOffer(peerID, protocol.OfferPayload{
+		Endpoint:    endpoint,
+		SessionID:   sessionID,
+		InitiatorID: client.PeerID(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		msg, err := nextMessage(ctx, client)
+		if err != nil {
+			return nil, fmt.Errorf("waiting for answer from %s: %w", peerID, err)
+		}
+
+		switch msg.Type {
+		case protocol.MessageTypeAnswer:
+			var answer protocol.AnswerPayload
+			if msg.PeerID != peerID || msg.ParsePayload(&answer) != nil || answer.SessionID != sessionID {
+				continue
+			}
+			if !answer.Accepted {
+				return nil, fmt.Errorf("peer %s rejected the offer", peerID)
+			}
+			addr, err := resolveEndpoint(answer.Endpoint)
+			if err != nil {
+				return nil, err
+			}
+			return &negotiation{peerID: peerID, sessionID: sessionID, addr: addr}, nil
+
+		case protocol.MessageTypePeerLeft:
+			if msg.PeerID == peerID {
+				return nil, fmt.Errorf("peer %s left before answering", peerID)
+			}
+
+		case protocol.MessageTypeError:
+			var payload protocol.ErrorPayload
+			msg.ParsePayload(&payload)
+			return nil, &signalclient.Error{Code: payload.Code, Message: payload.Message}
+		}
+	}
+}
+
+// awaitOffer waits for the first offer and accepts it with our endpoint
+func awaitOffer(ctx context.Context, client *signalclient.Client, endpoint protocol.Endpoint) (*negotiation, error) {
+	for {
+		msg, err := nextMessage(ctx, client)
+		if err != nil {
+			return nil, fmt.Errorf("waiting for a peer: %w", err)
+		}
+		if msg.Type != protocol.MessageTypeOffer {
+			continue
+		}
+
+		var offer protocol.OfferPayload
+		if err := msg.ParsePayload(&offer); err != nil {
+			continue
+		}
+
+		peerAddr, err := resolveEndpoint(offer.Endpoint)
+		if err != nil {
+			continue
+		}
+
+		err = client.SendAnswer(msg.PeerID, protocol.AnswerPayload{
+			Endpoint:  endpoint,
+			SessionID: offer.SessionID,
+			Accepted:  true,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &negotiation{peerID: msg.PeerID, sessionID: offer.SessionID, addr: peerAddr}, nil
+	}
+}
+
+// nextMessage returns the next unsolicited signaling message
+func nextMessage(ctx context.Context, client *signalclient.Client) (*protocol.Message, error) {
+	select {
+	case msg, ok := <-client.Messages():
+		if !ok {
+			if err := client.Err(); err != nil {
+				return nil, err
+			}
+			return nil, signalclient.ErrClosed
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// earliestPeer returns the ID of the peer that joined first
+func earliestPeer(peers []protocol.PeerInfo) string {
+	earliest := peers[0]
+	for _, peer := range peers[1:] {
+		if peer.JoinedAt < earliest.JoinedAt {
+			earliest = peer
+		}
+	}
+	return earliest.PeerID
+}
+
+// resolveEndpoint converts a signaling endpoint to a UDP address
+func resolveEndpoint(endpoint protocol.Endpoint) (*net.UDPAddr, error) {
+	ip := net.ParseIP(endpoint.IP)
+	if ip == nil || endpoint.Port <= 0 || endpoint.Port > 65535 {
+		return nil, fmt.Errorf("invalid peer endpoint %s", endpoint)
+	}
+	return &net.UDPAddr{IP: ip, Port: endpoint.Port}, nil
+}
@@ -0,0 +1,203 @@
+package altair
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saintparish4/altair/internal/signaling"
+	"github.com/saintparish4/altair/internal/signaling/signalingtest"
+	"github.com/saintparish4/altair/pkg/punch"
+	"github.com/saintparish4/altair/pkg/signalclient"
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// startSTUNServer answers binding requests with mapped as the public
+// address, or the sender's address if mapped is nil
+func startSTUNServer(t *testing.T, mapped *net.UDPAddr) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to start STUN server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			request, err := stun.Decode(buf[:n])
+			if err != nil || request.Type != stun.TypeBindingRequest {
+				continue
+			}
+
+			response := &stun.Message{
+				Type:          stun.TypeBindingSuccess,
+				TransactionID: request.TransactionID,
+			}
+			publicAddr := mapped
+			if publicAddr == nil {
+				publicAddr = addr
+			}
+			response.AddAttribute(stun.EncodeXORMappedAddress(publicAddr, request.TransactionID))
+
+			data, _ := response.Encode()
+			conn.WriteToUDP(data, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestNewClientDefaults(t *testing.T) {
+	c := NewClient(&Config{DisplayName: "alice"})
+
+	if c.config.STUNServer != DefaultSTUNServer {
+		t.Errorf("STUNServer = %q, want %q", c.config.STUNServer, DefaultSTUNServer)
+	}
+	if c.config.Timeout != DefaultConfig().Timeout {
+		t.Errorf("Timeout = %v, want %v", c.config.Timeout, DefaultConfig().Timeout)
+	}
+	if c.config.DisplayName != "alice" {
+		t.Errorf("DisplayName = %q, want alice", c.config.DisplayName)
+	}
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	addr, err := resolveEndpoint(protocol.Endpoint{IP: "203.0.113.1", Port: 4000})
+	if err != nil {
+		t.Fatalf("resolveEndpoint failed: %v", err)
+	}
+	if addr.String() != "203.0.113.1:4000" {
+		t.Errorf("got %s", addr)
+	}
+
+	for _, bad := range []protocol.Endpoint{
+		{IP: "not-an-ip", Port: 4000},
+		{IP: "203.0.113.1", Port: 0},
+		{IP: "203.0.113.1", Port: 70000},
+	} {
+		if _, err := resolveEndpoint(bad); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestConnectViaRoom(t *testing.T) {
+	stunServer := startSTUNServer(t, nil)
+
+	rooms := signaling.NewRoomManager()
+	handler := signaling.NewHandler(signaling.NewRegistry(), rooms)
+	handler.Logger = nil
+	dialer := signalingtest.NewDialer(handler)
+
+	var mu sync.Mutex
+	statuses := make(map[string][]Phase)
+
+	newClient := func(name string) *Client {
+		return NewClient(&Config{
+			STUNServer:  stunServer,
+			DisplayName: name,
+			Signaling:   &signalclient.Config{Dialer: dialer},
+			Punch: &punch.PuncherConfig{
+				Timeout:      5 * time.Second,
+				PingInterval: 50 * time.Millisecond,
+				MaxAttempts:  50,
+			},
+			Timeout: 10 * time.Second,
+			OnStatus: func(status Status) {
+				mu.Lock()
+				defer mu.Unlock()
+				statuses[name] = append(statuses[name], status.Phase)
+			},
+		})
+	}
+
+	type result struct {
+		conn *punch.Connection
+		err  error
+	}
+	results := make(chan result, 2)
+	connect := func(c *Client) {
+		conn, err := c.ConnectViaRoom("ws://signaling.test/ws", "room")
+		results <- result{conn, err}
+	}
+
+	// The first peer waits in the empty room for the second to offer
+	go connect(newClient("alice"))
+	awaitRoomSize(t, rooms, "room", 1)
+	go connect(newClient("bob"))
+
+	var conns []*punch.Connection
+	for range 2 {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("ConnectViaRoom failed: %v", r.err)
+		}
+		conns = append(conns, r.conn)
+		t.Cleanup(func() { r.conn.Close() })
+
+		// Reading answers the other side if it is still punching
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, err := r.conn.NetConn().Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	if conns[0].RemoteAddr.Port != conns[1].LocalAddr.Port || conns[1].RemoteAddr.Port != conns[0].LocalAddr.Port {
+		t.Errorf("peers connected to the wrong endpoints: %s and %s", conns[0], conns[1])
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []Phase{PhaseDiscovering, PhaseSignaling, PhaseNegotiating, PhasePunching, PhaseConnected}
+	for _, name := range []string{"alice", "bob"} {
+		if got := statuses[name]; !slices.Equal(got, want) {
+			t.Errorf("%s reported %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestConnectViaRoomReportsFailure(t *testing.T) {
+	handler := signaling.NewHandler(signaling.NewRegistry(), signaling.NewRoomManager())
+	handler.Logger = nil
+
+	var last Status
+	c := NewClient(&Config{
+		STUNServer: startSTUNServer(t, nil),
+		Signaling:  &signalclient.Config{Dialer: signalingtest.NewDialer(handler)},
+		Timeout:    200 * time.Millisecond,
+		OnStatus:   func(status Status) { last = status },
+	})
+
+	if _, err := c.ConnectViaRoom("ws://signaling.test/ws", "empty"); err == nil {
+		t.Fatal("expected ConnectViaRoom to fail in an empty room")
+	}
+	if last.Phase != PhaseFailed || last.Err == nil {
+		t.Errorf("last status = %v, want a failure", last)
+	}
+}
+
+func TestConnectSignalingRejectsNegotiationHandlers(t *testing.T) {
+	client := NewClient(&Config{
+		Signaling: &signalclient.Config{
+			OnOffer: func(string, protocol.OfferPayload) {},
+		},
+	})
+
+	if _, err := client.connectSignaling("ws://signaling.test/ws"); err == nil {
+		t.Error("expected an error for an OnOffer handler that would starve negotiation")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "os"
+
+// ANSI colors for human-readable output
+const (
+	colorReset  = "\033[0m"
+	colorBold   = "\033[1m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+)
+
+// colorEnabled reports whether f is a terminal and the user hasn't opted
+// out with NO_COLOR, so piped and redirected output stays plain
+func colorEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps s in color when color output is enabled
+func (o *output) paint(color, s string) string {
+	if !o.color {
+		return s
+	}
+	return color + s + colorReset
+}
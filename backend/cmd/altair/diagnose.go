@@ -0,0 +1,255 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/nat"
+	"github.com/saintparish4/altair/pkg/netutil"
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// Report is everything diagnose finds out. Checks that fail leave their
+// fields empty and add an entry to Errors, so one unreachable server
+// doesn't hide the rest of the report.
+type Report struct {
+	LocalAddresses []string `json:"local_addresses"`
+
+	// STUN discovery against the primary server
+	STUNServer     string `json:"stun_server"`
+	LocalEndpoint  string `json:"local_endpoint,omitempty"`
+	PublicEndpoint string `json:"public_endpoint,omitempty"`
+	STUNRTT        string `json:"stun_rtt,omitempty"`
+
+	// NAT detection; the behaviors need RFC 5780 servers and are
+	// "Unknown" otherwise
+	NATType           string `json:"nat_type"`
+	MappingBehavior   string `json:"mapping_behavior"`
+	FilteringBehavior string `json:"filtering_behavior"`
+	Hairpinning       bool   `json:"hairpinning"`
+	SupportsP2P       bool   `json:"supports_p2p"`
+
+	Relay *RelayCheck `json:"relay,omitempty"`
+
+	Errors   []string `json:"errors,omitempty"`
+	Duration string   `json:"duration"`
+}
+
+// RelayCheck reports whether a TURN server answered a STUN Binding request
+type RelayCheck struct {
+	Server    string `json:"server"`
+	Reachable bool   `json:"reachable"`
+	RTT       string `json:"rtt,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// diagnoseOptions are the diagnose flags
+type diagnoseOptions struct {
+	primary   string
+	secondary string
+	relay     string
+	timeout   time.Duration
+}
+
+func runDiagnose(args []string, out *output) error {
+	primary := os.Getenv("STUN_SERVER")
+	if primary == "" {
+		primary = nat.DefaultConfig().PrimaryServer
+	}
+
+	fs := flag.NewFlagSet("diagnose", flag.ContinueOnError)
+	opts := diagnoseOptions{}
+	fs.StringVar(&opts.primary, "stun", primary, "Primary STUN server")
+	fs.StringVar(&opts.secondary, "stun2", nat.DefaultConfig().SecondaryServer, "Secondary STUN server (different IP than the primary)")
+	fs.StringVar(&opts.relay, "relay", "", "TURN server to check for reachability (optional)")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Second, "Timeout for each STUN request")
+	fs.BoolVar(&out.json, "json", out.json, "Print the report as JSON (same as the global -json)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !out.json {
+		fmt.Fprintln(os.Stderr, "Running diagnostics...")
+	}
+	report := diagnose(opts)
+
+	return out.result(report, func() { printReport(os.Stdout, out, report) })
+}
+
+// diagnose runs every check and collects the results
+func diagnose(opts diagnoseOptions) *Report {
+	start := time.Now()
+	report := &Report{
+		STUNServer:     opts.primary,
+		LocalAddresses: []string{},
+	}
+	fail := func(format string, args ...interface{}) {
+		report.Errors = append(report.Errors, fmt.Sprintf(format, args...))
+	}
+
+	// Local addresses
+	ips, err := netutil.GetLocalAddresses()
+	if err != nil {
+		fail("local addresses: %v", err)
+	}
+	for _, ip := range ips {
+		kind := "public"
+		if netutil.IsPrivateIP(ip) {
+			kind = "private"
+		}
+		report.LocalAddresses = append(report.LocalAddresses, fmt.Sprintf("%s (%s)", ip, kind))
+	}
+
+	// Public endpoint
+	client, err := stun.NewClient(&stun.ClientConfig{ServerAddr: opts.primary, Timeout: opts.timeout})
+	if err != nil {
+		fail("STUN client: %v", err)
+	} else {
+		endpoint, err := client.Discover()
+		client.Close()
+		if err != nil {
+			fail("STUN discovery: %v", err)
+		} else {
+			report.LocalEndpoint = endpoint.LocalAddr.String()
+			report.PublicEndpoint = endpoint.PublicAddr.String()
+			report.STUNRTT = endpoint.RTT.Round(time.Microsecond).String()
+		}
+	}
+
+	// NAT type and behavior
+	mapping, err := detectNAT(opts)
+	if err != nil {
+		fail("NAT detection: %v", err)
+		mapping = &nat.Mapping{}
+	}
+	report.NATType = mapping.Type.String()
+	report.MappingBehavior = mapping.MappingBehavior.String()
+	report.FilteringBehavior = mapping.FilteringBehavior.String()
+	report.Hairpinning = mapping.Hairpinning
+	report.SupportsP2P = mapping.Type.SupportsP2P()
+
+	// Relay reachability
+	if opts.relay != "" {
+		report.Relay = checkRelay(opts.relay, opts.timeout)
+	}
+
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+	return report
+}
+
+// detectNAT runs the RFC 5780 behavior tests, falling back to classic
+// RFC 3489 detection against servers without OTHER-ADDRESS. Hairpinning
+// is tested either way.
+func detectNAT(opts diagnoseOptions) (*nat.Mapping, error) {
+	detector, err := nat.NewDetector(&nat.DetectorConfig{
+		PrimaryServer:   opts.primary,
+		SecondaryServer: opts.secondary,
+		Timeout:         opts.timeout,
+		RetryCount:      1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer detector.Close()
+
+	if mapping, err := detector.DetectBehavior(); err == nil {
+		return mapping, nil
+	}
+
+	mapping, err := detector.Detect()
+	if err != nil {
+		return nil, err
+	}
+	mapping.Hairpinning, _ = detector.DetectHairpinning()
+	return mapping, nil
+}
+
+// checkRelay sends a STUN Binding request to a TURN server, which every
+// TURN server answers without credentials
+func checkRelay(server string, timeout time.Duration) *RelayCheck {
+	check := &RelayCheck{Server: server}
+
+	client, err := stun.NewClient(&stun.ClientConfig{ServerAddr: server, Timeout: timeout})
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer client.Close()
+
+	endpoint, err := client.Discover()
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Reachable = true
+	check.RTT = endpoint.RTT.Round(time.Microsecond).String()
+	return check
+}
+
+// printReport writes the report for people
+func printReport(w io.Writer, out *output, r *Report) {
+	line := func(label, value string) {
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(w, "  %-20s %s\n", label+":", value)
+	}
+	yesNo := func(b bool) string {
+		if b {
+			return out.paint(colorGreen, "yes")
+		}
+		return out.paint(colorYellow, "no")
+	}
+	section := func(title string) {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, out.paint(colorBold+colorCyan, title))
+	}
+
+	section("Local")
+	if len(r.LocalAddresses) == 0 {
+		line("Addresses", "")
+	}
+	for i, addr := range r.LocalAddresses {
+		if i == 0 {
+			line("Addresses", addr)
+		} else {
+			fmt.Fprintf(w, "  %-20s %s\n", "", addr)
+		}
+	}
+
+	section("STUN")
+	line("Server", r.STUNServer)
+	line("Local endpoint", r.LocalEndpoint)
+	line("Public endpoint", r.PublicEndpoint)
+	line("RTT", r.STUNRTT)
+
+	section("NAT")
+	line("Type", r.NATType)
+	line("Mapping", r.MappingBehavior)
+	line("Filtering", r.FilteringBehavior)
+	line("Hairpinning", yesNo(r.Hairpinning))
+	line("Hole punching", yesNo(r.SupportsP2P))
+
+	if r.Relay != nil {
+		section("Relay")
+		line("Server", r.Relay.Server)
+		line("Reachable", yesNo(r.Relay.Reachable))
+		line("RTT", r.Relay.RTT)
+		if r.Relay.Error != "" {
+			line("Error", out.paint(colorRed, r.Relay.Error))
+		}
+	}
+
+	if len(r.Errors) > 0 {
+		section("Problems")
+		for _, e := range r.Errors {
+			fmt.Fprintf(w, "  - %s\n", out.paint(colorRed, e))
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Finished in %s\n", r.Duration)
+}
@@ -0,0 +1,126 @@
+// Command altair is the Altair command-line tool.
+//
+// Usage:
+//
+//	altair [-json] <command> [flags]
+//
+// Commands:
+//
+//	diagnose   Report public endpoint, NAT behavior, local addresses and relay reachability
+//	help       Show this help
+//
+// With -json, results and errors are printed to stdout as JSON for scripts
+// and CI. Otherwise output is formatted for people, in color when stdout is
+// a terminal and NO_COLOR is unset.
+//
+// The STUN_SERVER environment variable overrides the default primary STUN
+// server.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var (
+	version = "dev" // Set via ldflags
+)
+
+// command is a subcommand; run gets the arguments after its name
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, out *output) error
+}
+
+// output holds the global output options
+type output struct {
+	json  bool
+	color bool
+}
+
+// result prints v as JSON, or calls pretty to format it for people
+func (o *output) result(v interface{}, pretty func()) error {
+	if !o.json {
+		pretty()
+		return nil
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// fail reports err and exits
+func (o *output) fail(err error) {
+	if o.json {
+		json.NewEncoder(os.Stdout).Encode(map[string]string{"error": err.Error()})
+	} else {
+		fmt.Fprintln(os.Stderr, o.paint(colorRed, "Error: "+err.Error()))
+	}
+	os.Exit(1)
+}
+
+var commands = []command{
+	{"diagnose", "Report public endpoint, NAT behavior, local addresses and relay reachability", runDiagnose},
+}
+
+func main() {
+	global := flag.NewFlagSet("altair", flag.ContinueOnError)
+	global.Usage = usage
+	jsonOut := global.Bool("json", false, "Print results and errors as JSON")
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+
+	out := &output{json: *jsonOut, color: colorEnabled(os.Stdout)}
+
+	args := global.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := args[0]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	case "version", "-version", "--version":
+		fmt.Printf("altair %s\n", version)
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(args[1:], out); err != nil {
+				out.fail(err)
+			}
+			return
+		}
+	}
+
+	if out.json {
+		json.NewEncoder(os.Stdout).Encode(map[string]string{"error": fmt.Sprintf("unknown command %q", name)})
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n", name)
+		usage()
+	}
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: altair [-json] <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "  %-10s %s\n", "help", "Show this help")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Global flags:")
+	fmt.Fprintln(os.Stderr, "  -json      Print results and errors as JSON")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'altair <command> -h' for a command's flags.")
+}
@@ -8,7 +8,7 @@
 //	# Connect to peer (initiator mode)
 //	altair-chat --username Bob --peer 203.0.113.42:9000
 //
-//	# With signaling server (automatic coordination)
+//	# With signaling server (automatic coordination, build with -tags websocket)
 //	altair-chat --username Alice --room my-chat --signaling ws://server:8080/ws
 package main
 
@@ -23,7 +23,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/saintparish4/altair"
 	"github.com/saintparish4/altair/pkg/chat"
+	"github.com/saintparish4/altair/pkg/reliable"
 )
 
 const banner = `
@@ -74,11 +76,23 @@ func main() {
 		fmt.Printf("%sMode:%s Signaling (room: %s)\n", chat.ColorGray, chat.ColorReset, *roomID)
 		fmt.Printf("%sConnecting to signaling server...%s\n", chat.ColorYellow, chat.ColorReset)
 
-		// TODO: Integrate with actual signaling client and hole punching
-		// For now, show placeholder
-		fmt.Printf("\n%s[Signaling mode requires integration with altair holepunch package]%s\n", chat.ColorRed, chat.ColorReset)
-		fmt.Println("Use manual mode with --listen or --peer for now.")
-		os.Exit(1)
+		client := altair.NewClient(&altair.Config{
+			DisplayName: *username,
+			Timeout:     *timeout,
+		})
+		punched, err := client.ConnectViaRoom(*signalingURL, *roomID)
+		if err != nil {
+			fmt.Printf("%sError: failed to connect via room: %v%s\n", chat.ColorRed, err, chat.ColorReset)
+			os.Exit(1)
+		}
+
+		// The chat protocol needs an ordered stream, so run it over the
+		// punched UDP socket with reliable delivery
+		conn, err = reliable.New(punched.NetConn(), nil)
+		if err != nil {
+			fmt.Printf("%sError: %v%s\n", chat.ColorRed, err, chat.ColorReset)
+			os.Exit(1)
+		}
 
 	} else if *listenAddr != "" {
 		// Responder mode - listen for incoming connection
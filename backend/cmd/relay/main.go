@@ -0,0 +1,102 @@
+// Command relay runs a minimal Altair TURN relay server.
+//
+// The relay server lets peers that cannot establish a direct P2P connection
+// (e.g. both behind symmetric NATs) exchange datagrams through a relayed
+// transport address, using the TURN protocol (RFC 5766).
+//
+// Usage:
+//
+//	altair-relay [flags]
+//
+// Flags:
+//
+//	-addr string       UDP listen address (default ":3478")
+//	-relay-ip string   IP advertised in relay addresses (default: listen IP
+//	                   or the preferred local address)
+//	-verbose           Enable verbose logging
+//
+// Supported: UDP over IPv4, Allocate, Refresh, CreatePermission,
+// ChannelBind, Send/Data indications and ChannelData. No authentication.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/saintparish4/altair/internal/relay"
+)
+
+var (
+	version = "dev" // Set via ldflags
+)
+
+func main() {
+	// Parse command line flags
+	addr := flag.String("addr", ":3478", "UDP listen address (e.g., :3478 or 0.0.0.0:3478)")
+	relayIP := flag.String("relay-ip", "", "IP advertised in relay addresses (e.g., your public IP)")
+	maxAllocations := flag.Int("max-allocations", 1000, "Maximum live allocations (0 for no limit)")
+	maxPerIP := flag.Int("max-allocations-per-ip", 10, "Maximum live allocations per client IP (0 for no limit)")
+	verbose := flag.Bool("verbose", false, "Enable verbose logging")
+	showVersion := flag.Bool("version", false, "Show version and exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("altair-relay %s\n", version)
+		os.Exit(0)
+	}
+
+	// Configure logging
+	var logger *log.Logger
+	if *verbose {
+		logger = log.New(os.Stdout, "", log.LstdFlags|log.Lmicroseconds)
+	} else {
+		logger = log.New(io.Discard, "", 0)
+	}
+
+	// Create server configuration
+	cfg := relay.DefaultConfig()
+	cfg.Addr = *addr
+	cfg.MaxAllocations = *maxAllocations
+	cfg.MaxAllocationsPerIP = *maxPerIP
+	cfg.Logger = logger
+
+	if *relayIP != "" {
+		ip := net.ParseIP(*relayIP)
+		if ip == nil || ip.To4() == nil {
+			log.Fatalf("invalid -relay-ip %q: must be an IPv4 address", *relayIP)
+		}
+		cfg.RelayIP = ip
+	}
+
+	// Create and start server
+	server := relay.NewServer(cfg)
+
+	printBanner(*addr, *verbose)
+
+	if err := server.Start(); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+}
+
+func printBanner(addr string, verbose bool) {
+	fmt.Println()
+	fmt.Println("  █████╗ ██╗  ████████╗ █████╗ ██╗██████╗ ")
+	fmt.Println(" ██╔══██╗██║  ╚══██╔══╝██╔══██╗██║██╔══██╗")
+	fmt.Println(" ███████║██║     ██║   ███████║██║██████╔╝")
+	fmt.Println(" ██╔══██║██║     ██║   ██╔══██║██║██╔══██╗")
+	fmt.Println(" ██║  ██║███████╗██║   ██║  ██║██║██║  ██║")
+	fmt.Println(" ╚═╝  ╚═╝╚══════╝╚═╝   ╚═╝  ╚═╝╚═╝╚═╝  ╚═╝")
+	fmt.Println("            Relay Server")
+	fmt.Println()
+	fmt.Printf(" TURN (UDP): %s\n", addr)
+	fmt.Println()
+	if verbose {
+		fmt.Println(" Verbose logging: enabled")
+	}
+	fmt.Println(" Press Ctrl+C to stop")
+	fmt.Println()
+}
@@ -9,20 +9,31 @@
 //
 // Flags:
 //
-//	-addr string    Listen address (default ":8080")
-//	-verbose        Enable verbose logging
+//	-addr string         Listen address (default ":8080")
+//	-verbose             Enable verbose logging
+//	-log-json            Emit structured JSON logs
+//	-turn-secret string  Shared secret for issuing TURN credentials
+//	-turn-realm string   TURN realm returned with credentials
+//	-turn-uri string     Comma-separated TURN server URIs
+//	-candidate-hold dur  Hold trickled candidates until OFFER/ANSWER (default 2s, 0 disables)
+//	-implicit-rooms      Create rooms on first JOIN (default true; false requires POST /api/rooms)
+//	-instance-id string  Instance ID reported in the welcome ACK (default random)
+//	-compress            Negotiate permessage-deflate WebSocket compression
+//	-tls-cert string     TLS certificate file; with -tls-key serves HTTPS and wss://
+//	-tls-key string      TLS private key file
 //
 // Endpoints:
 //
-//	WebSocket: ws://host:port/ws
+//	WebSocket: ws://host:port/ws (wss:// with TLS)
 //	Health:    GET /health
 //	Stats:     GET /api/stats
-//	Rooms:     GET /api/rooms
+//	Metrics:   GET /metrics
+//	Rooms:     GET/POST /api/rooms
 //	Room:      GET /api/rooms/{id}
+//	Events:    GET /api/rooms/{id}/events
 //
-// Build with gorilla/websocket:
+// Build with WebSocket support (required to serve /ws):
 //
-//	go get github.com/gorilla/websocket
 //	go build -tags websocket ./cmd/signaling
 package main
 
@@ -31,7 +42,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/saintparish4/altair/internal/signaling"
@@ -45,7 +58,17 @@ func main() {
 	// Parse command line flags
 	addr := flag.String("addr", ":8080", "Listen address (e.g., :8080 or 0.0.0.0:8080)")
 	verbose := flag.Bool("verbose", false, "Enable verbose logging")
+	logJSON := flag.Bool("log-json", false, "Emit structured JSON logs (debug level with -verbose)")
 	showVersion := flag.Bool("version", false, "Show version and exit")
+	turnSecret := flag.String("turn-secret", "", "Shared secret for issuing TURN credentials (optional)")
+	turnRealm := flag.String("turn-realm", "", "TURN realm returned with credentials")
+	turnURIs := flag.String("turn-uri", "", "Comma-separated TURN server URIs (e.g., turn:relay.example.com:3478)")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; with -tls-key serves HTTPS and wss:// (optional)")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	candidateHold := flag.Duration("candidate-hold", 2*time.Second, "Hold trickled candidates until their OFFER/ANSWER is forwarded (0 disables)")
+	implicitRooms := flag.Bool("implicit-rooms", true, "Create rooms on first JOIN; when false, rooms must be created with POST /api/rooms")
+	compress := flag.Bool("compress", false, "Negotiate permessage-deflate compression with clients that support it")
+	instanceID := flag.String("instance-id", "", "Instance ID reported to clients in the welcome ACK (default random)")
 	flag.Parse()
 
 	if *showVersion {
@@ -63,39 +86,58 @@ func main() {
 
 	// Create server configuration
 	cfg := signaling.Config{
-		Addr:            *addr,
-		ReadTimeout:     15 * time.Second,
-		WriteTimeout:    15 * time.Second,
-		CleanupInterval: 1 * time.Minute,
-		StaleTimeout:    5 * time.Minute,
-		Logger:          logger,
+		Addr:               *addr,
+		ReadTimeout:        15 * time.Second,
+		WriteTimeout:       15 * time.Second,
+		CleanupInterval:    1 * time.Minute,
+		StaleTimeout:       5 * time.Minute,
+		Logger:             logger,
+		EnableWebSocket:    true,
+		CandidateHold:      *candidateHold,
+		TLSCertFile:        *tlsCert,
+		TLSKeyFile:         *tlsKey,
+		AllowImplicitRooms: *implicitRooms,
+		Version:            version,
+		InstanceID:         *instanceID,
+		EnableCompression:  *compress,
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
+
+	if *turnSecret != "" {
+		cfg.TURN = &signaling.TURNConfig{
+			Secret: *turnSecret,
+			Realm:  *turnRealm,
+		}
+		if *turnURIs != "" {
+			cfg.TURN.URIs = strings.Split(*turnURIs, ",")
+		}
+	}
+
+	if *logJSON {
+		level := slog.LevelInfo
+		if *verbose {
+			level = slog.LevelDebug
+		}
+		cfg.SLogger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
 	}
 
 	// Create and start server
 	server := signaling.NewServer(cfg)
 
-	// NOTE: WebSocket upgrader must be set before starting.
-	// When building with gorilla/websocket, use:
-	//
-	//   import "github.com/gorilla/websocket"
-	//   upgrader := &GorillaUpgrader{websocket.Upgrader{
-	//       ReadBufferSize:  1024,
-	//       WriteBufferSize: 1024,
-	//       CheckOrigin: func(r *http.Request) bool { return true },
-	//   }}
-	//   server.Handler().SetUpgrader(upgrader)
-	//
-	// See internal/signaling/gorilla.go for the adapter implementation.
-
 	// Print startup banner
-	printBanner(*addr, *verbose)
+	printBanner(server, *verbose)
 
 	if err := server.Start(); err != nil {
 		log.Fatalf("server error: %v", err)
 	}
 }
 
-func printBanner(addr string, verbose bool) {
+func printBanner(server *signaling.Server, verbose bool) {
+	base := server.ListenAddr()
+
 	fmt.Println()
 	fmt.Println("  █████╗ ██╗  ████████╗ █████╗ ██╗██████╗ ")
 	fmt.Println(" ██╔══██╗██║  ╚══██╔══╝██╔══██╗██║██╔══██╗")
@@ -105,10 +147,11 @@ func printBanner(addr string, verbose bool) {
 	fmt.Println(" ╚═╝  ╚═╝╚══════╝╚═╝   ╚═╝  ╚═╝╚═╝╚═╝  ╚═╝")
 	fmt.Println("          Signaling Server")
 	fmt.Println()
-	fmt.Printf(" WebSocket:  ws://localhost%s/ws\n", addr)
-	fmt.Printf(" Health:     http://localhost%s/health\n", addr)
-	fmt.Printf(" Stats:      http://localhost%s/api/stats\n", addr)
-	fmt.Printf(" Rooms:      http://localhost%s/api/rooms\n", addr)
+	fmt.Printf(" WebSocket:  %s\n", server.WebSocketURL())
+	fmt.Printf(" Health:     %s/health\n", base)
+	fmt.Printf(" Stats:      %s/api/stats\n", base)
+	fmt.Printf(" Metrics:    %s/metrics\n", base)
+	fmt.Printf(" Rooms:      %s/api/rooms\n", base)
 	fmt.Println()
 	if verbose {
 		fmt.Println(" Verbose logging: enabled")
@@ -31,13 +31,14 @@ var (
 	relayServer = flag.String("relay", "", "Relay server address (optional)")
 	username    = flag.String("user", "Anonymous", "Your username")
 	stunServer  = flag.String("stun", "stun.l.google.com:19302", "STUN address")
+	peerNAT     = flag.String("peer-nat", "", "Peer NAT type if known, e.g. full-cone (optional)")
 )
 
+// ChatConnection wraps the peer connection. Direct and relayed connections
+// share the net.Conn interface, so the chat loop doesn't care which it got.
 type ChatConnection struct {
-	conn        *net.UDPConn
-	remoteAddr  *net.UDPAddr
-	isRelayed   bool
-	relayClient *relay.Client
+	conn      net.Conn
+	isRelayed bool
 }
 
 func main() {
@@ -56,8 +57,8 @@ func main() {
 	fmt.Printf("%s✓ Public Address: %s%s\n", colorGreen, mapping.PublicAddr, colorReset)
 
 	// Get local addresses for LAN detection
-	localAddrs, _ := getLocalAddresses()
-	fmt.Printf("%s✓ Local Addresses: %v%s\n", colorGreen, localAddrs, colorReset)
+	localIPs, _ := netutil.GetPrivateAddresses()
+	fmt.Printf("%s✓ Local Addresses: %v%s\n", colorGreen, localIPs, colorReset)
 
 	// Step 2: Establish connection
 	var chatConn *ChatConnection
@@ -65,15 +66,14 @@ func main() {
 	if *peerAddr != "" {
 		// Connect to specified peer
 		fmt.Printf("\n%s[2/4] Connecting to peer %s...%s\n", colorCyan, *peerAddr, colorReset)
-		chatConn, err = connectToPeer(*peerAddr, mapping, localAddrs)
+		chatConn, err = connectToPeer(*peerAddr, mapping)
 		if err != nil {
 			log.Fatalf("%sConnection failed: %v%s\n", colorRed, err, colorReset)
 		}
 	} else {
 		// Listen mode
 		fmt.Printf("\n%s[2/4] Waiting for incoming connection...%s\n", colorCyan, colorReset)
-		printConnectionInfo(mapping, localAddrs)
-		chatConn, err = waitForPeer(mapping)
+		chatConn, err = waitForPeer(mapping, localIPs)
 		if err != nil {
 			log.Fatalf("%sFailed to accept connection: %v%s\n", colorRed, err, colorReset)
 		}
@@ -125,43 +125,47 @@ func detectNAT() (*nat.Mapping, error) {
 	return detector.DetectWithRetry()
 }
 
-func getLocalAddresses() ([]*net.UDPAddr, error) {
-	ips, err := netutil.GetPrivateAddresses()
-	if err != nil {
-		return nil, err
-	}
-
-	// Convert to UDP addresses (use ephemeral port)
-	addrs := make([]*net.UDPAddr, len(ips))
-	for i, ip := range ips {
-		addrs[i] = &net.UDPAddr{IP: ip, Port: 0}
-	}
-
-	return addrs, nil
-}
-
-func printConnectionInfo(mapping *nat.Mapping, localAddrs []*net.UDPAddr) {
+// printConnectionInfo shows the addresses a peer can use. The LAN address
+// is the private IP with the port we're actually listening on.
+func printConnectionInfo(mapping *nat.Mapping, localIPs []net.IP, port int) {
 	fmt.Printf("\n%s╔═══ Connection Information ═══╗%s\n", colorBlue, colorReset)
 	fmt.Printf("%sTo connect to you, peer should use:%s\n", colorYellow, colorReset)
 	fmt.Printf("  Public: %s-peer %s%s\n", colorGreen, mapping.PublicAddr, colorReset)
 
-	if len(localAddrs) > 0 {
-		fmt.Printf("  LAN:    %s-peer %s:%d%s (if on same network)\n",
-			colorGreen, localAddrs[0].IP, mapping.PublicAddr.Port, colorReset)
+	for _, ip := range localIPs {
+		fmt.Printf("  LAN:    %s-peer %s%s (if on same network)\n",
+			colorGreen, &net.UDPAddr{IP: ip, Port: port}, colorReset)
 	}
 	fmt.Printf("%s╚═══════════════════════════════╝%s\n\n", colorBlue, colorReset)
 }
 
-func connectToPeer(peerAddrStr string, mapping *nat.Mapping, localAddrs []*net.UDPAddr) (*ChatConnection, error) {
+func connectToPeer(peerAddrStr string, mapping *nat.Mapping) (*ChatConnection, error) {
 	// Parse peer address
 	peerUDP, err := net.ResolveUDPAddr("udp", peerAddrStr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid peer address: %w", err)
 	}
 
-	// Determine if we can use hole punching
-	// For simplicity, assume peer has compatible NAT if we do
-	if mapping.Type != nat.TypeSymmetric || mapping.Type == nat.TypeBlocked {
+	peerType := nat.TypeUnknown
+	if *peerNAT != "" {
+		peerType, err = nat.ParseType(*peerNAT)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A peer on one of our subnets is reached directly, so NAT types
+	// don't matter
+	networks, _ := netutil.GetLocalNetworks()
+	sameLAN := netutil.SameSubnet(peerUDP.IP, networks)
+	if sameLAN {
+		fmt.Printf("Peer is on the local network, connecting directly...\n")
+	}
+
+	// Skip punching when it's known to be futile: our UDP is blocked, or
+	// both NAT types are known and incompatible
+	if !sameLAN && (mapping.Type == nat.TypeBlocked ||
+		(peerType != nat.TypeUnknown && !nat.CanHolePunch(mapping.Type, peerType))) {
 		fmt.Printf("%sNAT type incompatible for P2P, using relay...%s\n", colorYellow, colorReset)
 		return connectViaRelay(peerUDP)
 	}
@@ -170,6 +174,7 @@ func connectToPeer(peerAddrStr string, mapping *nat.Mapping, localAddrs []*net.U
 	fmt.Printf("Attempting UDP hole punching...\n")
 
 	config := &punch.PuncherConfig{
+		LocalAddr:    &net.UDPAddr{IP: net.IPv4zero, Port: mapping.LocalAddr.Port},
 		Mapping:      mapping,
 		Timeout:      15 * time.Second,
 		PingInterval: 200 * time.Millisecond,
@@ -182,15 +187,19 @@ func connectToPeer(peerAddrStr string, mapping *nat.Mapping, localAddrs []*net.U
 	}
 	// DO NOT CLOSE PUNCHER - we need its socket
 
-	peerInfo := &punch.PeerInfo{
-		PublicAddr: peerUDP,
-		LocalAddrs: localAddrs,
-		NATType:    nat.TypeFullCone, // Optimistic assumption
+	peerInfo := &punch.PeerInfo{NATType: peerType}
+	if sameLAN {
+		peerInfo.LocalAddrs = []*net.UDPAddr{peerUDP}
+	} else {
+		peerInfo.PublicAddr = peerUDP
 	}
 
 	conn, err := puncher.PunchWithRetry(peerInfo, 2)
 	if err != nil {
 		fmt.Printf("%sHole punching failed: %v%s\n", colorYellow, err, colorReset)
+		if stats := puncher.LastStats(); stats != nil {
+			fmt.Printf("  %s\n", stats)
+		}
 
 		if *relayServer != "" {
 			fmt.Printf("Falling back to relay...\n")
@@ -201,9 +210,8 @@ func connectToPeer(peerAddrStr string, mapping *nat.Mapping, localAddrs []*net.U
 	}
 
 	return &ChatConnection{
-		conn:       conn.Conn,
-		remoteAddr: conn.RemoteAddr,
-		isRelayed:  false,
+		conn:      conn.NetConn(),
+		isRelayed: false,
 	}, nil
 }
 
@@ -217,28 +225,26 @@ func connectViaRelay(peerAddr *net.UDPAddr) (*ChatConnection, error) {
 		return nil, err
 	}
 
-	// Create a dummy connection for the interface
-	// We'll use relayClient.Send/Receive for actual communication
-	dummyConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
-	if err != nil {
+	if err := client.CreatePermission(peerAddr); err != nil {
 		client.Close()
-		return nil, fmt.Errorf("failed to create dummy connection: %w", err)
+		return nil, err
+	}
+
+	if err := client.StartAutoRefresh(); err != nil {
+		client.Close()
+		return nil, err
 	}
 
 	return &ChatConnection{
-		conn:        dummyConn,
-		remoteAddr:  peerAddr,
-		isRelayed:   true,
-		relayClient: client,
+		conn:      client.NetConn(peerAddr),
+		isRelayed: true,
 	}, nil
 }
 
-func waitForPeer(mapping *nat.Mapping) (*ChatConnection, error) {
-	// Create listener
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{
-		IP:   net.IPv4zero,
-		Port: mapping.PublicAddr.Port, // Try to use same port as STUN
-	})
+func waitForPeer(mapping *nat.Mapping, localIPs []net.IP) (*ChatConnection, error) {
+	// Reuse the local port STUN saw so the NAT mapping (and the public
+	// address we print) likely still applies
+	conn, err := netutil.CreateUDPSocketReuse("0.0.0.0", mapping.LocalAddr.Port)
 	if err != nil {
 		// If that fails, use any port
 		conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
@@ -247,6 +253,7 @@ func waitForPeer(mapping *nat.Mapping) (*ChatConnection, error) {
 		}
 	}
 
+	printConnectionInfo(mapping, localIPs, conn.LocalAddr().(*net.UDPAddr).Port)
 	fmt.Printf("Listening on %s\n", conn.LocalAddr())
 	fmt.Printf("Waiting for peer to connect...\n")
 
@@ -261,9 +268,11 @@ func waitForPeer(mapping *nat.Mapping) (*ChatConnection, error) {
 		}
 
 		// Check if it's a PING (hole punching attempt)
-		if n >= 4 && string(buf[:4]) == "PING" {
-			// Send PONG back
-			conn.WriteToUDP([]byte("PONG"), addr)
+		if ping, ok := punch.ParsePacket(buf[:n]); ok {
+			if ping.Type == punch.PacketPing {
+				// Send PONG back
+				conn.WriteToUDP(ping.Reply().Encode(), addr)
+			}
 			continue
 		}
 
@@ -273,10 +282,14 @@ func waitForPeer(mapping *nat.Mapping) (*ChatConnection, error) {
 		// Send confirmation
 		conn.WriteToUDP([]byte("CONNECTED"), addr)
 
+		connection := &punch.Connection{
+			Conn:       conn,
+			RemoteAddr: addr,
+		}
+
 		return &ChatConnection{
-			conn:       conn,
-			remoteAddr: addr,
-			isRelayed:  false,
+			conn:      connection.NetConn(),
+			isRelayed: false,
 		}, nil
 	}
 }
@@ -305,13 +318,7 @@ func sendMessages(chatConn *ChatConnection) {
 		fullMessage := fmt.Sprintf("%s: %s", *username, message)
 
 		// Send message
-		var err error
-		if chatConn.isRelayed && chatConn.relayClient != nil {
-			err = chatConn.relayClient.Send([]byte(fullMessage), chatConn.remoteAddr)
-		} else {
-			_, err = chatConn.conn.WriteToUDP([]byte(fullMessage), chatConn.remoteAddr)
-		}
-		if err != nil {
+		if _, err := chatConn.conn.Write([]byte(fullMessage)); err != nil {
 			fmt.Printf("%s✗ Failed to send: %v%s\n", colorRed, err, colorReset)
 			continue
 		}
@@ -322,38 +329,17 @@ func receiveMessages(chatConn *ChatConnection) {
 	buf := make([]byte, 1500)
 
 	for {
-		var n int
-		var addr *net.UDPAddr
-		var err error
-
-		if chatConn.isRelayed && chatConn.relayClient != nil {
-			// Use relay client's Receive method
-			data, recvAddr, recvErr := chatConn.relayClient.Receive()
-			if recvErr != nil {
-				fmt.Printf("\n%s✗ Connection error: %v%s\n", colorRed, recvErr, colorReset)
-				os.Exit(1)
-			}
-			n = len(data)
-			copy(buf, data)
-			addr = recvAddr
-		} else {
-			chatConn.conn.SetReadDeadline(time.Time{}) // No timeout
-			n, addr, err = chatConn.conn.ReadFromUDP(buf)
-			if err != nil {
-				fmt.Printf("\n%s✗ Connection error: %v%s\n", colorRed, err, colorReset)
-				os.Exit(1)
-			}
-		}
-
-		// Verify it's from our peer
-		if !addr.IP.Equal(chatConn.remoteAddr.IP) || addr.Port != chatConn.remoteAddr.Port {
-			continue
+		// Reads only return data from our peer
+		n, err := chatConn.conn.Read(buf)
+		if err != nil {
+			fmt.Printf("\n%s✗ Connection error: %v%s\n", colorRed, err, colorReset)
+			os.Exit(1)
 		}
 
 		message := string(buf[:n])
 
 		// Skip protocol messages
-		if message == "PING" || message == "PONG" || message == "CONNECTED" {
+		if message == "CONNECTED" {
 			continue
 		}
 
@@ -0,0 +1,32 @@
+// Package conntest provides loopback datagram connections for tests of the
+// layers that run over a punched path, such as pkg/reliable and
+// pkg/framing.
+package conntest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/saintparish4/altair/pkg/punch"
+)
+
+// PeerConns returns two peer-bound datagram conns connected over loopback,
+// the same shape a successful hole punch hands to the layers above it. The
+// caller owns both conns.
+func PeerConns(t testing.TB) (net.Conn, net.Conn) {
+	t.Helper()
+
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		if err != nil {
+			t.Fatalf("Failed to create UDP connection: %v", err)
+		}
+		return conn
+	}
+	a, b := listen(), listen()
+
+	connA := &punch.Connection{Conn: a, RemoteAddr: b.LocalAddr().(*net.UDPAddr)}
+	connB := &punch.Connection{Conn: b, RemoteAddr: a.LocalAddr().(*net.UDPAddr)}
+
+	return connA.NetConn(), connB.NetConn()
+}
@@ -0,0 +1,70 @@
+// Package ratelimit paces byte streams with a token bucket
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket measured in bytes. A nil *Limiter is unlimited,
+// so callers can hold one unconditionally.
+type Limiter struct {
+	rate  float64 // bytes per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter allowing bytesPerSec on average with bursts of up to
+// one second's worth. A rate of 0 or less means unlimited and returns nil.
+func New(bytesPerSec int) *Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		rate:   float64(bytesPerSec),
+		burst:  float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until n bytes may be sent. Requests larger than the burst are
+// allowed; they put the bucket into debt that later calls wait out.
+func (l *Limiter) Wait(n int) {
+	if delay := l.reserve(n); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// reserve takes n tokens and returns how long the caller must wait before
+// using them
+func (l *Limiter) reserve(n int) time.Duration {
+	if l == nil || n <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Rate returns the configured rate in bytes per second, or 0 if unlimited
+func (l *Limiter) Rate() int {
+	if l == nil {
+		return 0
+	}
+	return int(l.rate)
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewUnlimited(t *testing.T) {
+	for _, rate := range []int{0, -1} {
+		if limiter := New(rate); limiter != nil {
+			t.Errorf("New(%d) = %v, want nil", rate, limiter)
+		}
+	}
+
+	// A nil limiter never blocks
+	var limiter *Limiter
+	start := time.Now()
+	limiter.Wait(1 << 30)
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("nil limiter blocked for %v", elapsed)
+	}
+	if limiter.Rate() != 0 {
+		t.Errorf("Rate() = %d, want 0", limiter.Rate())
+	}
+}
+
+func TestLimiterBurst(t *testing.T) {
+	limiter := New(1000)
+
+	// A full bucket allows one second's worth immediately
+	if delay := limiter.reserve(1000); delay != 0 {
+		t.Errorf("First reserve delay = %v, want 0", delay)
+	}
+
+	// The next second's worth must wait for a refill
+	delay := limiter.reserve(500)
+	if delay < 450*time.Millisecond || delay > 500*time.Millisecond {
+		t.Errorf("Reserve after burst delay = %v, want ~500ms", delay)
+	}
+}
+
+func TestLimiterPacesThroughput(t *testing.T) {
+	const (
+		rate  = 10000
+		chunk = 1000
+		total = 25000
+	)
+	limiter := New(rate)
+
+	start := time.Now()
+	for sent := 0; sent < total; sent += chunk {
+		limiter.Wait(chunk)
+	}
+	elapsed := time.Since(start)
+
+	// The initial burst covers one second's worth; the rest is paced
+	expected := time.Duration(float64(total-rate) / rate * float64(time.Second))
+	if elapsed < expected-50*time.Millisecond {
+		t.Errorf("Sending %d bytes at %d B/s took %v, want at least %v", total, rate, elapsed, expected)
+	}
+	if elapsed > expected+time.Second {
+		t.Errorf("Sending %d bytes at %d B/s took %v, want about %v", total, rate, elapsed, expected)
+	}
+}
@@ -0,0 +1,670 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/netutil"
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// TURN error codes used by the server (RFC 5766 section 15)
+const (
+	codeBadRequest           = 400
+	codeAllocationMismatch   = 437
+	codeUnsupportedTransport = 442
+	codeAllocationQuota      = 486
+	codeInsufficientCapacity = 508
+)
+
+// Server is a minimal TURN relay server (RFC 5766) for UDP over IPv4.
+// It handles Allocate, Refresh, CreatePermission, ChannelBind, Send and
+// ChannelData, and relays datagrams between clients and permitted peers.
+// There is no authentication.
+type Server struct {
+	conn    *net.UDPConn
+	relayIP net.IP
+
+	allocations map[string]*allocation // Keyed by client address
+	mu          sync.Mutex
+
+	// Configuration
+	Addr                string
+	RelayIP             net.IP
+	DefaultLifetime     time.Duration
+	MaxLifetime         time.Duration
+	PermissionLifetime  time.Duration
+	ChannelLifetime     time.Duration
+	CleanupInterval     time.Duration
+	MaxAllocations      int
+	MaxAllocationsPerIP int
+
+	// Lifecycle
+	shutdownOnce sync.Once
+	done         chan struct{}
+
+	// Logging
+	Logger *log.Logger
+}
+
+// Config holds server configuration options.
+type Config struct {
+	// UDP listen address
+	Addr string
+
+	// IP advertised in XOR-RELAYED-ADDRESS. Defaults to the listen IP, or
+	// the preferred local address when listening on all interfaces.
+	RelayIP net.IP
+
+	DefaultLifetime    time.Duration
+	MaxLifetime        time.Duration
+	PermissionLifetime time.Duration
+	ChannelLifetime    time.Duration
+	CleanupInterval    time.Duration
+
+	// Caps on live allocations, in total and per client IP. Requests over
+	// either get 486 Allocation Quota Reached; zero means no limit.
+	MaxAllocations      int
+	MaxAllocationsPerIP int
+
+	Logger *log.Logger
+}
+
+// DefaultConfig returns sensible default configuration.
+func DefaultConfig() Config {
+	return Config{
+		Addr:                ":3478",
+		DefaultLifetime:     10 * time.Minute,
+		MaxLifetime:         1 * time.Hour,
+		PermissionLifetime:  5 * time.Minute,
+		ChannelLifetime:     10 * time.Minute,
+		CleanupInterval:     30 * time.Second,
+		MaxAllocations:      1000,
+		MaxAllocationsPerIP: 10,
+		Logger:              log.Default(),
+	}
+}
+
+// allocation is the server side of a client's relayed transport address.
+// All fields are guarded by Server.mu.
+type allocation struct {
+	client    *net.UDPAddr
+	relay     *net.UDPConn
+	expiresAt time.Time
+
+	permissions  map[string]time.Time // Peer IP -> expiry
+	channels     map[uint16]*channelBinding
+	peerChannels map[string]uint16 // Peer address -> channel number
+}
+
+// channelBinding ties a channel number to a peer address
+type channelBinding struct {
+	peer      *net.UDPAddr
+	expiresAt time.Time
+}
+
+// NewServer creates a new relay server with the given configuration.
+// Zero durations take their DefaultConfig values.
+func NewServer(cfg Config) *Server {
+	defaults := DefaultConfig()
+	if cfg.DefaultLifetime <= 0 {
+		cfg.DefaultLifetime = defaults.DefaultLifetime
+	}
+	if cfg.MaxLifetime <= 0 {
+		cfg.MaxLifetime = defaults.MaxLifetime
+	}
+	if cfg.MaxLifetime < cfg.DefaultLifetime {
+		cfg.MaxLifetime = cfg.DefaultLifetime
+	}
+	if cfg.PermissionLifetime <= 0 {
+		cfg.PermissionLifetime = defaults.PermissionLifetime
+	}
+	if cfg.ChannelLifetime <= 0 {
+		cfg.ChannelLifetime = defaults.ChannelLifetime
+	}
+	if cfg.CleanupInterval <= 0 {
+		cfg.CleanupInterval = defaults.CleanupInterval
+	}
+
+	return &Server{
+		allocations:         make(map[string]*allocation),
+		Addr:                cfg.Addr,
+		RelayIP:             cfg.RelayIP,
+		DefaultLifetime:     cfg.DefaultLifetime,
+		MaxLifetime:         cfg.MaxLifetime,
+		PermissionLifetime:  cfg.PermissionLifetime,
+		ChannelLifetime:     cfg.ChannelLifetime,
+		CleanupInterval:     cfg.CleanupInterval,
+		MaxAllocations:      cfg.MaxAllocations,
+		MaxAllocationsPerIP: cfg.MaxAllocationsPerIP,
+		Logger:              cfg.Logger,
+		done:                make(chan struct{}),
+	}
+}
+
+// Start listens on Addr and serves requests. Blocks until shutdown.
+func (s *Server) Start() error {
+	addr, err := net.ResolveUDPAddr("udp4", s.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to resolve listen address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	// Handle graceful shutdown
+	go s.handleShutdownSignals()
+
+	return s.Serve(conn)
+}
+
+// Serve handles requests arriving on conn. Blocks until shutdown.
+func (s *Server) Serve(conn *net.UDPConn) error {
+	s.mu.Lock()
+	s.conn = conn
+	s.relayIP = s.advertisedIP()
+	s.mu.Unlock()
+
+	// Start cleanup goroutine
+	go s.cleanupLoop()
+
+	s.log("listening on %s, relaying on %s", conn.LocalAddr(), s.relayIP)
+
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return fmt.Errorf("failed to read: %w", err)
+			}
+		}
+
+		s.handlePacket(buf[:n], addr)
+	}
+}
+
+// advertisedIP picks the IP clients' peers should send to
+func (s *Server) advertisedIP() net.IP {
+	if s.RelayIP != nil {
+		return s.RelayIP
+	}
+
+	ip := s.conn.LocalAddr().(*net.UDPAddr).IP
+	if !ip.IsUnspecified() {
+		return ip
+	}
+
+	if preferred, err := netutil.GetPreferredLocalAddress(); err == nil {
+		return preferred
+	}
+	return net.IPv4(127, 0, 0, 1)
+}
+
+// Shutdown stops the server and releases all allocations.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	s.shutdownOnce.Do(func() {
+		s.log("shutting down...")
+		close(s.done)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.conn != nil {
+			err = s.conn.Close()
+		}
+
+		for key, alloc := range s.allocations {
+			alloc.relay.Close()
+			delete(s.allocations, key)
+		}
+	})
+	return err
+}
+
+// handleShutdownSignals listens for OS signals and initiates graceful shutdown.
+func (s *Server) handleShutdownSignals() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case sig := <-sigChan:
+		s.log("received signal: %v", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	case <-s.done:
+		return
+	}
+}
+
+// cleanupLoop periodically expires allocations, permissions and channels.
+func (s *Server) cleanupLoop() {
+	ticker := time.NewTicker(s.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if expired := s.cleanup(now); expired > 0 {
+				s.log("cleanup: removed %d expired allocations", expired)
+			}
+		}
+	}
+}
+
+// cleanup removes state that expired before now and returns the number of
+// allocations removed.
+func (s *Server) cleanup(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := 0
+	for key, alloc := range s.allocations {
+		if now.After(alloc.expiresAt) {
+			alloc.relay.Close()
+			delete(s.allocations, key)
+			expired++
+			continue
+		}
+
+		for ip, expiresAt := range alloc.permissions {
+			if now.After(expiresAt) {
+				delete(alloc.permissions, ip)
+			}
+		}
+
+		for channel, binding := range alloc.channels {
+			if now.After(binding.expiresAt) {
+				delete(alloc.channels, channel)
+				delete(alloc.peerChannels, binding.peer.String())
+			}
+		}
+	}
+
+	return expired
+}
+
+// handlePacket dispatches a datagram from a client
+func (s *Server) handlePacket(data []byte, client *net.UDPAddr) {
+	if channel, payload, ok := stun.DecodeChannelData(data); ok {
+		s.handleChannelData(client, channel, payload)
+		return
+	}
+
+	msg, err := stun.Decode(data)
+	if err != nil {
+		return
+	}
+
+	var response *stun.Message
+	switch msg.Type {
+	case stun.TypeBindingRequest:
+		response = &stun.Message{Type: stun.TypeBindingSuccess, TransactionID: msg.TransactionID}
+		response.AddAttribute(stun.EncodeXORMappedAddress(client, msg.TransactionID))
+	case stun.TypeAllocateRequest:
+		response = s.handleAllocate(msg, client)
+	case stun.TypeRefreshRequest:
+		response = s.handleRefresh(msg, client)
+	case stun.TypeCreatePermissionRequest:
+		response = s.handleCreatePermission(msg, client)
+	case stun.TypeChannelBindRequest:
+		response = s.handleChannelBind(msg, client)
+	case stun.TypeSendIndication:
+		s.handleSend(msg, client)
+	}
+
+	if response == nil {
+		return
+	}
+
+	encoded, err := response.Encode()
+	if err != nil {
+		return
+	}
+	s.conn.WriteToUDP(encoded, client)
+}
+
+// handleAllocate creates an allocation and starts relaying for it
+func (s *Server) handleAllocate(msg *stun.Message, client *net.UDPAddr) *stun.Message {
+	attr, found := msg.GetAttribute(stun.AttrRequestedTransport)
+	if !found {
+		return errorResponse(msg, codeBadRequest, "Bad Request")
+	}
+	if protocol, err := stun.DecodeRequestedTransport(attr); err != nil || protocol != stun.ProtocolUDP {
+		return errorResponse(msg, codeUnsupportedTransport, "Unsupported Transport Protocol")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.allocations[client.String()]; exists {
+		return errorResponse(msg, codeAllocationMismatch, "Allocation Mismatch")
+	}
+	if s.overQuota(client.IP) {
+		s.log("allocation quota reached for %s", client)
+		return errorResponse(msg, codeAllocationQuota, "Allocation Quota Reached")
+	}
+
+	bindIP := s.conn.LocalAddr().(*net.UDPAddr).IP
+	relayConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: bindIP, Port: 0})
+	if err != nil {
+		s.log("failed to allocate relay socket: %v", err)
+		return errorResponse(msg, codeInsufficientCapacity, "Insufficient Capacity")
+	}
+
+	lifetime := s.lifetime(msg)
+	alloc := &allocation{
+		client:       client,
+		relay:        relayConn,
+		expiresAt:    time.Now().Add(lifetime),
+		permissions:  make(map[string]time.Time),
+		channels:     make(map[uint16]*channelBinding),
+		peerChannels: make(map[string]uint16),
+	}
+	s.allocations[client.String()] = alloc
+
+	go s.relayLoop(alloc)
+
+	relayAddr := &net.UDPAddr{IP: s.relayIP, Port: relayConn.LocalAddr().(*net.UDPAddr).Port}
+	s.log("allocated %s for %s (lifetime %v)", relayAddr, client, lifetime)
+
+	response := &stun.Message{Type: stun.TypeAllocateSuccess, TransactionID: msg.TransactionID}
+	response.AddAttribute(stun.EncodeXORAddress(stun.AttrXORRelayedAddress, relayAddr, msg.TransactionID))
+	response.AddAttribute(stun.EncodeXORMappedAddress(client, msg.TransactionID))
+	response.AddAttribute(stun.EncodeLifetime(lifetime))
+	return response
+}
+
+// overQuota reports whether another allocation would exceed MaxAllocations
+// or MaxAllocationsPerIP for ip. Callers must hold s.mu.
+func (s *Server) overQuota(ip net.IP) bool {
+	if s.MaxAllocations > 0 && len(s.allocations) >= s.MaxAllocations {
+		return true
+	}
+	if s.MaxAllocationsPerIP <= 0 {
+		return false
+	}
+
+	count := 0
+	for _, alloc := range s.allocations {
+		if alloc.client.IP.Equal(ip) {
+			count++
+		}
+	}
+	return count >= s.MaxAllocationsPerIP
+}
+
+// handleRefresh extends or (with a zero lifetime) deletes an allocation
+func (s *Server) handleRefresh(msg *stun.Message, client *net.UDPAddr) *stun.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alloc, exists := s.allocations[client.String()]
+	if !exists {
+		return errorResponse(msg, codeAllocationMismatch, "Allocation Mismatch")
+	}
+
+	// A zero LIFETIME deletes the allocation; anything else is treated as
+	// on Allocate
+	var lifetime time.Duration
+	if requested, found := requestedLifetime(msg); found && requested == 0 {
+		alloc.relay.Close()
+		delete(s.allocations, client.String())
+		s.log("released allocation for %s", client)
+	} else {
+		lifetime = s.lifetime(msg)
+		alloc.expiresAt = time.Now().Add(lifetime)
+	}
+
+	response := &stun.Message{Type: stun.TypeRefreshSuccess, TransactionID: msg.TransactionID}
+	response.AddAttribute(stun.EncodeLifetime(lifetime))
+	return response
+}
+
+// handleCreatePermission installs a permission for every XOR-PEER-ADDRESS
+func (s *Server) handleCreatePermission(msg *stun.Message, client *net.UDPAddr) *stun.Message {
+	var peers []*net.UDPAddr
+	for i := range msg.Attributes {
+		if msg.Attributes[i].Type != stun.AttrXORPeerAddress {
+			continue
+		}
+		peer, err := stun.DecodeXORAddress(&msg.Attributes[i], msg.TransactionID)
+		if err != nil {
+			return errorResponse(msg, codeBadRequest, "Bad Request")
+		}
+		peers = append(peers, peer)
+	}
+	if len(peers) == 0 {
+		return errorResponse(msg, codeBadRequest, "Bad Request")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alloc, exists := s.allocations[client.String()]
+	if !exists {
+		return errorResponse(msg, codeAllocationMismatch, "Allocation Mismatch")
+	}
+
+	expiresAt := time.Now().Add(s.PermissionLifetime)
+	for _, peer := range peers {
+		alloc.permissions[peer.IP.String()] = expiresAt
+	}
+
+	return &stun.Message{Type: stun.TypeCreatePermissionSuccess, TransactionID: msg.TransactionID}
+}
+
+// handleChannelBind binds a channel number to a peer, also refreshing the
+// peer's permission
+func (s *Server) handleChannelBind(msg *stun.Message, client *net.UDPAddr) *stun.Message {
+	channelAttr, found := msg.GetAttribute(stun.AttrChannelNumber)
+	if !found {
+		return errorResponse(msg, codeBadRequest, "Bad Request")
+	}
+	channel, err := stun.DecodeChannelNumber(channelAttr)
+	if err != nil || channel < stun.MinChannelNumber || channel > stun.MaxChannelNumber {
+		return errorResponse(msg, codeBadRequest, "Bad Request")
+	}
+
+	peerAttr, found := msg.GetAttribute(stun.AttrXORPeerAddress)
+	if !found {
+		return errorResponse(msg, codeBadRequest, "Bad Request")
+	}
+	peer, err := stun.DecodeXORAddress(peerAttr, msg.TransactionID)
+	if err != nil {
+		return errorResponse(msg, codeBadRequest, "Bad Request")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alloc, exists := s.allocations[client.String()]
+	if !exists {
+		return errorResponse(msg, codeAllocationMismatch, "Allocation Mismatch")
+	}
+
+	// A channel and a peer may only be bound to each other
+	if binding, bound := alloc.channels[channel]; bound && binding.peer.String() != peer.String() {
+		return errorResponse(msg, codeBadRequest, "Bad Request")
+	}
+	if existing, bound := alloc.peerChannels[peer.String()]; bound && existing != channel {
+		return errorResponse(msg, codeBadRequest, "Bad Request")
+	}
+
+	alloc.channels[channel] = &channelBinding{
+		peer:      peer,
+		expiresAt: time.Now().Add(s.ChannelLifetime),
+	}
+	alloc.peerChannels[peer.String()] = channel
+	alloc.permissions[peer.IP.String()] = time.Now().Add(s.PermissionLifetime)
+
+	return &stun.Message{Type: stun.TypeChannelBindSuccess, TransactionID: msg.TransactionID}
+}
+
+// handleSend relays the DATA of a Send indication to a permitted peer
+func (s *Server) handleSend(msg *stun.Message, client *net.UDPAddr) {
+	peerAttr, found := msg.GetAttribute(stun.AttrXORPeerAddress)
+	if !found {
+		return
+	}
+	peer, err := stun.DecodeXORAddress(peerAttr, msg.TransactionID)
+	if err != nil {
+		return
+	}
+	dataAttr, found := msg.GetAttribute(stun.AttrData)
+	if !found {
+		return
+	}
+
+	s.mu.Lock()
+	alloc, exists := s.allocations[client.String()]
+	permitted := exists && alloc.permitted(peer, time.Now())
+	s.mu.Unlock()
+
+	if permitted {
+		alloc.relay.WriteToUDP(dataAttr.Value, peer)
+	}
+}
+
+// handleChannelData relays a ChannelData payload to the channel's peer
+func (s *Server) handleChannelData(client *net.UDPAddr, channel uint16, data []byte) {
+	s.mu.Lock()
+	alloc, exists := s.allocations[client.String()]
+	var peer *net.UDPAddr
+	if exists {
+		if binding, bound := alloc.channels[channel]; bound && alloc.permitted(binding.peer, time.Now()) {
+			peer = binding.peer
+		}
+	}
+	s.mu.Unlock()
+
+	if peer != nil {
+		alloc.relay.WriteToUDP(data, peer)
+	}
+}
+
+// relayLoop forwards datagrams from peers to the client, as ChannelData
+// when the peer has a channel and in Data indications otherwise. Exits when
+// the relay socket is closed.
+func (s *Server) relayLoop(alloc *allocation) {
+	buf := make([]byte, 65536)
+	for {
+		n, peer, err := alloc.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		permitted := alloc.permitted(peer, time.Now())
+		channel, bound := alloc.peerChannels[peer.String()]
+		s.mu.Unlock()
+
+		if !permitted {
+			continue
+		}
+
+		if bound {
+			s.conn.WriteToUDP(stun.EncodeChannelData(channel, buf[:n]), alloc.client)
+			continue
+		}
+
+		indication, err := stun.NewMessage(stun.TypeDataIndication)
+		if err != nil {
+			continue
+		}
+		indication.AddAttribute(stun.EncodeXORAddress(stun.AttrXORPeerAddress, peer, indication.TransactionID))
+		indication.AddAttribute(stun.EncodeData(buf[:n]))
+
+		encoded, err := indication.Encode()
+		if err != nil {
+			continue
+		}
+		s.conn.WriteToUDP(encoded, alloc.client)
+	}
+}
+
+// permitted reports whether peer's IP has an unexpired permission.
+// Callers hold Server.mu.
+func (a *allocation) permitted(peer *net.UDPAddr, now time.Time) bool {
+	expiresAt, found := a.permissions[peer.IP.String()]
+	return found && now.Before(expiresAt)
+}
+
+// lifetime returns the allocation lifetime for a request (RFC 5766
+// sections 6.2 and 7.2): the requested LIFETIME capped at MaxLifetime but
+// no shorter than DefaultLifetime, or DefaultLifetime if absent
+func (s *Server) lifetime(msg *stun.Message) time.Duration {
+	lifetime := s.DefaultLifetime
+	if requested, found := requestedLifetime(msg); found && requested > lifetime {
+		lifetime = requested
+	}
+
+	if lifetime > s.MaxLifetime {
+		lifetime = s.MaxLifetime
+	}
+	return lifetime
+}
+
+// requestedLifetime returns the request's LIFETIME attribute, if any
+func requestedLifetime(msg *stun.Message) (time.Duration, bool) {
+	attr, found := msg.GetAttribute(stun.AttrLifetime)
+	if !found {
+		return 0, false
+	}
+	requested, err := stun.DecodeLifetime(attr)
+	if err != nil {
+		return 0, false
+	}
+	return requested, true
+}
+
+// errorResponse builds an error response carrying ERROR-CODE
+func errorResponse(request *stun.Message, code int, reason string) *stun.Message {
+	response := &stun.Message{
+		Type:          request.Type.ErrorResponseType(),
+		TransactionID: request.TransactionID,
+	}
+	response.AddAttribute(stun.EncodeErrorCode(code, reason))
+	return response
+}
+
+// log writes a log message if a logger is configured.
+func (s *Server) log(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf("[relay] "+format, args...)
+	}
+}
+
+// LocalAddr returns the address the server is listening on, or nil before
+// Serve is called.
+func (s *Server) LocalAddr() *net.UDPAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// AllocationCount returns the number of active allocations.
+func (s *Server) AllocationCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.allocations)
+}
@@ -0,0 +1,368 @@
+package relay
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	relayclient "github.com/saintparish4/altair/pkg/relay"
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// startTestServer runs a relay server on loopback for the duration of the test
+func startTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.Logger = nil
+	return startConfiguredServer(t, cfg)
+}
+
+// startConfiguredServer is startTestServer with a caller-supplied config
+func startConfiguredServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := NewServer(cfg)
+
+	go server.Serve(conn)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	// Wait for Serve to take ownership of the socket
+	for server.LocalAddr() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	return server
+}
+
+func newTestClient(t *testing.T, server *Server) *relayclient.Client {
+	t.Helper()
+
+	config := relayclient.DefaultClientConfig(server.LocalAddr().String())
+	config.Timeout = time.Second
+
+	client, err := relayclient.NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func newTestPeer(t *testing.T) *net.UDPConn {
+	t.Helper()
+
+	peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("failed to create peer: %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	return peer
+}
+
+// exchange sends "ping" from the client to the peer via send, then "pong"
+// from the peer back through the relay
+func exchange(t *testing.T, client *relayclient.Client, peer *net.UDPConn, send func([]byte, *net.UDPAddr) error) {
+	t.Helper()
+
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+	if err := send([]byte("ping"), peerAddr); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("peer read failed: %v", err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Errorf("peer received %q, want %q", buf[:n], "ping")
+	}
+
+	relayAddr := client.Allocation().RelayAddr
+	if from.Port != relayAddr.Port {
+		t.Errorf("peer received from %s, want relay %s", from, relayAddr)
+	}
+
+	peer.WriteToUDP([]byte("pong"), relayAddr)
+
+	data, addr, err := client.Receive()
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if string(data) != "pong" {
+		t.Errorf("client received %q, want %q", data, "pong")
+	}
+	if addr.String() != peerAddr.String() {
+		t.Errorf("client received from %s, want %s", addr, peerAddr)
+	}
+}
+
+func TestServerAllocateAndRelay(t *testing.T) {
+	server := startTestServer(t)
+	client := newTestClient(t, server)
+	peer := newTestPeer(t)
+
+	allocation, err := client.Allocate(20 * time.Minute)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	if allocation.Lifetime != 20*time.Minute {
+		t.Errorf("Lifetime = %v, want 20m", allocation.Lifetime)
+	}
+	if allocation.ReflexiveAddr.Port != client.LocalAddr().Port {
+		t.Errorf("ReflexiveAddr = %s, want port %d", allocation.ReflexiveAddr, client.LocalAddr().Port)
+	}
+	if server.AllocationCount() != 1 {
+		t.Errorf("AllocationCount = %d, want 1", server.AllocationCount())
+	}
+
+	if err := client.CreatePermission(peer.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatalf("CreatePermission failed: %v", err)
+	}
+
+	exchange(t, client, peer, client.Send)
+}
+
+func TestServerChannelBind(t *testing.T) {
+	server := startTestServer(t)
+	client := newTestClient(t, server)
+	peer := newTestPeer(t)
+
+	if _, err := client.Allocate(5 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	// ChannelBind installs the permission itself
+	if _, err := client.ChannelBind(peer.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatalf("ChannelBind failed: %v", err)
+	}
+
+	exchange(t, client, peer, client.SendChannel)
+}
+
+func TestServerDropsWithoutPermission(t *testing.T) {
+	server := startTestServer(t)
+	client := newTestClient(t, server)
+	peer := newTestPeer(t)
+
+	allocation, err := client.Allocate(5 * time.Minute)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	// Peer -> client is dropped without a permission
+	peer.WriteToUDP([]byte("unsolicited"), allocation.RelayAddr)
+	if data, _, err := client.Receive(); err == nil {
+		t.Errorf("Receive should time out, got %q", data)
+	}
+
+	// Client -> peer is dropped too
+	if err := client.Send([]byte("hello"), peer.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	peer.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := peer.ReadFromUDP(make([]byte, 64)); err == nil {
+		t.Error("peer should not receive data without a permission")
+	}
+}
+
+func TestServerAllocationMismatch(t *testing.T) {
+	server := startTestServer(t)
+	client := newTestClient(t, server)
+
+	if _, err := client.Allocate(5 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	if _, err := client.Allocate(5 * time.Minute); err == nil {
+		t.Error("second Allocate from the same client should fail")
+	}
+}
+
+func TestServerRefreshAndRelease(t *testing.T) {
+	server := startTestServer(t)
+	client := newTestClient(t, server)
+
+	if _, err := client.Allocate(5 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	// Lifetimes are capped at MaxLifetime
+	if err := client.Refresh(24 * time.Hour); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if got := client.Allocation().Lifetime; got != server.MaxLifetime {
+		t.Errorf("Lifetime = %v, want %v", got, server.MaxLifetime)
+	}
+
+	// Close sends a zero-lifetime Refresh
+	client.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for server.AllocationCount() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server.AllocationCount() != 0 {
+		t.Errorf("AllocationCount = %d after Close, want 0", server.AllocationCount())
+	}
+}
+
+func TestServerCleanupExpired(t *testing.T) {
+	server := startTestServer(t)
+	client := newTestClient(t, server)
+
+	if _, err := client.Allocate(time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	if removed := server.cleanup(time.Now()); removed != 0 {
+		t.Errorf("cleanup removed %d live allocations", removed)
+	}
+
+	// Short requests get DefaultLifetime
+	if removed := server.cleanup(time.Now().Add(server.DefaultLifetime + time.Minute)); removed != 1 {
+		t.Errorf("cleanup removed %d allocations, want 1", removed)
+	}
+	if server.AllocationCount() != 0 {
+		t.Errorf("AllocationCount = %d, want 0", server.AllocationCount())
+	}
+}
+
+func TestServerLifetimeFloor(t *testing.T) {
+	server := startTestServer(t)
+
+	allocate := func(port int, lifetime time.Duration) time.Duration {
+		t.Helper()
+
+		request, err := stun.NewMessage(stun.TypeAllocateRequest)
+		if err != nil {
+			t.Fatalf("NewMessage failed: %v", err)
+		}
+		request.AddAttribute(stun.EncodeRequestedTransport(stun.ProtocolUDP))
+		request.AddAttribute(stun.EncodeLifetime(lifetime))
+
+		response := server.handleAllocate(request, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		if response.Type != stun.TypeAllocateSuccess {
+			t.Fatalf("response type = %s, want Allocate Success Response", response.Type)
+		}
+		attr, _ := response.GetAttribute(stun.AttrLifetime)
+		granted, _ := stun.DecodeLifetime(attr)
+		return granted
+	}
+
+	// Zero and tiny requests can't create an allocation that expires at once
+	if got := allocate(5000, 0); got != server.DefaultLifetime {
+		t.Errorf("LIFETIME 0 granted %v, want %v", got, server.DefaultLifetime)
+	}
+	if got := allocate(5001, 3*time.Second); got != server.DefaultLifetime {
+		t.Errorf("LIFETIME 3s granted %v, want %v", got, server.DefaultLifetime)
+	}
+	if server.AllocationCount() != 2 {
+		t.Errorf("AllocationCount = %d, want 2", server.AllocationCount())
+	}
+}
+
+func TestNewServerDefaultsZeroConfig(t *testing.T) {
+	server := NewServer(Config{Addr: "127.0.0.1:0"})
+
+	defaults := DefaultConfig()
+	if server.DefaultLifetime != defaults.DefaultLifetime || server.MaxLifetime != defaults.MaxLifetime {
+		t.Errorf("lifetimes = %v/%v, want %v/%v", server.DefaultLifetime, server.MaxLifetime, defaults.DefaultLifetime, defaults.MaxLifetime)
+	}
+	if server.PermissionLifetime != defaults.PermissionLifetime || server.ChannelLifetime != defaults.ChannelLifetime {
+		t.Errorf("permission/channel lifetimes = %v/%v, want defaults", server.PermissionLifetime, server.ChannelLifetime)
+	}
+	if server.CleanupInterval != defaults.CleanupInterval {
+		t.Errorf("CleanupInterval = %v, want %v", server.CleanupInterval, defaults.CleanupInterval)
+	}
+}
+
+func TestServerRejectsUnsupportedTransport(t *testing.T) {
+	server := startTestServer(t)
+
+	request, err := stun.NewMessage(stun.TypeAllocateRequest)
+	if err != nil {
+		t.Fatalf("NewMessage failed: %v", err)
+	}
+	request.AddAttribute(stun.EncodeRequestedTransport(6)) // TCP
+
+	response := server.handleAllocate(request, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000})
+	if response.Type != stun.TypeAllocateError {
+		t.Fatalf("response type = %s, want Allocate Error Response", response.Type)
+	}
+
+	attr, _ := response.GetAttribute(stun.AttrErrorCode)
+	if code, _, _ := stun.DecodeErrorCode(attr); code != codeUnsupportedTransport {
+		t.Errorf("error code = %d, want %d", code, codeUnsupportedTransport)
+	}
+}
+
+func TestServerAllocationQuota(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Logger = nil
+	cfg.MaxAllocations = 3
+	cfg.MaxAllocationsPerIP = 2
+	server := startConfiguredServer(t, cfg)
+
+	allocate := func(ip net.IP, port int) int {
+		t.Helper()
+
+		request, err := stun.NewMessage(stun.TypeAllocateRequest)
+		if err != nil {
+			t.Fatalf("NewMessage failed: %v", err)
+		}
+		request.AddAttribute(stun.EncodeRequestedTransport(stun.ProtocolUDP))
+
+		response := server.handleAllocate(request, &net.UDPAddr{IP: ip, Port: port})
+		if response.Type != stun.TypeAllocateError {
+			return 0
+		}
+		attr, _ := response.GetAttribute(stun.AttrErrorCode)
+		code, _, _ := stun.DecodeErrorCode(attr)
+		return code
+	}
+
+	first := net.IPv4(127, 0, 0, 1)
+	second := net.IPv4(127, 0, 0, 2)
+
+	if code := allocate(first, 5000); code != 0 {
+		t.Fatalf("first allocation failed with %d", code)
+	}
+	if code := allocate(first, 5001); code != 0 {
+		t.Fatalf("second allocation failed with %d", code)
+	}
+
+	// Per-IP cap
+	if code := allocate(first, 5002); code != codeAllocationQuota {
+		t.Errorf("third allocation from %s: code = %d, want %d", first, code, codeAllocationQuota)
+	}
+
+	// Total cap
+	if code := allocate(second, 5000); code != 0 {
+		t.Fatalf("allocation from %s failed with %d", second, code)
+	}
+	if code := allocate(net.IPv4(127, 0, 0, 3), 5000); code != codeAllocationQuota {
+		t.Errorf("allocation over the total cap: code = %d, want %d", code, codeAllocationQuota)
+	}
+
+	if server.AllocationCount() != 3 {
+		t.Errorf("AllocationCount = %d, want 3", server.AllocationCount())
+	}
+
+	// Freed allocations make room again
+	server.cleanup(time.Now().Add(time.Hour + time.Minute))
+	if code := allocate(first, 5002); code != 0 {
+		t.Errorf("allocation after cleanup failed with %d", code)
+	}
+}
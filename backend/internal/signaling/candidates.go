@@ -0,0 +1,121 @@
+package signaling
+
+import (
+	"sync"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+)
+
+// maxHeldCandidates bounds how many candidates are held per session and
+// target. Reaching it releases the batch early.
+const maxHeldCandidates = 32
+
+// candidateKey identifies one direction of a session: candidates for
+// targetID in sessionID.
+type candidateKey struct {
+	sessionID string
+	targetID  string
+}
+
+// candidateBuffer holds trickled CANDIDATE messages until their target has
+// been sent the session's OFFER (answerer) or ANSWER (offerer), then
+// releases them in the order they arrived.
+type candidateBuffer struct {
+	mu      sync.Mutex
+	ready   map[candidateKey]bool
+	pending map[candidateKey]*heldCandidates
+
+	// release delivers candidates whose hold expired before the
+	// description was forwarded
+	release func(targetID string, msgs []*protocol.Message)
+}
+
+type heldCandidates struct {
+	msgs  []*protocol.Message
+	timer *time.Timer
+}
+
+func newCandidateBuffer(release func(targetID string, msgs []*protocol.Message)) *candidateBuffer {
+	return &candidateBuffer{
+		ready:   make(map[candidateKey]bool),
+		pending: make(map[candidateKey]*heldCandidates),
+		release: release,
+	}
+}
+
+// add holds msg for up to hold unless the target is already ready. It
+// returns the messages to send now, in order.
+func (b *candidateBuffer) add(key candidateKey, msg *protocol.Message, hold time.Duration) []*protocol.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if hold <= 0 || b.ready[key] {
+		return []*protocol.Message{msg}
+	}
+
+	held := b.pending[key]
+	if held == nil {
+		held = &heldCandidates{}
+		held.timer = time.AfterFunc(hold, func() { b.expire(key, held) })
+		b.pending[key] = held
+	}
+	held.msgs = append(held.msgs, msg)
+
+	if len(held.msgs) < maxHeldCandidates {
+		return nil
+	}
+
+	// Too many to keep holding; release what we have
+	held.timer.Stop()
+	delete(b.pending, key)
+	return held.msgs
+}
+
+// markReady records that key's target has the session description and
+// returns any held candidates to send after it.
+func (b *candidateBuffer) markReady(key candidateKey) []*protocol.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ready[key] = true
+
+	held := b.pending[key]
+	if held == nil {
+		return nil
+	}
+	held.timer.Stop()
+	delete(b.pending, key)
+	return held.msgs
+}
+
+// expire releases candidates whose description never arrived in time
+func (b *candidateBuffer) expire(key candidateKey, held *heldCandidates) {
+	b.mu.Lock()
+	if b.pending[key] != held {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	b.mu.Unlock()
+
+	b.release(key.targetID, held.msgs)
+}
+
+// forget drops all state for sessions targeting peerID
+func (b *candidateBuffer) forget(peerID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key := range b.ready {
+		if key.targetID == peerID {
+			delete(b.ready, key)
+		}
+	}
+	for key, held := range b.pending {
+		if key.targetID == peerID {
+			held.timer.Stop()
+			delete(b.pending, key)
+		}
+	}
+}
@@ -15,12 +15,24 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// Register the adapter so NewServer wires it up when Config.EnableWebSocket
+// is set.
+func init() {
+	newBuiltinUpgrader = func(compress bool) Upgrader {
+		upgrader := NewGorillaUpgrader()
+		upgrader.EnableCompression = compress
+		return upgrader
+	}
+}
+
 // GorillaUpgrader adapts websocket.Upgrader to our Upgrader interface.
 type GorillaUpgrader struct {
 	*websocket.Upgrader
 }
 
 // NewGorillaUpgrader creates a new GorillaUpgrader with sensible defaults.
+// Set EnableCompression to negotiate permessage-deflate; messages to
+// clients that accept it are then compressed transparently.
 func NewGorillaUpgrader() *GorillaUpgrader {
 	return &GorillaUpgrader{
 		Upgrader: &websocket.Upgrader{
@@ -0,0 +1,63 @@
+//go:build websocket
+
+package signaling
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+)
+
+func TestNewServerWiresGorillaUpgrader(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Logger = nil
+	server := NewServer(cfg)
+
+	if _, ok := server.Handler().upgrader.(*GorillaUpgrader); !ok {
+		t.Errorf("upgrader = %T, want *GorillaUpgrader", server.Handler().upgrader)
+	}
+
+	cfg.EnableWebSocket = false
+	server = NewServer(cfg)
+	if server.Handler().upgrader != nil {
+		t.Errorf("upgrader = %T, want nil with EnableWebSocket off", server.Handler().upgrader)
+	}
+}
+
+func TestGorillaUpgraderCompression(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		cfg := DefaultConfig()
+		cfg.Logger = nil
+		cfg.EnableCompression = enabled
+		server := NewServer(cfg)
+
+		ts := httptest.NewServer(server.Handler())
+		url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+		dialer := websocket.Dialer{EnableCompression: true}
+		conn, resp, err := dialer.Dial(url, nil)
+		if err != nil {
+			ts.Close()
+			t.Fatalf("Dial failed: %v", err)
+		}
+
+		extensions := resp.Header.Get("Sec-WebSocket-Extensions")
+		if negotiated := strings.Contains(extensions, "permessage-deflate"); negotiated != enabled {
+			t.Errorf("EnableCompression=%v: negotiated extensions %q", enabled, extensions)
+		}
+
+		// The welcome ACK must decode whether or not it was compressed
+		var welcome protocol.Message
+		if err := conn.ReadJSON(&welcome); err != nil {
+			t.Errorf("EnableCompression=%v: reading welcome failed: %v", enabled, err)
+		} else if welcome.Type != protocol.MessageTypeAck {
+			t.Errorf("EnableCompression=%v: expected ACK, got %s", enabled, welcome.Type)
+		}
+
+		conn.Close()
+		ts.Close()
+	}
+}
@@ -2,10 +2,15 @@ package signaling
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
 )
 
 // Upgrader abstracts WebSocket upgrade functionality.
@@ -14,11 +19,28 @@ type Upgrader interface {
 	Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (Conn, error)
 }
 
+// newBuiltinUpgrader creates the upgrader compiled in with -tags websocket
+// (see gorilla.go), offering permessage-deflate when compress is set. It is
+// nil in builds without WebSocket support.
+var newBuiltinUpgrader func(compress bool) Upgrader
+
+// WebSocketSupported reports whether this binary was built with a WebSocket
+// implementation (-tags websocket).
+func WebSocketSupported() bool {
+	return newBuiltinUpgrader != nil
+}
+
+// TokenValidator checks a client's token and returns the peer ID to assign.
+// ok is false for invalid tokens. An empty peerID gets a random ID instead.
+type TokenValidator func(token string) (peerID string, ok bool)
+
 // Handler processes WebSocket connections and signaling messages.
 type Handler struct {
-	registry *Registry
-	rooms    *RoomManager
-	upgrader Upgrader
+	registry   *Registry
+	rooms      *RoomManager
+	upgrader   Upgrader
+	metrics    *Metrics
+	candidates *candidateBuffer
 
 	// Configuration
 	ReadTimeout  time.Duration
@@ -26,22 +48,46 @@ type Handler struct {
 	PingInterval time.Duration
 	PongWait     time.Duration
 
-	// Logging
-	Logger *log.Logger
+	// Authentication (optional). When set, every connection must present a
+	// token via the "token" query parameter, an "Authorization: Bearer"
+	// header, or an AUTH first message.
+	TokenValidator TokenValidator
+
+	// TURN credential issuing (optional, see TURNConfig)
+	TURN *TURNConfig
+
+	// CandidateHold buffers CANDIDATE messages carrying a session_id until
+	// the target has been sent that session's OFFER or ANSWER, for at most
+	// this long. Zero forwards candidates immediately.
+	CandidateHold time.Duration
+
+	// Version and InstanceID identify this server in the welcome ACK.
+	// InstanceID defaults to a random ID.
+	Version    string
+	InstanceID string
+
+	// Logging. SLogger takes precedence; Logger receives the same records
+	// flattened to "msg key=value" lines.
+	Logger  *log.Logger
+	SLogger *slog.Logger
 }
 
 // NewHandler creates a new WebSocket handler.
 // Pass nil for upgrader to create a handler without WebSocket support (for testing).
 func NewHandler(registry *Registry, rooms *RoomManager) *Handler {
-	return &Handler{
+	h := &Handler{
 		registry:     registry,
 		rooms:        rooms,
+		metrics:      NewMetrics(),
 		ReadTimeout:  60 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		PingInterval: 30 * time.Second,
 		PongWait:     60 * time.Second,
 		Logger:       log.Default(),
+		InstanceID:   generatePeerID(),
 	}
+	h.candidates = newCandidateBuffer(h.sendHeldCandidates)
+	return h
 }
 
 // SetUpgrader sets the WebSocket upgrader.
@@ -56,21 +102,57 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Reject bad tokens on the request before upgrading
+	token := requestToken(r)
+	peerID := ""
+	if h.TokenValidator != nil && token != "" {
+		id, ok := h.TokenValidator(token)
+		if !ok {
+			h.log(slog.LevelWarn, "rejected connection", "reason", "invalid token")
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		peerID = id
+	}
+
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		h.log("upgrade error: %v", err)
+		h.log(slog.LevelError, "websocket upgrade failed", "error", err)
 		return
 	}
 
-	// Create and register peer
-	peer := NewPeer("", conn)
-	peer = h.registry.Register(peer)
-	h.log("peer %s connected", peer.ID)
+	peer := NewPeer(peerID, conn)
+	peer.writeTimeout = h.WriteTimeout
+
+	// Browsers can't set headers on WebSocket requests, so without a
+	// token on the request the first message must be AUTH
+	if h.TokenValidator != nil && token == "" {
+		id, err := h.authenticate(peer)
+		if err != nil {
+			h.log(slog.LevelWarn, "rejected connection", "error", err)
+			peer.SendError(protocol.ErrorCodeUnauthorized, err.Error())
+			peer.Close()
+			return
+		}
+		peer.ID = id
+	}
+
+	// Register peer, keeping an authenticated ID as-is
+	if peer.ID == "" {
+		peer = h.registry.Register(peer)
+	} else if !h.registry.RegisterWithID(peer) {
+		h.log(slog.LevelWarn, "rejected connection", "reason", "peer already connected", "peer_id", peer.ID)
+		peer.SendError(protocol.ErrorCodeUnauthorized, "peer ID already connected")
+		peer.Close()
+		return
+	}
+	h.metrics.connectionOpened()
+	h.log(slog.LevelInfo, "peer connected", "peer_id", peer.ID)
 
 	// Send welcome message with assigned peer ID
-	welcome := NewMessage(MessageTypeAck).
+	welcome := protocol.NewMessage(protocol.MessageTypeAck).
 		WithPeerID(peer.ID).
-		WithPayload(AckPayload{Message: "connected"})
+		WithPayload(protocol.AckPayload{Message: "connected", Server: h.serverInfo()})
 	peer.Send(welcome)
 
 	// Handle connection lifecycle
@@ -92,6 +174,49 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.readLoop(peer)
 }
 
+// requestToken extracts a token from the "token" query parameter or a
+// bearer Authorization header.
+func requestToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	return ""
+}
+
+// authenticate reads the peer's first message, which must be AUTH with a
+// valid token, and returns the peer ID from the validator.
+func (h *Handler) authenticate(peer *Peer) (string, error) {
+	conn := peer.Connection()
+	conn.SetReadDeadline(time.Now().Add(h.ReadTimeout))
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return "", fmt.Errorf("read auth message: %w", err)
+	}
+
+	var msg protocol.Message
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type != protocol.MessageTypeAuth {
+		return "", fmt.Errorf("first message must be %s", protocol.MessageTypeAuth)
+	}
+
+	var payload protocol.AuthPayload
+	if err := msg.ParsePayload(&payload); err != nil || payload.Token == "" {
+		return "", fmt.Errorf("token is required")
+	}
+
+	peerID, ok := h.TokenValidator(payload.Token)
+	if !ok {
+		return "", fmt.Errorf("invalid token")
+	}
+
+	return peerID, nil
+}
+
 // readLoop reads and processes messages from a peer.
 func (h *Handler) readLoop(peer *Peer) {
 	conn := peer.Connection()
@@ -101,16 +226,16 @@ func (h *Handler) readLoop(peer *Peer) {
 		if err != nil {
 			// Connection closed or error - log and exit
 			if !peer.IsClosed() {
-				h.log("peer %s read error: %v", peer.ID, err)
+				h.log(slog.LevelWarn, "peer read failed", "peer_id", peer.ID, "error", err)
 			}
 			return
 		}
 
 		peer.UpdateLastSeen()
 
-		var msg Message
+		var msg protocol.Message
 		if err := json.Unmarshal(data, &msg); err != nil {
-			peer.SendError(ErrorCodeInvalidMessage, "invalid JSON")
+			peer.SendError(protocol.ErrorCodeInvalidMessage, "invalid JSON")
 			continue
 		}
 
@@ -118,7 +243,7 @@ func (h *Handler) readLoop(peer *Peer) {
 		msg.PeerID = peer.ID
 
 		if err := h.handleMessage(peer, &msg); err != nil {
-			h.log("peer %s message error: %v", peer.ID, err)
+			h.log(slog.LevelWarn, "message handling failed", "peer_id", peer.ID, "msg_type", msg.Type, "error", err)
 		}
 	}
 }
@@ -134,9 +259,7 @@ func (h *Handler) pingLoop(peer *Peer) {
 			return
 		}
 
-		conn := peer.Connection()
-		conn.SetWriteDeadline(time.Now().Add(h.WriteTimeout))
-		if err := conn.WriteMessage(PingMessage, nil); err != nil {
+		if err := peer.Ping(); err != nil {
 			return
 		}
 	}
@@ -151,7 +274,7 @@ func (h *Handler) handleDisconnect(peer *Peer) {
 			room.Remove(peer.ID)
 
 			// Notify remaining peers
-			notification := NewMessage(MessageTypePeerLeft).
+			notification := protocol.NewMessage(protocol.MessageTypePeerLeft).
 				WithPeerID(peer.ID).
 				WithRoomID(roomID)
 			room.Broadcast(notification)
@@ -161,45 +284,61 @@ func (h *Handler) handleDisconnect(peer *Peer) {
 	// Close connection and unregister
 	peer.Close()
 	h.registry.Unregister(peer.ID)
-	h.log("peer %s disconnected", peer.ID)
+	h.candidates.forget(peer.ID)
+	h.log(slog.LevelInfo, "peer disconnected", "peer_id", peer.ID)
 }
 
 // handleMessage routes messages to appropriate handlers.
-func (h *Handler) handleMessage(peer *Peer, msg *Message) error {
+func (h *Handler) handleMessage(peer *Peer, msg *protocol.Message) error {
+	defer h.metrics.observeMessage(msg.Type, time.Now())
+
 	switch msg.Type {
-	case MessageTypeJoin:
+	case protocol.MessageTypeJoin:
 		return h.handleJoin(peer, msg)
-	case MessageTypeLeave:
+	case protocol.MessageTypeLeave:
 		return h.handleLeave(peer, msg)
-	case MessageTypeDiscover:
+	case protocol.MessageTypeDiscover:
 		return h.handleDiscover(peer, msg)
-	case MessageTypeOffer:
+	case protocol.MessageTypeOffer:
 		return h.handleOffer(peer, msg)
-	case MessageTypeAnswer:
+	case protocol.MessageTypeAnswer:
 		return h.handleAnswer(peer, msg)
-	case MessageTypeCandidate:
+	case protocol.MessageTypeCandidate:
 		return h.handleCandidate(peer, msg)
-	case MessageTypeKeepAlive:
+	case protocol.MessageTypeBroadcast:
+		return h.handleBroadcast(peer, msg)
+	case protocol.MessageTypeKeepAlive:
 		return h.handleKeepAlive(peer, msg)
+	case protocol.MessageTypeTURNCredentials:
+		return h.handleTURNCredentials(peer, msg)
+	case protocol.MessageTypeAuth:
+		return peer.ReplyError(msg, protocol.ErrorCodeInvalidMessage, "AUTH is only valid as the first message")
 	default:
-		return peer.SendError(ErrorCodeInvalidMessage, fmt.Sprintf("unknown message type: %s", msg.Type))
+		return peer.ReplyError(msg, protocol.ErrorCodeInvalidMessage, fmt.Sprintf("unknown message type: %s", msg.Type))
 	}
 }
 
 // handleJoin processes a room join request.
-func (h *Handler) handleJoin(peer *Peer, msg *Message) error {
+func (h *Handler) handleJoin(peer *Peer, msg *protocol.Message) error {
 	roomID := msg.RoomID
 	if roomID == "" {
-		return peer.SendError(ErrorCodeInvalidMessage, "room_id is required")
+		return peer.ReplyError(msg, protocol.ErrorCodeInvalidMessage, "room_id is required")
 	}
 
 	// Parse optional payload
-	var payload JoinPayload
+	var payload protocol.JoinPayload
 	if msg.Payload != nil {
 		msg.ParsePayload(&payload)
 	}
 
+	if err := protocol.ValidateMetadata(payload.Metadata); err != nil {
+		return peer.ReplyError(msg, protocol.ErrorCodeInvalidMessage, err.Error())
+	}
+
 	// Update peer info
+	if payload.Metadata != nil {
+		peer.SetMetadata(payload.Metadata)
+	}
 	if payload.DisplayName != "" {
 		peer.SetDisplayName(payload.DisplayName)
 	}
@@ -209,30 +348,36 @@ func (h *Handler) handleJoin(peer *Peer, msg *Message) error {
 
 	// Check if already in this room
 	if peer.GetRoomID() == roomID {
-		return peer.SendError(ErrorCodeAlreadyInRoom, "already in this room")
+		return peer.ReplyError(msg, protocol.ErrorCodeAlreadyInRoom, "already in this room")
 	}
 
 	// Join room
-	room, err := h.rooms.JoinRoom(peer, roomID)
+	room, err := h.rooms.JoinRoomWithPassword(peer, roomID, payload.Password)
+	if errors.Is(err, ErrWrongPassword) {
+		return peer.ReplyError(msg, protocol.ErrorCodeUnauthorized, err.Error())
+	}
+	if errors.Is(err, ErrRoomNotFound) {
+		return peer.ReplyError(msg, protocol.ErrorCodeRoomNotFound, err.Error())
+	}
 	if err != nil {
-		return peer.SendError(ErrorCodeRoomFull, err.Error())
+		return peer.ReplyError(msg, protocol.ErrorCodeRoomFull, err.Error())
 	}
 
-	h.log("peer %s joined room %s", peer.ID, roomID)
+	h.log(slog.LevelInfo, "peer joined room", "peer_id", peer.ID, "room_id", roomID)
 
 	// Send ACK with peer list to joining peer
-	ack := NewMessage(MessageTypeAck).
+	ack := protocol.NewMessage(protocol.MessageTypeAck).
 		WithPeerID(peer.ID).
 		WithRoomID(roomID).
 		WithRequestID(msg.RequestID).
-		WithPayload(PeerListPayload{
+		WithPayload(protocol.PeerListPayload{
 			RoomID: roomID,
 			Peers:  room.PeerInfos(),
 		})
 	peer.Send(ack)
 
 	// Notify other peers in room
-	notification := NewMessage(MessageTypePeerJoined).
+	notification := protocol.NewMessage(protocol.MessageTypePeerJoined).
 		WithPeerID(peer.ID).
 		WithRoomID(roomID).
 		WithPayload(peer.Info())
@@ -242,10 +387,10 @@ func (h *Handler) handleJoin(peer *Peer, msg *Message) error {
 }
 
 // handleLeave processes a room leave request.
-func (h *Handler) handleLeave(peer *Peer, msg *Message) error {
+func (h *Handler) handleLeave(peer *Peer, msg *protocol.Message) error {
 	roomID := peer.GetRoomID()
 	if roomID == "" {
-		return peer.SendError(ErrorCodeNotInRoom, "not in any room")
+		return peer.ReplyError(msg, protocol.ErrorCodeNotInRoom, "not in any room")
 	}
 
 	room := h.rooms.Get(roomID)
@@ -253,43 +398,43 @@ func (h *Handler) handleLeave(peer *Peer, msg *Message) error {
 		room.Remove(peer.ID)
 
 		// Notify remaining peers
-		notification := NewMessage(MessageTypePeerLeft).
+		notification := protocol.NewMessage(protocol.MessageTypePeerLeft).
 			WithPeerID(peer.ID).
 			WithRoomID(roomID)
 		room.Broadcast(notification)
 	}
 
-	h.log("peer %s left room %s", peer.ID, roomID)
+	h.log(slog.LevelInfo, "peer left room", "peer_id", peer.ID, "room_id", roomID)
 
 	// Send ACK
-	ack := NewMessage(MessageTypeAck).
+	ack := protocol.NewMessage(protocol.MessageTypeAck).
 		WithPeerID(peer.ID).
 		WithRequestID(msg.RequestID).
-		WithPayload(AckPayload{Message: "left room"})
+		WithPayload(protocol.AckPayload{Message: "left room"})
 	return peer.Send(ack)
 }
 
 // handleDiscover processes a peer discovery request.
-func (h *Handler) handleDiscover(peer *Peer, msg *Message) error {
+func (h *Handler) handleDiscover(peer *Peer, msg *protocol.Message) error {
 	roomID := msg.RoomID
 	if roomID == "" {
 		roomID = peer.GetRoomID()
 	}
 
 	if roomID == "" {
-		return peer.SendError(ErrorCodeNotInRoom, "no room specified and not in any room")
+		return peer.ReplyError(msg, protocol.ErrorCodeNotInRoom, "no room specified and not in any room")
 	}
 
 	room := h.rooms.Get(roomID)
 	if room == nil {
-		return peer.SendError(ErrorCodeRoomNotFound, "room not found")
+		return peer.ReplyError(msg, protocol.ErrorCodeRoomNotFound, "room not found")
 	}
 
-	response := NewMessage(MessageTypePeerList).
+	response := protocol.NewMessage(protocol.MessageTypePeerList).
 		WithPeerID(peer.ID).
 		WithRoomID(roomID).
 		WithRequestID(msg.RequestID).
-		WithPayload(PeerListPayload{
+		WithPayload(protocol.PeerListPayload{
 			RoomID: roomID,
 			Peers:  room.PeerInfos(),
 		})
@@ -298,82 +443,211 @@ func (h *Handler) handleDiscover(peer *Peer, msg *Message) error {
 }
 
 // handleOffer forwards a connection offer to the target peer.
-func (h *Handler) handleOffer(peer *Peer, msg *Message) error {
+func (h *Handler) handleOffer(peer *Peer, msg *protocol.Message) error {
 	if msg.TargetID == "" {
-		return peer.SendError(ErrorCodeInvalidMessage, "target_id is required")
+		return peer.ReplyError(msg, protocol.ErrorCodeInvalidMessage, "target_id is required")
 	}
 
 	target := h.registry.Get(msg.TargetID)
 	if target == nil {
-		return peer.SendError(ErrorCodePeerNotFound, "target peer not found")
+		return peer.ReplyError(msg, protocol.ErrorCodePeerNotFound, "target peer not found")
 	}
 
 	// Forward offer to target
-	forward := NewMessage(MessageTypeOffer).
+	forward := protocol.NewMessage(protocol.MessageTypeOffer).
 		WithPeerID(peer.ID).
 		WithTargetID(msg.TargetID).
 		WithRequestID(msg.RequestID)
 	forward.Payload = msg.Payload
 
-	h.log("forwarding offer from %s to %s", peer.ID, msg.TargetID)
-	return target.Send(forward)
+	h.log(slog.LevelDebug, "forwarding message", "msg_type", msg.Type, "peer_id", peer.ID, "target_id", msg.TargetID)
+	if err := target.Send(forward); err != nil {
+		return err
+	}
+	h.recordEvent(peer, RoomEventOffer, msg.TargetID)
+
+	// Candidates the target was waiting on can follow the description now
+	if sessionID := payloadSessionID(msg.Payload); sessionID != "" {
+		for _, held := range h.candidates.markReady(candidateKey{sessionID, target.ID}) {
+			target.Send(held)
+		}
+	}
+	return nil
 }
 
 // handleAnswer forwards a connection answer to the target peer.
-func (h *Handler) handleAnswer(peer *Peer, msg *Message) error {
+func (h *Handler) handleAnswer(peer *Peer, msg *protocol.Message) error {
 	if msg.TargetID == "" {
-		return peer.SendError(ErrorCodeInvalidMessage, "target_id is required")
+		return peer.ReplyError(msg, protocol.ErrorCodeInvalidMessage, "target_id is required")
 	}
 
 	target := h.registry.Get(msg.TargetID)
 	if target == nil {
-		return peer.SendError(ErrorCodePeerNotFound, "target peer not found")
+		return peer.ReplyError(msg, protocol.ErrorCodePeerNotFound, "target peer not found")
 	}
 
 	// Forward answer to target
-	forward := NewMessage(MessageTypeAnswer).
+	forward := protocol.NewMessage(protocol.MessageTypeAnswer).
 		WithPeerID(peer.ID).
 		WithTargetID(msg.TargetID).
 		WithRequestID(msg.RequestID)
 	forward.Payload = msg.Payload
 
-	h.log("forwarding answer from %s to %s", peer.ID, msg.TargetID)
-	return target.Send(forward)
+	h.log(slog.LevelDebug, "forwarding message", "msg_type", msg.Type, "peer_id", peer.ID, "target_id", msg.TargetID)
+	if err := target.Send(forward); err != nil {
+		return err
+	}
+	h.recordEvent(peer, RoomEventAnswer, msg.TargetID)
+
+	// Candidates the target was waiting on can follow the description now
+	if sessionID := payloadSessionID(msg.Payload); sessionID != "" {
+		for _, held := range h.candidates.markReady(candidateKey{sessionID, target.ID}) {
+			target.Send(held)
+		}
+	}
+	return nil
+}
+
+// recordEvent adds an event to the history of peer's room, if it is in one
+func (h *Handler) recordEvent(peer *Peer, eventType RoomEventType, targetID string) {
+	if room := h.rooms.Get(peer.GetRoomID()); room != nil {
+		room.RecordEvent(eventType, peer.ID, targetID)
+	}
 }
 
 // handleCandidate forwards an ICE candidate to the target peer.
-func (h *Handler) handleCandidate(peer *Peer, msg *Message) error {
+func (h *Handler) handleCandidate(peer *Peer, msg *protocol.Message) error {
 	if msg.TargetID == "" {
-		return peer.SendError(ErrorCodeInvalidMessage, "target_id is required")
+		return peer.ReplyError(msg, protocol.ErrorCodeInvalidMessage, "target_id is required")
 	}
 
 	target := h.registry.Get(msg.TargetID)
 	if target == nil {
-		return peer.SendError(ErrorCodePeerNotFound, "target peer not found")
+		return peer.ReplyError(msg, protocol.ErrorCodePeerNotFound, "target peer not found")
 	}
 
 	// Forward candidate to target
-	forward := NewMessage(MessageTypeCandidate).
+	forward := protocol.NewMessage(protocol.MessageTypeCandidate).
 		WithPeerID(peer.ID).
 		WithTargetID(msg.TargetID).
 		WithRequestID(msg.RequestID)
 	forward.Payload = msg.Payload
 
-	return target.Send(forward)
+	sessionID := payloadSessionID(msg.Payload)
+	if sessionID == "" {
+		return target.Send(forward)
+	}
+
+	// Hold it until the target has the session's OFFER or ANSWER
+	for _, ready := range h.candidates.add(candidateKey{sessionID, target.ID}, forward, h.CandidateHold) {
+		if err := target.Send(ready); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendHeldCandidates delivers candidates whose hold expired.
+func (h *Handler) sendHeldCandidates(targetID string, msgs []*protocol.Message) {
+	target := h.registry.Get(targetID)
+	if target == nil {
+		return
+	}
+
+	for _, msg := range msgs {
+		target.Send(msg)
+	}
+}
+
+// payloadSessionID extracts the session_id shared by OFFER, ANSWER and
+// CANDIDATE payloads, or "" if there isn't one.
+func payloadSessionID(payload json.RawMessage) string {
+	var session struct {
+		SessionID string `json:"session_id"`
+	}
+	if len(payload) == 0 || json.Unmarshal(payload, &session) != nil {
+		return ""
+	}
+	return session.SessionID
+}
+
+// handleBroadcast relays a message to every other peer in the sender's room.
+func (h *Handler) handleBroadcast(peer *Peer, msg *protocol.Message) error {
+	roomID := peer.GetRoomID()
+	if roomID == "" {
+		return peer.ReplyError(msg, protocol.ErrorCodeNotInRoom, "not in any room")
+	}
+
+	room := h.rooms.Get(roomID)
+	if room == nil {
+		return peer.ReplyError(msg, protocol.ErrorCodeRoomNotFound, "room not found")
+	}
+
+	forward := protocol.NewMessage(protocol.MessageTypeBroadcast).
+		WithPeerID(peer.ID).
+		WithRoomID(roomID).
+		WithRequestID(msg.RequestID)
+	forward.Payload = msg.Payload
+
+	room.Broadcast(forward, peer.ID)
+	return nil
+}
+
+// serverInfo describes this server and the optional features it has
+// enabled, for the welcome ACK.
+func (h *Handler) serverInfo() *protocol.ServerInfo {
+	info := &protocol.ServerInfo{
+		Software:   protocol.ServerSoftware,
+		Version:    h.Version,
+		InstanceID: h.InstanceID,
+	}
+	if h.TokenValidator != nil {
+		info.Features = append(info.Features, protocol.FeatureAuth)
+	}
+	if h.TURN != nil && h.TURN.Secret != "" {
+		info.Features = append(info.Features, protocol.FeatureTURNCredentials)
+	}
+	if h.CandidateHold > 0 {
+		info.Features = append(info.Features, protocol.FeatureCandidateHold)
+	}
+	if h.rooms.AllowImplicitRooms {
+		info.Features = append(info.Features, protocol.FeatureImplicitRooms)
+	}
+	return info
+}
+
+// handleTURNCredentials issues TURN credentials so a peer that fails to
+// punch can fall back to relay.
+func (h *Handler) handleTURNCredentials(peer *Peer, msg *protocol.Message) error {
+	if h.TURN == nil || h.TURN.Secret == "" {
+		return peer.ReplyError(msg, protocol.ErrorCodeInternal, "TURN credentials are not configured")
+	}
+
+	response := protocol.NewMessage(protocol.MessageTypeTURNCredentials).
+		WithPeerID(peer.ID).
+		WithRequestID(msg.RequestID).
+		WithPayload(h.TURN.Credentials(peer.ID, time.Now()))
+
+	h.log(slog.LevelInfo, "issued TURN credentials", "peer_id", peer.ID)
+	return peer.Send(response)
 }
 
 // handleKeepAlive processes a keep-alive message.
-func (h *Handler) handleKeepAlive(peer *Peer, msg *Message) error {
+func (h *Handler) handleKeepAlive(peer *Peer, msg *protocol.Message) error {
 	// Just update timestamp (already done in readLoop) and send ACK
-	ack := NewMessage(MessageTypeAck).
+	ack := protocol.NewMessage(protocol.MessageTypeAck).
 		WithPeerID(peer.ID).
 		WithRequestID(msg.RequestID)
 	return peer.Send(ack)
 }
 
-// log writes a log message if a logger is configured.
-func (h *Handler) log(format string, args ...interface{}) {
-	if h.Logger != nil {
-		h.Logger.Printf("[signaling] "+format, args...)
-	}
+// Metrics returns the handler's metrics collector.
+func (h *Handler) Metrics() *Metrics {
+	return h.metrics
+}
+
+// log writes a structured log record if a logger is configured. args are
+// slog key-value pairs.
+func (h *Handler) log(level slog.Level, msg string, args ...any) {
+	logTo(h.SLogger, h.Logger, "[signaling] ", level, msg, args...)
 }
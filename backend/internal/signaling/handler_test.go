@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
 )
 
 func TestHandlerServeHTTPWithoutUpgrader(t *testing.T) {
@@ -36,7 +39,7 @@ func TestHandlerMessageTypes(t *testing.T) {
 
 	tests := []struct {
 		name     string
-		msg      *Message
+		msg      *protocol.Message
 		wantErr  bool
 		errCode  string
 		setup    func()
@@ -44,17 +47,17 @@ func TestHandlerMessageTypes(t *testing.T) {
 	}{
 		{
 			name: "join without room_id",
-			msg: &Message{
-				Type:   MessageTypeJoin,
+			msg: &protocol.Message{
+				Type:   protocol.MessageTypeJoin,
 				PeerID: "test-peer",
 			},
 			wantErr: true,
-			errCode: ErrorCodeInvalidMessage,
+			errCode: protocol.ErrorCodeInvalidMessage,
 		},
 		{
 			name: "join with room_id",
-			msg: &Message{
-				Type:   MessageTypeJoin,
+			msg: &protocol.Message{
+				Type:   protocol.MessageTypeJoin,
 				PeerID: "test-peer",
 				RoomID: "test-room",
 			},
@@ -70,8 +73,8 @@ func TestHandlerMessageTypes(t *testing.T) {
 		},
 		{
 			name: "join already in same room",
-			msg: &Message{
-				Type:   MessageTypeJoin,
+			msg: &protocol.Message{
+				Type:   protocol.MessageTypeJoin,
 				PeerID: "test-peer",
 				RoomID: "test-room",
 			},
@@ -79,24 +82,24 @@ func TestHandlerMessageTypes(t *testing.T) {
 				rooms.JoinRoom(peer, "test-room")
 			},
 			wantErr: true,
-			errCode: ErrorCodeAlreadyInRoom,
+			errCode: protocol.ErrorCodeAlreadyInRoom,
 		},
 		{
 			name: "discover without room",
-			msg: &Message{
-				Type:   MessageTypeDiscover,
+			msg: &protocol.Message{
+				Type:   protocol.MessageTypeDiscover,
 				PeerID: "test-peer",
 			},
 			setup: func() {
 				peer.SetRoomID("")
 			},
 			wantErr: true,
-			errCode: ErrorCodeNotInRoom,
+			errCode: protocol.ErrorCodeNotInRoom,
 		},
 		{
 			name: "discover with room",
-			msg: &Message{
-				Type:   MessageTypeDiscover,
+			msg: &protocol.Message{
+				Type:   protocol.MessageTypeDiscover,
 				PeerID: "test-peer",
 				RoomID: "discover-room",
 			},
@@ -107,69 +110,69 @@ func TestHandlerMessageTypes(t *testing.T) {
 		},
 		{
 			name: "leave without being in room",
-			msg: &Message{
-				Type:   MessageTypeLeave,
+			msg: &protocol.Message{
+				Type:   protocol.MessageTypeLeave,
 				PeerID: "test-peer",
 			},
 			setup: func() {
 				peer.SetRoomID("")
 			},
 			wantErr: true,
-			errCode: ErrorCodeNotInRoom,
+			errCode: protocol.ErrorCodeNotInRoom,
 		},
 		{
 			name: "offer without target_id",
-			msg: &Message{
-				Type:   MessageTypeOffer,
+			msg: &protocol.Message{
+				Type:   protocol.MessageTypeOffer,
 				PeerID: "test-peer",
 			},
 			wantErr: true,
-			errCode: ErrorCodeInvalidMessage,
+			errCode: protocol.ErrorCodeInvalidMessage,
 		},
 		{
 			name: "offer with nonexistent target",
-			msg: &Message{
-				Type:     MessageTypeOffer,
+			msg: &protocol.Message{
+				Type:     protocol.MessageTypeOffer,
 				PeerID:   "test-peer",
 				TargetID: "nonexistent",
 			},
 			wantErr: true,
-			errCode: ErrorCodePeerNotFound,
+			errCode: protocol.ErrorCodePeerNotFound,
 		},
 		{
 			name: "answer without target_id",
-			msg: &Message{
-				Type:   MessageTypeAnswer,
+			msg: &protocol.Message{
+				Type:   protocol.MessageTypeAnswer,
 				PeerID: "test-peer",
 			},
 			wantErr: true,
-			errCode: ErrorCodeInvalidMessage,
+			errCode: protocol.ErrorCodeInvalidMessage,
 		},
 		{
 			name: "candidate without target_id",
-			msg: &Message{
-				Type:   MessageTypeCandidate,
+			msg: &protocol.Message{
+				Type:   protocol.MessageTypeCandidate,
 				PeerID: "test-peer",
 			},
 			wantErr: true,
-			errCode: ErrorCodeInvalidMessage,
+			errCode: protocol.ErrorCodeInvalidMessage,
 		},
 		{
 			name: "keep_alive",
-			msg: &Message{
-				Type:   MessageTypeKeepAlive,
+			msg: &protocol.Message{
+				Type:   protocol.MessageTypeKeepAlive,
 				PeerID: "test-peer",
 			},
 			wantErr: false,
 		},
 		{
 			name: "unknown message type",
-			msg: &Message{
+			msg: &protocol.Message{
 				Type:   "UNKNOWN",
 				PeerID: "test-peer",
 			},
 			wantErr: true,
-			errCode: ErrorCodeInvalidMessage,
+			errCode: protocol.ErrorCodeInvalidMessage,
 		},
 	}
 
@@ -184,6 +187,7 @@ func TestHandlerMessageTypes(t *testing.T) {
 			}
 
 			err := handler.handleMessage(peer, tt.msg)
+			peer.Flush()
 
 			if tt.wantErr {
 				// Check that error was sent to peer
@@ -191,15 +195,15 @@ func TestHandlerMessageTypes(t *testing.T) {
 				if len(written) == 0 {
 					t.Fatal("expected error message to be sent")
 				}
-				var response Message
+				var response protocol.Message
 				if err := json.Unmarshal(written[len(written)-1], &response); err != nil {
 					t.Fatalf("failed to parse response: %v", err)
 				}
-				if response.Type != MessageTypeError {
+				if response.Type != protocol.MessageTypeError {
 					t.Errorf("expected error message, got %s", response.Type)
 				}
 				if tt.errCode != "" {
-					var errPayload ErrorPayload
+					var errPayload protocol.ErrorPayload
 					if err := response.ParsePayload(&errPayload); err == nil {
 						if errPayload.Code != tt.errCode {
 							t.Errorf("expected error code %s, got %s", tt.errCode, errPayload.Code)
@@ -232,8 +236,8 @@ func TestHandlerOfferForwarding(t *testing.T) {
 	registry.Register(peer2)
 
 	// Peer1 sends offer to Peer2
-	offer := &Message{
-		Type:     MessageTypeOffer,
+	offer := &protocol.Message{
+		Type:     protocol.MessageTypeOffer,
 		PeerID:   "peer1",
 		TargetID: "peer2",
 		Payload:  json.RawMessage(`{"endpoint":{"ip":"1.2.3.4","port":5678}}`),
@@ -243,6 +247,7 @@ func TestHandlerOfferForwarding(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to handle offer: %v", err)
 	}
+	peer2.Flush()
 
 	// Verify peer2 received the forwarded offer
 	written := mockConn2.GetWritten()
@@ -250,12 +255,12 @@ func TestHandlerOfferForwarding(t *testing.T) {
 		t.Fatal("peer2 should have received forwarded offer")
 	}
 
-	var forwarded Message
+	var forwarded protocol.Message
 	if err := json.Unmarshal(written[0], &forwarded); err != nil {
 		t.Fatalf("failed to parse forwarded message: %v", err)
 	}
 
-	if forwarded.Type != MessageTypeOffer {
+	if forwarded.Type != protocol.MessageTypeOffer {
 		t.Errorf("expected OFFER, got %s", forwarded.Type)
 	}
 	if forwarded.PeerID != "peer1" {
@@ -273,14 +278,14 @@ func TestHandlerJoinWithPayload(t *testing.T) {
 	registry.Register(peer)
 
 	// Join with display name and endpoint
-	joinPayload := JoinPayload{
+	joinPayload := protocol.JoinPayload{
 		DisplayName: "Alice",
-		Endpoint:    &Endpoint{IP: "1.2.3.4", Port: 5678},
+		Endpoint:    &protocol.Endpoint{IP: "1.2.3.4", Port: 5678},
 	}
 	payloadBytes, _ := json.Marshal(joinPayload)
 
-	msg := &Message{
-		Type:    MessageTypeJoin,
+	msg := &protocol.Message{
+		Type:    protocol.MessageTypeJoin,
 		PeerID:  "test-peer",
 		RoomID:  "test-room",
 		Payload: payloadBytes,
@@ -301,6 +306,72 @@ func TestHandlerJoinWithPayload(t *testing.T) {
 	}
 }
 
+func TestHandlerJoinMetadataInDiscover(t *testing.T) {
+	registry := NewRegistry()
+	rooms := NewRoomManager()
+	handler := NewHandler(registry, rooms)
+
+	alice := NewPeer("alice", NewMockConn())
+	registry.Register(alice)
+	bobConn := NewMockConn()
+	bob := NewPeer("bob", bobConn)
+	registry.Register(bob)
+
+	metadata := map[string]string{"avatar": "https://example.com/a.png", "protocol": "2"}
+	payload, _ := json.Marshal(protocol.JoinPayload{DisplayName: "Alice", Metadata: metadata})
+	if err := handler.handleMessage(alice, &protocol.Message{Type: protocol.MessageTypeJoin, RoomID: "lobby", Payload: payload}); err != nil {
+		t.Fatalf("alice join failed: %v", err)
+	}
+
+	// Metadata the caller keeps mutating must not leak into the peer
+	metadata["protocol"] = "3"
+
+	rooms.JoinRoom(bob, "lobby")
+	if err := handler.handleMessage(bob, &protocol.Message{Type: protocol.MessageTypeDiscover}); err != nil {
+		t.Fatalf("discover failed: %v", err)
+	}
+	bob.Flush()
+
+	reply := lastWritten(t, bobConn)
+	var list protocol.PeerListPayload
+	if err := reply.ParsePayload(&list); err != nil {
+		t.Fatalf("failed to parse peer list: %v", err)
+	}
+
+	for _, info := range list.Peers {
+		if info.PeerID != "alice" {
+			continue
+		}
+		if info.Metadata["avatar"] != "https://example.com/a.png" || info.Metadata["protocol"] != "2" {
+			t.Errorf("alice metadata = %v", info.Metadata)
+		}
+		return
+	}
+	t.Error("alice missing from peer list")
+}
+
+func TestHandlerJoinMetadataTooLarge(t *testing.T) {
+	registry := NewRegistry()
+	rooms := NewRoomManager()
+	handler := NewHandler(registry, rooms)
+
+	mockConn := NewMockConn()
+	peer := NewPeer("test-peer", mockConn)
+	registry.Register(peer)
+
+	metadata := map[string]string{"blob": strings.Repeat("x", protocol.MaxMetadataSize)}
+	payload, _ := json.Marshal(protocol.JoinPayload{Metadata: metadata})
+	handler.handleMessage(peer, &protocol.Message{Type: protocol.MessageTypeJoin, RoomID: "lobby", Payload: payload})
+	peer.Flush()
+
+	if msg := lastWritten(t, mockConn); msg.Type != protocol.MessageTypeError {
+		t.Errorf("expected ERROR, got %s", msg.Type)
+	}
+	if peer.GetRoomID() != "" {
+		t.Error("peer should not join with oversized metadata")
+	}
+}
+
 func TestHandlerLeaveRoom(t *testing.T) {
 	registry := NewRegistry()
 	rooms := NewRoomManager()
@@ -317,8 +388,8 @@ func TestHandlerLeaveRoom(t *testing.T) {
 	}
 
 	// Leave the room
-	msg := &Message{
-		Type:   MessageTypeLeave,
+	msg := &protocol.Message{
+		Type:   protocol.MessageTypeLeave,
 		PeerID: "test-peer",
 	}
 
@@ -351,8 +422,8 @@ func TestHandlerPeerJoinNotification(t *testing.T) {
 	peer2 := NewPeer("peer2", mockConn2)
 	registry.Register(peer2)
 
-	msg := &Message{
-		Type:   MessageTypeJoin,
+	msg := &protocol.Message{
+		Type:   protocol.MessageTypeJoin,
 		PeerID: "peer2",
 		RoomID: "test-room",
 	}
@@ -362,8 +433,7 @@ func TestHandlerPeerJoinNotification(t *testing.T) {
 		t.Fatalf("failed to join: %v", err)
 	}
 
-	// Give async broadcast time to complete
-	time.Sleep(10 * time.Millisecond)
+	peer1.Flush()
 
 	// Peer1 should receive PEER_JOINED notification
 	written := mockConn1.GetWritten()
@@ -371,12 +441,12 @@ func TestHandlerPeerJoinNotification(t *testing.T) {
 		t.Fatal("peer1 should receive notification")
 	}
 
-	var notification Message
+	var notification protocol.Message
 	if err := json.Unmarshal(written[0], &notification); err != nil {
 		t.Fatalf("failed to parse notification: %v", err)
 	}
 
-	if notification.Type != MessageTypePeerJoined {
+	if notification.Type != protocol.MessageTypePeerJoined {
 		t.Errorf("expected PEER_JOINED, got %s", notification.Type)
 	}
 	if notification.PeerID != "peer2" {
@@ -403,8 +473,8 @@ func TestHandlerDiscover(t *testing.T) {
 	peer := NewPeer("requester", mockConn)
 	registry.Register(peer)
 
-	msg := &Message{
-		Type:   MessageTypeDiscover,
+	msg := &protocol.Message{
+		Type:   protocol.MessageTypeDiscover,
 		PeerID: "requester",
 		RoomID: "test-room",
 	}
@@ -413,6 +483,7 @@ func TestHandlerDiscover(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to discover: %v", err)
 	}
+	peer.Flush()
 
 	// Check response
 	written := mockConn.GetWritten()
@@ -420,16 +491,16 @@ func TestHandlerDiscover(t *testing.T) {
 		t.Fatal("should receive peer list response")
 	}
 
-	var response Message
+	var response protocol.Message
 	if err := json.Unmarshal(written[0], &response); err != nil {
 		t.Fatalf("failed to parse response: %v", err)
 	}
 
-	if response.Type != MessageTypePeerList {
+	if response.Type != protocol.MessageTypePeerList {
 		t.Errorf("expected PEER_LIST, got %s", response.Type)
 	}
 
-	var payload PeerListPayload
+	var payload protocol.PeerListPayload
 	if err := response.ParsePayload(&payload); err != nil {
 		t.Fatalf("failed to parse payload: %v", err)
 	}
@@ -497,3 +568,539 @@ func TestMockUpgrader(t *testing.T) {
 		t.Errorf("expected 1 connection, got %d", len(upgrader.Connections))
 	}
 }
+
+// serveWithToken runs a connection through a handler that accepts only the
+// token "good-token" and assigns it the peer ID "alice".
+func serveWithToken(t *testing.T, registry *Registry, req *http.Request, conn *MockConn) *httptest.ResponseRecorder {
+	t.Helper()
+
+	handler := NewHandler(registry, NewRoomManager())
+	handler.Logger = nil
+	handler.TokenValidator = func(token string) (string, bool) {
+		return "alice", token == "good-token"
+	}
+
+	upgrader := NewMockUpgrader()
+	upgrader.SetNextConnection(conn)
+	handler.SetUpgrader(upgrader)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// firstWritten decodes the first message written to conn.
+func firstWritten(t *testing.T, conn *MockConn) protocol.Message {
+	t.Helper()
+
+	written := conn.GetWritten()
+	if len(written) == 0 {
+		t.Fatal("expected a message to be written")
+	}
+
+	var msg protocol.Message
+	if err := json.Unmarshal(written[0], &msg); err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	return msg
+}
+
+// lastWritten returns the most recent message written to conn
+func lastWritten(t *testing.T, conn *MockConn) protocol.Message {
+	t.Helper()
+
+	written := conn.GetWritten()
+	if len(written) == 0 {
+		t.Fatal("expected a message to be written")
+	}
+
+	var msg protocol.Message
+	if err := json.Unmarshal(written[len(written)-1], &msg); err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	return msg
+}
+
+func TestHandlerTokenAccepted(t *testing.T) {
+	requests := map[string]func() *http.Request{
+		"query param": func() *http.Request {
+			return httptest.NewRequest("GET", "/ws?token=good-token", nil)
+		},
+		"bearer header": func() *http.Request {
+			req := httptest.NewRequest("GET", "/ws", nil)
+			req.Header.Set("Authorization", "Bearer good-token")
+			return req
+		},
+	}
+
+	for name, newRequest := range requests {
+		t.Run(name, func(t *testing.T) {
+			conn := NewMockConn()
+			serveWithToken(t, NewRegistry(), newRequest(), conn)
+
+			welcome := firstWritten(t, conn)
+			if welcome.Type != protocol.MessageTypeAck {
+				t.Errorf("expected ACK, got %s", welcome.Type)
+			}
+			if welcome.PeerID != "alice" {
+				t.Errorf("expected peer ID from validator, got %s", welcome.PeerID)
+			}
+		})
+	}
+}
+
+func TestHandlerWelcomeServerInfo(t *testing.T) {
+	handler := NewHandler(NewRegistry(), NewRoomManager())
+	handler.Logger = nil
+	handler.Version = "1.2.3"
+	handler.InstanceID = "node-a"
+	handler.TURN = &TURNConfig{Secret: "secret"}
+
+	conn := NewMockConn()
+	upgrader := NewMockUpgrader()
+	upgrader.SetNextConnection(conn)
+	handler.SetUpgrader(upgrader)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ws", nil))
+
+	welcome := firstWritten(t, conn)
+	if welcome.Type != protocol.MessageTypeAck {
+		t.Fatalf("expected ACK, got %s", welcome.Type)
+	}
+
+	var ack protocol.AckPayload
+	if err := welcome.ParsePayload(&ack); err != nil {
+		t.Fatalf("failed to parse payload: %v", err)
+	}
+	if ack.Server == nil {
+		t.Fatal("expected server info in welcome")
+	}
+	if ack.Server.Software != protocol.ServerSoftware || ack.Server.Version != "1.2.3" || ack.Server.InstanceID != "node-a" {
+		t.Errorf("unexpected server info: %+v", ack.Server)
+	}
+	for _, feature := range []string{protocol.FeatureTURNCredentials, protocol.FeatureImplicitRooms} {
+		if !ack.Server.HasFeature(feature) {
+			t.Errorf("expected feature %s in %v", feature, ack.Server.Features)
+		}
+	}
+	for _, feature := range []string{protocol.FeatureAuth, protocol.FeatureCandidateHold} {
+		if ack.Server.HasFeature(feature) {
+			t.Errorf("unexpected feature %s in %v", feature, ack.Server.Features)
+		}
+	}
+}
+
+func TestNewHandlerInstanceID(t *testing.T) {
+	a := NewHandler(NewRegistry(), NewRoomManager())
+	b := NewHandler(NewRegistry(), NewRoomManager())
+	if a.InstanceID == "" || a.InstanceID == b.InstanceID {
+		t.Errorf("expected distinct random instance IDs, got %q and %q", a.InstanceID, b.InstanceID)
+	}
+}
+
+func TestHandlerTokenFirstMessage(t *testing.T) {
+	conn := NewMockConn()
+	auth, _ := json.Marshal(protocol.NewMessage(protocol.MessageTypeAuth).WithPayload(protocol.AuthPayload{Token: "good-token"}))
+	conn.EnqueueRead(auth)
+
+	serveWithToken(t, NewRegistry(), httptest.NewRequest("GET", "/ws", nil), conn)
+
+	welcome := firstWritten(t, conn)
+	if welcome.Type != protocol.MessageTypeAck || welcome.PeerID != "alice" {
+		t.Errorf("expected ACK for alice, got %s for %s", welcome.Type, welcome.PeerID)
+	}
+}
+
+func TestHandlerTokenRejected(t *testing.T) {
+	registry := NewRegistry()
+	conn := NewMockConn()
+
+	w := serveWithToken(t, registry, httptest.NewRequest("GET", "/ws?token=bad-token", nil), conn)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+	if len(conn.GetWritten()) != 0 {
+		t.Error("rejected request should not be upgraded")
+	}
+	if registry.Count() != 0 {
+		t.Errorf("expected no registered peers, got %d", registry.Count())
+	}
+}
+
+func TestHandlerTokenFirstMessageRejected(t *testing.T) {
+	tests := map[string]*protocol.Message{
+		"wrong token":   protocol.NewMessage(protocol.MessageTypeAuth).WithPayload(protocol.AuthPayload{Token: "bad-token"}),
+		"missing token": protocol.NewMessage(protocol.MessageTypeAuth),
+		"not auth":      protocol.NewMessage(protocol.MessageTypeJoin).WithRoomID("room"),
+	}
+
+	for name, first := range tests {
+		t.Run(name, func(t *testing.T) {
+			registry := NewRegistry()
+			conn := NewMockConn()
+			data, _ := json.Marshal(first)
+			conn.EnqueueRead(data)
+
+			serveWithToken(t, registry, httptest.NewRequest("GET", "/ws", nil), conn)
+
+			response := firstWritten(t, conn)
+			var payload protocol.ErrorPayload
+			response.ParsePayload(&payload)
+			if response.Type != protocol.MessageTypeError || payload.Code != protocol.ErrorCodeUnauthorized {
+				t.Errorf("expected UNAUTHORIZED error, got %s %s", response.Type, payload.Code)
+			}
+			if !conn.IsClosed() {
+				t.Error("connection should be closed")
+			}
+			if registry.Count() != 0 {
+				t.Errorf("expected no registered peers, got %d", registry.Count())
+			}
+		})
+	}
+}
+
+func TestHandlerTokenDuplicatePeerID(t *testing.T) {
+	registry := NewRegistry()
+	registry.peers["alice"] = &Peer{ID: "alice"}
+
+	conn := NewMockConn()
+	serveWithToken(t, registry, httptest.NewRequest("GET", "/ws?token=good-token", nil), conn)
+
+	response := firstWritten(t, conn)
+	if response.Type != protocol.MessageTypeError {
+		t.Errorf("expected ERROR, got %s", response.Type)
+	}
+	if !conn.IsClosed() {
+		t.Error("connection should be closed")
+	}
+}
+
+// joinWithPassword sends a JOIN for room-1 and returns the response.
+func joinWithPassword(t *testing.T, handler *Handler, peerID, password string) protocol.Message {
+	t.Helper()
+
+	conn := NewMockConn()
+	peer := NewPeer(peerID, conn)
+	handler.registry.Register(peer)
+
+	msg := protocol.NewMessage(protocol.MessageTypeJoin).
+		WithRoomID("room-1").
+		WithPayload(protocol.JoinPayload{Password: password})
+	if err := handler.handleMessage(peer, msg); err != nil {
+		t.Fatalf("handleMessage failed: %v", err)
+	}
+	peer.Flush()
+
+	return firstWritten(t, conn)
+}
+
+func TestHandlerJoinRoomPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(rooms *RoomManager)
+		password string
+		wantType protocol.MessageType
+		wantCode string
+	}{
+		{
+			name:     "correct password",
+			setup:    func(rooms *RoomManager) { rooms.CreateRoom("room-1", "1234", 0) },
+			password: "1234",
+			wantType: protocol.MessageTypeAck,
+		},
+		{
+			name:     "wrong password",
+			setup:    func(rooms *RoomManager) { rooms.CreateRoom("room-1", "1234", 0) },
+			password: "0000",
+			wantType: protocol.MessageTypeError,
+			wantCode: protocol.ErrorCodeUnauthorized,
+		},
+		{
+			name:     "missing password",
+			setup:    func(rooms *RoomManager) { rooms.CreateRoom("room-1", "1234", 0) },
+			wantType: protocol.MessageTypeError,
+			wantCode: protocol.ErrorCodeUnauthorized,
+		},
+		{
+			name:     "no-password room ignores password",
+			setup:    func(rooms *RoomManager) { rooms.GetOrCreate("room-1") },
+			password: "anything",
+			wantType: protocol.MessageTypeAck,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(NewRegistry(), NewRoomManager())
+			tt.setup(handler.rooms)
+
+			response := joinWithPassword(t, handler, "joiner", tt.password)
+			if response.Type != tt.wantType {
+				t.Fatalf("expected %s, got %s", tt.wantType, response.Type)
+			}
+
+			if tt.wantCode != "" {
+				var payload protocol.ErrorPayload
+				response.ParsePayload(&payload)
+				if payload.Code != tt.wantCode {
+					t.Errorf("expected error code %s, got %s", tt.wantCode, payload.Code)
+				}
+				if handler.rooms.Get("room-1").Contains("joiner") {
+					t.Error("peer should not be in the room")
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerJoinImplicitRoomsDisabled(t *testing.T) {
+	rooms := NewRoomManager()
+	rooms.AllowImplicitRooms = false
+	handler := NewHandler(NewRegistry(), rooms)
+
+	errorCode := func(response protocol.Message) string {
+		var payload protocol.ErrorPayload
+		response.ParsePayload(&payload)
+		return payload.Code
+	}
+
+	// A missing room is not created by joining it
+	response := joinWithPassword(t, handler, "early", "")
+	if response.Type != protocol.MessageTypeError || errorCode(response) != protocol.ErrorCodeRoomNotFound {
+		t.Fatalf("expected ROOM_NOT_FOUND, got %s %s", response.Type, errorCode(response))
+	}
+	if rooms.Get("room-1") != nil {
+		t.Fatal("room should not have been created")
+	}
+
+	// A pre-created room can be joined up to its capacity
+	if _, err := rooms.CreateRoom("room-1", "", 1); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+	if response := joinWithPassword(t, handler, "first", ""); response.Type != protocol.MessageTypeAck {
+		t.Fatalf("expected ACK, got %s", response.Type)
+	}
+	response = joinWithPassword(t, handler, "second", "")
+	if response.Type != protocol.MessageTypeError || errorCode(response) != protocol.ErrorCodeRoomFull {
+		t.Errorf("expected ROOM_FULL, got %s %s", response.Type, errorCode(response))
+	}
+}
+
+func TestHandlerFirstJoinerSetsPassword(t *testing.T) {
+	handler := NewHandler(NewRegistry(), NewRoomManager())
+
+	if response := joinWithPassword(t, handler, "creator", "1234"); response.Type != protocol.MessageTypeAck {
+		t.Fatalf("creator join: expected ACK, got %s", response.Type)
+	}
+
+	if response := joinWithPassword(t, handler, "intruder", ""); response.Type != protocol.MessageTypeError {
+		t.Errorf("join without password: expected ERROR, got %s", response.Type)
+	}
+	if response := joinWithPassword(t, handler, "friend", "1234"); response.Type != protocol.MessageTypeAck {
+		t.Errorf("join with password: expected ACK, got %s", response.Type)
+	}
+}
+
+func TestHandlerBroadcast(t *testing.T) {
+	registry := NewRegistry()
+	rooms := NewRoomManager()
+	handler := NewHandler(registry, rooms)
+
+	conns := make(map[string]*MockConn)
+	peers := make(map[string]*Peer)
+	for _, id := range []string{"sender", "peer1", "peer2"} {
+		conns[id] = NewMockConn()
+		peers[id] = NewPeer(id, conns[id])
+		registry.Register(peers[id])
+		rooms.JoinRoom(peers[id], "group")
+	}
+
+	// A peer outside the room must not receive it
+	outsiderConn := NewMockConn()
+	registry.Register(NewPeer("outsider", outsiderConn))
+
+	msg := protocol.NewMessage(protocol.MessageTypeBroadcast).WithPayload(map[string]string{"status": "online"})
+	if err := handler.handleMessage(peers["sender"], msg); err != nil {
+		t.Fatalf("failed to broadcast: %v", err)
+	}
+
+	for _, peer := range peers {
+		peer.Flush()
+	}
+
+	for _, id := range []string{"peer1", "peer2"} {
+		received := firstWritten(t, conns[id])
+		if received.Type != protocol.MessageTypeBroadcast {
+			t.Errorf("%s: expected BROADCAST, got %s", id, received.Type)
+		}
+		if received.PeerID != "sender" || received.RoomID != "group" {
+			t.Errorf("%s: expected broadcast from sender in group, got %s in %s", id, received.PeerID, received.RoomID)
+		}
+
+		var payload map[string]string
+		if err := received.ParsePayload(&payload); err != nil || payload["status"] != "online" {
+			t.Errorf("%s: payload not forwarded: %v", id, payload)
+		}
+	}
+
+	if len(conns["sender"].GetWritten()) != 0 {
+		t.Error("sender should not receive its own broadcast")
+	}
+	if len(outsiderConn.GetWritten()) != 0 {
+		t.Error("peer outside the room should not receive the broadcast")
+	}
+}
+
+func TestHandlerBroadcastNotInRoom(t *testing.T) {
+	handler := NewHandler(NewRegistry(), NewRoomManager())
+
+	conn := NewMockConn()
+	peer := NewPeer("loner", conn)
+	handler.registry.Register(peer)
+
+	handler.handleMessage(peer, protocol.NewMessage(protocol.MessageTypeBroadcast))
+	peer.Flush()
+
+	response := firstWritten(t, conn)
+	var payload protocol.ErrorPayload
+	response.ParsePayload(&payload)
+	if payload.Code != protocol.ErrorCodeNotInRoom {
+		t.Errorf("expected %s, got %s", protocol.ErrorCodeNotInRoom, payload.Code)
+	}
+}
+
+func TestHandlerTURNCredentials(t *testing.T) {
+	handler := NewHandler(NewRegistry(), NewRoomManager())
+
+	conn := NewMockConn()
+	peer := NewPeer("stuck-peer", conn)
+	handler.registry.Register(peer)
+
+	// Not configured
+	handler.handleMessage(peer, protocol.NewMessage(protocol.MessageTypeTURNCredentials))
+	peer.Flush()
+
+	response := firstWritten(t, conn)
+	if response.Type != protocol.MessageTypeError {
+		t.Errorf("expected ERROR without TURN config, got %s", response.Type)
+	}
+
+	// Configured
+	handler.TURN = &TURNConfig{Secret: "secret", Realm: "altair", URIs: []string{"turn:relay:3478"}}
+	handler.handleMessage(peer, protocol.NewMessage(protocol.MessageTypeTURNCredentials).WithRequestID("req-1"))
+	peer.Flush()
+
+	written := conn.GetWritten()
+	var reply protocol.Message
+	if err := json.Unmarshal(written[len(written)-1], &reply); err != nil {
+		t.Fatalf("failed to parse reply: %v", err)
+	}
+	if reply.Type != protocol.MessageTypeTURNCredentials || reply.RequestID != "req-1" {
+		t.Fatalf("expected TURN_CREDENTIALS for req-1, got %s for %s", reply.Type, reply.RequestID)
+	}
+
+	var creds protocol.TURNCredentialsPayload
+	if err := reply.ParsePayload(&creds); err != nil {
+		t.Fatalf("failed to parse credentials: %v", err)
+	}
+	if !strings.HasSuffix(creds.Username, ":stuck-peer") || creds.Password == "" {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+	if creds.Realm != "altair" || len(creds.URIs) != 1 {
+		t.Errorf("expected realm and URIs in reply, got %+v", creds)
+	}
+}
+
+// writtenMessages parses every message written to conn
+func writtenMessages(t *testing.T, conn *MockConn) []protocol.Message {
+	t.Helper()
+
+	var msgs []protocol.Message
+	for _, data := range conn.GetWritten() {
+		var msg protocol.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("failed to parse message: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestHandlerCandidatesHeldUntilOffer(t *testing.T) {
+	handler := NewHandler(NewRegistry(), NewRoomManager())
+	handler.CandidateHold = time.Minute
+
+	alice := NewPeer("alice", NewMockConn())
+	handler.registry.Register(alice)
+	bobConn := NewMockConn()
+	bob := NewPeer("bob", bobConn)
+	handler.registry.Register(bob)
+
+	// Candidates for a session bob hasn't been offered yet are held
+	for _, port := range []int{1001, 1002, 1003} {
+		candidate := protocol.NewMessage(protocol.MessageTypeCandidate).
+			WithTargetID("bob").
+			WithPayload(protocol.CandidatePayload{SessionID: "sess-1", Endpoint: protocol.Endpoint{IP: "10.0.0.1", Port: port}})
+		if err := handler.handleMessage(alice, candidate); err != nil {
+			t.Fatalf("candidate failed: %v", err)
+		}
+	}
+	bob.Flush()
+	if n := len(bobConn.GetWritten()); n != 0 {
+		t.Fatalf("bob received %d messages before the offer", n)
+	}
+
+	offer := protocol.NewMessage(protocol.MessageTypeOffer).
+		WithTargetID("bob").
+		WithPayload(protocol.OfferPayload{SessionID: "sess-1", InitiatorID: "alice"})
+	if err := handler.handleMessage(alice, offer); err != nil {
+		t.Fatalf("offer failed: %v", err)
+	}
+
+	// Later candidates for the session go straight through
+	late := protocol.NewMessage(protocol.MessageTypeCandidate).
+		WithTargetID("bob").
+		WithPayload(protocol.CandidatePayload{SessionID: "sess-1", Endpoint: protocol.Endpoint{IP: "10.0.0.1", Port: 1004}})
+	handler.handleMessage(alice, late)
+	bob.Flush()
+
+	msgs := writtenMessages(t, bobConn)
+	if len(msgs) != 5 || msgs[0].Type != protocol.MessageTypeOffer {
+		t.Fatalf("expected OFFER then 4 candidates, got %d messages", len(msgs))
+	}
+	for i, msg := range msgs[1:] {
+		var payload protocol.CandidatePayload
+		msg.ParsePayload(&payload)
+		if msg.Type != protocol.MessageTypeCandidate || payload.Endpoint.Port != 1001+i {
+			t.Errorf("message %d: got %s port %d, want CANDIDATE port %d", i+1, msg.Type, payload.Endpoint.Port, 1001+i)
+		}
+	}
+}
+
+func TestHandlerCandidatesReleasedAfterHold(t *testing.T) {
+	handler := NewHandler(NewRegistry(), NewRoomManager())
+	handler.CandidateHold = 20 * time.Millisecond
+
+	alice := NewPeer("alice", NewMockConn())
+	handler.registry.Register(alice)
+	bobConn := NewMockConn()
+	bob := NewPeer("bob", bobConn)
+	handler.registry.Register(bob)
+
+	// Without a session ID there's nothing to wait for
+	handler.handleMessage(alice, protocol.NewMessage(protocol.MessageTypeCandidate).WithTargetID("bob").
+		WithPayload(protocol.CandidatePayload{Endpoint: protocol.Endpoint{IP: "10.0.0.1", Port: 1}}))
+	bob.Flush()
+	if n := len(bobConn.GetWritten()); n != 1 {
+		t.Fatalf("candidate without session_id: bob received %d messages, want 1", n)
+	}
+
+	handler.handleMessage(alice, protocol.NewMessage(protocol.MessageTypeCandidate).WithTargetID("bob").
+		WithPayload(protocol.CandidatePayload{SessionID: "sess-1", Endpoint: protocol.Endpoint{IP: "10.0.0.1", Port: 2}}))
+
+	time.Sleep(100 * time.Millisecond)
+	bob.Flush()
+	if n := len(bobConn.GetWritten()); n != 2 {
+		t.Errorf("held candidate should be released after the hold, bob received %d messages", n)
+	}
+}
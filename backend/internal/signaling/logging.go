@@ -0,0 +1,36 @@
+package signaling
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// logTo emits a structured record. args are slog key-value pairs or
+// slog.Attrs. A non-nil slogger wins; otherwise the record is flattened
+// onto logger as "prefix msg key=value ..." so *log.Logger users keep
+// working.
+func logTo(slogger *slog.Logger, logger *log.Logger, prefix string, level slog.Level, msg string, args ...any) {
+	if slogger != nil {
+		slogger.Log(context.Background(), level, msg, args...)
+		return
+	}
+	if logger == nil {
+		return
+	}
+
+	record := slog.NewRecord(time.Time{}, level, msg, 0)
+	record.Add(args...)
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	b.WriteString(msg)
+	record.Attrs(func(attr slog.Attr) bool {
+		b.WriteString(" ")
+		b.WriteString(attr.String())
+		return true
+	})
+	logger.Print(b.String())
+}
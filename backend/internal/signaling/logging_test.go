@@ -0,0 +1,46 @@
+package signaling
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogToFlattensForLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+
+	logTo(nil, logger, "[signaling] ", slog.LevelWarn, "peer read failed",
+		"peer_id", "abc", "error", errors.New("boom"))
+
+	want := "[signaling] peer read failed peer_id=abc error=boom\n"
+	if buf.String() != want {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+}
+
+func TestLogToPrefersSLogger(t *testing.T) {
+	var plain, structured bytes.Buffer
+	logger := log.New(&plain, "", 0)
+	slogger := slog.New(slog.NewJSONHandler(&structured, nil))
+
+	logTo(slogger, logger, "[signaling] ", slog.LevelInfo, "peer joined room",
+		"peer_id", "abc", "room_id", "lobby")
+
+	if plain.Len() != 0 {
+		t.Errorf("Logger should be bypassed when SLogger is set, got %q", plain.String())
+	}
+	for _, field := range []string{`"msg":"peer joined room"`, `"peer_id":"abc"`, `"room_id":"lobby"`} {
+		if !strings.Contains(structured.String(), field) {
+			t.Errorf("structured log %q missing %s", structured.String(), field)
+		}
+	}
+}
+
+func TestLogToNoLogger(t *testing.T) {
+	// Must not panic without any logger
+	logTo(nil, nil, "", slog.LevelInfo, "dropped", "key", "value")
+}
@@ -0,0 +1,119 @@
+package signaling
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+)
+
+// knownMessageTypes bounds the type label so clients can't create
+// unbounded metric series by sending made-up types.
+var knownMessageTypes = map[protocol.MessageType]bool{
+	protocol.MessageTypeJoin:            true,
+	protocol.MessageTypeLeave:           true,
+	protocol.MessageTypeOffer:           true,
+	protocol.MessageTypeAnswer:          true,
+	protocol.MessageTypeCandidate:       true,
+	protocol.MessageTypeDiscover:        true,
+	protocol.MessageTypeKeepAlive:       true,
+	protocol.MessageTypeAuth:            true,
+	protocol.MessageTypeBroadcast:       true,
+	protocol.MessageTypeTURNCredentials: true,
+}
+
+// Metrics counts signaling activity for the /metrics endpoint.
+// It is safe for concurrent use.
+type Metrics struct {
+	mu          sync.Mutex
+	connections uint64
+	messages    map[protocol.MessageType]uint64
+	durations   map[protocol.MessageType]time.Duration
+}
+
+// NewMetrics creates an empty metrics collector.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		messages:  make(map[protocol.MessageType]uint64),
+		durations: make(map[protocol.MessageType]time.Duration),
+	}
+}
+
+// connectionOpened counts an accepted WebSocket connection.
+func (m *Metrics) connectionOpened() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connections++
+}
+
+// observeMessage counts a handled message and the time since start, which
+// for OFFER/ANSWER/CANDIDATE includes forwarding to the target peer.
+func (m *Metrics) observeMessage(msgType protocol.MessageType, start time.Time) {
+	if !knownMessageTypes[msgType] {
+		msgType = "UNKNOWN"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[msgType]++
+	m.durations[msgType] += time.Since(start)
+}
+
+// WritePrometheus writes the metrics, plus live peer and room gauges, in
+// the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer, registry *Registry, rooms *RoomManager) {
+	m.mu.Lock()
+	connections := m.connections
+	types := make([]string, 0, len(m.messages))
+	for t := range m.messages {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+	messages := make(map[string]uint64, len(types))
+	durations := make(map[string]time.Duration, len(types))
+	for _, t := range types {
+		messages[t] = m.messages[protocol.MessageType(t)]
+		durations[t] = m.durations[protocol.MessageType(t)]
+	}
+	m.mu.Unlock()
+
+	roomStats := rooms.Stats()
+
+	writeMetric(w, "altair_signaling_connections_total", "counter",
+		"WebSocket connections accepted.")
+	fmt.Fprintf(w, "altair_signaling_connections_total %d\n", connections)
+
+	writeMetric(w, "altair_signaling_peers", "gauge",
+		"Currently connected peers.")
+	fmt.Fprintf(w, "altair_signaling_peers %d\n", registry.Count())
+
+	writeMetric(w, "altair_signaling_rooms", "gauge",
+		"Current rooms.")
+	fmt.Fprintf(w, "altair_signaling_rooms %d\n", roomStats.TotalRooms)
+
+	writeMetric(w, "altair_signaling_room_peers", "gauge",
+		"Peers currently in a room.")
+	fmt.Fprintf(w, "altair_signaling_room_peers %d\n", roomStats.TotalPeers)
+
+	writeMetric(w, "altair_signaling_messages_total", "counter",
+		"Messages received by type.")
+	for _, t := range types {
+		fmt.Fprintf(w, "altair_signaling_messages_total{type=%q} %d\n", t, messages[t])
+	}
+
+	writeMetric(w, "altair_signaling_message_duration_seconds", "summary",
+		"Time to handle a message, including forwarding to the target peer.")
+	for _, t := range types {
+		fmt.Fprintf(w, "altair_signaling_message_duration_seconds_sum{type=%q} %g\n", t, durations[t].Seconds())
+		fmt.Fprintf(w, "altair_signaling_message_duration_seconds_count{type=%q} %d\n", t, messages[t])
+	}
+}
+
+// writeMetric writes the HELP and TYPE lines for a metric.
+func writeMetric(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
@@ -0,0 +1,36 @@
+package signaling
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+)
+
+func TestMetricsUnknownTypesShareALabel(t *testing.T) {
+	m := NewMetrics()
+
+	m.observeMessage(protocol.MessageTypeOffer, time.Now())
+	m.observeMessage("MADE_UP_1", time.Now())
+	m.observeMessage("MADE_UP_2", time.Now())
+	m.connectionOpened()
+
+	var buf bytes.Buffer
+	m.WritePrometheus(&buf, NewRegistry(), NewRoomManager())
+	out := buf.String()
+
+	for _, want := range []string{
+		"altair_signaling_connections_total 1",
+		`altair_signaling_messages_total{type="OFFER"} 1`,
+		`altair_signaling_messages_total{type="UNKNOWN"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+	if strings.Contains(out, "MADE_UP") {
+		t.Error("unknown message types should not become labels")
+	}
+}
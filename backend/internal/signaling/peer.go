@@ -2,9 +2,13 @@ package signaling
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"sync"
 	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
 )
 
 // Conn abstracts a WebSocket connection for testability.
@@ -28,72 +32,196 @@ const (
 	PongMessage   = 10
 )
 
+// ErrSendQueueFull is returned by Send when a peer isn't draining its
+// outbound queue fast enough.
+var ErrSendQueueFull = errors.New("send queue full")
+
+// sendQueueSize is how many outbound messages are buffered per peer
+const sendQueueSize = 64
+
+// outbound is a queued write. A flush marker carries a non-nil flushed
+// channel and no data.
+type outbound struct {
+	messageType int
+	data        []byte
+	flushed     chan struct{}
+}
+
 // Peer represents a connected client.
+//
+// WebSocket connections allow only one concurrent writer, so every write
+// goes through a per-peer queue drained by a single writer goroutine.
 type Peer struct {
 	ID          string
 	DisplayName string
-	Endpoint    *Endpoint
+	Endpoint    *protocol.Endpoint
+	Metadata    map[string]string
 	RoomID      string
 	JoinedAt    time.Time
 	LastSeen    time.Time
 
-	conn   Conn
-	mu     sync.Mutex // Protects conn writes
-	closed bool
+	conn         Conn
+	writeTimeout time.Duration // Deadline for a single write
+	mu           sync.Mutex    // Protects the fields above and closed/writeErr
+	queue        chan outbound
+	closing      chan struct{} // Closed by Close; the writer drains and exits
+	stopped      chan struct{} // Closed when the writer has exited
+	closed       bool
+	writeErr     error
 }
 
-// NewPeer creates a new peer with the given WebSocket connection.
+// NewPeer creates a new peer with the given WebSocket connection and starts
+// its writer.
 func NewPeer(id string, conn Conn) *Peer {
 	now := time.Now()
-	return &Peer{
-		ID:       id,
-		conn:     conn,
-		JoinedAt: now,
-		LastSeen: now,
+	p := &Peer{
+		ID:           id,
+		conn:         conn,
+		writeTimeout: 10 * time.Second,
+		JoinedAt:     now,
+		LastSeen:     now,
+		queue:        make(chan outbound, sendQueueSize),
+		closing:      make(chan struct{}),
+		stopped:      make(chan struct{}),
 	}
+	go p.writeLoop()
+	return p
 }
 
-// Send sends a message to the peer. Thread-safe.
-func (p *Peer) Send(msg *Message) error {
+// Send queues a message for the peer without blocking. Thread-safe.
+// Returns ErrSendQueueFull if the peer has fallen too far behind.
+func (p *Peer) Send(msg *protocol.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+
+	return p.enqueue(outbound{messageType: TextMessage, data: data})
+}
+
+// Ping queues a WebSocket ping.
+func (p *Peer) Ping() error {
+	return p.enqueue(outbound{messageType: PingMessage})
+}
+
+// enqueue adds a write to the queue unless the peer is closed, broken or
+// backed up.
+func (p *Peer) enqueue(out outbound) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	if p.closed {
 		return fmt.Errorf("peer %s connection is closed", p.ID)
 	}
+	if p.writeErr != nil {
+		return fmt.Errorf("peer %s connection failed: %w", p.ID, p.writeErr)
+	}
+	if p.queue == nil {
+		return fmt.Errorf("peer %s has no connection", p.ID)
+	}
 
-	// Set write deadline to prevent blocking indefinitely
-	if err := p.conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
-		return fmt.Errorf("set write deadline: %w", err)
+	select {
+	case p.queue <- out:
+		return nil
+	default:
+		return fmt.Errorf("peer %s: %w", p.ID, ErrSendQueueFull)
 	}
+}
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("marshal message: %w", err)
+// Flush blocks until every message queued before the call has been written,
+// or the peer is closed.
+func (p *Peer) Flush() {
+	if p.queue == nil {
+		return
 	}
 
-	if err := p.conn.WriteMessage(TextMessage, data); err != nil {
-		return fmt.Errorf("write message: %w", err)
+	flushed := make(chan struct{})
+	select {
+	case p.queue <- outbound{flushed: flushed}:
+	case <-p.closing:
+		return
 	}
 
-	return nil
+	select {
+	case <-flushed:
+	case <-p.stopped:
+	}
+}
+
+// writeLoop is the peer's only writer. After Close it drains what is
+// already queued so final messages (errors, PEER_LEFT) still go out.
+func (p *Peer) writeLoop() {
+	defer close(p.stopped)
+
+	for {
+		select {
+		case out := <-p.queue:
+			p.write(out)
+		case <-p.closing:
+			for {
+				select {
+				case out := <-p.queue:
+					p.write(out)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write performs one queued write. After a failure the connection is
+// unusable, so later writes are dropped.
+func (p *Peer) write(out outbound) {
+	if out.flushed != nil {
+		close(out.flushed)
+		return
+	}
+
+	p.mu.Lock()
+	failed := p.writeErr != nil
+	p.mu.Unlock()
+	if failed {
+		return
+	}
+
+	err := p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout))
+	if err == nil {
+		err = p.conn.WriteMessage(out.messageType, out.data)
+	}
+	if err != nil {
+		p.mu.Lock()
+		p.writeErr = err
+		p.mu.Unlock()
+	}
 }
 
 // SendError sends an error message to the peer.
 func (p *Peer) SendError(code, message string) error {
-	return p.Send(NewErrorMessage(code, message))
+	return p.Send(protocol.NewErrorMessage(code, message))
 }
 
-// Close closes the peer's connection.
+// ReplyError sends an error answering request, echoing its request ID so
+// clients can match it to the request that failed.
+func (p *Peer) ReplyError(request *protocol.Message, code, message string) error {
+	return p.Send(protocol.NewErrorMessage(code, message).WithRequestID(request.RequestID))
+}
+
+// Close flushes queued messages and closes the peer's connection.
 func (p *Peer) Close() error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	if p.closed {
+		p.mu.Unlock()
 		return nil
 	}
-
 	p.closed = true
+	p.mu.Unlock()
+
+	if p.closing != nil {
+		close(p.closing)
+		<-p.stopped
+	}
+
 	if p.conn != nil {
 		return p.conn.Close()
 	}
@@ -115,19 +243,20 @@ func (p *Peer) UpdateLastSeen() {
 }
 
 // Info returns a PeerInfo snapshot for protocol messages.
-func (p *Peer) Info() PeerInfo {
+func (p *Peer) Info() protocol.PeerInfo {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return PeerInfo{
+	return protocol.PeerInfo{
 		PeerID:      p.ID,
 		DisplayName: p.DisplayName,
 		Endpoint:    p.Endpoint,
+		Metadata:    maps.Clone(p.Metadata),
 		JoinedAt:    p.JoinedAt.UnixMilli(),
 	}
 }
 
 // SetEndpoint updates the peer's public endpoint.
-func (p *Peer) SetEndpoint(endpoint *Endpoint) {
+func (p *Peer) SetEndpoint(endpoint *protocol.Endpoint) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.Endpoint = endpoint
@@ -140,6 +269,13 @@ func (p *Peer) SetDisplayName(name string) {
 	p.DisplayName = name
 }
 
+// SetMetadata replaces the peer's metadata with a copy of metadata.
+func (p *Peer) SetMetadata(metadata map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Metadata = maps.Clone(metadata)
+}
+
 // SetRoomID updates the peer's current room.
 func (p *Peer) SetRoomID(roomID string) {
 	p.mu.Lock()
@@ -0,0 +1,128 @@
+package signaling
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+)
+
+// exclusiveConn fails the test if WriteMessage is ever entered concurrently,
+// which gorilla/websocket forbids
+type exclusiveConn struct {
+	*MockConn
+	t       *testing.T
+	writing atomic.Int32
+}
+
+func (c *exclusiveConn) WriteMessage(messageType int, data []byte) error {
+	if c.writing.Add(1) != 1 {
+		c.t.Error("concurrent WriteMessage")
+	}
+	defer c.writing.Add(-1)
+
+	time.Sleep(10 * time.Microsecond) // widen the window for overlap
+	return c.MockConn.WriteMessage(messageType, data)
+}
+
+func TestPeerSendConcurrent(t *testing.T) {
+	conn := &exclusiveConn{MockConn: NewMockConn(), t: t}
+	peer := NewPeer("p1", conn)
+
+	const senders, perSender = 8, 100
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				if err := peer.Send(protocol.NewMessage(protocol.MessageTypeKeepAlive)); err == nil {
+					sent.Add(1)
+				} else if !errors.Is(err, ErrSendQueueFull) {
+					t.Errorf("Send failed: %v", err)
+				}
+				if j%10 == 0 {
+					peer.Ping()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := peer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Every accepted message is written before Close returns
+	texts := 0
+	for _, data := range conn.GetWritten() {
+		if len(data) > 0 {
+			texts++
+		}
+	}
+	if int64(texts) != sent.Load() {
+		t.Errorf("wrote %d messages, Send accepted %d", texts, sent.Load())
+	}
+}
+
+// blockingConn holds every write until release is closed
+type blockingConn struct {
+	*MockConn
+	release chan struct{}
+}
+
+func (c *blockingConn) WriteMessage(messageType int, data []byte) error {
+	<-c.release
+	return c.MockConn.WriteMessage(messageType, data)
+}
+
+func TestPeerSendQueueFull(t *testing.T) {
+	conn := &blockingConn{MockConn: NewMockConn(), release: make(chan struct{})}
+	peer := NewPeer("slow", conn)
+
+	// One message is taken by the stuck writer, the rest fill the queue
+	var err error
+	for i := 0; i <= sendQueueSize+1 && err == nil; i++ {
+		err = peer.Send(protocol.NewMessage(protocol.MessageTypeKeepAlive))
+	}
+	if !errors.Is(err, ErrSendQueueFull) {
+		t.Errorf("Send to a stalled peer = %v, want ErrSendQueueFull", err)
+	}
+
+	close(conn.release)
+	peer.Close()
+
+	if err := peer.Send(protocol.NewMessage(protocol.MessageTypeKeepAlive)); err == nil {
+		t.Error("Send after Close should fail")
+	}
+}
+
+func TestPeerWriteErrorStopsSends(t *testing.T) {
+	conn := NewMockConn()
+	conn.SetWriteError(errors.New("broken pipe"))
+	peer := NewPeer("broken", conn)
+	defer peer.Close()
+
+	peer.Send(protocol.NewMessage(protocol.MessageTypeKeepAlive))
+	peer.Flush()
+
+	if err := peer.Send(protocol.NewMessage(protocol.MessageTypeKeepAlive)); err == nil {
+		t.Error("Send after a failed write should fail")
+	}
+}
+
+func TestPeerWithoutConnection(t *testing.T) {
+	peer := &Peer{ID: "bare"}
+
+	if err := peer.Send(protocol.NewMessage(protocol.MessageTypeKeepAlive)); err == nil {
+		t.Error("Send without a connection should fail")
+	}
+	peer.Flush()
+	if err := peer.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
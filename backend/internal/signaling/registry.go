@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
 )
 
 // Registry manages all connected peers and provides thread-safe operations.
@@ -55,6 +57,28 @@ func (r *Registry) Register(peer *Peer) *Peer {
 	return peer
 }
 
+// RegisterWithID adds a peer under its existing ID.
+// Returns false, leaving the registry unchanged, if the ID is empty or taken.
+func (r *Registry) RegisterWithID(peer *Peer) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if peer.ID == "" {
+		return false
+	}
+	if _, exists := r.peers[peer.ID]; exists {
+		return false
+	}
+
+	r.peers[peer.ID] = peer
+
+	if r.OnPeerAdded != nil {
+		go r.OnPeerAdded(peer)
+	}
+
+	return true
+}
+
 // Unregister removes a peer from the registry.
 func (r *Registry) Unregister(peerID string) {
 	r.mu.Lock()
@@ -116,7 +140,7 @@ func (r *Registry) ForEach(fn func(peer *Peer)) {
 }
 
 // Broadcast sends a message to all peers except the excluded ones.
-func (r *Registry) Broadcast(msg *Message, excludeIDs ...string) {
+func (r *Registry) Broadcast(msg *protocol.Message, excludeIDs ...string) {
 	excludeSet := make(map[string]bool, len(excludeIDs))
 	for _, id := range excludeIDs {
 		excludeSet[id] = true
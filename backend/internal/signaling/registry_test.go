@@ -254,3 +254,24 @@ func TestRegistryStatsString(t *testing.T) {
 	// Just verify it doesn't panic and returns something
 	t.Logf("Stats string: %s", s)
 }
+
+func TestRegistryRegisterWithID(t *testing.T) {
+	r := NewRegistry()
+
+	if !r.RegisterWithID(&Peer{ID: "alice"}) {
+		t.Fatal("RegisterWithID should accept a new ID")
+	}
+	if !r.Exists("alice") {
+		t.Error("peer should exist after RegisterWithID")
+	}
+
+	if r.RegisterWithID(&Peer{ID: "alice"}) {
+		t.Error("RegisterWithID should reject a taken ID")
+	}
+	if r.RegisterWithID(&Peer{}) {
+		t.Error("RegisterWithID should reject an empty ID")
+	}
+	if r.Count() != 1 {
+		t.Errorf("expected count 1, got %d", r.Count())
+	}
+}
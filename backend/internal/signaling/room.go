@@ -1,29 +1,118 @@
 package signaling
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
 )
 
+// ErrWrongPassword is returned when joining a password-protected room
+// without the right password.
+var ErrWrongPassword = errors.New("wrong room password")
+
+// ErrRoomExists is returned when creating a room whose ID is taken.
+var ErrRoomExists = errors.New("room already exists")
+
+// ErrRoomNotFound is returned when joining a missing room while implicit
+// room creation is disabled.
+var ErrRoomNotFound = errors.New("room not found")
+
+// RoomEventHistory is how many recent events a room keeps.
+const RoomEventHistory = 64
+
+// RoomEventType identifies what happened in a room.
+type RoomEventType string
+
+const (
+	RoomEventJoined RoomEventType = "joined"
+	RoomEventLeft   RoomEventType = "left"
+	RoomEventOffer  RoomEventType = "offer"
+	RoomEventAnswer RoomEventType = "answer"
+)
+
+// RoomEvent records one event for operators debugging a room. PeerCount is
+// the number of peers right after it.
+type RoomEvent struct {
+	Type      RoomEventType `json:"type"`
+	PeerID    string        `json:"peer_id"`
+	TargetID  string        `json:"target_id,omitempty"` // For OFFER/ANSWER
+	PeerCount int           `json:"peer_count"`
+	Time      time.Time     `json:"time"`
+}
+
 // Room represents a logical grouping of peers for discovery and coordination.
 type Room struct {
 	ID        string
 	CreatedAt time.Time
 	MaxPeers  int // 0 = unlimited
 
+	// SHA-256 of the join password; nil when the room is open
+	passwordHash []byte
+
+	// Created with CreateRoom rather than by a JOIN
+	explicit bool
+
+	// When the last peer left (or creation time); zero while occupied
+	emptySince time.Time
+
 	peers map[string]*Peer // peerID -> Peer
 	mu    sync.RWMutex
+
+	// Ring buffer of the last RoomEventHistory events
+	events     []RoomEvent
+	eventsNext int
 }
 
 // NewRoom creates a new room with the given ID.
 func NewRoom(id string) *Room {
+	now := time.Now()
 	return &Room{
-		ID:        id,
-		CreatedAt: time.Now(),
-		MaxPeers:  0, // unlimited by default
-		peers:     make(map[string]*Peer),
+		ID:         id,
+		CreatedAt:  now,
+		MaxPeers:   0, // unlimited by default
+		peers:      make(map[string]*Peer),
+		emptySince: now,
+	}
+}
+
+// SetPassword requires password to join the room. An empty password opens
+// the room to everyone.
+func (r *Room) SetPassword(password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if password == "" {
+		r.passwordHash = nil
+		return
 	}
+	hash := sha256.Sum256([]byte(password))
+	r.passwordHash = hash[:]
+}
+
+// HasPassword returns true if joining requires a password.
+func (r *Room) HasPassword() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.passwordHash != nil
+}
+
+// CheckPassword reports whether password may join the room.
+// Rooms without a password accept anything. Comparing fixed-length hashes
+// keeps the check constant-time.
+func (r *Room) CheckPassword(password string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.passwordHash == nil {
+		return true
+	}
+	hash := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(hash[:], r.passwordHash) == 1
 }
 
 // Add adds a peer to the room.
@@ -38,6 +127,8 @@ func (r *Room) Add(peer *Peer) error {
 
 	r.peers[peer.ID] = peer
 	peer.SetRoomID(r.ID)
+	r.emptySince = time.Time{}
+	r.recordLocked(RoomEventJoined, peer.ID, "")
 	return nil
 }
 
@@ -49,7 +140,48 @@ func (r *Room) Remove(peerID string) {
 	if peer, exists := r.peers[peerID]; exists {
 		peer.SetRoomID("")
 		delete(r.peers, peerID)
+		r.recordLocked(RoomEventLeft, peerID, "")
+		if len(r.peers) == 0 {
+			r.emptySince = time.Now()
+		}
+	}
+}
+
+// RecordEvent adds an event, such as a forwarded OFFER, to the room's
+// history.
+func (r *Room) RecordEvent(eventType RoomEventType, peerID, targetID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordLocked(eventType, peerID, targetID)
+}
+
+// recordLocked appends an event, overwriting the oldest once the history
+// is full. Callers hold r.mu.
+func (r *Room) recordLocked(eventType RoomEventType, peerID, targetID string) {
+	event := RoomEvent{
+		Type:      eventType,
+		PeerID:    peerID,
+		TargetID:  targetID,
+		PeerCount: len(r.peers),
+		Time:      time.Now(),
+	}
+
+	if len(r.events) < RoomEventHistory {
+		r.events = append(r.events, event)
+		return
 	}
+	r.events[r.eventsNext] = event
+	r.eventsNext = (r.eventsNext + 1) % RoomEventHistory
+}
+
+// Events returns the room's recent events, oldest first.
+func (r *Room) Events() []RoomEvent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := make([]RoomEvent, 0, len(r.events))
+	events = append(events, r.events[r.eventsNext:]...)
+	return append(events, r.events[:r.eventsNext]...)
 }
 
 // Get retrieves a peer from the room by ID.
@@ -80,11 +212,11 @@ func (r *Room) Peers() []*Peer {
 }
 
 // PeerInfos returns PeerInfo for all peers (for protocol messages).
-func (r *Room) PeerInfos() []PeerInfo {
+func (r *Room) PeerInfos() []protocol.PeerInfo {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	infos := make([]PeerInfo, 0, len(r.peers))
+	infos := make([]protocol.PeerInfo, 0, len(r.peers))
 	for _, p := range r.peers {
 		infos = append(infos, p.Info())
 	}
@@ -103,8 +235,16 @@ func (r *Room) IsEmpty() bool {
 	return r.Count() == 0
 }
 
+// EmptySince returns when the room last became empty, or false if it has
+// peers.
+func (r *Room) EmptySince() (time.Time, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.emptySince, len(r.peers) == 0
+}
+
 // Broadcast sends a message to all peers in the room except excluded ones.
-func (r *Room) Broadcast(msg *Message, excludeIDs ...string) {
+func (r *Room) Broadcast(msg *protocol.Message, excludeIDs ...string) {
 	excludeSet := make(map[string]bool, len(excludeIDs))
 	for _, id := range excludeIDs {
 		excludeSet[id] = true
@@ -119,8 +259,9 @@ func (r *Room) Broadcast(msg *Message, excludeIDs ...string) {
 	}
 	r.mu.RUnlock()
 
+	// Send only queues, so a slow peer can't hold up the others
 	for _, p := range peers {
-		go p.Send(msg)
+		p.Send(msg)
 	}
 }
 
@@ -132,16 +273,22 @@ type RoomManager struct {
 	mu    sync.RWMutex
 
 	// Configuration
-	DefaultMaxPeers int           // Default max peers per room (0 = unlimited)
-	EmptyRoomTTL    time.Duration // How long to keep empty rooms
+	DefaultMaxPeers    int           // Default max peers per room (0 = unlimited)
+	EmptyRoomTTL       time.Duration // How long to keep empty rooms created by JOIN
+	AllowImplicitRooms bool          // Whether joining a missing room creates it
+
+	// How long to keep empty rooms created with CreateRoom; 0 keeps them
+	// until deleted, so their password and limits survive quiet periods
+	ExplicitRoomTTL time.Duration
 }
 
 // NewRoomManager creates a new room manager.
 func NewRoomManager() *RoomManager {
 	return &RoomManager{
-		rooms:           make(map[string]*Room),
-		DefaultMaxPeers: 0, // unlimited
-		EmptyRoomTTL:    5 * time.Minute,
+		rooms:              make(map[string]*Room),
+		DefaultMaxPeers:    0, // unlimited
+		EmptyRoomTTL:       5 * time.Minute,
+		AllowImplicitRooms: true,
 	}
 }
 
@@ -160,6 +307,24 @@ func (rm *RoomManager) GetOrCreate(roomID string) *Room {
 	return room
 }
 
+// CreateRoom creates a room with an optional password and peer limit.
+// Returns ErrRoomExists if the ID is taken.
+func (rm *RoomManager) CreateRoom(roomID, password string, maxPeers int) (*Room, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if _, exists := rm.rooms[roomID]; exists {
+		return nil, ErrRoomExists
+	}
+
+	room := NewRoom(roomID)
+	room.MaxPeers = maxPeers
+	room.SetPassword(password)
+	room.explicit = true
+	rm.rooms[roomID] = room
+	return room, nil
+}
+
 // Get retrieves a room by ID. Returns nil if not found.
 func (rm *RoomManager) Get(roomID string) *Room {
 	rm.mu.RLock()
@@ -207,10 +372,11 @@ func (rm *RoomManager) Stats() RoomStats {
 		count := room.Count()
 		stats.TotalPeers += count
 		stats.Rooms = append(stats.Rooms, RoomInfo{
-			ID:        room.ID,
-			PeerCount: count,
-			MaxPeers:  room.MaxPeers,
-			CreatedAt: room.CreatedAt,
+			ID:          room.ID,
+			PeerCount:   count,
+			MaxPeers:    room.MaxPeers,
+			HasPassword: room.HasPassword(),
+			CreatedAt:   room.CreatedAt,
 		})
 	}
 
@@ -226,23 +392,33 @@ type RoomStats struct {
 
 // RoomInfo contains information about a single room.
 type RoomInfo struct {
-	ID        string    `json:"id"`
-	PeerCount int       `json:"peer_count"`
-	MaxPeers  int       `json:"max_peers"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          string    `json:"id"`
+	PeerCount   int       `json:"peer_count"`
+	MaxPeers    int       `json:"max_peers"`
+	HasPassword bool      `json:"has_password"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
-// CleanupEmpty removes rooms that have been empty for longer than EmptyRoomTTL.
+// CleanupEmpty removes rooms that have been empty for longer than
+// EmptyRoomTTL, or ExplicitRoomTTL for rooms created with CreateRoom.
 // Returns the number of rooms removed.
 func (rm *RoomManager) CleanupEmpty() int {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
 	removed := 0
-	cutoff := time.Now().Add(-rm.EmptyRoomTTL)
+	now := time.Now()
 
 	for id, room := range rm.rooms {
-		if room.IsEmpty() && room.CreatedAt.Before(cutoff) {
+		ttl := rm.EmptyRoomTTL
+		if room.explicit {
+			if rm.ExplicitRoomTTL <= 0 {
+				continue
+			}
+			ttl = rm.ExplicitRoomTTL
+		}
+
+		if since, empty := room.EmptySince(); empty && since.Before(now.Add(-ttl)) {
 			delete(rm.rooms, id)
 			removed++
 		}
@@ -254,6 +430,20 @@ func (rm *RoomManager) CleanupEmpty() int {
 // JoinRoom adds a peer to a room, creating the room if necessary.
 // Handles removing the peer from their previous room.
 func (rm *RoomManager) JoinRoom(peer *Peer, roomID string) (*Room, error) {
+	return rm.JoinRoomWithPassword(peer, roomID, "")
+}
+
+// JoinRoomWithPassword is like JoinRoom but checks password against a
+// protected room, returning ErrWrongPassword on mismatch. If the room
+// doesn't exist yet, it is created with password (if any) as its password,
+// or ErrRoomNotFound is returned when AllowImplicitRooms is false.
+func (rm *RoomManager) JoinRoomWithPassword(peer *Peer, roomID, password string) (*Room, error) {
+	// Check access before leaving the current room
+	room, err := rm.getOrCreateWithPassword(roomID, password)
+	if err != nil {
+		return nil, err
+	}
+
 	// Leave current room if in one
 	currentRoomID := peer.GetRoomID()
 	if currentRoomID != "" && currentRoomID != roomID {
@@ -263,7 +453,6 @@ func (rm *RoomManager) JoinRoom(peer *Peer, roomID string) (*Room, error) {
 	}
 
 	// Join new room
-	room := rm.GetOrCreate(roomID)
 	if err := room.Add(peer); err != nil {
 		return nil, err
 	}
@@ -271,6 +460,30 @@ func (rm *RoomManager) JoinRoom(peer *Peer, roomID string) (*Room, error) {
 	return room, nil
 }
 
+// getOrCreateWithPassword returns the room if password may join it, or
+// creates it protected by password. Creation and the password check happen
+// under one lock so no one can slip into a new room before its password is set.
+func (rm *RoomManager) getOrCreateWithPassword(roomID, password string) (*Room, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if room, exists := rm.rooms[roomID]; exists {
+		if !room.CheckPassword(password) {
+			return nil, ErrWrongPassword
+		}
+		return room, nil
+	}
+	if !rm.AllowImplicitRooms {
+		return nil, ErrRoomNotFound
+	}
+
+	room := NewRoom(roomID)
+	room.MaxPeers = rm.DefaultMaxPeers
+	room.SetPassword(password)
+	rm.rooms[roomID] = room
+	return room, nil
+}
+
 // LeaveRoom removes a peer from their current room.
 func (rm *RoomManager) LeaveRoom(peer *Peer) {
 	roomID := peer.GetRoomID()
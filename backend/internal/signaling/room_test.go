@@ -1,6 +1,7 @@
 package signaling
 
 import (
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -317,6 +318,53 @@ func TestRoomManagerCleanupEmpty(t *testing.T) {
 	}
 }
 
+func TestRoomManagerCleanupEmptyMeasuresFromLastLeave(t *testing.T) {
+	rm := NewRoomManager()
+	rm.EmptyRoomTTL = 50 * time.Millisecond
+
+	peer := &Peer{ID: "p1"}
+	if _, err := rm.JoinRoom(peer, "busy"); err != nil {
+		t.Fatalf("failed to join: %v", err)
+	}
+
+	// Older than the TTL, but only just emptied
+	time.Sleep(80 * time.Millisecond)
+	rm.LeaveRoom(peer)
+
+	if removed := rm.CleanupEmpty(); removed != 0 {
+		t.Errorf("expected a just-emptied room to be kept, removed %d", removed)
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if removed := rm.CleanupEmpty(); removed != 1 {
+		t.Errorf("expected the room to be removed after the TTL, removed %d", removed)
+	}
+}
+
+func TestRoomManagerCleanupKeepsCreatedRooms(t *testing.T) {
+	rm := NewRoomManager()
+	rm.EmptyRoomTTL = 0
+
+	if _, err := rm.CreateRoom("private", "secret", 0); err != nil {
+		t.Fatalf("CreateRoom failed: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if removed := rm.CleanupEmpty(); removed != 0 {
+		t.Fatalf("expected created room to be kept, removed %d", removed)
+	}
+
+	// The password still guards the room, rather than a JOIN re-creating it
+	if _, err := rm.JoinRoomWithPassword(&Peer{ID: "intruder"}, "private", "guess"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("expected ErrWrongPassword, got %v", err)
+	}
+
+	rm.ExplicitRoomTTL = 5 * time.Millisecond
+	if removed := rm.CleanupEmpty(); removed != 1 {
+		t.Errorf("expected created room to be removed after ExplicitRoomTTL, removed %d", removed)
+	}
+}
+
 func TestRoomManagerJoinRoom(t *testing.T) {
 	rm := NewRoomManager()
 	peer := &Peer{ID: "test-peer"}
@@ -402,3 +450,89 @@ func TestRoomManagerConcurrentAccess(t *testing.T) {
 		t.Errorf("expected %d rooms, got %d", numOps, rm.Count())
 	}
 }
+
+func TestRoomPassword(t *testing.T) {
+	room := NewRoom("room")
+
+	if room.HasPassword() {
+		t.Error("new room should not have a password")
+	}
+	if !room.CheckPassword("") || !room.CheckPassword("anything") {
+		t.Error("open room should accept any password")
+	}
+
+	room.SetPassword("secret")
+	if !room.HasPassword() {
+		t.Error("room should have a password")
+	}
+	if !room.CheckPassword("secret") {
+		t.Error("correct password should be accepted")
+	}
+	if room.CheckPassword("") || room.CheckPassword("Secret") {
+		t.Error("wrong password should be rejected")
+	}
+
+	room.SetPassword("")
+	if room.HasPassword() {
+		t.Error("empty password should open the room")
+	}
+}
+
+func TestRoomManagerJoinWrongPasswordKeepsCurrentRoom(t *testing.T) {
+	rm := NewRoomManager()
+	peer := &Peer{ID: "p1"}
+
+	rm.JoinRoom(peer, "lobby")
+	rm.CreateRoom("private", "secret", 0)
+
+	if _, err := rm.JoinRoomWithPassword(peer, "private", "wrong"); err != ErrWrongPassword {
+		t.Fatalf("expected ErrWrongPassword, got %v", err)
+	}
+	if peer.GetRoomID() != "lobby" {
+		t.Errorf("peer should stay in lobby, got %q", peer.GetRoomID())
+	}
+
+	if _, err := rm.CreateRoom("private", "", 0); err != ErrRoomExists {
+		t.Errorf("expected ErrRoomExists, got %v", err)
+	}
+}
+
+func TestRoomEventHistory(t *testing.T) {
+	room := NewRoom("test-room")
+	room.Add(&Peer{ID: "p1"})
+	room.Add(&Peer{ID: "p2"})
+	room.RecordEvent(RoomEventOffer, "p2", "p1")
+	room.Remove("p1")
+	room.Remove("p1") // Not in the room, so not recorded
+
+	events := room.Events()
+	want := []RoomEvent{
+		{Type: RoomEventJoined, PeerID: "p1", PeerCount: 1},
+		{Type: RoomEventJoined, PeerID: "p2", PeerCount: 2},
+		{Type: RoomEventOffer, PeerID: "p2", TargetID: "p1", PeerCount: 2},
+		{Type: RoomEventLeft, PeerID: "p1", PeerCount: 1},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %d", len(want), len(events))
+	}
+	for i, event := range events {
+		event.Time = time.Time{}
+		if event != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, event, want[i])
+		}
+	}
+
+	// Once full, the oldest events are dropped
+	for i := 0; i < RoomEventHistory; i++ {
+		room.RecordEvent(RoomEventAnswer, "p2", "p1")
+	}
+	events = room.Events()
+	if len(events) != RoomEventHistory {
+		t.Fatalf("expected %d events, got %d", RoomEventHistory, len(events))
+	}
+	for _, event := range events {
+		if event.Type != RoomEventAnswer {
+			t.Fatalf("expected only the newest events, found %+v", event)
+		}
+	}
+}
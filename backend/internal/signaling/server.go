@@ -1,13 +1,19 @@
+// Package signaling implements a WebSocket-based signaling server for P2P coordination.
+// It enables peers to discover each other and exchange endpoint information for NAT traversal.
+// The wire format is defined in pkg/signaling/protocol.
 package signaling
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -29,12 +35,21 @@ type Server struct {
 	CleanupInterval time.Duration
 	StaleTimeout    time.Duration
 
+	// TLS (see Config)
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSConfig   *tls.Config
+
+	// Whether Start requires a WebSocket upgrader
+	enableWebSocket bool
+
 	// Lifecycle
 	shutdownOnce sync.Once
 	done         chan struct{}
 
-	// Logging
-	Logger *log.Logger
+	// Logging (see Config)
+	Logger  *log.Logger
+	SLogger *slog.Logger
 }
 
 // Config holds server configuration options.
@@ -45,17 +60,64 @@ type Config struct {
 	CleanupInterval time.Duration
 	StaleTimeout    time.Duration
 	Logger          *log.Logger
+
+	// SLogger receives structured records (peer_id, room_id, msg_type,
+	// error, ...). When set it takes precedence over Logger.
+	SLogger *slog.Logger
+
+	// TokenValidator gates WebSocket connections (optional, see Handler)
+	TokenValidator TokenValidator
+
+	// TURN enables the TURN_CREDENTIALS message (optional)
+	TURN *TURNConfig
+
+	// CandidateHold buffers trickled candidates until their session's
+	// OFFER/ANSWER has been forwarded (optional, see Handler)
+	CandidateHold time.Duration
+
+	// AllowImplicitRooms lets the first JOIN create a missing room. When
+	// false, rooms must be created with POST /api/rooms and joining any
+	// other room fails with ROOM_NOT_FOUND.
+	AllowImplicitRooms bool
+
+	// Version and InstanceID are reported to clients in the welcome ACK.
+	// An empty InstanceID gets a random one.
+	Version    string
+	InstanceID string
+
+	// EnableWebSocket wires the built-in gorilla/websocket upgrader. The
+	// binary must be built with -tags websocket, otherwise Start fails
+	// unless an upgrader was set with Handler().SetUpgrader.
+	EnableWebSocket bool
+
+	// EnableCompression negotiates permessage-deflate (RFC 7692) with
+	// clients that offer it, shrinking peer lists and candidate blobs.
+	// It applies to the built-in upgrader only.
+	EnableCompression bool
+
+	// TLSCertFile and TLSKeyFile make Start serve HTTPS, and so wss://
+	// on /ws, which browsers require on https pages. Leave them empty
+	// for plain HTTP, e.g. in local development.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TLSConfig is used when serving HTTPS (optional). Certificates set
+	// here, or by GetCertificate, enable TLS without the files.
+	TLSConfig *tls.Config
 }
 
 // DefaultConfig returns sensible default configuration.
 func DefaultConfig() Config {
 	return Config{
-		Addr:            ":8080",
-		ReadTimeout:     15 * time.Second,
-		WriteTimeout:    15 * time.Second,
-		CleanupInterval: 1 * time.Minute,
-		StaleTimeout:    5 * time.Minute,
-		Logger:          log.Default(),
+		Addr:               ":8080",
+		ReadTimeout:        15 * time.Second,
+		WriteTimeout:       15 * time.Second,
+		CleanupInterval:    1 * time.Minute,
+		StaleTimeout:       5 * time.Minute,
+		Logger:             log.Default(),
+		EnableWebSocket:    true,
+		CandidateHold:      2 * time.Second,
+		AllowImplicitRooms: true,
 	}
 }
 
@@ -63,11 +125,24 @@ func DefaultConfig() Config {
 func NewServer(cfg Config) *Server {
 	registry := NewRegistry()
 	rooms := NewRoomManager()
+	rooms.AllowImplicitRooms = cfg.AllowImplicitRooms
 	handler := NewHandler(registry, rooms)
 
 	if cfg.Logger != nil {
 		handler.Logger = cfg.Logger
 	}
+	handler.SLogger = cfg.SLogger
+	handler.TokenValidator = cfg.TokenValidator
+	handler.TURN = cfg.TURN
+	handler.CandidateHold = cfg.CandidateHold
+	handler.Version = cfg.Version
+	if cfg.InstanceID != "" {
+		handler.InstanceID = cfg.InstanceID
+	}
+
+	if cfg.EnableWebSocket && WebSocketSupported() {
+		handler.SetUpgrader(newBuiltinUpgrader(cfg.EnableCompression))
+	}
 
 	s := &Server{
 		registry:        registry,
@@ -80,6 +155,11 @@ func NewServer(cfg Config) *Server {
 		CleanupInterval: cfg.CleanupInterval,
 		StaleTimeout:    cfg.StaleTimeout,
 		Logger:          cfg.Logger,
+		SLogger:         cfg.SLogger,
+		TLSCertFile:     cfg.TLSCertFile,
+		TLSKeyFile:      cfg.TLSKeyFile,
+		TLSConfig:       cfg.TLSConfig,
+		enableWebSocket: cfg.EnableWebSocket,
 		done:            make(chan struct{}),
 	}
 
@@ -95,20 +175,43 @@ func (s *Server) setupRoutes() {
 	// REST API endpoints
 	s.mux.HandleFunc("/health", s.handleHealth)
 	s.mux.HandleFunc("/api/stats", s.handleStats)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
 	s.mux.HandleFunc("/api/rooms", s.handleRooms)
-	s.mux.HandleFunc("/api/rooms/", s.handleRoom) // /api/rooms/{roomID}
+	s.mux.HandleFunc("/api/rooms/", s.handleRoom) // /api/rooms/{roomID}[/events]
 
 	// CORS middleware wrapper for API endpoints
 	s.mux.HandleFunc("/", s.handleNotFound)
 }
 
-// Start begins serving requests. Blocks until shutdown.
+// Start begins serving requests, over HTTPS if TLS is configured. Blocks
+// until shutdown.
 func (s *Server) Start() error {
+	if s.TLSEnabled() {
+		return s.StartTLS(s.TLSCertFile, s.TLSKeyFile)
+	}
+	return s.serve(func() error { return s.httpServer.ListenAndServe() })
+}
+
+// StartTLS begins serving requests over HTTPS, so /ws is wss://. The files
+// may be empty if TLSConfig provides the certificate. Blocks until
+// shutdown.
+func (s *Server) StartTLS(certFile, keyFile string) error {
+	s.TLSCertFile, s.TLSKeyFile = certFile, keyFile
+	return s.serve(func() error { return s.httpServer.ListenAndServeTLS(certFile, keyFile) })
+}
+
+// serve sets up the HTTP server and runs listen until shutdown
+func (s *Server) serve(listen func() error) error {
+	if s.enableWebSocket && s.handler.upgrader == nil {
+		return fmt.Errorf("WebSocket support is not compiled in: rebuild with -tags websocket or set an upgrader")
+	}
+
 	s.httpServer = &http.Server{
 		Addr:         s.Addr,
 		Handler:      s.corsMiddleware(s.mux),
 		ReadTimeout:  s.ReadTimeout,
 		WriteTimeout: s.WriteTimeout,
+		TLSConfig:    s.TLSConfig,
 	}
 
 	// Start cleanup goroutine
@@ -117,19 +220,27 @@ func (s *Server) Start() error {
 	// Handle graceful shutdown
 	go s.handleShutdownSignals()
 
-	s.log("starting server on %s", s.Addr)
-	err := s.httpServer.ListenAndServe()
+	s.log(slog.LevelInfo, "starting server", "addr", s.Addr, "tls", s.TLSEnabled())
+	err := listen()
 	if err == http.ErrServerClosed {
 		return nil
 	}
 	return err
 }
 
+// TLSEnabled reports whether Start serves HTTPS
+func (s *Server) TLSEnabled() bool {
+	if s.TLSCertFile != "" && s.TLSKeyFile != "" {
+		return true
+	}
+	return s.TLSConfig != nil && (len(s.TLSConfig.Certificates) > 0 || s.TLSConfig.GetCertificate != nil)
+}
+
 // Shutdown gracefully stops the server.
 func (s *Server) Shutdown(ctx context.Context) error {
 	var err error
 	s.shutdownOnce.Do(func() {
-		s.log("shutting down...")
+		s.log(slog.LevelInfo, "shutting down")
 		close(s.done)
 
 		if s.httpServer != nil {
@@ -157,7 +268,7 @@ func (s *Server) cleanupLoop() {
 			stalePeers := s.registry.CleanupStale(s.StaleTimeout)
 			emptyRooms := s.rooms.CleanupEmpty()
 			if stalePeers > 0 || emptyRooms > 0 {
-				s.log("cleanup: removed %d stale peers, %d empty rooms", stalePeers, emptyRooms)
+				s.log(slog.LevelInfo, "cleanup", "stale_peers", stalePeers, "empty_rooms", emptyRooms)
 			}
 		}
 	}
@@ -170,7 +281,7 @@ func (s *Server) handleShutdownSignals() {
 
 	select {
 	case sig := <-sigChan:
-		s.log("received signal: %v", sig)
+		s.log(slog.LevelInfo, "received signal", "signal", sig.String())
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 		s.Shutdown(ctx)
@@ -235,6 +346,17 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleMetrics exposes metrics in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.handler.Metrics().WritePrometheus(w, s.registry, s.rooms)
+}
+
 // handleRooms returns list of rooms or creates a room.
 func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -245,11 +367,53 @@ func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
 			"rooms": stats.Rooms,
 		})
 
+	case http.MethodPost:
+		s.handleCreateRoom(w, r)
+
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// CreateRoomRequest is the body of POST /api/rooms.
+type CreateRoomRequest struct {
+	ID       string `json:"id"`
+	Password string `json:"password,omitempty"` // Required to join if set
+	MaxPeers int    `json:"max_peers,omitempty"`
+}
+
+// handleCreateRoom creates a room, optionally password-protected.
+func (s *Server) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	var req CreateRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		http.Error(w, "room ID required", http.StatusBadRequest)
+		return
+	}
+	if req.MaxPeers < 0 {
+		http.Error(w, "max_peers cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.rooms.CreateRoom(req.ID, req.Password, req.MaxPeers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":           room.ID,
+		"max_peers":    room.MaxPeers,
+		"has_password": room.HasPassword(),
+		"created_at":   room.CreatedAt.UnixMilli(),
+	})
+}
+
 // handleRoom returns details for a specific room.
 func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -257,8 +421,9 @@ func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract room ID from path: /api/rooms/{roomID}
+	// Extract room ID from path: /api/rooms/{roomID} or /api/rooms/{roomID}/events
 	roomID := r.URL.Path[len("/api/rooms/"):]
+	roomID, events := strings.CutSuffix(roomID, "/events")
 	if roomID == "" {
 		http.Error(w, "room ID required", http.StatusBadRequest)
 		return
@@ -271,12 +436,21 @@ func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if events {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":         room.ID,
+			"peer_count": room.Count(),
+			"events":     room.Events(),
+		})
+		return
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":         room.ID,
-		"peers":      room.PeerInfos(),
-		"peer_count": room.Count(),
-		"max_peers":  room.MaxPeers,
-		"created_at": room.CreatedAt.UnixMilli(),
+		"id":           room.ID,
+		"peers":        room.PeerInfos(),
+		"peer_count":   room.Count(),
+		"max_peers":    room.MaxPeers,
+		"has_password": room.HasPassword(),
+		"created_at":   room.CreatedAt.UnixMilli(),
 	})
 }
 
@@ -290,11 +464,10 @@ func (s *Server) handleNotFound(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// log writes a log message if a logger is configured.
-func (s *Server) log(format string, args ...interface{}) {
-	if s.Logger != nil {
-		s.Logger.Printf("[server] "+format, args...)
-	}
+// log writes a structured log record if a logger is configured. args are
+// slog key-value pairs.
+func (s *Server) log(level slog.Level, msg string, args ...any) {
+	logTo(s.SLogger, s.Logger, "[server] ", level, msg, args...)
 }
 
 // Handler returns the WebSocket handler for configuration.
@@ -318,7 +491,20 @@ func (s *Server) HandlerFunc() http.Handler {
 	return s.corsMiddleware(s.mux)
 }
 
-// ListenAddr returns the address format string.
+// ListenAddr returns the address format string, with an https scheme
+// when TLS is enabled.
 func (s *Server) ListenAddr() string {
+	if s.TLSEnabled() {
+		return fmt.Sprintf("https://localhost%s", s.Addr)
+	}
 	return fmt.Sprintf("http://localhost%s", s.Addr)
 }
+
+// WebSocketURL returns the URL clients connect to: ws://, or wss:// when
+// TLS is enabled.
+func (s *Server) WebSocketURL() string {
+	if s.TLSEnabled() {
+		return fmt.Sprintf("wss://localhost%s/ws", s.Addr)
+	}
+	return fmt.Sprintf("ws://localhost%s/ws", s.Addr)
+}
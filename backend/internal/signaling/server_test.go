@@ -1,11 +1,26 @@
 package signaling
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
 )
 
 func TestServerHealthEndpoint(t *testing.T) {
@@ -167,6 +182,70 @@ func TestServerRoomEndpoint(t *testing.T) {
 	}
 }
 
+func TestServerRoomEventsEndpoint(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Logger = nil
+	server := NewServer(cfg)
+	handler := server.Handler()
+
+	alice := NewPeer("alice", NewMockConn())
+	bob := NewPeer("bob", NewMockConn())
+	server.Registry().Register(alice)
+	server.Registry().Register(bob)
+
+	for _, msg := range []struct {
+		peer *Peer
+		msg  *protocol.Message
+	}{
+		{alice, &protocol.Message{Type: protocol.MessageTypeJoin, RoomID: "test-room"}},
+		{bob, &protocol.Message{Type: protocol.MessageTypeJoin, RoomID: "test-room"}},
+		{bob, &protocol.Message{Type: protocol.MessageTypeOffer, TargetID: "alice", Payload: json.RawMessage(`{}`)}},
+		{alice, &protocol.Message{Type: protocol.MessageTypeLeave}},
+	} {
+		if err := handler.handleMessage(msg.peer, msg.msg); err != nil {
+			t.Fatalf("failed to handle %s: %v", msg.msg.Type, err)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/rooms/test-room/events", nil)
+	w := httptest.NewRecorder()
+
+	server.HandlerFunc().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var response struct {
+		ID     string      `json:"id"`
+		Events []RoomEvent `json:"events"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	want := []RoomEventType{RoomEventJoined, RoomEventJoined, RoomEventOffer, RoomEventLeft}
+	if response.ID != "test-room" || len(response.Events) != len(want) {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	for i, event := range response.Events {
+		if event.Type != want[i] || event.Time.IsZero() {
+			t.Errorf("event %d = %+v, want %s", i, event, want[i])
+		}
+	}
+	if left := response.Events[3]; left.PeerID != "alice" || left.PeerCount != 1 {
+		t.Errorf("leave event = %+v, want alice leaving 1 peer behind", left)
+	}
+
+	// Unknown rooms have no events
+	req = httptest.NewRequest("GET", "/api/rooms/nonexistent/events", nil)
+	w = httptest.NewRecorder()
+	server.HandlerFunc().ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
 func TestServerRoomEndpointNotFound(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.Logger = nil
@@ -296,11 +375,115 @@ func TestServerAccessors(t *testing.T) {
 	if addr != "http://localhost:8080" {
 		t.Errorf("expected 'http://localhost:8080', got '%s'", addr)
 	}
+	if url := server.WebSocketURL(); url != "ws://localhost:8080/ws" {
+		t.Errorf("expected 'ws://localhost:8080/ws', got '%s'", url)
+	}
+	if server.TLSEnabled() {
+		t.Error("TLS should be off by default")
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// its key to dir, returning their paths and the certificate
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "altair-test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestServerStartTLS(t *testing.T) {
+	certFile, keyFile, cert := writeTestCertificate(t, t.TempDir())
+
+	// Find a free port for the server
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a port: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := DefaultConfig()
+	cfg.Logger = nil
+	cfg.Addr = addr
+	cfg.EnableWebSocket = false
+	server := NewServer(cfg)
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.StartTLS(certFile, keyFile) }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		Timeout:   time.Second,
+	}
+
+	var resp *http.Response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err = client.Get("https://" + addr + "/health")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET /health over TLS failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("expected 200 over TLS, got %d (TLS %v)", resp.StatusCode, resp.TLS != nil)
+	}
+	if !server.TLSEnabled() || !strings.HasPrefix(server.ListenAddr(), "https://") || !strings.HasPrefix(server.WebSocketURL(), "wss://") {
+		t.Errorf("expected https/wss addresses, got %s and %s", server.ListenAddr(), server.WebSocketURL())
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("StartTLS returned %v", err)
+	}
 }
 
 func TestServerWebSocketEndpointWithoutUpgrader(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.Logger = nil
+	cfg.EnableWebSocket = false
 	server := NewServer(cfg)
 
 	req := httptest.NewRequest("GET", "/ws", nil)
@@ -314,6 +497,21 @@ func TestServerWebSocketEndpointWithoutUpgrader(t *testing.T) {
 	}
 }
 
+func TestServerStartRequiresWebSocketSupport(t *testing.T) {
+	if WebSocketSupported() {
+		t.Skip("built with -tags websocket")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Logger = nil
+	cfg.Addr = "127.0.0.1:0"
+	server := NewServer(cfg)
+
+	if err := server.Start(); err == nil {
+		t.Error("Start should fail when WebSocket is enabled but not compiled in")
+	}
+}
+
 func TestServerWebSocketEndpointWithMockUpgrader(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.Logger = nil
@@ -337,3 +535,109 @@ func TestServerWebSocketEndpointWithMockUpgrader(t *testing.T) {
 		t.Errorf("expected 1 connection attempt, got %d", len(mockUpgrader.Connections))
 	}
 }
+
+func TestServerCreateRoom(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Logger = nil
+	server := NewServer(cfg)
+
+	body := `{"id": "pin-room", "password": "1234", "max_peers": 2}`
+	req := httptest.NewRequest("POST", "/api/rooms", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	server.HandlerFunc().ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", w.Code)
+	}
+
+	room := server.Rooms().Get("pin-room")
+	if room == nil {
+		t.Fatal("room should exist")
+	}
+	if !room.HasPassword() || !room.CheckPassword("1234") {
+		t.Error("room should be protected by the given password")
+	}
+	if room.MaxPeers != 2 {
+		t.Errorf("expected max peers 2, got %d", room.MaxPeers)
+	}
+
+	// Creating it again conflicts
+	req = httptest.NewRequest("POST", "/api/rooms", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	server.HandlerFunc().ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", w.Code)
+	}
+}
+
+func TestServerRoomsRequireCreation(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Logger = nil
+	cfg.AllowImplicitRooms = false
+	server := NewServer(cfg)
+
+	join := func(peerID string) protocol.Message {
+		conn := NewMockConn()
+		peer := NewPeer(peerID, conn)
+		server.Registry().Register(peer)
+		server.Handler().handleMessage(peer, protocol.NewMessage(protocol.MessageTypeJoin).WithRoomID("ops-room"))
+		peer.Flush()
+		return firstWritten(t, conn)
+	}
+
+	if response := join("p1"); response.Type != protocol.MessageTypeError {
+		t.Fatalf("join before creation: expected ERROR, got %s", response.Type)
+	}
+
+	req := httptest.NewRequest("POST", "/api/rooms", strings.NewReader(`{"id": "ops-room", "max_peers": 4}`))
+	w := httptest.NewRecorder()
+	server.HandlerFunc().ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", w.Code)
+	}
+
+	if response := join("p2"); response.Type != protocol.MessageTypeAck {
+		t.Errorf("join after creation: expected ACK, got %s", response.Type)
+	}
+	if room := server.Rooms().Get("ops-room"); room.MaxPeers != 4 || room.Count() != 1 {
+		t.Errorf("room has max %d and %d peers, want 4 and 1", room.MaxPeers, room.Count())
+	}
+}
+
+func TestServerMetricsEndpoint(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Logger = nil
+	server := NewServer(cfg)
+
+	peer := NewPeer("p1", NewMockConn())
+	server.Registry().Register(peer)
+	join := protocol.NewMessage(protocol.MessageTypeJoin).WithRoomID("room-1")
+	server.Handler().handleMessage(peer, join)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+
+	server.HandlerFunc().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected text/plain content type, got %s", ct)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE altair_signaling_peers gauge",
+		"altair_signaling_peers 1",
+		"altair_signaling_rooms 1",
+		`altair_signaling_messages_total{type="JOIN"} 1`,
+		`altair_signaling_message_duration_seconds_count{type="JOIN"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
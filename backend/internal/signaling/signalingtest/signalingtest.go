@@ -0,0 +1,191 @@
+// Package signalingtest connects signal clients to an in-process
+// signaling.Handler, for tests that need a server without a network or
+// WebSocket library.
+package signalingtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/saintparish4/altair/internal/signaling"
+	"github.com/saintparish4/altair/pkg/signalclient"
+)
+
+// Dialer connects clients to a signaling.Handler over in-memory
+// connections.
+type Dialer struct {
+	handler *signaling.Handler
+}
+
+// upgradeKey is the request context key for a pending upgrade
+type upgradeKey struct{}
+
+// pendingUpgrade hands the server end of a pipe to Upgrade
+type pendingUpgrade struct {
+	conn     signaling.Conn
+	upgraded chan struct{}
+}
+
+// NewDialer creates a dialer for handler and installs itself as the
+// handler's upgrader.
+func NewDialer(handler *signaling.Handler) *Dialer {
+	d := &Dialer{handler: handler}
+	handler.SetUpgrader(d)
+	return d
+}
+
+// Dial implements signalclient.Dialer. The handler serves the connection
+// until either end closes it.
+func (d *Dialer) Dial(ctx context.Context, url string, header http.Header) (signalclient.Conn, error) {
+	client, server := NewPipe()
+	upgrade := &pendingUpgrade{conn: server, upgraded: make(chan struct{})}
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req = req.WithContext(context.WithValue(context.Background(), upgradeKey{}, upgrade))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	rejected := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		d.handler.ServeHTTP(rec, req)
+		rejected <- rec.Code
+		server.Close()
+	}()
+
+	select {
+	case <-upgrade.upgraded:
+		return client, nil
+	case code := <-rejected:
+		return nil, fmt.Errorf("handshake rejected with status %d", code)
+	case <-ctx.Done():
+		server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Upgrade implements signaling.Upgrader.
+func (d *Dialer) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (signaling.Conn, error) {
+	upgrade, ok := r.Context().Value(upgradeKey{}).(*pendingUpgrade)
+	if !ok {
+		return nil, errors.New("request was not made by signalingtest.Dialer")
+	}
+	close(upgrade.upgraded)
+	return upgrade.conn, nil
+}
+
+// pipe is the state shared by both ends of an in-memory connection
+type pipe struct {
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Conn is one end of an in-memory WebSocket connection. Control frames
+// (pings and pongs) are dropped.
+type Conn struct {
+	pipe *pipe
+	in   chan []byte
+	out  chan []byte
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// NewPipe returns two connected ends
+func NewPipe() (*Conn, *Conn) {
+	p := &pipe{closed: make(chan struct{})}
+	a := make(chan []byte, 64)
+	b := make(chan []byte, 64)
+	return &Conn{pipe: p, in: a, out: b}, &Conn{pipe: p, in: b, out: a}
+}
+
+// WriteMessage implements signalclient.Conn and signaling.Conn.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType == signaling.PingMessage || messageType == signaling.PongMessage {
+		return nil
+	}
+
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+
+	select {
+	case <-c.pipe.closed:
+		return errors.New("connection closed")
+	default:
+	}
+
+	select {
+	case c.out <- dataCopy:
+		return nil
+	case <-c.pipe.closed:
+		return errors.New("connection closed")
+	}
+}
+
+// ReadMessage implements signalclient.Conn and signaling.Conn. Messages written before Close are still
+// delivered.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-c.in:
+		return signaling.TextMessage, data, nil
+	default:
+	}
+
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case data := <-c.in:
+		return signaling.TextMessage, data, nil
+	case <-c.pipe.closed:
+		select {
+		case data := <-c.in:
+			return signaling.TextMessage, data, nil
+		default:
+			return 0, nil, errors.New("connection closed")
+		}
+	case <-timeout:
+		return 0, nil, errors.New("read deadline exceeded")
+	}
+}
+
+// Close closes both ends.
+func (c *Conn) Close() error {
+	c.pipe.closeOnce.Do(func() {
+		close(c.pipe.closed)
+	})
+	return nil
+}
+
+// SetWriteDeadline implements signalclient.Conn and signaling.Conn.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline implements signalclient.Conn and signaling.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetReadLimit implements signaling.Conn.
+func (c *Conn) SetReadLimit(limit int64) {}
+
+// SetPongHandler implements signaling.Conn.
+func (c *Conn) SetPongHandler(h func(appData string) error) {}
@@ -0,0 +1,50 @@
+package signaling
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+)
+
+// DefaultTURNCredentialTTL is how long issued TURN credentials stay valid
+// when TURNConfig.TTL is unset.
+const DefaultTURNCredentialTTL = 1 * time.Hour
+
+// TURNConfig enables issuing short-lived TURN credentials over signaling.
+//
+// Credentials follow the shared-secret scheme used by coturn's
+// use-auth-secret (the "TURN REST API"): the username is
+// "<expiry unix time>:<peer ID>" and the password is
+// base64(HMAC-SHA1(secret, username)). A TURN server configured with the
+// same secret can verify them without talking to the signaling server.
+type TURNConfig struct {
+	Secret string        // Shared with the TURN server
+	Realm  string        // TURN realm, returned to clients
+	URIs   []string      // TURN server URIs, e.g. "turn:relay.example.com:3478"
+	TTL    time.Duration // Credential lifetime (default DefaultTURNCredentialTTL)
+}
+
+// Credentials issues credentials for peerID valid from now until now+TTL.
+func (c *TURNConfig) Credentials(peerID string, now time.Time) protocol.TURNCredentialsPayload {
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = DefaultTURNCredentialTTL
+	}
+
+	username := fmt.Sprintf("%d:%s", now.Add(ttl).Unix(), peerID)
+
+	mac := hmac.New(sha1.New, []byte(c.Secret))
+	mac.Write([]byte(username))
+
+	return protocol.TURNCredentialsPayload{
+		Username: username,
+		Password: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		TTL:      int(ttl / time.Second),
+		Realm:    c.Realm,
+		URIs:     c.URIs,
+	}
+}
@@ -0,0 +1,62 @@
+package signaling
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTURNCredentials(t *testing.T) {
+	cfg := &TURNConfig{
+		Secret: "north",
+		Realm:  "altair.example",
+		URIs:   []string{"turn:relay.example:3478"},
+		TTL:    10 * time.Minute,
+	}
+	now := time.Unix(1700000000, 0)
+
+	creds := cfg.Credentials("peer-1", now)
+
+	if creds.Username != "1700000600:peer-1" {
+		t.Errorf("expected username 1700000600:peer-1, got %s", creds.Username)
+	}
+	if creds.TTL != 600 {
+		t.Errorf("expected TTL 600, got %d", creds.TTL)
+	}
+	if creds.Realm != cfg.Realm || len(creds.URIs) != 1 {
+		t.Errorf("realm and URIs should be passed through, got %q %v", creds.Realm, creds.URIs)
+	}
+
+	// A TURN server holding the secret derives the same password
+	mac := hmac.New(sha1.New, []byte("north"))
+	mac.Write([]byte(creds.Username))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); creds.Password != want {
+		t.Errorf("expected password %s, got %s", want, creds.Password)
+	}
+
+	other := (&TURNConfig{Secret: "south"}).Credentials("peer-1", now)
+	if other.Password == creds.Password {
+		t.Error("different secrets should give different passwords")
+	}
+}
+
+func TestTURNCredentialsDefaultTTL(t *testing.T) {
+	now := time.Now()
+	creds := (&TURNConfig{Secret: "s"}).Credentials("p", now)
+
+	if creds.TTL != int(DefaultTURNCredentialTTL/time.Second) {
+		t.Errorf("expected default TTL, got %d", creds.TTL)
+	}
+
+	expiry, err := strconv.ParseInt(strings.SplitN(creds.Username, ":", 2)[0], 10, 64)
+	if err != nil {
+		t.Fatalf("username should start with an expiry: %v", err)
+	}
+	if expiry != now.Add(DefaultTURNCredentialTTL).Unix() {
+		t.Errorf("expected expiry %d, got %d", now.Add(DefaultTURNCredentialTTL).Unix(), expiry)
+	}
+}
@@ -0,0 +1,328 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNS record types used by DNS-SD
+const (
+	typeA   uint16 = 1
+	typePTR uint16 = 12
+	typeTXT uint16 = 16
+	typeSRV uint16 = 33
+	typeANY uint16 = 255
+)
+
+const (
+	classIN = 1
+
+	// classTopBit is the mDNS "unicast response" bit in questions and the
+	// "cache flush" bit in records (RFC 6762 sections 5.4 and 10.2)
+	classTopBit = 0x8000
+
+	flagResponse      = 0x8000
+	flagAuthoritative = 0x0400
+
+	dnsHeaderSize = 12
+	maxLabelSize  = 63
+)
+
+// question is a DNS question
+type question struct {
+	name    string
+	qtype   uint16
+	unicast bool // QU bit: the querier wants a unicast reply
+}
+
+// record is a DNS resource record. Only the fields relevant to its type are
+// set.
+type record struct {
+	name  string
+	rtype uint16
+	ttl   uint32
+
+	target string // PTR and SRV
+	port   uint16 // SRV
+	ip     net.IP // A
+	txt    []string
+}
+
+// message is a DNS message restricted to what mDNS service discovery needs
+type message struct {
+	id          uint16
+	response    bool
+	questions   []question
+	answers     []record
+	additionals []record
+}
+
+// encode serializes the message. Names are written uncompressed.
+func (m *message) encode() ([]byte, error) {
+	buf := make([]byte, dnsHeaderSize, 512)
+	binary.BigEndian.PutUint16(buf[0:2], m.id)
+	if m.response {
+		binary.BigEndian.PutUint16(buf[2:4], flagResponse|flagAuthoritative)
+	}
+	binary.BigEndian.PutUint16(buf[4:6], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(buf[6:8], uint16(len(m.answers)))
+	binary.BigEndian.PutUint16(buf[10:12], uint16(len(m.additionals)))
+
+	var err error
+	for _, q := range m.questions {
+		if buf, err = appendName(buf, q.name); err != nil {
+			return nil, err
+		}
+		class := uint16(classIN)
+		if q.unicast {
+			class |= classTopBit
+		}
+		buf = binary.BigEndian.AppendUint16(buf, q.qtype)
+		buf = binary.BigEndian.AppendUint16(buf, class)
+	}
+
+	for _, section := range [][]record{m.answers, m.additionals} {
+		for _, r := range section {
+			if buf, err = appendRecord(buf, r); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf, nil
+}
+
+func appendRecord(buf []byte, r record) ([]byte, error) {
+	var err error
+	if buf, err = appendName(buf, r.name); err != nil {
+		return nil, err
+	}
+
+	// Records for unique names (everything but the shared PTR) set
+	// cache-flush
+	class := uint16(classIN)
+	if r.rtype != typePTR {
+		class |= classTopBit
+	}
+	buf = binary.BigEndian.AppendUint16(buf, r.rtype)
+	buf = binary.BigEndian.AppendUint16(buf, class)
+	buf = binary.BigEndian.AppendUint32(buf, r.ttl)
+
+	// Reserve RDLENGTH and fill it in once RDATA is written
+	lengthAt := len(buf)
+	buf = append(buf, 0, 0)
+
+	switch r.rtype {
+	case typeA:
+		ip4 := r.ip.To4()
+		if ip4 == nil {
+			return nil, fmt.Errorf("A record for %s needs an IPv4 address", r.name)
+		}
+		buf = append(buf, ip4...)
+	case typePTR:
+		if buf, err = appendName(buf, r.target); err != nil {
+			return nil, err
+		}
+	case typeSRV:
+		buf = binary.BigEndian.AppendUint16(buf, 0) // priority
+		buf = binary.BigEndian.AppendUint16(buf, 0) // weight
+		buf = binary.BigEndian.AppendUint16(buf, r.port)
+		if buf, err = appendName(buf, r.target); err != nil {
+			return nil, err
+		}
+	case typeTXT:
+		if len(r.txt) == 0 {
+			buf = append(buf, 0) // a TXT record holds at least one string
+		}
+		for _, s := range r.txt {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("TXT string too long: %d bytes", len(s))
+			}
+			buf = append(buf, byte(len(s)))
+			buf = append(buf, s...)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported record type %d", r.rtype)
+	}
+
+	binary.BigEndian.PutUint16(buf[lengthAt:], uint16(len(buf)-lengthAt-2))
+	return buf, nil
+}
+
+// appendName writes a dotted name as DNS labels
+func appendName(buf []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > maxLabelSize {
+				return nil, fmt.Errorf("invalid DNS name %q", name)
+			}
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, label...)
+		}
+	}
+	return append(buf, 0), nil
+}
+
+// decodeMessage parses a DNS message. Records of types we don't use are
+// skipped.
+func decodeMessage(data []byte) (*message, error) {
+	if len(data) < dnsHeaderSize {
+		return nil, fmt.Errorf("message too short: %d bytes", len(data))
+	}
+
+	m := &message{
+		id:       binary.BigEndian.Uint16(data[0:2]),
+		response: binary.BigEndian.Uint16(data[2:4])&flagResponse != 0,
+	}
+	qdCount := int(binary.BigEndian.Uint16(data[4:6]))
+	anCount := int(binary.BigEndian.Uint16(data[6:8]))
+	nsCount := int(binary.BigEndian.Uint16(data[8:10]))
+	arCount := int(binary.BigEndian.Uint16(data[10:12]))
+
+	offset := dnsHeaderSize
+	for i := 0; i < qdCount; i++ {
+		name, next, err := readName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(data) {
+			return nil, fmt.Errorf("truncated question")
+		}
+		class := binary.BigEndian.Uint16(data[next+2 : next+4])
+		m.questions = append(m.questions, question{
+			name:    name,
+			qtype:   binary.BigEndian.Uint16(data[next : next+2]),
+			unicast: class&classTopBit != 0,
+		})
+		offset = next + 4
+	}
+
+	for i := 0; i < anCount+nsCount+arCount; i++ {
+		r, next, err := readRecord(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
+
+		if r == nil {
+			continue
+		}
+		switch {
+		case i < anCount:
+			m.answers = append(m.answers, *r)
+		case i >= anCount+nsCount:
+			m.additionals = append(m.additionals, *r)
+		}
+	}
+
+	return m, nil
+}
+
+// readRecord parses the record at offset. It returns a nil record for
+// types we don't use.
+func readRecord(data []byte, offset int) (*record, int, error) {
+	name, next, err := readName(data, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if next+10 > len(data) {
+		return nil, 0, fmt.Errorf("truncated record")
+	}
+
+	r := &record{
+		name:  name,
+		rtype: binary.BigEndian.Uint16(data[next : next+2]),
+		ttl:   binary.BigEndian.Uint32(data[next+4 : next+8]),
+	}
+	rdLength := int(binary.BigEndian.Uint16(data[next+8 : next+10]))
+	rdStart := next + 10
+	rdEnd := rdStart + rdLength
+	if rdEnd > len(data) {
+		return nil, 0, fmt.Errorf("truncated record data")
+	}
+	rdata := data[rdStart:rdEnd]
+
+	switch r.rtype {
+	case typeA:
+		if len(rdata) != net.IPv4len {
+			return nil, 0, fmt.Errorf("invalid A record length %d", len(rdata))
+		}
+		r.ip = net.IP(append([]byte(nil), rdata...))
+	case typePTR:
+		if r.target, _, err = readName(data, rdStart); err != nil {
+			return nil, 0, err
+		}
+	case typeSRV:
+		if len(rdata) < 7 {
+			return nil, 0, fmt.Errorf("invalid SRV record length %d", len(rdata))
+		}
+		r.port = binary.BigEndian.Uint16(rdata[4:6])
+		if r.target, _, err = readName(data, rdStart+6); err != nil {
+			return nil, 0, err
+		}
+	case typeTXT:
+		for i := 0; i < len(rdata); {
+			n := int(rdata[i])
+			if i+1+n > len(rdata) {
+				return nil, 0, fmt.Errorf("truncated TXT string")
+			}
+			if n > 0 {
+				r.txt = append(r.txt, string(rdata[i+1:i+1+n]))
+			}
+			i += 1 + n
+		}
+	default:
+		return nil, rdEnd, nil
+	}
+
+	return r, rdEnd, nil
+}
+
+// readName parses a possibly compressed name at offset and returns it with
+// a trailing dot, plus the offset just past it in the original position.
+func readName(data []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+
+	// Each pointer must go backwards, which also bounds the loop
+	for limit := offset; ; {
+		if offset >= len(data) {
+			return "", 0, fmt.Errorf("truncated name")
+		}
+
+		length := int(data[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+
+		case length&0xC0 == 0xC0:
+			if offset+2 > len(data) {
+				return "", 0, fmt.Errorf("truncated name pointer")
+			}
+			pointer := int(binary.BigEndian.Uint16(data[offset:offset+2]) & 0x3FFF)
+			if pointer >= limit {
+				return "", 0, fmt.Errorf("invalid name pointer")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset, limit = pointer, pointer
+
+		case length > maxLabelSize:
+			return "", 0, fmt.Errorf("invalid label length %d", length)
+
+		default:
+			if offset+1+length > len(data) {
+				return "", 0, fmt.Errorf("truncated label")
+			}
+			labels = append(labels, string(data[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
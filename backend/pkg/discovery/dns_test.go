@@ -0,0 +1,87 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMessageEncodeDecode(t *testing.T) {
+	original := &message{
+		id:        0x1234,
+		response:  true,
+		questions: []question{{name: ServiceType, qtype: typePTR, unicast: true}},
+		answers: []record{
+			{name: ServiceType, rtype: typePTR, ttl: 120, target: "alice." + ServiceType},
+		},
+		additionals: []record{
+			{name: "alice." + ServiceType, rtype: typeSRV, ttl: 120, target: "host.local.", port: 4242},
+			{name: "alice." + ServiceType, rtype: typeTXT, ttl: 120, txt: []string{"v=1"}},
+			{name: "host.local.", rtype: typeA, ttl: 120, ip: net.IPv4(192, 168, 1, 10)},
+		},
+	}
+
+	data, err := original.encode()
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	decoded, err := decodeMessage(data)
+	if err != nil {
+		t.Fatalf("decodeMessage failed: %v", err)
+	}
+
+	if decoded.id != 0x1234 || !decoded.response {
+		t.Errorf("header = id %#x response %v", decoded.id, decoded.response)
+	}
+	if len(decoded.questions) != 1 || !decoded.questions[0].unicast || decoded.questions[0].name != ServiceType {
+		t.Errorf("questions = %+v", decoded.questions)
+	}
+	if len(decoded.answers) != 1 || decoded.answers[0].target != "alice."+ServiceType {
+		t.Errorf("answers = %+v", decoded.answers)
+	}
+	if len(decoded.additionals) != 3 {
+		t.Fatalf("expected 3 additionals, got %d", len(decoded.additionals))
+	}
+
+	srv, txt, a := decoded.additionals[0], decoded.additionals[1], decoded.additionals[2]
+	if srv.port != 4242 || srv.target != "host.local." {
+		t.Errorf("SRV = %+v", srv)
+	}
+	if len(txt.txt) != 1 || txt.txt[0] != "v=1" {
+		t.Errorf("TXT = %+v", txt)
+	}
+	if !a.ip.Equal(net.IPv4(192, 168, 1, 10)) {
+		t.Errorf("A = %v", a.ip)
+	}
+}
+
+func TestReadNameCompression(t *testing.T) {
+	// "local." at offset 0, then "host" + pointer to offset 0
+	data := []byte{5, 'l', 'o', 'c', 'a', 'l', 0, 4, 'h', 'o', 's', 't', 0xC0, 0x00}
+
+	name, next, err := readName(data, 7)
+	if err != nil {
+		t.Fatalf("readName failed: %v", err)
+	}
+	if name != "host.local." || next != len(data) {
+		t.Errorf("readName = %q, %d; want %q, %d", name, next, "host.local.", len(data))
+	}
+
+	// A pointer to itself must not loop forever
+	if _, _, err := readName([]byte{0xC0, 0x00}, 0); err == nil {
+		t.Error("readName should reject a self-referencing pointer")
+	}
+}
+
+func TestDecodeMessageTruncated(t *testing.T) {
+	data, err := (&message{questions: []question{{name: ServiceType, qtype: typePTR}}}).encode()
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	for n := 0; n < len(data); n++ {
+		if _, err := decodeMessage(data[:n]); err == nil {
+			t.Errorf("decodeMessage accepted %d of %d bytes", n, len(data))
+		}
+	}
+}
@@ -0,0 +1,370 @@
+// Package discovery finds Altair peers on the local network with multicast
+// DNS service discovery (RFC 6762, RFC 6763), so two devices on the same LAN
+// can connect without STUN or a signaling server.
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/saintparish4/altair/pkg/netutil"
+)
+
+const (
+	// ServiceType is the DNS-SD service Altair peers advertise
+	ServiceType = "_altair._udp.local."
+
+	// recordTTL is how long (seconds) others may cache our records
+	recordTTL = 120
+
+	maxPacketSize = 9000
+
+	// maxReadErrors consecutive read failures stop an Advertiser; the
+	// waits between them start at readErrorBackoff (see netutil.Backoff)
+	maxReadErrors    = 5
+	readErrorBackoff = 10 * time.Millisecond
+)
+
+// mdnsGroup is the IPv4 mDNS multicast address
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// LocalPeer is a peer found on the local network
+type LocalPeer struct {
+	Name  string         // Instance name passed to AdvertiseLocal
+	Addrs []*net.UDPAddr // Candidate addresses, suitable for punch.PeerInfo.LocalAddrs
+}
+
+// Advertiser answers mDNS queries for one advertised instance until closed
+type Advertiser struct {
+	conn     *net.UDPConn
+	instance string
+	host     string
+	port     int
+	ips      []net.IP
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// AdvertiseLocal announces this peer as name on the local network,
+// reachable on port at every local IPv4 address. It answers queries from
+// DiscoverLocal until Close is called.
+func AdvertiseLocal(name string, port int) (*Advertiser, error) {
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+
+	label := instanceLabel(name)
+	if label == "" {
+		return nil, fmt.Errorf("invalid instance name %q", name)
+	}
+
+	ips, err := localIPv4s()
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no IPv4 addresses to advertise")
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join mDNS group: %w", err)
+	}
+
+	a := newAdvertiser(conn, label, port, ips)
+
+	// Announce so listeners learn about us without asking
+	a.send(a.response(0, nil, recordTTL), mdnsGroup)
+
+	go a.serve()
+	return a, nil
+}
+
+func newAdvertiser(conn *net.UDPConn, label string, port int, ips []net.IP) *Advertiser {
+	return &Advertiser{
+		conn:     conn,
+		instance: label + "." + ServiceType,
+		host:     fmt.Sprintf("altair-%08x.local.", rand.Uint32()),
+		port:     port,
+		ips:      ips,
+		done:     make(chan struct{}),
+	}
+}
+
+// Close stops answering queries and tells listeners to forget the instance
+func (a *Advertiser) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		close(a.done)
+		a.send(a.response(0, nil, 0), mdnsGroup) // goodbye (TTL 0)
+		err = a.conn.Close()
+	})
+	return err
+}
+
+// serve answers queries that mention our service, instance or host. It
+// returns once the socket is closed, and gives up after maxReadErrors
+// failed reads in a row rather than spinning on a broken socket.
+func (a *Advertiser) serve() {
+	buf := make([]byte, maxPacketSize)
+	failures := 0
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			failures++
+			if failures >= maxReadErrors {
+				return
+			}
+			select {
+			case <-a.done:
+				return
+			case <-time.After(netutil.Backoff(failures-1, readErrorBackoff)):
+			}
+			continue
+		}
+		failures = 0
+
+		query, err := decodeMessage(buf[:n])
+		if err != nil || query.response || !a.matches(query.questions) {
+			continue
+		}
+
+		// Queries not from port 5353 are one-shot "legacy" queries that
+		// expect a unicast reply echoing the ID and questions. QU
+		// questions also ask for unicast; everything else is multicast.
+		legacy := from.Port != mdnsGroup.Port
+		switch {
+		case legacy:
+			a.send(a.response(query.id, query.questions, recordTTL), from)
+		case query.questions[0].unicast:
+			a.send(a.response(0, nil, recordTTL), from)
+		default:
+			a.send(a.response(0, nil, recordTTL), mdnsGroup)
+		}
+	}
+}
+
+// matches reports whether any question is about this advertiser
+func (a *Advertiser) matches(questions []question) bool {
+	for _, q := range questions {
+		if q.qtype != typePTR && q.qtype != typeSRV && q.qtype != typeTXT &&
+			q.qtype != typeA && q.qtype != typeANY {
+			continue
+		}
+		if strings.EqualFold(q.name, ServiceType) ||
+			strings.EqualFold(q.name, a.instance) ||
+			strings.EqualFold(q.name, a.host) {
+			return true
+		}
+	}
+	return false
+}
+
+// response builds the full record set: PTR as the answer, SRV, TXT and A
+// as additionals
+func (a *Advertiser) response(id uint16, questions []question, ttl uint32) *message {
+	m := &message{
+		id:        id,
+		response:  true,
+		questions: questions,
+		answers: []record{
+			{name: ServiceType, rtype: typePTR, ttl: ttl, target: a.instance},
+		},
+		additionals: []record{
+			{name: a.instance, rtype: typeSRV, ttl: ttl, target: a.host, port: uint16(a.port)},
+			{name: a.instance, rtype: typeTXT, ttl: ttl},
+		},
+	}
+	for _, ip := range a.ips {
+		m.additionals = append(m.additionals, record{name: a.host, rtype: typeA, ttl: ttl, ip: ip})
+	}
+	return m
+}
+
+func (a *Advertiser) send(m *message, to *net.UDPAddr) {
+	data, err := m.encode()
+	if err != nil {
+		return
+	}
+	a.conn.WriteToUDP(data, to)
+}
+
+// DiscoverLocal queries the local network for advertised peers and collects
+// answers until timeout. Our own Advertiser, if any, is included; filter it
+// out by name.
+func DiscoverLocal(timeout time.Duration) ([]LocalPeer, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	return discover(conn, mdnsGroup, timeout)
+}
+
+// discover sends PTR queries for ServiceType to dest from conn and parses
+// responses until timeout
+func discover(conn *net.UDPConn, dest *net.UDPAddr, timeout time.Duration) ([]LocalPeer, error) {
+	query := &message{
+		id:        uint16(rand.Uint32()),
+		questions: []question{{name: ServiceType, qtype: typePTR, unicast: true}},
+	}
+	data, err := query.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	send := func() error {
+		if _, err := conn.WriteToUDP(data, dest); err != nil {
+			return fmt.Errorf("failed to send mDNS query: %w", err)
+		}
+		return nil
+	}
+	if err := send(); err != nil {
+		return nil, err
+	}
+
+	results := newResultSet()
+	deadline := time.Now().Add(timeout)
+
+	// Ask again halfway through in case the first query was lost
+	resend := time.Now().Add(timeout / 2)
+	buf := make([]byte, maxPacketSize)
+	for time.Now().Before(deadline) {
+		readUntil := deadline
+		if !resend.IsZero() {
+			readUntil = resend
+		}
+
+		conn.SetReadDeadline(readUntil)
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				return nil, fmt.Errorf("failed to read mDNS response: %w", err)
+			}
+			if !resend.IsZero() && !time.Now().Before(resend) {
+				resend = time.Time{}
+				if err := send(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		msg, err := decodeMessage(buf[:n])
+		if err != nil || !msg.response {
+			continue
+		}
+		results.add(msg)
+	}
+
+	return results.peers(), nil
+}
+
+// resultSet merges records from many responses. Records can arrive in any
+// order and across packets, so peers are assembled at the end.
+type resultSet struct {
+	instances map[string]string   // lowercased instance -> name from PTR
+	srv       map[string]record   // lowercased instance -> SRV
+	hosts     map[string][]net.IP // lowercased host -> A addresses
+	order     []string            // instances in discovery order
+}
+
+func newResultSet() *resultSet {
+	return &resultSet{
+		instances: make(map[string]string),
+		srv:       make(map[string]record),
+		hosts:     make(map[string][]net.IP),
+	}
+}
+
+func (rs *resultSet) add(m *message) {
+	for _, r := range append(m.answers, m.additionals...) {
+		name := strings.ToLower(r.name)
+		switch r.rtype {
+		case typePTR:
+			if name == ServiceType && r.ttl > 0 {
+				instance := strings.ToLower(r.target)
+				if _, ok := rs.instances[instance]; !ok {
+					rs.instances[instance] = instanceName(r.target)
+					rs.order = append(rs.order, instance)
+				}
+			}
+		case typeSRV:
+			rs.srv[name] = r
+		case typeA:
+			if !slices.ContainsFunc(rs.hosts[name], r.ip.Equal) {
+				rs.hosts[name] = append(rs.hosts[name], r.ip)
+			}
+		}
+	}
+}
+
+func (rs *resultSet) peers() []LocalPeer {
+	var peers []LocalPeer
+	for _, instance := range rs.order {
+		srv, ok := rs.srv[instance]
+		if !ok {
+			continue
+		}
+
+		peer := LocalPeer{Name: rs.instances[instance]}
+		for _, ip := range rs.hosts[strings.ToLower(srv.target)] {
+			peer.Addrs = append(peer.Addrs, &net.UDPAddr{IP: ip, Port: int(srv.port)})
+		}
+		if len(peer.Addrs) > 0 {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// instanceLabel turns a display name into a single DNS label
+func instanceLabel(name string) string {
+	label := strings.Map(func(r rune) rune {
+		if r == '.' || r < 0x20 {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(name))
+
+	// Trim whole runes so the label stays valid UTF-8
+	for len(label) > maxLabelSize {
+		_, size := utf8.DecodeLastRuneInString(label)
+		label = label[:len(label)-size]
+	}
+	return label
+}
+
+// instanceName strips the service suffix from a full instance name
+func instanceName(instance string) string {
+	return strings.TrimSuffix(instance, "."+ServiceType)
+}
+
+// localIPv4s returns the IPv4 addresses to advertise
+func localIPv4s() ([]net.IP, error) {
+	addrs, err := netutil.GetLocalAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, ip := range addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			ips = append(ips, ip4)
+		}
+	}
+	return ips, nil
+}
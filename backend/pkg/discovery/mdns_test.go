@@ -0,0 +1,149 @@
+package discovery
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiscoverFindsAdvertiser(t *testing.T) {
+	// Exercise the query/response path over loopback unicast so the test
+	// doesn't depend on multicast being available
+	serverConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to create UDP connection: %v", err)
+	}
+
+	ips := []net.IP{net.IPv4(192, 168, 1, 10), net.IPv4(10, 0, 0, 5)}
+	advertiser := newAdvertiser(serverConn, "Alice's Laptop", 4242, ips)
+	go advertiser.serve()
+	defer serverConn.Close()
+
+	clientConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to create UDP connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	peers, err := discover(clientConn, serverConn.LocalAddr().(*net.UDPAddr), 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("discover failed: %v", err)
+	}
+
+	if len(peers) != 1 {
+		t.Fatalf("expected 1 peer, got %d", len(peers))
+	}
+	if peers[0].Name != "Alice's Laptop" {
+		t.Errorf("Name = %q, want %q", peers[0].Name, "Alice's Laptop")
+	}
+	if len(peers[0].Addrs) != len(ips) {
+		t.Fatalf("expected %d addresses, got %v", len(ips), peers[0].Addrs)
+	}
+	for i, addr := range peers[0].Addrs {
+		if !addr.IP.Equal(ips[i]) || addr.Port != 4242 {
+			t.Errorf("Addrs[%d] = %v, want %v:4242", i, addr, ips[i])
+		}
+	}
+}
+
+func TestAdvertiserIgnoresOtherServices(t *testing.T) {
+	advertiser := newAdvertiser(nil, "alice", 4242, []net.IP{net.IPv4(192, 168, 1, 10)})
+
+	if advertiser.matches([]question{{name: "_http._tcp.local.", qtype: typePTR}}) {
+		t.Error("should not answer queries for other services")
+	}
+	if !advertiser.matches([]question{{name: strings.ToUpper(ServiceType), qtype: typePTR}}) {
+		t.Error("service names should match case-insensitively")
+	}
+	if !advertiser.matches([]question{{name: advertiser.instance, qtype: typeSRV}}) {
+		t.Error("should answer SRV queries for its instance")
+	}
+}
+
+func TestResultSetGoodbye(t *testing.T) {
+	advertiser := newAdvertiser(nil, "alice", 4242, []net.IP{net.IPv4(192, 168, 1, 10)})
+
+	rs := newResultSet()
+	rs.add(advertiser.response(0, nil, 0)) // goodbye
+
+	if peers := rs.peers(); len(peers) != 0 {
+		t.Errorf("goodbye announcement should not produce peers, got %v", peers)
+	}
+}
+
+func TestInstanceLabel(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"alice", "alice"},
+		{"  bob  ", "bob"},
+		{"alice.laptop", "alice-laptop"},
+		{"", ""},
+		{strings.Repeat("é", 40), strings.Repeat("é", 31)}, // 2 bytes each, trimmed to 62
+	}
+
+	for _, tt := range tests {
+		if result := instanceLabel(tt.name); result != tt.expected {
+			t.Errorf("instanceLabel(%q) = %q, want %q", tt.name, result, tt.expected)
+		}
+	}
+}
+
+func TestAdvertiseLocalValidation(t *testing.T) {
+	if _, err := AdvertiseLocal("alice", 0); err == nil {
+		t.Error("AdvertiseLocal should reject port 0")
+	}
+	if _, err := AdvertiseLocal("   ", 4242); err == nil {
+		t.Error("AdvertiseLocal should reject an empty name")
+	}
+}
+
+func TestAdvertiserServeStops(t *testing.T) {
+	// Neither case closes the Advertiser, so serve has to notice the
+	// socket failing on its own
+	run := func(conn *net.UDPConn) <-chan struct{} {
+		advertiser := newAdvertiser(conn, "alice", 4242, []net.IP{net.IPv4(192, 168, 1, 10)})
+		stopped := make(chan struct{})
+		go func() {
+			advertiser.serve()
+			close(stopped)
+		}()
+		return stopped
+	}
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("Failed to create UDP connection: %v", err)
+		}
+		return conn
+	}
+
+	t.Run("socket closed", func(t *testing.T) {
+		conn := listen()
+		stopped := run(conn)
+		time.Sleep(20 * time.Millisecond)
+		conn.Close()
+
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("serve kept running after its socket was closed")
+		}
+	})
+
+	t.Run("repeated read errors", func(t *testing.T) {
+		// A deadline in the past fails every read without closing the socket
+		conn := listen()
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(-time.Second))
+		stopped := run(conn)
+
+		select {
+		case <-stopped:
+		case <-time.After(2 * time.Second):
+			t.Fatal("serve kept retrying after repeated read errors")
+		}
+	})
+}
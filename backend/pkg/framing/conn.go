@@ -0,0 +1,283 @@
+// Package framing carries whole messages over a datagram connection such as
+// a punched or relayed UDP path.
+//
+// Messages larger than one datagram are split into fragments that each fit
+// under the path MTU, tagged with a message ID and their position, and put
+// back together on the other side. There are no retransmits: a message
+// missing a fragment is dropped once its reassembly times out, so callers
+// see whole messages or nothing. Use pkg/reliable where every byte must
+// arrive.
+package framing
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxDatagramSize bounds the datagrams read from the underlying conn
+	maxDatagramSize = 65535
+
+	// maxFragments is the most fragments the header can number
+	maxFragments = 1<<16 - 1
+)
+
+// ErrMessageTooLarge is returned by WriteMessage for messages over the
+// configured MaxMessageSize
+var ErrMessageTooLarge = errors.New("message too large")
+
+// Config holds configuration for a framed connection. Both ends should use
+// the same MaxMessageSize.
+type Config struct {
+	// Maximum payload bytes per fragment. Keep header plus payload below
+	// the path MTU to avoid IP fragmentation.
+	FragmentSize int
+
+	// Largest message that may be sent or reassembled
+	MaxMessageSize int
+
+	// How long an incomplete message waits for its missing fragments
+	// before it is dropped
+	ReassemblyTimeout time.Duration
+
+	// Maximum number of incomplete messages held at once; the oldest is
+	// dropped to make room for a new one
+	MaxPending int
+}
+
+// DefaultConfig returns a configuration with sensible defaults
+func DefaultConfig() *Config {
+	return &Config{
+		FragmentSize:      1200,
+		MaxMessageSize:    1 << 20,
+		ReassemblyTimeout: 5 * time.Second,
+		MaxPending:        16,
+	}
+}
+
+// partial is a message still being reassembled
+type partial struct {
+	fragments [][]byte
+	received  int
+	size      int
+	startedAt time.Time
+}
+
+// Conn sends and receives whole messages over a datagram connection
+type Conn struct {
+	conn net.Conn
+
+	fragmentSize      int
+	maxMessageSize    int
+	reassemblyTimeout time.Duration
+	maxPending        int
+
+	nextID  atomic.Uint32
+	dropped atomic.Uint64
+
+	// readMu serializes ReadMessage and guards the reassembly state
+	readMu  sync.Mutex
+	pending map[uint32]*partial
+	buf     []byte
+}
+
+// New frames messages over conn, which must preserve datagram boundaries:
+// a message larger than config.FragmentSize goes out as several
+// fragments and is handed to ReadMessage only once all of them arrive.
+// Closing the Conn closes conn.
+func New(conn net.Conn, config *Config) (*Conn, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("connection cannot be nil")
+	}
+
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	if config.FragmentSize <= 0 || config.FragmentSize > maxDatagramSize-headerSize {
+		return nil, fmt.Errorf("invalid fragment size %d", config.FragmentSize)
+	}
+	if config.MaxMessageSize <= 0 {
+		return nil, fmt.Errorf("max message size must be positive")
+	}
+	if fragmentCount(config.MaxMessageSize, config.FragmentSize) > maxFragments {
+		return nil, fmt.Errorf("max message size %d needs more than %d fragments of %d bytes",
+			config.MaxMessageSize, maxFragments, config.FragmentSize)
+	}
+	if config.ReassemblyTimeout <= 0 {
+		return nil, fmt.Errorf("reassembly timeout must be positive")
+	}
+	if config.MaxPending <= 0 {
+		return nil, fmt.Errorf("max pending must be positive")
+	}
+
+	return &Conn{
+		conn:              conn,
+		fragmentSize:      config.FragmentSize,
+		maxMessageSize:    config.MaxMessageSize,
+		reassemblyTimeout: config.ReassemblyTimeout,
+		maxPending:        config.MaxPending,
+		pending:           make(map[uint32]*partial),
+		buf:               make([]byte, maxDatagramSize),
+	}, nil
+}
+
+// fragmentCount returns how many fragments a message of size bytes needs.
+// An empty message still takes one.
+func fragmentCount(size, fragmentSize int) int {
+	return max(1, (size+fragmentSize-1)/fragmentSize)
+}
+
+// WriteMessage sends msg as one or more fragments. It is safe to call
+// concurrently; fragments of different messages may interleave.
+func (c *Conn) WriteMessage(msg []byte) error {
+	if len(msg) > c.maxMessageSize {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrMessageTooLarge, len(msg), c.maxMessageSize)
+	}
+
+	id := c.nextID.Add(1)
+	count := fragmentCount(len(msg), c.fragmentSize)
+	for i := 0; i < count; i++ {
+		start := i * c.fragmentSize
+		end := min(start+c.fragmentSize, len(msg))
+		f := fragment{id: id, index: uint16(i), count: uint16(count), payload: msg[start:end]}
+		if _, err := c.conn.Write(f.encode()); err != nil {
+			return fmt.Errorf("failed to send fragment %d/%d: %w", i+1, count, err)
+		}
+	}
+	return nil
+}
+
+// ReadMessage returns the next complete message. Datagrams that aren't
+// fragments are skipped, and messages missing fragments are dropped once
+// they time out (see Dropped). Read deadlines set on the Conn apply.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for {
+		n, err := c.conn.Read(c.buf)
+		if err != nil {
+			return nil, err
+		}
+
+		f, ok := decodeFragment(c.buf[:n])
+		if !ok {
+			continue
+		}
+
+		c.expire(time.Now())
+		if msg, ok := c.add(f); ok {
+			return msg, nil
+		}
+	}
+}
+
+// add files a fragment, returning the message once it is complete.
+// Callers hold readMu.
+func (c *Conn) add(f fragment) ([]byte, bool) {
+	if f.count == 1 {
+		if len(f.payload) > c.maxMessageSize {
+			return nil, false
+		}
+		return f.payload, true
+	}
+
+	p, ok := c.pending[f.id]
+	if !ok {
+		// Refuse messages that could never fit before buffering anything
+		if int(f.count-1)*c.fragmentSize >= c.maxMessageSize {
+			c.dropped.Add(1)
+			return nil, false
+		}
+		if len(c.pending) >= c.maxPending {
+			c.dropOldest()
+		}
+		p = &partial{fragments: make([][]byte, f.count), startedAt: time.Now()}
+		c.pending[f.id] = p
+	}
+
+	if int(f.count) != len(p.fragments) || p.fragments[f.index] != nil {
+		// A duplicate, or a fragment disagreeing about the count
+		return nil, false
+	}
+
+	p.fragments[f.index] = f.payload
+	p.received++
+	p.size += len(f.payload)
+	if p.size > c.maxMessageSize {
+		delete(c.pending, f.id)
+		c.dropped.Add(1)
+		return nil, false
+	}
+	if p.received < len(p.fragments) {
+		return nil, false
+	}
+
+	delete(c.pending, f.id)
+	msg := make([]byte, 0, p.size)
+	for _, payload := range p.fragments {
+		msg = append(msg, payload...)
+	}
+	return msg, true
+}
+
+// expire drops incomplete messages older than the reassembly timeout.
+// Callers hold readMu.
+func (c *Conn) expire(now time.Time) {
+	for id, p := range c.pending {
+		if now.Sub(p.startedAt) >= c.reassemblyTimeout {
+			delete(c.pending, id)
+			c.dropped.Add(1)
+		}
+	}
+}
+
+// dropOldest drops the incomplete message that started first. Callers
+// hold readMu.
+func (c *Conn) dropOldest() {
+	var oldest uint32
+	var startedAt time.Time
+	for id, p := range c.pending {
+		if startedAt.IsZero() || p.startedAt.Before(startedAt) {
+			oldest, startedAt = id, p.startedAt
+		}
+	}
+	delete(c.pending, oldest)
+	c.dropped.Add(1)
+}
+
+// Dropped returns how many incomplete messages have been dropped, whether
+// they timed out, were evicted or grew past MaxMessageSize
+func (c *Conn) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// Close closes the underlying connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local address of the underlying connection
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the peer's address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the deadline for ReadMessage
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for WriteMessage
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
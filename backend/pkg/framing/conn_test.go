@@ -0,0 +1,271 @@
+package framing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saintparish4/altair/internal/conntest"
+)
+
+// countingConn counts datagrams written through it and drops the writes
+// listed in drop (numbered from 1)
+type countingConn struct {
+	net.Conn
+	drop   map[int64]bool
+	writes atomic.Int64
+}
+
+func (cc *countingConn) Write(b []byte) (int, error) {
+	if cc.drop[cc.writes.Add(1)] {
+		return len(b), nil
+	}
+	return cc.Conn.Write(b)
+}
+
+func newPair(t *testing.T, a, b net.Conn, config *Config) (*Conn, *Conn) {
+	t.Helper()
+
+	connA, err := New(a, config)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	connB, err := New(b, config)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() {
+		connA.Close()
+		connB.Close()
+	})
+	return connA, connB
+}
+
+func readMessage(t *testing.T, c *Conn) []byte {
+	t.Helper()
+
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	return msg
+}
+
+func TestNewValidation(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	defer a.Close()
+	defer b.Close()
+
+	if _, err := New(nil, nil); err == nil {
+		t.Error("New should fail with a nil connection")
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*Config)
+	}{
+		{"zero fragment size", func(c *Config) { c.FragmentSize = 0 }},
+		{"oversized fragment", func(c *Config) { c.FragmentSize = maxDatagramSize }},
+		{"zero max message", func(c *Config) { c.MaxMessageSize = 0 }},
+		{"too many fragments", func(c *Config) { c.FragmentSize = 1; c.MaxMessageSize = maxFragments + 1 }},
+		{"zero reassembly timeout", func(c *Config) { c.ReassemblyTimeout = 0 }},
+		{"zero max pending", func(c *Config) { c.MaxPending = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.mutate(config)
+			if _, err := New(a, config); err == nil {
+				t.Error("New should fail")
+			}
+		})
+	}
+}
+
+func TestFragmentEncoding(t *testing.T) {
+	f := fragment{id: 42, index: 2, count: 3, payload: []byte("hello")}
+
+	decoded, ok := decodeFragment(f.encode())
+	if !ok {
+		t.Fatal("decodeFragment failed")
+	}
+	if decoded.id != 42 || decoded.index != 2 || decoded.count != 3 || string(decoded.payload) != "hello" {
+		t.Errorf("decodeFragment = %+v", decoded)
+	}
+
+	for _, raw := range [][]byte{
+		nil,
+		[]byte("PING"),
+		fragment{id: 1, index: 0, count: 0}.encode(),
+		fragment{id: 1, index: 3, count: 3}.encode(),
+	} {
+		if _, ok := decodeFragment(raw); ok {
+			t.Errorf("decodeFragment(%q) should fail", raw)
+		}
+	}
+}
+
+func TestSmallMessage(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	connA, connB := newPair(t, a, b, nil)
+
+	if err := connA.WriteMessage([]byte(`{"type":"chat","text":"hi"}`)); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	if err := connA.WriteMessage(nil); err != nil {
+		t.Fatalf("WriteMessage failed for an empty message: %v", err)
+	}
+
+	if msg := readMessage(t, connB); string(msg) != `{"type":"chat","text":"hi"}` {
+		t.Errorf("ReadMessage = %q", msg)
+	}
+	if msg := readMessage(t, connB); len(msg) != 0 {
+		t.Errorf("ReadMessage = %q, want an empty message", msg)
+	}
+}
+
+func TestLargeMessageFragments(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	counter := &countingConn{Conn: a}
+	connA, connB := newPair(t, counter, b, nil)
+
+	msg := make([]byte, 64*1024)
+	rand.Read(msg)
+
+	if err := connA.WriteMessage(msg); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	if got, want := counter.writes.Load(), int64(fragmentCount(len(msg), 1200)); got != want {
+		t.Errorf("sent %d datagrams, want %d", got, want)
+	}
+
+	if got := readMessage(t, connB); !bytes.Equal(got, msg) {
+		t.Errorf("reassembled %d bytes, not matching the %d sent", len(got), len(msg))
+	}
+}
+
+func TestOutOfOrderFragments(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	_, connB := newPair(t, a, b, nil)
+
+	// Send the fragments of one message backwards, with a duplicate and
+	// an unrelated datagram mixed in
+	parts := []string{"one ", "two ", "three"}
+	for i := len(parts) - 1; i >= 0; i-- {
+		f := fragment{id: 7, index: uint16(i), count: uint16(len(parts)), payload: []byte(parts[i])}
+		a.Write(f.encode())
+		if i == 1 {
+			a.Write(f.encode())
+			a.Write([]byte("not a fragment"))
+		}
+	}
+
+	if msg := readMessage(t, connB); string(msg) != "one two three" {
+		t.Errorf("ReadMessage = %q, want %q", msg, "one two three")
+	}
+}
+
+func TestDroppedFragment(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+
+	config := DefaultConfig()
+	config.FragmentSize = 100
+	config.ReassemblyTimeout = 50 * time.Millisecond
+
+	// Lose the second of the first message's three fragments
+	lossy := &countingConn{Conn: a, drop: map[int64]bool{2: true}}
+	connA, connB := newPair(t, lossy, b, config)
+
+	first := bytes.Repeat([]byte("a"), 250)
+	second := bytes.Repeat([]byte("b"), 250)
+
+	// Keep reading so the incomplete message starts its timeout on arrival
+	received := make(chan []byte, 1)
+	go func() {
+		connB.SetReadDeadline(time.Now().Add(2 * time.Second))
+		msg, _ := connB.ReadMessage()
+		received <- msg
+	}()
+
+	if err := connA.WriteMessage(first); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	time.Sleep(2 * config.ReassemblyTimeout)
+	if err := connA.WriteMessage(second); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+
+	if msg := <-received; !bytes.Equal(msg, second) {
+		t.Errorf("ReadMessage = %q..., want the second message", msg[:min(len(msg), 10)])
+	}
+	if dropped := connB.Dropped(); dropped != 1 {
+		t.Errorf("Dropped = %d, want 1", dropped)
+	}
+}
+
+func TestMaxPendingEvictsOldest(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+
+	config := DefaultConfig()
+	config.MaxPending = 1
+	_, connB := newPair(t, a, b, config)
+
+	// Message 1 never completes; message 2 pushes it out
+	a.Write(fragment{id: 1, index: 0, count: 2, payload: []byte("x")}.encode())
+	a.Write(fragment{id: 2, index: 0, count: 2, payload: []byte("y")}.encode())
+	a.Write(fragment{id: 2, index: 1, count: 2, payload: []byte("z")}.encode())
+
+	if msg := readMessage(t, connB); string(msg) != "yz" {
+		t.Errorf("ReadMessage = %q, want %q", msg, "yz")
+	}
+	if dropped := connB.Dropped(); dropped != 1 {
+		t.Errorf("Dropped = %d, want 1", dropped)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+
+	config := DefaultConfig()
+	config.FragmentSize = 10
+	config.MaxMessageSize = 25
+	connA, connB := newPair(t, a, b, config)
+
+	err := connA.WriteMessage(make([]byte, 26))
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("WriteMessage error = %v, want ErrMessageTooLarge", err)
+	}
+
+	// A peer announcing more fragments than could fit is refused outright
+	a.Write(fragment{id: 1, index: 0, count: 4, payload: make([]byte, 10)}.encode())
+	// One with oversized fragments is dropped once it grows past the limit
+	a.Write(fragment{id: 2, index: 0, count: 2, payload: make([]byte, 20)}.encode())
+	a.Write(fragment{id: 2, index: 1, count: 2, payload: make([]byte, 20)}.encode())
+
+	if err := connA.WriteMessage([]byte("fits")); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	if msg := readMessage(t, connB); string(msg) != "fits" {
+		t.Errorf("ReadMessage = %q, want %q", msg, "fits")
+	}
+	if dropped := connB.Dropped(); dropped != 2 {
+		t.Errorf("Dropped = %d, want 2", dropped)
+	}
+}
+
+func TestReadMessageDeadline(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	_, connB := newPair(t, a, b, nil)
+
+	connB.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := connB.ReadMessage()
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("ReadMessage error = %v, want a timeout", err)
+	}
+}
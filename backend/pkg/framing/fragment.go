@@ -0,0 +1,49 @@
+package framing
+
+import "encoding/binary"
+
+// Wire format: magic (1 byte) | message ID (4 bytes) | fragment index
+// (2 bytes) | fragment count (2 bytes) | payload. Every fragment of a
+// message repeats its ID and count, so fragments can be reassembled in any
+// order. The magic is allocated alongside punch's packet magic.
+const (
+	fragmentMagic byte = 0xA8
+	headerSize         = 9
+)
+
+type fragment struct {
+	id      uint32
+	index   uint16
+	count   uint16
+	payload []byte
+}
+
+// encode serializes the fragment
+func (f fragment) encode() []byte {
+	buf := make([]byte, headerSize+len(f.payload))
+	buf[0] = fragmentMagic
+	binary.BigEndian.PutUint32(buf[1:5], f.id)
+	binary.BigEndian.PutUint16(buf[5:7], f.index)
+	binary.BigEndian.PutUint16(buf[7:9], f.count)
+	copy(buf[headerSize:], f.payload)
+	return buf
+}
+
+// decodeFragment parses a datagram, reporting false if it isn't a
+// fragment. The payload is copied so buf may be reused.
+func decodeFragment(buf []byte) (fragment, bool) {
+	if len(buf) < headerSize || buf[0] != fragmentMagic {
+		return fragment{}, false
+	}
+
+	f := fragment{
+		id:    binary.BigEndian.Uint32(buf[1:5]),
+		index: binary.BigEndian.Uint16(buf[5:7]),
+		count: binary.BigEndian.Uint16(buf[7:9]),
+	}
+	if f.count == 0 || f.index >= f.count {
+		return fragment{}, false
+	}
+	f.payload = append([]byte(nil), buf[headerSize:]...)
+	return f, true
+}
@@ -0,0 +1,160 @@
+package nat
+
+import (
+	"fmt"
+
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// PortAllocation describes how a NAT picks external ports for new mappings
+type PortAllocation int
+
+const (
+	// AllocationUnknown indicates the pattern was not determined
+	AllocationUnknown PortAllocation = iota
+
+	// AllocationPreserved keeps the local port as the external port
+	AllocationPreserved
+
+	// AllocationIncremental assigns ports a constant step apart
+	AllocationIncremental
+
+	// AllocationRandom assigns ports with no usable pattern
+	AllocationRandom
+)
+
+// maxIncrementalStep is the largest step still treated as incremental;
+// anything wider is indistinguishable from random allocation
+const maxIncrementalStep = 64
+
+// String returns a human-readable name for the allocation pattern
+func (a PortAllocation) String() string {
+	switch a {
+	case AllocationUnknown:
+		return "Unknown"
+	case AllocationPreserved:
+		return "Preserved"
+	case AllocationIncremental:
+		return "Incremental"
+	case AllocationRandom:
+		return "Random"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(a))
+	}
+}
+
+// PortPrediction reports whether future external ports can be guessed
+func (a PortAllocation) PortPrediction() bool {
+	return a == AllocationPreserved || a == AllocationIncremental
+}
+
+// PortAllocationResult is the outcome of ProbePortAllocation
+type PortAllocationResult struct {
+	Allocation PortAllocation
+
+	// Most common difference between consecutive external ports
+	// (meaningful for AllocationIncremental)
+	Step int
+
+	// Local and external ports of each sample, in the order they were
+	// opened. Ports can be passed to punch.PredictPorts.
+	LocalPorts []int
+	Ports      []int
+}
+
+// ProbePortAllocation opens samples fresh sockets one after another, runs
+// a binding request to the primary server from each and classifies the
+// external ports the NAT assigned. It tells whether port prediction can
+// reach a peer behind a symmetric NAT. At least two samples are needed;
+// more make the classification more reliable.
+func (d *Detector) ProbePortAllocation(samples int) (*PortAllocationResult, error) {
+	if samples < 2 {
+		return nil, fmt.Errorf("port allocation probe needs at least 2 samples, got %d", samples)
+	}
+	if d.primaryServer == "" {
+		return nil, fmt.Errorf("port allocation probe needs a primary server address")
+	}
+
+	timeout := d.timeout
+	if timeout == 0 {
+		timeout = stun.DefaultTimeout
+	}
+
+	// Every socket stays open until the end, so the NAT can't hand a
+	// released port to the next one
+	var clients []*stun.Client
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+
+	result := &PortAllocationResult{}
+	for i := 0; i < samples; i++ {
+		client, err := stun.NewClient(&stun.ClientConfig{
+			ServerAddr: d.primaryServer,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create STUN client: %w", err)
+		}
+		clients = append(clients, client)
+
+		endpoint, err := d.observe(client.Discover())
+		if err != nil {
+			return nil, fmt.Errorf("port allocation sample %d failed: %w", i+1, err)
+		}
+
+		result.LocalPorts = append(result.LocalPorts, endpoint.LocalAddr.Port)
+		result.Ports = append(result.Ports, endpoint.PublicAddr.Port)
+	}
+
+	result.Allocation, result.Step = classifyAllocation(result.LocalPorts, result.Ports)
+	return result, nil
+}
+
+// classifyAllocation derives the allocation pattern from the local and
+// external ports of consecutive samples
+func classifyAllocation(local, public []int) (PortAllocation, int) {
+	if len(public) < 2 || len(local) != len(public) {
+		return AllocationUnknown, 0
+	}
+
+	preserved := true
+	for i := range public {
+		if local[i] != public[i] {
+			preserved = false
+			break
+		}
+	}
+
+	// Most common step, preferring the smaller on ties
+	freq := make(map[int]int)
+	step, best := 0, 0
+	for i := 1; i < len(public); i++ {
+		delta := public[i] - public[i-1]
+		freq[delta]++
+		if freq[delta] > best || (freq[delta] == best && abs(delta) < abs(step)) {
+			step, best = delta, freq[delta]
+		}
+	}
+
+	if preserved {
+		return AllocationPreserved, step
+	}
+
+	// Other traffic through the NAT can claim ports between samples, so
+	// the step only has to account for most of them
+	deltas := len(public) - 1
+	if step != 0 && abs(step) <= maxIncrementalStep && best*2 > deltas {
+		return AllocationIncremental, step
+	}
+	return AllocationRandom, step
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,184 @@
+package nat
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+func TestPortAllocationString(t *testing.T) {
+	tests := []struct {
+		allocation PortAllocation
+		expected   string
+	}{
+		{AllocationUnknown, "Unknown"},
+		{AllocationPreserved, "Preserved"},
+		{AllocationIncremental, "Incremental"},
+		{AllocationRandom, "Random"},
+		{PortAllocation(99), "Unknown(99)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.allocation.String(); got != tt.expected {
+			t.Errorf("PortAllocation(%d).String() = %q, want %q", int(tt.allocation), got, tt.expected)
+		}
+	}
+}
+
+func TestClassifyAllocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		local      []int
+		public     []int
+		allocation PortAllocation
+		step       int
+	}{
+		{"preserved", []int{5000, 5001, 5007}, []int{5000, 5001, 5007}, AllocationPreserved, 1},
+		{"incremental", []int{5000, 5001, 5002}, []int{40000, 40002, 40004}, AllocationIncremental, 2},
+		{"decrementing", []int{5000, 5001, 5002}, []int{40010, 40009, 40008}, AllocationIncremental, -1},
+		{"skipped port", []int{1, 2, 3, 4}, []int{40000, 40001, 40003, 40004}, AllocationIncremental, 1},
+		{"random", []int{1, 2, 3, 4}, []int{40000, 12345, 61000, 23456}, AllocationRandom, -27655},
+		{"wide step", []int{1, 2}, []int{40000, 41000}, AllocationRandom, 1000},
+		{"same port", []int{1, 2}, []int{40000, 40000}, AllocationRandom, 0},
+		{"one sample", []int{1}, []int{40000}, AllocationUnknown, 0},
+		{"mismatched", []int{1}, []int{40000, 40001}, AllocationUnknown, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocation, step := classifyAllocation(tt.local, tt.public)
+			if allocation != tt.allocation {
+				t.Errorf("allocation = %v, want %v", allocation, tt.allocation)
+			}
+			if tt.allocation != AllocationRandom && step != tt.step {
+				t.Errorf("step = %d, want %d", step, tt.step)
+			}
+		})
+	}
+}
+
+// startIncrementingServer runs a loopback STUN server that reports a new
+// external port step apart for every request, like a symmetric NAT with
+// sequential allocation
+func startIncrementingServer(t *testing.T, first, step int) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		port := first
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			request, err := stun.Decode(buf[:n])
+			if err != nil || request.Type != stun.TypeBindingRequest {
+				continue
+			}
+
+			response := &stun.Message{
+				Type:          stun.TypeBindingSuccess,
+				TransactionID: request.TransactionID,
+			}
+			mapped := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: port}
+			response.AddAttribute(stun.EncodeXORMappedAddress(mapped, request.TransactionID))
+			port += step
+
+			data, _ := response.Encode()
+			conn.WriteToUDP(data, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestProbePortAllocation(t *testing.T) {
+	// Loopback reports each socket's own port, so allocation is preserved
+	preserving, _ := startDelayedServer(t, nil, 0)
+	incrementing := startIncrementingServer(t, 40000, 3)
+
+	tests := []struct {
+		name       string
+		server     string
+		allocation PortAllocation
+	}{
+		{"preserved", preserving, AllocationPreserved},
+		{"incremental", incrementing, AllocationIncremental},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector, err := NewDetector(&DetectorConfig{
+				PrimaryServer:   tt.server,
+				SecondaryServer: tt.server,
+				Timeout:         time.Second,
+			})
+			if err != nil {
+				t.Fatalf("NewDetector failed: %v", err)
+			}
+			defer detector.Close()
+
+			result, err := detector.ProbePortAllocation(4)
+			if err != nil {
+				t.Fatalf("ProbePortAllocation failed: %v", err)
+			}
+			if result.Allocation != tt.allocation {
+				t.Errorf("Allocation = %v, want %v (ports %v)", result.Allocation, tt.allocation, result.Ports)
+			}
+			if len(result.Ports) != 4 || len(result.LocalPorts) != 4 {
+				t.Errorf("expected 4 samples, got %v and %v", result.LocalPorts, result.Ports)
+			}
+		})
+	}
+}
+
+func TestProbePortAllocationIncrementalStep(t *testing.T) {
+	server := startIncrementingServer(t, 40000, 3)
+
+	detector, err := NewDetector(&DetectorConfig{
+		PrimaryServer:   server,
+		SecondaryServer: server,
+		Timeout:         time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewDetector failed: %v", err)
+	}
+	defer detector.Close()
+
+	result, err := detector.ProbePortAllocation(3)
+	if err != nil {
+		t.Fatalf("ProbePortAllocation failed: %v", err)
+	}
+	if result.Step != 3 {
+		t.Errorf("Step = %d, want 3", result.Step)
+	}
+	if !result.Allocation.PortPrediction() {
+		t.Error("expected incremental allocation to support port prediction")
+	}
+}
+
+func TestProbePortAllocationErrors(t *testing.T) {
+	detector, err := NewDetector(&DetectorConfig{
+		Primary:   &fakeDiscoverer{},
+		Secondary: &fakeDiscoverer{},
+	})
+	if err != nil {
+		t.Fatalf("NewDetector failed: %v", err)
+	}
+
+	if _, err := detector.ProbePortAllocation(1); err == nil {
+		t.Error("expected an error for a single sample")
+	}
+	if _, err := detector.ProbePortAllocation(3); err == nil {
+		t.Error("expected an error without a primary server address")
+	}
+}
@@ -0,0 +1,264 @@
+package nat
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// MappingBehavior describes how a NAT assigns public endpoints (RFC 4787 / RFC 5780)
+type MappingBehavior int
+
+const (
+	// MappingUnknown indicates mapping behavior was not determined
+	MappingUnknown MappingBehavior = iota
+
+	// MappingEndpointIndependent reuses the same public endpoint for all destinations
+	MappingEndpointIndependent
+
+	// MappingAddressDependent reuses the public endpoint only for the same destination IP
+	MappingAddressDependent
+
+	// MappingAddressAndPortDependent uses a new public endpoint for every destination IP:port
+	MappingAddressAndPortDependent
+)
+
+// String returns a human-readable name for the mapping behavior
+func (b MappingBehavior) String() string {
+	switch b {
+	case MappingUnknown:
+		return "Unknown"
+	case MappingEndpointIndependent:
+		return "Endpoint-Independent"
+	case MappingAddressDependent:
+		return "Address-Dependent"
+	case MappingAddressAndPortDependent:
+		return "Address and Port-Dependent"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(b))
+	}
+}
+
+// FilteringBehavior describes which inbound packets a NAT lets through (RFC 4787 / RFC 5780)
+type FilteringBehavior int
+
+const (
+	// FilteringUnknown indicates filtering behavior was not determined
+	FilteringUnknown FilteringBehavior = iota
+
+	// FilteringEndpointIndependent accepts packets from any external endpoint
+	FilteringEndpointIndependent
+
+	// FilteringAddressDependent accepts packets only from IPs we've sent to
+	FilteringAddressDependent
+
+	// FilteringAddressAndPortDependent accepts packets only from IP:port pairs we've sent to
+	FilteringAddressAndPortDependent
+)
+
+// String returns a human-readable name for the filtering behavior
+func (b FilteringBehavior) String() string {
+	switch b {
+	case FilteringUnknown:
+		return "Unknown"
+	case FilteringEndpointIndependent:
+		return "Endpoint-Independent"
+	case FilteringAddressDependent:
+		return "Address-Dependent"
+	case FilteringAddressAndPortDependent:
+		return "Address and Port-Dependent"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(b))
+	}
+}
+
+// DetectMappingBehavior runs the RFC 5780 section 4.3 mapping tests against
+// the primary server. The server must report OTHER-ADDRESS.
+func (d *Detector) DetectMappingBehavior() (MappingBehavior, error) {
+	// Test I: binding request to the primary address
+	endpoint1, err := d.observe(d.primary.Discover())
+	if err != nil {
+		return MappingUnknown, fmt.Errorf("mapping test I failed: %w", err)
+	}
+
+	// No NAT: the mapping is trivially endpoint-independent
+	if endpoint1.LocalAddr.IP.Equal(endpoint1.PublicAddr.IP) &&
+		endpoint1.LocalAddr.Port == endpoint1.PublicAddr.Port {
+		return MappingEndpointIndependent, nil
+	}
+
+	other := endpoint1.OtherAddr
+	if other == nil {
+		return MappingUnknown, fmt.Errorf("server %s does not report OTHER-ADDRESS", endpoint1.ServerAddr)
+	}
+
+	// Test II: alternate IP, primary port
+	endpoint2, err := d.discoverAt(&net.UDPAddr{IP: other.IP, Port: endpoint1.ServerAddr.Port})
+	if err != nil {
+		return MappingUnknown, fmt.Errorf("mapping test II failed: %w", err)
+	}
+
+	if sameAddr(endpoint1.PublicAddr, endpoint2.PublicAddr) {
+		return MappingEndpointIndependent, nil
+	}
+
+	// Test III: alternate IP and alternate port
+	endpoint3, err := d.discoverAt(other)
+	if err != nil {
+		return MappingUnknown, fmt.Errorf("mapping test III failed: %w", err)
+	}
+
+	return classifyMapping(endpoint1.PublicAddr, endpoint2.PublicAddr, endpoint3.PublicAddr), nil
+}
+
+// discoverAt queries serverAddr from the primary, if it supports that
+func (d *Detector) discoverAt(serverAddr *net.UDPAddr) (*stun.Endpoint, error) {
+	primary, ok := d.primary.(AddrDiscoverer)
+	if !ok {
+		return nil, fmt.Errorf("discoverer does not support querying a specific server address")
+	}
+	return d.observe(primary.DiscoverAt(serverAddr))
+}
+
+// DetectFilteringBehavior runs the RFC 5780 section 4.4 filtering tests
+// against the primary server. The server must honor CHANGE-REQUEST. Run it
+// before DetectMappingBehavior on the same socket: the mapping tests send
+// to OTHER-ADDRESS, which opens the NAT to the responses these tests expect
+// it to filter.
+func (d *Detector) DetectFilteringBehavior() (FilteringBehavior, error) {
+	// Test I: binding request to the primary address
+	endpoint1, err := d.observe(d.primary.Discover())
+	if err != nil {
+		return FilteringUnknown, fmt.Errorf("filtering test I failed: %w", err)
+	}
+
+	// Test II: response from alternate IP and port
+	var testII, testIII *net.UDPAddr
+	changed, err := d.discoverWithChange(true, true)
+	if err == nil {
+		testII = changed.SourceAddr
+	} else if !errors.Is(err, stun.ErrTimeout) {
+		return FilteringUnknown, fmt.Errorf("filtering test II failed: %w", err)
+	}
+
+	// Test III: response from alternate port only
+	if testII == nil {
+		changed, err = d.discoverWithChange(false, true)
+		if err == nil {
+			testIII = changed.SourceAddr
+		} else if !errors.Is(err, stun.ErrTimeout) {
+			return FilteringUnknown, fmt.Errorf("filtering test III failed: %w", err)
+		}
+	}
+
+	behavior := classifyFiltering(endpoint1.ServerAddr, testII, testIII)
+	if behavior == FilteringUnknown {
+		return FilteringUnknown, fmt.Errorf("server %s does not support CHANGE-REQUEST", endpoint1.ServerAddr)
+	}
+	return behavior, nil
+}
+
+// DetectBehavior runs both RFC 5780 test sequences and returns a mapping with
+// MappingBehavior and FilteringBehavior filled in. Type is derived from the
+// two behaviors. Hairpinning is tested too; if that test fails it is left
+// false.
+func (d *Detector) DetectBehavior() (*Mapping, error) {
+	endpoint, err := d.observe(d.primary.Discover())
+	if err != nil {
+		return nil, fmt.Errorf("test 1 failed (primary server): %w", err)
+	}
+
+	// Filtering first: the mapping tests open the NAT to OTHER-ADDRESS
+	filteringBehavior, err := d.DetectFilteringBehavior()
+	if err != nil {
+		return nil, err
+	}
+
+	mappingBehavior, err := d.DetectMappingBehavior()
+	if err != nil {
+		return nil, err
+	}
+
+	natType := typeFromBehavior(mappingBehavior, filteringBehavior)
+	if endpoint.LocalAddr.IP.Equal(endpoint.PublicAddr.IP) {
+		natType = TypeOpenInternet
+	}
+
+	hairpinning, _ := d.DetectHairpinning()
+
+	return &Mapping{
+		LocalAddr:         endpoint.LocalAddr,
+		PublicAddr:        endpoint.PublicAddr,
+		Type:              natType,
+		MappingBehavior:   mappingBehavior,
+		FilteringBehavior: filteringBehavior,
+		Hairpinning:       hairpinning,
+		DetectedAt:        time.Now(),
+	}, nil
+}
+
+// classifyMapping maps the public addresses seen by RFC 5780 mapping tests
+// I, II and III to a mapping behavior
+func classifyMapping(x1, x2, x3 *net.UDPAddr) MappingBehavior {
+	if sameAddr(x1, x2) {
+		return MappingEndpointIndependent
+	}
+	if sameAddr(x2, x3) {
+		return MappingAddressDependent
+	}
+	return MappingAddressAndPortDependent
+}
+
+// classifyFiltering maps the results of RFC 5780 filtering tests II and III
+// to a filtering behavior. testII and testIII are the source addresses of the
+// responses, or nil if none arrived. A response from the unchanged server
+// address means the server ignored CHANGE-REQUEST (FilteringUnknown).
+func classifyFiltering(server, testII, testIII *net.UDPAddr) FilteringBehavior {
+	if testII != nil {
+		if server != nil && testII.IP.Equal(server.IP) {
+			return FilteringUnknown
+		}
+		return FilteringEndpointIndependent
+	}
+
+	if testIII != nil {
+		if server != nil && testIII.Port == server.Port {
+			return FilteringUnknown
+		}
+		return FilteringAddressDependent
+	}
+
+	return FilteringAddressAndPortDependent
+}
+
+// typeFromBehavior derives the classic RFC 3489 NAT type from RFC 5780 behaviors
+func typeFromBehavior(mapping MappingBehavior, filtering FilteringBehavior) Type {
+	switch mapping {
+	case MappingUnknown:
+		return TypeUnknown
+	case MappingAddressDependent, MappingAddressAndPortDependent:
+		return TypeSymmetric
+	}
+
+	switch filtering {
+	case FilteringEndpointIndependent:
+		return TypeFullCone
+	case FilteringAddressDependent:
+		return TypeRestrictedCone
+	case FilteringAddressAndPortDependent:
+		return TypePortRestrictedCone
+	default:
+		return TypeUnknown
+	}
+}
+
+// sameAddr reports whether two UDP addresses have the same IP and port
+func sameAddr(a, b *net.UDPAddr) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
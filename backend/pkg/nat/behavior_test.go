@@ -0,0 +1,214 @@
+package nat
+
+import (
+	"net"
+	"testing"
+
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+func TestMappingBehaviorString(t *testing.T) {
+	tests := []struct {
+		behavior MappingBehavior
+		expected string
+	}{
+		{MappingUnknown, "Unknown"},
+		{MappingEndpointIndependent, "Endpoint-Independent"},
+		{MappingAddressDependent, "Address-Dependent"},
+		{MappingAddressAndPortDependent, "Address and Port-Dependent"},
+		{MappingBehavior(99), "Unknown(99)"},
+	}
+
+	for _, tt := range tests {
+		if result := tt.behavior.String(); result != tt.expected {
+			t.Errorf("MappingBehavior.String() = %q, want %q", result, tt.expected)
+		}
+	}
+}
+
+func TestFilteringBehaviorString(t *testing.T) {
+	tests := []struct {
+		behavior FilteringBehavior
+		expected string
+	}{
+		{FilteringUnknown, "Unknown"},
+		{FilteringEndpointIndependent, "Endpoint-Independent"},
+		{FilteringAddressDependent, "Address-Dependent"},
+		{FilteringAddressAndPortDependent, "Address and Port-Dependent"},
+		{FilteringBehavior(99), "Unknown(99)"},
+	}
+
+	for _, tt := range tests {
+		if result := tt.behavior.String(); result != tt.expected {
+			t.Errorf("FilteringBehavior.String() = %q, want %q", result, tt.expected)
+		}
+	}
+}
+
+func TestClassifyMapping(t *testing.T) {
+	x1 := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 40000}
+	x2 := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 40001}
+	x3 := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 40002}
+
+	tests := []struct {
+		name       string
+		x1, x2, x3 *net.UDPAddr
+		expected   MappingBehavior
+	}{
+		{"same mapping for all destinations", x1, x1, x1, MappingEndpointIndependent},
+		{"new mapping per destination IP", x1, x2, x2, MappingAddressDependent},
+		{"new mapping per destination IP:port", x1, x2, x3, MappingAddressAndPortDependent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := classifyMapping(tt.x1, tt.x2, tt.x3); result != tt.expected {
+				t.Errorf("classifyMapping() = %s, want %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestClassifyFiltering(t *testing.T) {
+	server := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 3478}
+	altIPAndPort := &net.UDPAddr{IP: net.ParseIP("198.51.100.2"), Port: 3479}
+	altPort := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 3479}
+
+	tests := []struct {
+		name     string
+		testII   *net.UDPAddr
+		testIII  *net.UDPAddr
+		expected FilteringBehavior
+	}{
+		{"test II from alternate IP", altIPAndPort, nil, FilteringEndpointIndependent},
+		{"test II ignored by server", server, nil, FilteringUnknown},
+		{"test III from alternate port", nil, altPort, FilteringAddressDependent},
+		{"test III ignored by server", nil, server, FilteringUnknown},
+		{"no responses", nil, nil, FilteringAddressAndPortDependent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := classifyFiltering(server, tt.testII, tt.testIII); result != tt.expected {
+				t.Errorf("classifyFiltering() = %s, want %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTypeFromBehavior(t *testing.T) {
+	tests := []struct {
+		mapping   MappingBehavior
+		filtering FilteringBehavior
+		expected  Type
+	}{
+		{MappingEndpointIndependent, FilteringEndpointIndependent, TypeFullCone},
+		{MappingEndpointIndependent, FilteringAddressDependent, TypeRestrictedCone},
+		{MappingEndpointIndependent, FilteringAddressAndPortDependent, TypePortRestrictedCone},
+		{MappingEndpointIndependent, FilteringUnknown, TypeUnknown},
+		{MappingAddressDependent, FilteringEndpointIndependent, TypeSymmetric},
+		{MappingAddressAndPortDependent, FilteringAddressAndPortDependent, TypeSymmetric},
+		{MappingUnknown, FilteringEndpointIndependent, TypeUnknown},
+	}
+
+	for _, tt := range tests {
+		if result := typeFromBehavior(tt.mapping, tt.filtering); result != tt.expected {
+			t.Errorf("typeFromBehavior(%s, %s) = %s, want %s",
+				tt.mapping, tt.filtering, result, tt.expected)
+		}
+	}
+}
+
+// fakeNAT is a primary discoverer behind an endpoint-independent mapping
+// NAT that filters inbound packets by address, like a real one: responses
+// only get through from IPs the client has sent to
+type fakeNAT struct {
+	local, public *net.UDPAddr
+	server, other *net.UDPAddr
+	filterByPort  bool
+	contacted     []*net.UDPAddr
+}
+
+func (n *fakeNAT) endpoint(server, source *net.UDPAddr) (*stun.Endpoint, error) {
+	n.contacted = append(n.contacted, server)
+
+	allowed := false
+	for _, addr := range n.contacted {
+		if addr.IP.Equal(source.IP) && (!n.filterByPort || addr.Port == source.Port) {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, stun.ErrTimeout
+	}
+
+	return &stun.Endpoint{
+		LocalAddr:  n.local,
+		PublicAddr: n.public,
+		ServerAddr: server,
+		SourceAddr: source,
+		OtherAddr:  n.other,
+	}, nil
+}
+
+func (n *fakeNAT) Discover() (*stun.Endpoint, error) {
+	return n.endpoint(n.server, n.server)
+}
+
+func (n *fakeNAT) DiscoverAt(serverAddr *net.UDPAddr) (*stun.Endpoint, error) {
+	return n.endpoint(serverAddr, serverAddr)
+}
+
+func (n *fakeNAT) DiscoverWithChange(changeIP, changePort bool) (*stun.Endpoint, error) {
+	source := &net.UDPAddr{IP: n.server.IP, Port: n.server.Port}
+	if changeIP {
+		source.IP = n.other.IP
+	}
+	if changePort {
+		source.Port = n.other.Port
+	}
+	return n.endpoint(n.server, source)
+}
+
+func TestDetectBehaviorFilteringNotOpenedByMappingTests(t *testing.T) {
+	tests := []struct {
+		name         string
+		filterByPort bool
+		filtering    FilteringBehavior
+		natType      Type
+	}{
+		{"address-dependent", false, FilteringAddressDependent, TypeRestrictedCone},
+		{"address and port-dependent", true, FilteringAddressAndPortDependent, TypePortRestrictedCone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nat := &fakeNAT{
+				local:        &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 50000},
+				public:       &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40001},
+				server:       &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 3478},
+				other:        &net.UDPAddr{IP: net.ParseIP("198.51.100.2"), Port: 3479},
+				filterByPort: tt.filterByPort,
+			}
+
+			detector, err := NewDetector(&DetectorConfig{Primary: nat, Secondary: &fakeDiscoverer{}})
+			if err != nil {
+				t.Fatalf("NewDetector failed: %v", err)
+			}
+
+			mapping, err := detector.DetectBehavior()
+			if err != nil {
+				t.Fatalf("DetectBehavior failed: %v", err)
+			}
+			if mapping.MappingBehavior != MappingEndpointIndependent {
+				t.Errorf("MappingBehavior = %s, want %s", mapping.MappingBehavior, MappingEndpointIndependent)
+			}
+			if mapping.FilteringBehavior != tt.filtering {
+				t.Errorf("FilteringBehavior = %s, want %s", mapping.FilteringBehavior, tt.filtering)
+			}
+			if mapping.Type != tt.natType {
+				t.Errorf("Type = %s, want %s", mapping.Type, tt.natType)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package nat
+
+import (
+	"net"
+	"slices"
+	"time"
+)
+
+// mappingCache holds the last detected mapping and the local addresses it
+// was detected with
+type mappingCache struct {
+	mapping    *Mapping
+	localAddrs []net.IP
+}
+
+// DetectCached returns the last mapping from DetectCached if it is younger
+// than maxAge and the host's local addresses haven't changed since;
+// otherwise it runs Detect and caches the result. Concurrent callers share
+// one detection. Failed detections aren't cached.
+func (d *Detector) DetectCached(maxAge time.Duration) (*Mapping, error) {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+
+	// If the addresses can't be read, assume they changed
+	current, err := d.localAddrs()
+	if err != nil {
+		current = nil
+	}
+
+	if d.cache.mapping.IsValid(maxAge) && current != nil &&
+		slices.EqualFunc(current, d.cache.localAddrs, net.IP.Equal) {
+		return d.cache.mapping, nil
+	}
+
+	mapping, err := d.Detect()
+	if err != nil {
+		d.cache = mappingCache{}
+		return nil, err
+	}
+
+	d.cache = mappingCache{mapping: mapping, localAddrs: current}
+	return mapping, nil
+}
+
+// Invalidate drops the cached mapping so the next DetectCached re-detects,
+// e.g. from a netutil.WatchNetworkChanges callback
+func (d *Detector) Invalidate() {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	d.cache = mappingCache{}
+}
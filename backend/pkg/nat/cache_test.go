@@ -0,0 +1,114 @@
+package nat
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newSymmetricDetector returns a detector against two loopback servers
+// reporting different mappings, plus the primary's request counter
+func newSymmetricDetector(t *testing.T) (*Detector, func() int32) {
+	t.Helper()
+
+	primary, requests := startDelayedServer(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40001}, 0)
+	secondary, _ := startDelayedServer(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40002}, 0)
+
+	detector, err := NewDetector(&DetectorConfig{
+		PrimaryServer:   primary,
+		SecondaryServer: secondary,
+		Timeout:         time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewDetector failed: %v", err)
+	}
+	t.Cleanup(func() { detector.Close() })
+
+	return detector, requests.Load
+}
+
+func TestDetectCachedReusesFreshMapping(t *testing.T) {
+	detector, requests := newSymmetricDetector(t)
+	detector.localAddrs = func() ([]net.IP, error) {
+		return []net.IP{net.ParseIP("192.168.1.20")}, nil
+	}
+
+	first, err := detector.DetectCached(time.Minute)
+	if err != nil {
+		t.Fatalf("DetectCached failed: %v", err)
+	}
+	if got := requests(); got != 1 {
+		t.Fatalf("First call sent %d requests, want 1", got)
+	}
+
+	second, err := detector.DetectCached(time.Minute)
+	if err != nil {
+		t.Fatalf("DetectCached failed: %v", err)
+	}
+	if second != first {
+		t.Error("Second call within maxAge returned a different mapping")
+	}
+	if got := requests(); got != 1 {
+		t.Errorf("Second call within maxAge sent a request (%d total)", got)
+	}
+
+	// Concurrent callers share the cached result too
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			detector.DetectCached(time.Minute)
+		}()
+	}
+	wg.Wait()
+	if got := requests(); got != 1 {
+		t.Errorf("Concurrent calls sent %d requests, want 1", got)
+	}
+}
+
+func TestDetectCachedRedetects(t *testing.T) {
+	detector, requests := newSymmetricDetector(t)
+
+	var mu sync.Mutex
+	local := []net.IP{net.ParseIP("192.168.1.20")}
+	detector.localAddrs = func() ([]net.IP, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return local, nil
+	}
+
+	if _, err := detector.DetectCached(time.Minute); err != nil {
+		t.Fatalf("DetectCached failed: %v", err)
+	}
+
+	// Expired
+	time.Sleep(20 * time.Millisecond)
+	if _, err := detector.DetectCached(10 * time.Millisecond); err != nil {
+		t.Fatalf("DetectCached failed: %v", err)
+	}
+	if got := requests(); got != 2 {
+		t.Errorf("Expired mapping: %d requests, want 2", got)
+	}
+
+	// Local address changed (e.g. Wi-Fi to cellular)
+	mu.Lock()
+	local = []net.IP{net.ParseIP("10.64.3.7")}
+	mu.Unlock()
+	if _, err := detector.DetectCached(time.Minute); err != nil {
+		t.Fatalf("DetectCached failed: %v", err)
+	}
+	if got := requests(); got != 3 {
+		t.Errorf("Address change: %d requests, want 3", got)
+	}
+
+	// Explicitly invalidated
+	detector.Invalidate()
+	if _, err := detector.DetectCached(time.Minute); err != nil {
+		t.Fatalf("DetectCached failed: %v", err)
+	}
+	if got := requests(); got != 4 {
+		t.Errorf("After Invalidate: %d requests, want 4", got)
+	}
+}
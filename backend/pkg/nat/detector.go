@@ -1,10 +1,15 @@
 package nat
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/saintparish4/altair/pkg/netutil"
 	"github.com/saintparish4/altair/pkg/stun"
 )
 
@@ -64,6 +69,21 @@ func (t Type) String() string {
 	}
 }
 
+// ParseType parses a NAT type name as printed by String. Matching ignores
+// case, spaces, hyphens and underscores, so "full-cone" parses too.
+func ParseType(s string) (Type, error) {
+	normalize := strings.NewReplacer(" ", "", "-", "", "_", "")
+	name := strings.ToLower(normalize.Replace(s))
+
+	for t := TypeUnknown; t <= TypeBlocked; t++ {
+		if name == strings.ToLower(normalize.Replace(t.String())) {
+			return t, nil
+		}
+	}
+
+	return TypeUnknown, fmt.Errorf("unknown NAT type %q", s)
+}
+
 // SupportsP2P returns whether this NAT type generally supports P2P connections
 func (t Type) SupportsP2P() bool {
 	switch t {
@@ -106,6 +126,15 @@ type Mapping struct {
 	PublicAddr *net.UDPAddr // Public (mapped) address
 	Type       Type         // Detected NAT type
 	DetectedAt time.Time    // When the mapping was discovered
+
+	// RFC 5780 behaviors, set by DetectBehavior (Unknown otherwise)
+	MappingBehavior   MappingBehavior
+	FilteringBehavior FilteringBehavior
+
+	// Hairpinning is true if the NAT loops traffic to its own public
+	// endpoints back inside, so peers behind it can reach each other on
+	// public addresses. Set by DetectBehavior; false if unknown.
+	Hairpinning bool
 }
 
 // String returns a human-readable representation of the mapping
@@ -125,13 +154,54 @@ func (m *Mapping) IsValid(maxAge time.Duration) bool {
 	return time.Since(m.DetectedAt) < maxAge
 }
 
+// Discoverer performs STUN binding requests. *stun.Client implements it;
+// tests can supply fakes returning fixed endpoints.
+type Discoverer interface {
+	Discover() (*stun.Endpoint, error)
+}
+
+// ChangeDiscoverer is a Discoverer that can send CHANGE-REQUEST, needed to
+// tell cone types apart and for filtering tests
+type ChangeDiscoverer interface {
+	Discoverer
+	DiscoverWithChange(changeIP, changePort bool) (*stun.Endpoint, error)
+}
+
+// AddrDiscoverer is a Discoverer that can query a specific server address,
+// needed for RFC 5780 mapping tests
+type AddrDiscoverer interface {
+	Discoverer
+	DiscoverAt(serverAddr *net.UDPAddr) (*stun.Endpoint, error)
+}
+
+// errChangeUnsupported is returned for CHANGE-REQUEST tests when the primary
+// Discoverer can't send them
+var errChangeUnsupported = errors.New("discoverer does not support CHANGE-REQUEST")
+
 // Detector performs NAT type detection using STUN
 type Detector struct {
-	primary    *stun.Client
-	secondary  *stun.Client
-	localConn  *net.UDPConn
-	timeout    time.Duration
-	retryCount int
+	primary     Discoverer
+	secondary   Discoverer
+	ownsClients bool // False when the caller injected the discoverers
+	localConn   *net.UDPConn
+
+	// Server addresses from the config, used by tests that need fresh
+	// sockets
+	primaryServer   string
+	secondaryServer string
+	timeout         time.Duration
+	retryCount      int
+	retryBackoff    time.Duration
+
+	// DetectCached state; localAddrs lists the host's addresses
+	cacheMu    sync.Mutex
+	cache      mappingCache
+	localAddrs func() ([]net.IP, error)
+
+	// Round-trip times of every STUN response received (see AverageRTT)
+	rttMu    sync.Mutex
+	rttTotal time.Duration
+	rttCount int
 }
 
 // DetectorConfig holds configuration for NAT detection
@@ -148,8 +218,21 @@ type DetectorConfig struct {
 	// Number of retries for failed requests
 	RetryCount int
 
+	// First DetectWithRetry backoff, doubled per retry up to
+	// netutil.MaxBackoff and jittered (optional, see netutil.Backoff)
+	RetryBackoff time.Duration
+
 	// Optional: existing UDP connection to use
 	LocalConn *net.UDPConn
+
+	// Optional: "udp4" or "udp6" to detect over one address family only
+	// (see DetectDualStack). Empty uses whichever the servers resolve to.
+	Network string
+
+	// Optional: discoverers to use instead of STUN clients for the servers
+	// above, e.g. fakes in tests. Both must be set; Close leaves them open.
+	Primary   Discoverer
+	Secondary Discoverer
 }
 
 // DefaultConfig returns a detector configuration with sensible defaults
@@ -168,10 +251,29 @@ func NewDetector(config *DetectorConfig) (*Detector, error) {
 		config = DefaultConfig()
 	}
 
-	// Create primary STUN client
+	if config.Primary != nil || config.Secondary != nil {
+		if config.Primary == nil || config.Secondary == nil {
+			return nil, fmt.Errorf("both primary and secondary discoverers are required")
+		}
+		return &Detector{
+			primary:         config.Primary,
+			secondary:       config.Secondary,
+			primaryServer:   config.PrimaryServer,
+			secondaryServer: config.SecondaryServer,
+			timeout:         config.Timeout,
+			retryCount:      config.RetryCount,
+			retryBackoff:    config.RetryBackoff,
+			localAddrs:      netutil.GetLocalAddresses,
+		}, nil
+	}
+
+	// Create primary STUN client (on the caller's socket if provided,
+	// so the detected mapping is the one that socket will use)
 	primary, err := stun.NewClient(&stun.ClientConfig{
 		ServerAddr: config.PrimaryServer,
 		Timeout:    config.Timeout,
+		Conn:       config.LocalConn,
+		Network:    config.Network,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create primary STUN client: %w", err)
@@ -181,6 +283,7 @@ func NewDetector(config *DetectorConfig) (*Detector, error) {
 	secondary, err := stun.NewClient(&stun.ClientConfig{
 		ServerAddr: config.SecondaryServer,
 		Timeout:    config.Timeout,
+		Network:    config.Network,
 	})
 	if err != nil {
 		primary.Close()
@@ -188,18 +291,49 @@ func NewDetector(config *DetectorConfig) (*Detector, error) {
 	}
 
 	return &Detector{
-		primary:    primary,
-		secondary:  secondary,
-		localConn:  config.LocalConn,
-		timeout:    config.Timeout,
-		retryCount: config.RetryCount,
+		primary:         primary,
+		secondary:       secondary,
+		ownsClients:     true,
+		primaryServer:   config.PrimaryServer,
+		secondaryServer: config.SecondaryServer,
+		localConn:       config.LocalConn,
+		timeout:         config.Timeout,
+		retryCount:      config.RetryCount,
+		retryBackoff:    config.RetryBackoff,
+		localAddrs:      netutil.GetLocalAddresses,
 	}, nil
 }
 
-// Detect performs NAT type detection using the RFC 3489 algorithm
+// discoverResult is the outcome of one Discover call
+type discoverResult struct {
+	endpoint *stun.Endpoint
+	err      error
+}
+
+// discoverBoth runs test 1 (primary) and test 2 (secondary) concurrently.
+// The clients own separate sockets, so the requests don't compete for
+// responses. Both calls finish before it returns, so neither client is left
+// reading when the next test or Detect uses it.
+func (d *Detector) discoverBoth() (primary, secondary discoverResult) {
+	results := make(chan discoverResult, 1)
+	go func() {
+		endpoint, err := d.observe(d.secondary.Discover())
+		results <- discoverResult{endpoint, err}
+	}()
+
+	primary.endpoint, primary.err = d.observe(d.primary.Discover())
+	secondary = <-results
+	return primary, secondary
+}
+
+// Detect performs NAT type detection using the RFC 3489 algorithm. The
+// primary and secondary binding requests are sent in parallel, so detection
+// costs one round trip rather than two before the cone tests.
 func (d *Detector) Detect() (*Mapping, error) {
-	// Test 1: Send request to primary server
-	endpoint1, err := d.primary.Discover()
+	// Tests 1 and 2: binding requests to the primary and secondary servers
+	result1, result2 := d.discoverBoth()
+
+	endpoint1, err := result1.endpoint, result1.err
 	if err != nil {
 		return nil, fmt.Errorf("test 1 failed (primary server): %w", err)
 	}
@@ -214,9 +348,8 @@ func (d *Detector) Detect() (*Mapping, error) {
 		}, nil
 	}
 
-	// We're behind NAT, continue tests
-	// Test 2: Send request to secondary server (different IP)
-	endpoint2, err := d.secondary.Discover()
+	// We're behind NAT, so test 2 (secondary server, different IP) matters
+	endpoint2, err := result2.endpoint, result2.err
 	if err != nil {
 		return nil, fmt.Errorf("test 2 failed (secondary server): %w", err)
 	}
@@ -236,20 +369,99 @@ func (d *Detector) Detect() (*Mapping, error) {
 	}
 
 	// Same public endpoint from both servers
-	// Now we need to determine cone type (Full, Restricted, or Port-Restricted)
-	// This requires STUN server features we don't have in basic implementation
-	// For now, we classify as Restricted Cone (most common)
+	// Now determine the cone type (Full, Restricted, or Port-Restricted)
+	// by asking the primary server to answer from a different address
+
+	// Test II: request a response from a different IP and port.
+	// Only a full cone NAT lets it through.
+	var testII, testIII *net.UDPAddr
+	changed, err := d.discoverWithChange(true, true)
+	if err == nil {
+		testII = changed.SourceAddr
+	} else if !errors.Is(err, stun.ErrTimeout) {
+		// Server rejected CHANGE-REQUEST, so we can't narrow it down
+		return d.coneMapping(endpoint1, TypeRestrictedCone), nil
+	}
 
-	// TODO: Implement full cone detection (requires CHANGE-REQUEST attribute support)
-	// This would involve requesting server to respond from different IP/port
-	// and checking if we receive the response
+	// Test III: request a response from the same IP but a different port.
+	// A restricted cone NAT lets it through, a port-restricted one doesn't.
+	if testII == nil {
+		changed, err = d.discoverWithChange(false, true)
+		if err == nil {
+			testIII = changed.SourceAddr
+		} else if !errors.Is(err, stun.ErrTimeout) {
+			return d.coneMapping(endpoint1, TypeRestrictedCone), nil
+		}
+	}
+
+	return d.coneMapping(endpoint1, classifyCone(endpoint1.ServerAddr, testII, testIII)), nil
+}
+
+// discoverWithChange sends a CHANGE-REQUEST test from the primary, if it
+// supports them
+func (d *Detector) discoverWithChange(changeIP, changePort bool) (*stun.Endpoint, error) {
+	primary, ok := d.primary.(ChangeDiscoverer)
+	if !ok {
+		return nil, errChangeUnsupported
+	}
+	return d.observe(primary.DiscoverWithChange(changeIP, changePort))
+}
+
+// observe records the round-trip time of a successful request and passes
+// the result through
+func (d *Detector) observe(endpoint *stun.Endpoint, err error) (*stun.Endpoint, error) {
+	if err == nil && endpoint.RTT > 0 {
+		d.rttMu.Lock()
+		d.rttTotal += endpoint.RTT
+		d.rttCount++
+		d.rttMu.Unlock()
+	}
+	return endpoint, err
+}
 
+// AverageRTT returns the mean round-trip time of every STUN response the
+// detector has received, or 0 before the first one
+func (d *Detector) AverageRTT() time.Duration {
+	d.rttMu.Lock()
+	defer d.rttMu.Unlock()
+
+	if d.rttCount == 0 {
+		return 0
+	}
+	return d.rttTotal / time.Duration(d.rttCount)
+}
+
+// coneMapping builds the mapping returned for a non-symmetric NAT
+func (d *Detector) coneMapping(endpoint *stun.Endpoint, natType Type) *Mapping {
 	return &Mapping{
-		LocalAddr:  endpoint1.LocalAddr,
-		PublicAddr: endpoint1.PublicAddr,
-		Type:       TypeRestrictedCone, // Conservative estimate
+		LocalAddr:  endpoint.LocalAddr,
+		PublicAddr: endpoint.PublicAddr,
+		Type:       natType,
 		DetectedAt: time.Now(),
-	}, nil
+	}
+}
+
+// classifyCone maps the results of RFC 3489 tests II and III to a cone type.
+// testII and testIII are the source addresses of the responses to the
+// change-IP-and-port and change-port requests, or nil if none arrived.
+// A response from the unchanged server address means the server ignored
+// CHANGE-REQUEST; in that case we fall back to Restricted Cone
+// (conservative estimate).
+func classifyCone(server, testII, testIII *net.UDPAddr) Type {
+	if testII != nil {
+		if server != nil && testII.IP.Equal(server.IP) {
+			return TypeRestrictedCone
+		}
+		return TypeFullCone
+	}
+
+	// A test III response means only the port changed and we still got it.
+	// (If the server ignored CHANGE-REQUEST this is the conservative answer too.)
+	if testIII != nil {
+		return TypeRestrictedCone
+	}
+
+	return TypePortRestrictedCone
 }
 
 // DetectWithRetry performs NAT detection with automatic retry on failure
@@ -264,29 +476,30 @@ func (d *Detector) DetectWithRetry() (*Mapping, error) {
 
 		lastErr = err
 
-		// Wait before retry (exponential backoff)
+		// Wait before retry (exponential backoff with jitter)
 		if attempt < d.retryCount {
-			backoff := time.Duration(1<<uint(attempt)) * time.Second
-			if backoff > 10*time.Second {
-				backoff = 10 * time.Second
-			}
-			time.Sleep(backoff)
+			time.Sleep(netutil.Backoff(attempt, d.retryBackoff))
 		}
 	}
 
 	return nil, fmt.Errorf("detection failed after %d attempts: %w", d.retryCount, lastErr)
 }
 
-// Close releases resources used by the detector
+// Close releases resources used by the detector. Injected discoverers are
+// left open.
 func (d *Detector) Close() error {
+	if !d.ownsClients {
+		return nil
+	}
+
 	var err1, err2 error
 
-	if d.primary != nil {
-		err1 = d.primary.Close()
+	if client, ok := d.primary.(io.Closer); ok {
+		err1 = client.Close()
 	}
 
-	if d.secondary != nil {
-		err2 = d.secondary.Close()
+	if client, ok := d.secondary.(io.Closer); ok {
+		err2 = client.Close()
 	}
 
 	if err1 != nil {
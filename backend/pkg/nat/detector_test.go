@@ -2,8 +2,11 @@ package nat
 
 import (
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/saintparish4/altair/pkg/stun"
 )
 
 func TestTypeString(t *testing.T) {
@@ -30,6 +33,44 @@ func TestTypeString(t *testing.T) {
 	}
 }
 
+func TestParseType(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Type
+	}{
+		{"Unknown", TypeUnknown},
+		{"Open Internet", TypeOpenInternet},
+		{"full-cone", TypeFullCone},
+		{"restricted_cone", TypeRestrictedCone},
+		{"PortRestrictedCone", TypePortRestrictedCone},
+		{"SYMMETRIC", TypeSymmetric},
+		{"blocked", TypeBlocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseType(tt.input)
+			if err != nil {
+				t.Fatalf("ParseType(%q) failed: %v", tt.input, err)
+			}
+			if result != tt.expected {
+				t.Errorf("ParseType(%q) = %s, want %s", tt.input, result, tt.expected)
+			}
+		})
+	}
+
+	// Every name String produces must round-trip
+	for natType := TypeUnknown; natType <= TypeBlocked; natType++ {
+		if parsed, err := ParseType(natType.String()); err != nil || parsed != natType {
+			t.Errorf("ParseType(%q) = %s, %v", natType.String(), parsed, err)
+		}
+	}
+
+	if _, err := ParseType("cone"); err == nil {
+		t.Error("ParseType should reject unknown names")
+	}
+}
+
 func TestTypeSupportsP2P(t *testing.T) {
 	tests := []struct {
 		natType  Type
@@ -335,6 +376,323 @@ func TestDetectorClose(t *testing.T) {
 	}
 }
 
+// startDelayedServer runs a loopback STUN server that answers binding
+// requests after delay, reporting mapped as the public address (or the
+// sender's address if mapped is nil). The counter tracks requests received.
+func startDelayedServer(t *testing.T, mapped *net.UDPAddr, delay time.Duration) (string, *atomic.Int32) {
+	t.Helper()
+	return startServerOn(t, net.IPv4(127, 0, 0, 1), mapped, delay)
+}
+
+// startServerOn is startDelayedServer listening on ip
+func startServerOn(t *testing.T, ip net.IP, mapped *net.UDPAddr, delay time.Duration) (string, *atomic.Int32) {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: 0})
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	requests := &atomic.Int32{}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			request, err := stun.Decode(buf[:n])
+			if err != nil || request.Type != stun.TypeBindingRequest {
+				continue
+			}
+			requests.Add(1)
+
+			response := &stun.Message{
+				Type:          stun.TypeBindingSuccess,
+				TransactionID: request.TransactionID,
+			}
+			publicAddr := mapped
+			if publicAddr == nil {
+				publicAddr = addr
+			}
+			response.AddAttribute(stun.EncodeXORMappedAddress(publicAddr, request.TransactionID))
+
+			data, _ := response.Encode()
+			time.AfterFunc(delay, func() { conn.WriteToUDP(data, addr) })
+		}
+	}()
+
+	return conn.LocalAddr().String(), requests
+}
+
+func TestDetectQueriesServersInParallel(t *testing.T) {
+	const delay = 300 * time.Millisecond
+
+	// Different public ports per server: symmetric, decided from tests 1
+	// and 2 alone
+	primary, _ := startDelayedServer(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40001}, delay)
+	secondary, _ := startDelayedServer(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40002}, delay)
+	config := &DetectorConfig{
+		PrimaryServer:   primary,
+		SecondaryServer: secondary,
+		Timeout:         2 * time.Second,
+	}
+
+	detector, err := NewDetector(config)
+	if err != nil {
+		t.Fatalf("NewDetector failed: %v", err)
+	}
+	defer detector.Close()
+
+	start := time.Now()
+	mapping, err := detector.Detect()
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Detect failed: %v", err)
+	}
+
+	if mapping.Type != TypeSymmetric {
+		t.Errorf("Type = %s, want %s", mapping.Type, TypeSymmetric)
+	}
+	if mapping.PublicAddr.Port != 40001 {
+		t.Errorf("PublicAddr = %s, want the primary server's mapping", mapping.PublicAddr)
+	}
+	if elapsed >= 2*delay {
+		t.Errorf("Detect took %v, want under %v with parallel requests", elapsed, 2*delay)
+	}
+}
+
+func TestDetectorAverageRTT(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	primary, _ := startDelayedServer(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40001}, delay)
+	secondary, _ := startDelayedServer(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40002}, delay)
+
+	detector, err := NewDetector(&DetectorConfig{
+		PrimaryServer:   primary,
+		SecondaryServer: secondary,
+		Timeout:         2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewDetector failed: %v", err)
+	}
+	defer detector.Close()
+
+	if rtt := detector.AverageRTT(); rtt != 0 {
+		t.Errorf("AverageRTT before any request = %v, want 0", rtt)
+	}
+
+	if _, err := detector.Detect(); err != nil {
+		t.Fatalf("Detect failed: %v", err)
+	}
+
+	if rtt := detector.AverageRTT(); rtt < delay || rtt >= time.Second {
+		t.Errorf("AverageRTT = %v, want at least the server delay %v", rtt, delay)
+	}
+}
+
+func TestDetectSecondaryFailure(t *testing.T) {
+	// The secondary never answers, so test 2 times out
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer silent.Close()
+
+	primary, _ := startDelayedServer(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40001}, 0)
+	config := &DetectorConfig{
+		PrimaryServer:   primary,
+		SecondaryServer: silent.LocalAddr().String(),
+		Timeout:         200 * time.Millisecond,
+	}
+
+	detector, err := NewDetector(config)
+	if err != nil {
+		t.Fatalf("NewDetector failed: %v", err)
+	}
+	defer detector.Close()
+
+	if _, err := detector.Detect(); err == nil {
+		t.Error("Expected error when the secondary server doesn't answer")
+	}
+}
+
+func TestClassifyCone(t *testing.T) {
+	server := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 3478}
+	altIPAndPort := &net.UDPAddr{IP: net.ParseIP("198.51.100.2"), Port: 3479}
+	altPort := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 3479}
+
+	tests := []struct {
+		name     string
+		testII   *net.UDPAddr
+		testIII  *net.UDPAddr
+		expected Type
+	}{
+		{"test II from alternate IP", altIPAndPort, nil, TypeFullCone},
+		{"test II ignored by server", server, nil, TypeRestrictedCone},
+		{"test III from alternate port", nil, altPort, TypeRestrictedCone},
+		{"test III ignored by server", nil, server, TypeRestrictedCone},
+		{"no responses", nil, nil, TypePortRestrictedCone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := classifyCone(server, tt.testII, tt.testIII)
+			if result != tt.expected {
+				t.Errorf("classifyCone() = %s, want %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+// fakeDiscoverer returns a fixed binding result
+type fakeDiscoverer struct {
+	endpoint *stun.Endpoint
+	err      error
+}
+
+func (f *fakeDiscoverer) Discover() (*stun.Endpoint, error) {
+	return f.endpoint, f.err
+}
+
+// fakeChangeDiscoverer also answers CHANGE-REQUEST tests
+type fakeChangeDiscoverer struct {
+	fakeDiscoverer
+	testII, testIII *stun.Endpoint // nil means the request timed out
+}
+
+func (f *fakeChangeDiscoverer) DiscoverWithChange(changeIP, changePort bool) (*stun.Endpoint, error) {
+	response := f.testIII
+	if changeIP {
+		response = f.testII
+	}
+	if response == nil {
+		return nil, stun.ErrTimeout
+	}
+	return response, nil
+}
+
+func TestDetectWithFakeDiscoverers(t *testing.T) {
+	local := &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 50000}
+	public := &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40001}
+	server := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 3478}
+
+	endpoint := func(publicAddr *net.UDPAddr) *stun.Endpoint {
+		return &stun.Endpoint{LocalAddr: local, PublicAddr: publicAddr, ServerAddr: server}
+	}
+	fromSource := func(source *net.UDPAddr) *stun.Endpoint {
+		e := endpoint(public)
+		e.SourceAddr = source
+		return e
+	}
+
+	tests := []struct {
+		name      string
+		primary   Discoverer
+		secondary Discoverer
+		expected  Type
+		wantErr   bool
+	}{
+		{
+			name:      "open internet",
+			primary:   &fakeDiscoverer{endpoint: &stun.Endpoint{LocalAddr: public, PublicAddr: public}},
+			secondary: &fakeDiscoverer{err: stun.ErrTimeout},
+			expected:  TypeOpenInternet,
+		},
+		{
+			name:      "different port is symmetric",
+			primary:   &fakeDiscoverer{endpoint: endpoint(public)},
+			secondary: &fakeDiscoverer{endpoint: endpoint(&net.UDPAddr{IP: public.IP, Port: 40002})},
+			expected:  TypeSymmetric,
+		},
+		{
+			name:      "different IP is symmetric",
+			primary:   &fakeDiscoverer{endpoint: endpoint(public)},
+			secondary: &fakeDiscoverer{endpoint: endpoint(&net.UDPAddr{IP: net.ParseIP("203.0.113.6"), Port: public.Port})},
+			expected:  TypeSymmetric,
+		},
+		{
+			name: "full cone",
+			primary: &fakeChangeDiscoverer{
+				fakeDiscoverer: fakeDiscoverer{endpoint: endpoint(public)},
+				testII:         fromSource(&net.UDPAddr{IP: net.ParseIP("198.51.100.2"), Port: 3479}),
+			},
+			secondary: &fakeDiscoverer{endpoint: endpoint(public)},
+			expected:  TypeFullCone,
+		},
+		{
+			name: "restricted cone",
+			primary: &fakeChangeDiscoverer{
+				fakeDiscoverer: fakeDiscoverer{endpoint: endpoint(public)},
+				testIII:        fromSource(&net.UDPAddr{IP: server.IP, Port: 3479}),
+			},
+			secondary: &fakeDiscoverer{endpoint: endpoint(public)},
+			expected:  TypeRestrictedCone,
+		},
+		{
+			name: "port restricted cone",
+			primary: &fakeChangeDiscoverer{
+				fakeDiscoverer: fakeDiscoverer{endpoint: endpoint(public)},
+			},
+			secondary: &fakeDiscoverer{endpoint: endpoint(public)},
+			expected:  TypePortRestrictedCone,
+		},
+		{
+			name:      "no CHANGE-REQUEST support",
+			primary:   &fakeDiscoverer{endpoint: endpoint(public)},
+			secondary: &fakeDiscoverer{endpoint: endpoint(public)},
+			expected:  TypeRestrictedCone,
+		},
+		{
+			name:      "primary fails",
+			primary:   &fakeDiscoverer{err: stun.ErrTimeout},
+			secondary: &fakeDiscoverer{endpoint: endpoint(public)},
+			wantErr:   true,
+		},
+		{
+			name:      "secondary fails behind NAT",
+			primary:   &fakeDiscoverer{endpoint: endpoint(public)},
+			secondary: &fakeDiscoverer{err: stun.ErrTimeout},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector, err := NewDetector(&DetectorConfig{Primary: tt.primary, Secondary: tt.secondary})
+			if err != nil {
+				t.Fatalf("NewDetector failed: %v", err)
+			}
+
+			mapping, err := detector.Detect()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Detect() = %s, want error", mapping)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Detect failed: %v", err)
+			}
+			if mapping.Type != tt.expected {
+				t.Errorf("Type = %s, want %s", mapping.Type, tt.expected)
+			}
+			if !mapping.PublicAddr.IP.Equal(public.IP) || mapping.PublicAddr.Port != public.Port {
+				t.Errorf("PublicAddr = %s, want %s", mapping.PublicAddr, public)
+			}
+		})
+	}
+}
+
+func TestNewDetectorRequiresBothDiscoverers(t *testing.T) {
+	_, err := NewDetector(&DetectorConfig{Primary: &fakeDiscoverer{}})
+	if err == nil {
+		t.Error("NewDetector should fail with only a primary discoverer")
+	}
+}
+
 func BenchmarkTypeString(b *testing.B) {
 	natType := TypeFullCone
 	b.ResetTimer()
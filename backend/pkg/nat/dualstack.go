@@ -0,0 +1,137 @@
+package nat
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// DualStackMapping holds the mappings detected over each address family.
+// A family that couldn't be detected (no addresses, no server address of
+// that family, or no responses) is nil.
+type DualStackMapping struct {
+	IPv4 *Mapping
+	IPv6 *Mapping
+}
+
+// Preferred returns the mapping to connect over: IPv6 when it is at least
+// as easy to traverse as IPv4 (it usually has no NAT), otherwise IPv4.
+func (m *DualStackMapping) Preferred() *Mapping {
+	if m == nil {
+		return nil
+	}
+	if m.IPv6 != nil && (m.IPv4 == nil || m.IPv6.Type.Difficulty() <= m.IPv4.Type.Difficulty()) {
+		return m.IPv6
+	}
+	return m.IPv4
+}
+
+// DetectDualStack detects each address family on its own sockets to the
+// configured servers, which need A and AAAA records respectively. IPv4 runs
+// the full Detect algorithm. It fails only if neither family could be
+// detected.
+func (d *Detector) DetectDualStack() (*DualStackMapping, error) {
+	var result DualStackMapping
+
+	var err4, err6 error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result.IPv6, err6 = d.detectIPv6()
+	}()
+	result.IPv4, err4 = d.detectIPv4()
+	<-done
+
+	if err4 != nil && err6 != nil {
+		return nil, fmt.Errorf("dual-stack detection failed: %w", errors.Join(
+			fmt.Errorf("IPv4: %w", err4), fmt.Errorf("IPv6: %w", err6)))
+	}
+	return &result, nil
+}
+
+// detectIPv4 runs Detect over IPv4-only clients
+func (d *Detector) detectIPv4() (*Mapping, error) {
+	if d.primaryServer == "" || d.secondaryServer == "" {
+		return nil, fmt.Errorf("IPv4 detection needs server addresses")
+	}
+
+	detector, err := NewDetector(&DetectorConfig{
+		PrimaryServer:   d.primaryServer,
+		SecondaryServer: d.secondaryServer,
+		Timeout:         d.timeout,
+		Network:         "udp4",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer detector.Close()
+
+	return detector.Detect()
+}
+
+// detectIPv6 classifies the IPv6 path. A reflexive address assigned to this
+// host means there is no NAT (Open Internet). Otherwise NAT66/NPTv6 is
+// treated like its IPv4 counterpart: a mapping that differs between servers
+// is symmetric; a consistent one is assumed port restricted, since servers
+// rarely support CHANGE-REQUEST over IPv6.
+func (d *Detector) detectIPv6() (*Mapping, error) {
+	if d.primaryServer == "" || d.secondaryServer == "" {
+		return nil, fmt.Errorf("IPv6 detection needs server addresses")
+	}
+
+	primary, err := d.newIPv6Client(d.primaryServer)
+	if err != nil {
+		return nil, err
+	}
+	defer primary.Close()
+
+	endpoint1, err := primary.Discover()
+	if err != nil {
+		return nil, fmt.Errorf("IPv6 test 1 failed (primary server): %w", err)
+	}
+
+	if endpoint1.LocalAddr.IP.Equal(endpoint1.PublicAddr.IP) || d.isLocalAddr(endpoint1.PublicAddr.IP) {
+		return d.coneMapping(endpoint1, TypeOpenInternet), nil
+	}
+
+	secondary, err := d.newIPv6Client(d.secondaryServer)
+	if err != nil {
+		return nil, err
+	}
+	defer secondary.Close()
+
+	endpoint2, err := secondary.Discover()
+	if err != nil {
+		return nil, fmt.Errorf("IPv6 test 2 failed (secondary server): %w", err)
+	}
+
+	if !sameAddr(endpoint1.PublicAddr, endpoint2.PublicAddr) {
+		return d.coneMapping(endpoint1, TypeSymmetric), nil
+	}
+	return d.coneMapping(endpoint1, TypePortRestrictedCone), nil
+}
+
+// newIPv6Client creates a STUN client on its own IPv6 socket
+func (d *Detector) newIPv6Client(server string) (*stun.Client, error) {
+	client, err := stun.NewClient(&stun.ClientConfig{
+		ServerAddr: server,
+		Timeout:    d.timeout,
+		Network:    "udp6",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IPv6 STUN client: %w", err)
+	}
+	return client, nil
+}
+
+// isLocalAddr reports whether ip is assigned to this host
+func (d *Detector) isLocalAddr(ip net.IP) bool {
+	addrs, err := d.localAddrs()
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(addrs, ip.Equal)
+}
@@ -0,0 +1,123 @@
+package nat
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// startIPv6Server starts a loopback IPv6 test server, skipping the test if
+// the host has no IPv6 loopback
+func startIPv6Server(t *testing.T, mapped *net.UDPAddr) string {
+	t.Helper()
+
+	probe, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	probe.Close()
+
+	addr, _ := startServerOn(t, net.IPv6loopback, mapped, 0)
+	return addr
+}
+
+func TestDetectDualStack(t *testing.T) {
+	v4Primary, _ := startDelayedServer(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40001}, 0)
+	v4Secondary, _ := startDelayedServer(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40002}, 0)
+
+	tests := []struct {
+		name      string
+		primary   string
+		secondary string
+		want4     Type
+		want6     Type
+	}{
+		{
+			name:      "IPv4 only",
+			primary:   v4Primary,
+			secondary: v4Secondary,
+			want4:     TypeSymmetric,
+			want6:     TypeUnknown, // not detected
+		},
+		{
+			// The server reports our own ::1 address back
+			name:      "IPv6 without NAT",
+			primary:   startIPv6Server(t, nil),
+			secondary: startIPv6Server(t, nil),
+			want4:     TypeUnknown,
+			want6:     TypeOpenInternet,
+		},
+		{
+			name:      "IPv6 behind symmetric NAT66",
+			primary:   startIPv6Server(t, &net.UDPAddr{IP: net.ParseIP("2001:db8::5"), Port: 40001}),
+			secondary: startIPv6Server(t, &net.UDPAddr{IP: net.ParseIP("2001:db8::5"), Port: 40002}),
+			want4:     TypeUnknown,
+			want6:     TypeSymmetric,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector, err := NewDetector(&DetectorConfig{
+				PrimaryServer:   tt.primary,
+				SecondaryServer: tt.secondary,
+				Timeout:         200 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("NewDetector failed: %v", err)
+			}
+			defer detector.Close()
+			detector.localAddrs = func() ([]net.IP, error) {
+				return []net.IP{net.ParseIP("192.168.1.20"), net.IPv6loopback}, nil
+			}
+
+			result, err := detector.DetectDualStack()
+			if err != nil {
+				t.Fatalf("DetectDualStack failed: %v", err)
+			}
+
+			check := func(family string, m *Mapping, want Type) {
+				if want == TypeUnknown {
+					if m != nil {
+						t.Errorf("%s = %s, want nil", family, m)
+					}
+					return
+				}
+				if m == nil {
+					t.Errorf("%s = nil, want %s", family, want)
+				} else if m.Type != want {
+					t.Errorf("%s type = %s, want %s", family, m.Type, want)
+				}
+			}
+			check("IPv4", result.IPv4, tt.want4)
+			check("IPv6", result.IPv6, tt.want6)
+		})
+	}
+}
+
+func TestDualStackMappingPreferred(t *testing.T) {
+	cone := &Mapping{Type: TypeFullCone}
+	open := &Mapping{Type: TypeOpenInternet}
+	symmetric := &Mapping{Type: TypeSymmetric}
+
+	tests := []struct {
+		name     string
+		mapping  *DualStackMapping
+		expected *Mapping
+	}{
+		{"IPv6 easier", &DualStackMapping{IPv4: cone, IPv6: open}, open},
+		{"IPv4 easier", &DualStackMapping{IPv4: cone, IPv6: symmetric}, cone},
+		{"equal prefers IPv6", &DualStackMapping{IPv4: &Mapping{Type: TypeFullCone}, IPv6: cone}, cone},
+		{"IPv4 only", &DualStackMapping{IPv4: cone}, cone},
+		{"IPv6 only", &DualStackMapping{IPv6: symmetric}, symmetric},
+		{"nil", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mapping.Preferred(); got != tt.expected {
+				t.Errorf("Preferred() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
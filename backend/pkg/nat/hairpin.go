@@ -0,0 +1,87 @@
+package nat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// hairpinMagic prefixes hairpin probes so stray packets aren't mistaken for
+// one
+var hairpinMagic = []byte("ALTAIR-HAIRPIN")
+
+// DetectHairpinning reports whether the NAT loops packets sent to one of its
+// own public endpoints back inside (RFC 5780 section 4.5). It learns the
+// public endpoint of a fresh socket from the primary server, then sends a
+// probe to that endpoint from a second socket. Without hairpinning, peers
+// behind the same NAT can only reach each other on local addresses.
+func (d *Detector) DetectHairpinning() (bool, error) {
+	if d.primaryServer == "" {
+		return false, fmt.Errorf("hairpinning test needs a primary server address")
+	}
+
+	timeout := d.timeout
+	if timeout == 0 {
+		timeout = stun.DefaultTimeout
+	}
+
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return false, fmt.Errorf("failed to create UDP socket: %w", err)
+	}
+	defer receiver.Close()
+
+	client, err := stun.NewClient(&stun.ClientConfig{
+		ServerAddr: d.primaryServer,
+		Timeout:    timeout,
+		Conn:       receiver,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to create STUN client: %w", err)
+	}
+	defer client.Close()
+
+	endpoint, err := client.Discover()
+	if err != nil {
+		return false, fmt.Errorf("hairpinning test failed to discover mapping: %w", err)
+	}
+
+	sender, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return false, fmt.Errorf("failed to create UDP socket: %w", err)
+	}
+	defer sender.Close()
+
+	probe := make([]byte, len(hairpinMagic)+16)
+	copy(probe, hairpinMagic)
+	if _, err := rand.Read(probe[len(hairpinMagic):]); err != nil {
+		return false, fmt.Errorf("failed to generate probe: %w", err)
+	}
+	if _, err := sender.WriteToUDP(probe, endpoint.PublicAddr); err != nil {
+		return false, fmt.Errorf("failed to send hairpin probe: %w", err)
+	}
+
+	if err := receiver.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return false, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := receiver.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to read hairpin probe: %w", err)
+		}
+		if bytes.Equal(buf[:n], probe) {
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package nat
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDetectHairpinning(t *testing.T) {
+	// Reporting the sender's own address makes loopback behave like a
+	// hairpinning NAT: the probe reaches the receiver directly
+	server, _ := startDelayedServer(t, nil, 0)
+
+	detector, err := NewDetector(&DetectorConfig{
+		PrimaryServer:   server,
+		SecondaryServer: server,
+		Timeout:         time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewDetector failed: %v", err)
+	}
+	defer detector.Close()
+
+	hairpinning, err := detector.DetectHairpinning()
+	if err != nil {
+		t.Fatalf("DetectHairpinning failed: %v", err)
+	}
+	if !hairpinning {
+		t.Error("Expected hairpinning when the probe loops back")
+	}
+}
+
+func TestDetectHairpinningUnsupported(t *testing.T) {
+	// A public endpoint nobody listens on: the probe never comes back
+	dead, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	deadAddr := dead.LocalAddr().(*net.UDPAddr)
+	dead.Close()
+
+	server, _ := startDelayedServer(t, deadAddr, 0)
+
+	detector, err := NewDetector(&DetectorConfig{
+		PrimaryServer:   server,
+		SecondaryServer: server,
+		Timeout:         200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewDetector failed: %v", err)
+	}
+	defer detector.Close()
+
+	hairpinning, err := detector.DetectHairpinning()
+	if err != nil {
+		t.Fatalf("DetectHairpinning failed: %v", err)
+	}
+	if hairpinning {
+		t.Error("Expected no hairpinning when the probe is lost")
+	}
+}
+
+func TestDetectHairpinningNeedsServer(t *testing.T) {
+	detector, err := NewDetector(&DetectorConfig{
+		Primary:   &fakeDiscoverer{},
+		Secondary: &fakeDiscoverer{},
+	})
+	if err != nil {
+		t.Fatalf("NewDetector failed: %v", err)
+	}
+
+	if _, err := detector.DetectHairpinning(); err == nil {
+		t.Error("Expected error without a primary server address")
+	}
+}
@@ -0,0 +1,32 @@
+package netutil
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// DefaultBackoffBase is the delay before the first retry, before jitter
+const DefaultBackoffBase = time.Second
+
+// MaxBackoff caps the delay between retries
+const MaxBackoff = 10 * time.Second
+
+// Backoff returns how long to wait before retrying after failed attempt
+// number attempt (counting from 0). The delay starts at base, doubles per
+// attempt up to MaxBackoff, and then gets "equal jitter": half of it is kept
+// and the other half is random, so clients that failed together (say,
+// during a STUN server blip) don't all retry in lockstep. A non-positive
+// base uses DefaultBackoffBase.
+func Backoff(attempt int, base time.Duration) time.Duration {
+	if base <= 0 {
+		base = DefaultBackoffBase
+	}
+
+	delay := min(base, MaxBackoff)
+	for i := 0; i < attempt && delay < MaxBackoff; i++ {
+		delay = min(2*delay, MaxBackoff)
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
@@ -0,0 +1,43 @@
+package netutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration
+		want    time.Duration // delay before jitter
+	}{
+		{0, time.Second, time.Second},
+		{1, time.Second, 2 * time.Second},
+		{3, time.Second, 8 * time.Second},
+		{4, time.Second, MaxBackoff},
+		{100, time.Second, MaxBackoff},
+		{0, 0, DefaultBackoffBase},
+		{2, 50 * time.Millisecond, 200 * time.Millisecond},
+		{0, time.Minute, MaxBackoff},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			got := Backoff(tt.attempt, tt.base)
+			if got < tt.want/2 || got > tt.want {
+				t.Fatalf("Backoff(%d, %v) = %v, want within [%v, %v]",
+					tt.attempt, tt.base, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	// Clients retrying after the same failure should spread out
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 10; i++ {
+		seen[Backoff(2, time.Second)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("10 backoffs for the same attempt were all %v", Backoff(2, time.Second))
+	}
+}
@@ -0,0 +1,34 @@
+//go:build darwin
+
+package netutil
+
+import (
+	"net"
+	"syscall"
+)
+
+// IP_BOUND_IF and IPV6_BOUND_IF from <netinet/in.h>; the syscall package
+// only has the IPv4 one on some architectures
+const (
+	ipBoundIf   = 0x19
+	ipv6BoundIf = 0x7d
+)
+
+// bindToDevice sets IP_BOUND_IF (IPV6_BOUND_IF on IPv6 sockets) so packets
+// leave through iface whatever the routing table says
+func bindToDevice(iface *net.Interface, c syscall.RawConn) error {
+	var v4Err, v6Err error
+	err := c.Control(func(fd uintptr) {
+		v4Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, ipBoundIf, iface.Index)
+		if v4Err != nil {
+			v6Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6BoundIf, iface.Index)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if v4Err != nil && v6Err != nil {
+		return v4Err
+	}
+	return nil
+}
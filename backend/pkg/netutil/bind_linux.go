@@ -0,0 +1,21 @@
+//go:build linux
+
+package netutil
+
+import (
+	"net"
+	"syscall"
+)
+
+// bindToDevice sets SO_BINDTODEVICE so packets leave through iface whatever
+// the routing table says. Linux needs CAP_NET_RAW for it before 5.7.
+func bindToDevice(iface *net.Interface, c syscall.RawConn) error {
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		opErr = syscall.BindToDevice(int(fd), iface.Name)
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
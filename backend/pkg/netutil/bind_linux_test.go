@@ -0,0 +1,49 @@
+//go:build linux
+
+package netutil
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// soBindToIfindex is SO_BINDTOIFINDEX (Linux 5.0+), which reads back the
+// device set with SO_BINDTODEVICE as an index
+const soBindToIfindex = 62
+
+func TestCreateUDPSocketOnInterfaceBindsDevice(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("No lo interface")
+	}
+
+	conn, err := CreateUDPSocketOnInterface(lo.Name, 0)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("SO_BINDTODEVICE needs CAP_NET_RAW on this kernel")
+	}
+	if err != nil {
+		t.Fatalf("CreateUDPSocketOnInterface failed: %v", err)
+	}
+	defer conn.Close()
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn failed: %v", err)
+	}
+	var index int
+	var getErr error
+	raw.Control(func(fd uintptr) {
+		index, getErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, soBindToIfindex)
+	})
+	if errors.Is(getErr, syscall.ENOPROTOOPT) {
+		t.Skip("SO_BINDTOIFINDEX not supported by this kernel")
+	}
+	if getErr != nil {
+		t.Fatalf("getsockopt failed: %v", getErr)
+	}
+	if index != lo.Index {
+		t.Errorf("bound device index = %d, want %d (lo)", index, lo.Index)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !(darwin || linux)
+
+package netutil
+
+import (
+	"net"
+	"syscall"
+)
+
+// bindToDevice is a no-op where no per-socket interface option is
+// available; the socket only takes the interface's source address
+func bindToDevice(iface *net.Interface, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,38 @@
+package netutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// DSCP code points for common realtime traffic classes (RFC 4594)
+const (
+	DSCPDefault             = 0  // Best effort
+	DSCPExpeditedForwarding = 46 // EF, for voice
+	DSCPAF41                = 34 // Interactive video
+	DSCPCS5                 = 40 // Signaling
+)
+
+// ErrDSCPUnsupported is returned by SetDSCP where the platform can't mark
+// packets
+var ErrDSCPUnsupported = errors.New("DSCP marking is not supported on this platform")
+
+// SetDSCP marks every packet sent on conn with the DSCP code point dscp
+// (0-63) so routers that honor it can prioritize the traffic. It sets
+// IP_TOS and, for IPv6 and dual-stack sockets, IPV6_TCLASS. Routers and
+// ISPs are free to ignore or rewrite the marking, so treat it as a hint.
+func SetDSCP(conn *net.UDPConn, dscp int) error {
+	if dscp < 0 || dscp > 63 {
+		return fmt.Errorf("DSCP value %d out of range 0-63", dscp)
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("failed to access socket: %w", err)
+	}
+
+	// DSCP is the upper six bits of the TOS / traffic class byte; the
+	// lower two belong to ECN
+	return setTrafficClass(raw, dscp<<2)
+}
@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package netutil
+
+import "syscall"
+
+// setTrafficClass can't mark packets here. Windows ignores IP_TOS unless
+// the QoS2 API is used, which needs more than a socket option.
+func setTrafficClass(c syscall.RawConn, tos int) error {
+	return ErrDSCPUnsupported
+}
@@ -0,0 +1,42 @@
+package netutil
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestSetDSCP(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:0", "0.0.0.0:0"} {
+		// The wildcard address gives a dual-stack socket where IPv6 is
+		// available
+		laddr, _ := net.ResolveUDPAddr("udp", addr)
+		conn, err := net.ListenUDP("udp", laddr)
+		if err != nil {
+			t.Fatalf("ListenUDP failed: %v", err)
+		}
+		defer conn.Close()
+
+		err = SetDSCP(conn, DSCPExpeditedForwarding)
+		if errors.Is(err, ErrDSCPUnsupported) {
+			t.Skip("DSCP marking not supported on this platform")
+		}
+		if err != nil {
+			t.Errorf("SetDSCP on %s failed: %v", addr, err)
+		}
+	}
+}
+
+func TestSetDSCPOutOfRange(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer conn.Close()
+
+	for _, dscp := range []int{-1, 64} {
+		if err := SetDSCP(conn, dscp); err == nil {
+			t.Errorf("expected an error for DSCP %d", dscp)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package netutil
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setTrafficClass sets IP_TOS and IPV6_TCLASS. One succeeding is enough:
+// IPv4 sockets reject IPV6_TCLASS, and some platforms reject IP_TOS on
+// IPv6 sockets.
+func setTrafficClass(c syscall.RawConn, tos int) error {
+	var v4Err, v6Err error
+	err := c.Control(func(fd uintptr) {
+		v4Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		v6Err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	})
+	if err != nil {
+		return err
+	}
+	if v4Err != nil && v6Err != nil {
+		return fmt.Errorf("failed to set IP_TOS: %w", v4Err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package netutil
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestSetDSCPMarksSocket(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer conn.Close()
+
+	if err := SetDSCP(conn, DSCPAF41); err != nil {
+		t.Fatalf("SetDSCP failed: %v", err)
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn failed: %v", err)
+	}
+	var tos int
+	var getErr error
+	raw.Control(func(fd uintptr) {
+		tos, getErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+	})
+	if getErr != nil {
+		t.Fatalf("getsockopt failed: %v", getErr)
+	}
+	if tos != DSCPAF41<<2 {
+		t.Errorf("IP_TOS = %#x, want %#x", tos, DSCPAF41<<2)
+	}
+}
@@ -1,9 +1,12 @@
 package netutil
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
+	"syscall"
 )
 
 // GetLocalAddresses returns all non-loopback local IP addresses
@@ -181,6 +184,80 @@ func CreateUDPSocketWithAddress(address string, port int) (*net.UDPConn, error)
 	return conn, nil
 }
 
+// CreateUDPSocketOnInterface creates a UDP socket bound to the address of
+// the named interface (e.g. "eth0") and, on Linux (SO_BINDTODEVICE) and
+// macOS (IP_BOUND_IF), to the interface itself, so traffic leaves through
+// that NIC rather than whichever the routing table picks. Elsewhere only
+// the source address is chosen. See InterfaceAddress for which address is
+// used.
+func CreateUDPSocketOnInterface(ifaceName string, port int) (*net.UDPConn, error) {
+	ip, err := InterfaceAddress(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	// InterfaceAddress has already checked the interface exists
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q not found: %w", ifaceName, err)
+	}
+
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			if err := bindToDevice(iface, c); err != nil {
+				return fmt.Errorf("failed to bind to device %s: %w", ifaceName, err)
+			}
+			return nil
+		},
+	}
+
+	addr := &net.UDPAddr{IP: ip, Port: port}
+	pc, err := lc.ListenPacket(context.Background(), "udp", addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UDP socket on %s (%s): %w", ifaceName, ip, err)
+	}
+
+	return pc.(*net.UDPConn), nil
+}
+
+// InterfaceAddress returns the address to bind on the named interface: its
+// first IPv4 address, or else its first global IPv6 address. It fails if
+// the interface doesn't exist, is down or has no usable address.
+func InterfaceAddress(ifaceName string) (net.IP, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q not found: %w", ifaceName, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("interface %q is down", ifaceName)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of interface %q: %w", ifaceName, err)
+	}
+
+	var v6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsUnspecified() {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+		// Link-local IPv6 needs a zone to bind; skip it
+		if v6 == nil && !ipNet.IP.IsLinkLocalUnicast() {
+			v6 = ipNet.IP
+		}
+	}
+	if v6 != nil {
+		return v6, nil
+	}
+
+	return nil, fmt.Errorf("interface %q has no usable IP address", ifaceName)
+}
+
 // PortScanner helps find multiple available ports
 type PortScanner struct {
 	mu    sync.Mutex
@@ -230,6 +307,29 @@ func (ps *PortScanner) FindPort() (int, error) {
 	return 0, fmt.Errorf("no available ports in range %d-%d", ps.start, ps.end)
 }
 
+// ListenUDP binds a UDP socket on ip (nil for all addresses) to the first
+// free port in the range. Unlike FindPort the socket is kept, so nobody can
+// take the port in between; it is free again once the socket is closed.
+// Several clients can share one scanner to keep all their sockets in the
+// range, e.g. one a firewall allows.
+func (ps *PortScanner) ListenUDP(network string, ip net.IP) (*net.UDPConn, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for port := ps.start; port <= ps.end; port++ {
+		if ps.used[port] {
+			continue
+		}
+
+		conn, err := net.ListenUDP(network, &net.UDPAddr{IP: ip, Port: port})
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no available ports in range %d-%d", ps.start, ps.end)
+}
+
 // ReleasePort marks a port as available again
 func (ps *PortScanner) ReleasePort(port int) {
 	ps.mu.Lock()
@@ -238,7 +338,9 @@ func (ps *PortScanner) ReleasePort(port int) {
 }
 
 // GetPreferredLocalAddress returns the preferred local address for external communication
-// It attempts to determine which local address would be used for internet connectivity
+// It attempts to determine which local address would be used for internet connectivity.
+// On multi-homed hosts the guess follows the routing table; use
+// CreateUDPSocketOnInterface to pick the interface explicitly.
 func GetPreferredLocalAddress() (net.IP, error) {
 	// Try to connect to a public address (doesn't actually send data)
 	// This helps determine which interface would be used for internet access
@@ -280,6 +382,96 @@ func SameNetwork(ip1, ip2 net.IP, mask net.IPMask) bool {
 	return network1.Equal(network2)
 }
 
+// GetLocalNetworks returns the subnets of all up, non-loopback interfaces
+func GetLocalNetworks() ([]*net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network interfaces: %w", err)
+	}
+
+	var networks []*net.IPNet
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				networks = append(networks, ipNet)
+			}
+		}
+	}
+
+	return networks, nil
+}
+
+// subnetMatch returns the prefix length of the most specific network
+// containing ip, or -1 if none does
+func subnetMatch(ip net.IP, networks []*net.IPNet) int {
+	best := -1
+	for _, network := range networks {
+		if !SameNetwork(ip, network.IP, network.Mask) {
+			continue
+		}
+		if ones, _ := network.Mask.Size(); ones > best {
+			best = ones
+		}
+	}
+	return best
+}
+
+// SameSubnet reports whether ip is on one of networks, e.g. from
+// GetLocalNetworks
+func SameSubnet(ip net.IP, networks []*net.IPNet) bool {
+	return subnetMatch(ip, networks) >= 0
+}
+
+// SortBySubnet orders a peer's local addresses so those on one of networks
+// come first, most specific subnet first. Addresses off every subnet follow
+// in their original order. matched is how many shared a subnet.
+func SortBySubnet(addrs []*net.UDPAddr, networks []*net.IPNet) (sorted []*net.UDPAddr, matched int) {
+	prefixes := make([]int, len(addrs))
+	for i, addr := range addrs {
+		prefixes[i] = -1
+		if addr != nil {
+			prefixes[i] = subnetMatch(addr.IP, networks)
+		}
+		if prefixes[i] >= 0 {
+			matched++
+		}
+	}
+
+	order := make([]int, len(addrs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return prefixes[order[a]] > prefixes[order[b]]
+	})
+
+	sorted = make([]*net.UDPAddr, len(addrs))
+	for i, index := range order {
+		sorted[i] = addrs[index]
+	}
+	return sorted, matched
+}
+
+// BestLocalCandidate returns the peer address most likely reachable
+// directly: the one on the most specific subnet we share. ok is false when
+// the peer isn't on any of our networks.
+func BestLocalCandidate(addrs []*net.UDPAddr, networks []*net.IPNet) (addr *net.UDPAddr, ok bool) {
+	sorted, matched := SortBySubnet(addrs, networks)
+	if matched == 0 {
+		return nil, false
+	}
+	return sorted[0], true
+}
+
 // ValidateUDPAddr checks if a UDP address string is valid
 func ValidateUDPAddr(addr string) error {
 	if addr == "" {
@@ -182,6 +182,39 @@ func TestCreateUDPSocketWithAddress(t *testing.T) {
 	}
 }
 
+func TestCreateUDPSocketOnInterface(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("net.Interfaces() failed: %v", err)
+	}
+
+	var loopback string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+	if loopback == "" {
+		t.Skip("No loopback interface")
+	}
+
+	conn, err := CreateUDPSocketOnInterface(loopback, 0)
+	if err != nil {
+		t.Fatalf("CreateUDPSocketOnInterface(%q) failed: %v", loopback, err)
+	}
+	defer conn.Close()
+
+	localAddr := conn.LocalAddr().(*net.UDPAddr)
+	if !localAddr.IP.IsLoopback() {
+		t.Errorf("Expected loopback address, got %s", localAddr.IP)
+	}
+
+	if _, err := CreateUDPSocketOnInterface("no-such-iface0", 0); err == nil {
+		t.Error("Expected error for unknown interface")
+	}
+}
+
 func TestPortScanner(t *testing.T) {
 	scanner := NewPortScanner(10000, 10010)
 
@@ -237,6 +270,31 @@ func TestPortScannerExhaustion(t *testing.T) {
 	}
 }
 
+func TestPortScannerListenUDP(t *testing.T) {
+	scanner := NewPortScanner(10000, 10002)
+
+	// Sockets stay bound, so each gets its own port
+	seen := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		conn, err := scanner.ListenUDP("udp4", net.IPv4(127, 0, 0, 1))
+		if err != nil {
+			t.Fatalf("ListenUDP() iteration %d failed: %v", i, err)
+		}
+		defer conn.Close()
+
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		if port < 10000 || port > 10002 || seen[port] {
+			t.Errorf("ListenUDP() bound port %d, want a new port in [10000, 10002]", port)
+		}
+		seen[port] = true
+	}
+
+	if conn, err := scanner.ListenUDP("udp4", net.IPv4(127, 0, 0, 1)); err == nil {
+		conn.Close()
+		t.Error("ListenUDP() should fail when every port is bound")
+	}
+}
+
 func TestGetPreferredLocalAddress(t *testing.T) {
 	ip, err := GetPreferredLocalAddress()
 	if err != nil {
@@ -305,6 +363,92 @@ func TestSameNetwork(t *testing.T) {
 	}
 }
 
+// mustNetworks parses CIDRs as interface addresses (IP plus mask)
+func mustNetworks(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		ip, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%q) failed: %v", cidr, err)
+		}
+		network.IP = ip
+		networks[i] = network
+	}
+	return networks
+}
+
+func TestSameSubnet(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks []string
+		ip       string
+		expected bool
+	}{
+		{"Home Wi-Fi /24", []string{"192.168.1.10/24"}, "192.168.1.20", true},
+		{"Other /24", []string{"192.168.1.10/24"}, "192.168.2.20", false},
+		{"Wide /16 covers it", []string{"10.1.2.3/16"}, "10.1.200.4", true},
+		{"Second interface matches", []string{"192.168.1.10/24", "10.0.0.5/8"}, "10.9.9.9", true},
+		{"Public address", []string{"192.168.1.10/24"}, "203.0.113.5", false},
+		{"IPv6 subnet", []string{"fd00:1::10/64"}, "fd00:1::20", true},
+		{"IPv4 peer, IPv6 interface", []string{"fd00:1::10/64"}, "192.168.1.20", false},
+		{"No interfaces", nil, "192.168.1.20", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := SameSubnet(net.ParseIP(tt.ip), mustNetworks(t, tt.networks...))
+			if result != tt.expected {
+				t.Errorf("SameSubnet(%s, %v) = %v, want %v", tt.ip, tt.networks, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSortBySubnet(t *testing.T) {
+	networks := mustNetworks(t, "10.0.0.5/8", "192.168.1.10/24")
+	addrs := []*net.UDPAddr{
+		{IP: net.ParseIP("172.16.0.9"), Port: 1},   // no shared subnet
+		{IP: net.ParseIP("10.20.30.40"), Port: 2},  // /8
+		{IP: net.ParseIP("192.168.1.77"), Port: 3}, // /24, most specific
+		{IP: net.ParseIP("192.168.5.1"), Port: 4},  // no shared subnet
+	}
+
+	sorted, matched := SortBySubnet(addrs, networks)
+
+	if matched != 2 {
+		t.Errorf("matched = %d, want 2", matched)
+	}
+	wantPorts := []int{3, 2, 1, 4}
+	for i, addr := range sorted {
+		if addr.Port != wantPorts[i] {
+			t.Errorf("sorted[%d] = %v, want port %d", i, addr, wantPorts[i])
+		}
+	}
+
+	// The input is left alone
+	if addrs[0].Port != 1 {
+		t.Error("SortBySubnet should not reorder its input")
+	}
+}
+
+func TestBestLocalCandidate(t *testing.T) {
+	networks := mustNetworks(t, "192.168.1.10/24")
+
+	best, ok := BestLocalCandidate([]*net.UDPAddr{
+		{IP: net.ParseIP("10.0.0.1"), Port: 1},
+		{IP: net.ParseIP("192.168.1.20"), Port: 2},
+	}, networks)
+	if !ok || best.Port != 2 {
+		t.Errorf("BestLocalCandidate = %v, %v; want port 2", best, ok)
+	}
+
+	if _, ok := BestLocalCandidate([]*net.UDPAddr{{IP: net.ParseIP("10.0.0.1"), Port: 1}}, networks); ok {
+		t.Error("BestLocalCandidate should fail when no address shares a subnet")
+	}
+}
+
 func TestSameNetworkEdgeCases(t *testing.T) {
 	ip := net.ParseIP("192.168.1.1")
 	mask := net.CIDRMask(24, 32)
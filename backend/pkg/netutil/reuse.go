@@ -0,0 +1,35 @@
+package netutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// ReusePortSupported reports whether CreateUDPSocketReuse sets SO_REUSEPORT
+// on this platform. Where it doesn't, only SO_REUSEADDR (or nothing) is set
+// and binding a port another socket holds may fail.
+const ReusePortSupported = reusePortSupported
+
+// CreateUDPSocketReuse creates a UDP socket bound to address and port with
+// SO_REUSEADDR and, where supported, SO_REUSEPORT set before binding. This
+// lets a listener share the port a STUN query just used, so the NAT mapping
+// it learned stays valid for the connection.
+func CreateUDPSocketReuse(address string, port int) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: setReuse}
+	pc, err := lc.ListenPacket(context.Background(), "udp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UDP socket: %w", err)
+	}
+
+	return pc.(*net.UDPConn), nil
+}
+
+// ReuseControl sets the same options as CreateUDPSocketReuse on any socket.
+// Use it as net.ListenConfig.Control or net.Dialer.Control so several
+// sockets (e.g. a TCP listener and outgoing connections) share a port.
+func ReuseControl(network, address string, c syscall.RawConn) error {
+	return setReuse(network, address, c)
+}
@@ -0,0 +1,13 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package netutil
+
+import "syscall"
+
+const reusePortSupported = false
+
+// setReuse is a no-op where the socket options aren't available; the socket
+// binds normally and sharing a port in use fails with "address in use"
+func setReuse(network, address string, c syscall.RawConn) error {
+	return nil
+}
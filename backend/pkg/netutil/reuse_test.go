@@ -0,0 +1,40 @@
+package netutil
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCreateUDPSocketReuse(t *testing.T) {
+	first, err := CreateUDPSocketReuse("127.0.0.1", 0)
+	if err != nil {
+		t.Fatalf("CreateUDPSocketReuse failed: %v", err)
+	}
+	defer first.Close()
+
+	port := first.LocalAddr().(*net.UDPAddr).Port
+	if port == 0 {
+		t.Fatal("Expected an assigned port")
+	}
+
+	if !ReusePortSupported {
+		t.Skip("SO_REUSEPORT not supported on this platform")
+	}
+
+	// A second socket can bind the same port while the first is open
+	second, err := CreateUDPSocketReuse("127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("Rebinding port %d failed: %v", port, err)
+	}
+	defer second.Close()
+
+	if got := second.LocalAddr().(*net.UDPAddr).Port; got != port {
+		t.Errorf("Second socket port = %d, want %d", got, port)
+	}
+}
+
+func TestCreateUDPSocketReuseInvalidAddress(t *testing.T) {
+	if _, err := CreateUDPSocketReuse("not-an-ip", 0); err == nil {
+		t.Error("Expected error for invalid address")
+	}
+}
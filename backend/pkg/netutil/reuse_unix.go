@@ -0,0 +1,28 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package netutil
+
+import (
+	"fmt"
+	"syscall"
+)
+
+const reusePortSupported = true
+
+// setReuse sets SO_REUSEADDR and SO_REUSEPORT on the socket before bind
+func setReuse(network, address string, c syscall.RawConn) error {
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		if opErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); opErr != nil {
+			opErr = fmt.Errorf("failed to set SO_REUSEADDR: %w", opErr)
+			return
+		}
+		if opErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1); opErr != nil {
+			opErr = fmt.Errorf("failed to set SO_REUSEPORT: %w", opErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package netutil
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Windows has no SO_REUSEPORT; SO_REUSEADDR alone allows sharing the port
+const reusePortSupported = false
+
+// setReuse sets SO_REUSEADDR on the socket before bind
+func setReuse(network, address string, c syscall.RawConn) error {
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		if opErr = syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); opErr != nil {
+			opErr = fmt.Errorf("failed to set SO_REUSEADDR: %w", opErr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
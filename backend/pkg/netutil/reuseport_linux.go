@@ -0,0 +1,7 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package netutil
+
+// soReusePort is SO_REUSEPORT, which the syscall package omits on some Linux
+// architectures (e.g. amd64). Its value is 15 everywhere but MIPS.
+const soReusePort = 0xf
@@ -0,0 +1,114 @@
+package netutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// DefaultWatchInterval is how often WatchNetworkChanges polls interface
+// addresses
+const DefaultWatchInterval = 2 * time.Second
+
+// NetworkChange describes a change in the set of local addresses, e.g. when
+// a device moves from Wi-Fi to cellular. Any NAT mapping learned before the
+// change should be considered stale.
+type NetworkChange struct {
+	Before  []net.IP // Addresses before the change
+	After   []net.IP // Addresses after the change
+	Added   []net.IP // In After but not Before
+	Removed []net.IP // In Before but not After
+	At      time.Time
+}
+
+// WatchNetworkChanges calls onChange from a background goroutine whenever
+// local addresses (as reported by GetLocalAddresses) appear or disappear,
+// until ctx is cancelled. Addresses are polled every DefaultWatchInterval.
+// It returns an error if the initial address set can't be read.
+func WatchNetworkChanges(ctx context.Context, onChange func(NetworkChange)) error {
+	return watchNetworks(ctx, DefaultWatchInterval, GetLocalAddresses, onChange)
+}
+
+// watchNetworks polls list every interval and reports differences
+func watchNetworks(ctx context.Context, interval time.Duration, list func() ([]net.IP, error), onChange func(NetworkChange)) error {
+	if onChange == nil {
+		return fmt.Errorf("onChange callback is required")
+	}
+
+	current, err := list()
+	if err != nil {
+		return err
+	}
+	current = sortedIPs(current)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// A failed read (e.g. interfaces mid-reconfiguration) is
+			// retried on the next tick rather than reported as a change
+			next, err := list()
+			if err != nil {
+				continue
+			}
+			next = sortedIPs(next)
+
+			added, removed := diffIPs(current, next)
+			if len(added) == 0 && len(removed) == 0 {
+				continue
+			}
+
+			change := NetworkChange{
+				Before:  current,
+				After:   next,
+				Added:   added,
+				Removed: removed,
+				At:      time.Now(),
+			}
+			current = next
+
+			if ctx.Err() != nil {
+				return
+			}
+			onChange(change)
+		}
+	}()
+
+	return nil
+}
+
+// sortedIPs returns a sorted, de-duplicated copy of ips in 16-byte form
+func sortedIPs(ips []net.IP) []net.IP {
+	out := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ip16 := ip.To16(); ip16 != nil {
+			out = append(out, ip16)
+		}
+	}
+	slices.SortFunc(out, func(a, b net.IP) int { return slices.Compare(a, b) })
+	return slices.CompactFunc(out, net.IP.Equal)
+}
+
+// diffIPs compares two sorted address sets
+func diffIPs(before, after []net.IP) (added, removed []net.IP) {
+	for _, ip := range after {
+		if !slices.ContainsFunc(before, ip.Equal) {
+			added = append(added, ip)
+		}
+	}
+	for _, ip := range before {
+		if !slices.ContainsFunc(after, ip.Equal) {
+			removed = append(removed, ip)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,125 @@
+package netutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAddrs is an address source tests can change between polls
+type fakeAddrs struct {
+	mu  sync.Mutex
+	ips []net.IP
+	err error
+}
+
+func (f *fakeAddrs) set(ips []net.IP, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ips, f.err = ips, err
+}
+
+func (f *fakeAddrs) list() ([]net.IP, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ips, f.err
+}
+
+func TestWatchNetworksReportsChanges(t *testing.T) {
+	wifi := net.ParseIP("192.168.1.20")
+	cellular := net.ParseIP("10.64.3.7")
+	v6 := net.ParseIP("2001:db8::5")
+
+	source := &fakeAddrs{ips: []net.IP{wifi, v6}}
+	changes := make(chan NetworkChange, 4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := watchNetworks(ctx, 10*time.Millisecond, source.list, func(c NetworkChange) {
+		changes <- c
+	})
+	if err != nil {
+		t.Fatalf("watchNetworks failed: %v", err)
+	}
+
+	// Order changes alone aren't a change
+	source.set([]net.IP{v6, wifi}, nil)
+	select {
+	case c := <-changes:
+		t.Fatalf("Unexpected change for reordered addresses: %+v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Read errors are skipped
+	source.set(nil, errors.New("interfaces unavailable"))
+	time.Sleep(30 * time.Millisecond)
+
+	// Wi-Fi to cellular
+	source.set([]net.IP{cellular, v6}, nil)
+
+	var c NetworkChange
+	select {
+	case c = <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("No change reported")
+	}
+
+	if len(c.Added) != 1 || !c.Added[0].Equal(cellular) {
+		t.Errorf("Added = %v, want [%s]", c.Added, cellular)
+	}
+	if len(c.Removed) != 1 || !c.Removed[0].Equal(wifi) {
+		t.Errorf("Removed = %v, want [%s]", c.Removed, wifi)
+	}
+	if len(c.Before) != 2 || len(c.After) != 2 {
+		t.Errorf("Before = %v, After = %v, want 2 addresses each", c.Before, c.After)
+	}
+	if c.At.IsZero() {
+		t.Error("At not set")
+	}
+
+	// Nothing further once stable
+	select {
+	case c := <-changes:
+		t.Errorf("Unexpected second change: %+v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatchNetworksStopsOnCancel(t *testing.T) {
+	source := &fakeAddrs{ips: []net.IP{net.ParseIP("192.168.1.20")}}
+	changes := make(chan NetworkChange, 4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := watchNetworks(ctx, 10*time.Millisecond, source.list, func(c NetworkChange) {
+		changes <- c
+	})
+	if err != nil {
+		t.Fatalf("watchNetworks failed: %v", err)
+	}
+
+	cancel()
+	time.Sleep(30 * time.Millisecond)
+	source.set([]net.IP{net.ParseIP("10.0.0.2")}, nil)
+
+	select {
+	case c := <-changes:
+		t.Errorf("Change reported after cancel: %+v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatchNetworksInitialError(t *testing.T) {
+	source := &fakeAddrs{err: errors.New("no interfaces")}
+	err := watchNetworks(context.Background(), time.Second, source.list, func(NetworkChange) {})
+	if err == nil {
+		t.Error("Expected error when the initial address set can't be read")
+	}
+
+	if err := WatchNetworkChanges(context.Background(), nil); err == nil {
+		t.Error("Expected error for nil callback")
+	}
+}
@@ -0,0 +1,112 @@
+package punch
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+const (
+	pingPrefix = "PING"
+	pongPrefix = "PONG"
+)
+
+// handshake builds and verifies PING/PONG packets. By default they are
+// framed (see Packet) and packets for other sessions are ignored; legacy
+// handshakes use the plain strings "PING" and "PONG". With a secret
+// configured each packet carries an HMAC-SHA256 tag over its contents and
+// the nonce, so an off-path host that learns our endpoint can't answer in
+// the peer's place.
+type handshake struct {
+	secret []byte
+	nonce  []byte
+
+	legacy  bool
+	session uint32
+}
+
+// ping returns the packet sent to probe a candidate
+func (h handshake) ping() []byte {
+	if h.legacy {
+		return h.legacyPacket(pingPrefix)
+	}
+	return h.encode(NewPing(h.session))
+}
+
+// pong returns the packet sent in reply to a valid PING
+func (h handshake) pong(ping Packet) []byte {
+	if h.legacy {
+		return h.legacyPacket(pongPrefix)
+	}
+	return h.encode(ping.Reply())
+}
+
+// nominate returns the packet the controlling peer sends to choose a pair.
+// Legacy handshakes have no nominations.
+func (h handshake) nominate() []byte {
+	packet := NewPing(h.session)
+	packet.Type = PacketNominate
+	return h.encode(packet)
+}
+
+// ack returns the packet confirming a NOMINATE
+func (h handshake) ack(nominate Packet) []byte {
+	return h.encode(nominate.Reply())
+}
+
+// parse verifies data and returns the packet it carries. Legacy packets
+// have no session or timestamp.
+func (h handshake) parse(data []byte) (Packet, bool) {
+	if h.legacy {
+		switch {
+		case h.legacyVerify(pingPrefix, data):
+			return Packet{Type: PacketPing}, true
+		case h.legacyVerify(pongPrefix, data):
+			return Packet{Type: PacketPong}, true
+		}
+		return Packet{}, false
+	}
+
+	packet, ok := ParsePacket(data)
+	if !ok || packet.SessionID != h.session {
+		return Packet{}, false
+	}
+	if len(h.secret) == 0 {
+		return packet, len(data) == PacketSize
+	}
+	return packet, hmac.Equal(data[PacketSize:], h.tag(data[:PacketSize]))
+}
+
+func (h handshake) encode(packet Packet) []byte {
+	data := packet.Encode()
+	if len(h.secret) == 0 {
+		return data
+	}
+	return append(data, h.tag(data)...)
+}
+
+func (h handshake) legacyPacket(prefix string) []byte {
+	if len(h.secret) == 0 {
+		return []byte(prefix)
+	}
+	return append([]byte(prefix), h.tag([]byte(prefix))...)
+}
+
+func (h handshake) legacyVerify(prefix string, data []byte) bool {
+	if len(h.secret) == 0 {
+		return bytes.Equal(data, []byte(prefix))
+	}
+	if len(data) < len(prefix) || !bytes.Equal(data[:len(prefix)], []byte(prefix)) {
+		return false
+	}
+	return hmac.Equal(data[len(prefix):], h.tag([]byte(prefix)))
+}
+
+// tag computes HMAC-SHA256(secret, data || nonce). Covering the packet type
+// stops a captured PING from being replayed as a PONG.
+func (h handshake) tag(data []byte) []byte {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(data)
+	mac.Write(h.nonce)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,128 @@
+package punch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// isPing reports whether data is a PING h accepts. The receive paths parse
+// once and switch on the type; these keep the tests short.
+func (h handshake) isPing(data []byte) bool {
+	packet, ok := h.parse(data)
+	return ok && packet.Type == PacketPing
+}
+
+// isPong reports whether data is a PONG h accepts
+func (h handshake) isPong(data []byte) bool {
+	packet, ok := h.parse(data)
+	return ok && packet.Type == PacketPong
+}
+
+func TestHandshakePlain(t *testing.T) {
+	h := handshake{legacy: true}
+
+	if string(h.ping()) != "PING" || string(h.pong(Packet{})) != "PONG" {
+		t.Errorf("Expected plain packets, got %q / %q", h.ping(), h.pong(Packet{}))
+	}
+	if !h.isPing([]byte("PING")) || !h.isPong([]byte("PONG")) {
+		t.Error("Plain packets should be accepted")
+	}
+	if h.isPong([]byte("PING")) {
+		t.Error("PING should not be accepted as PONG")
+	}
+	if h.isPing([]byte("PINGS are fun")) {
+		t.Error("Application data starting with PING should not be accepted")
+	}
+}
+
+func TestHandshakeTags(t *testing.T) {
+	h := handshake{secret: []byte("shared-secret"), nonce: []byte("session-1"), legacy: true}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		valid bool
+	}{
+		{"valid pong", h.pong(Packet{}), true},
+		{"plain pong", []byte("PONG"), false},
+		{"wrong secret", handshake{secret: []byte("other"), nonce: []byte("session-1"), legacy: true}.pong(Packet{}), false},
+		{"wrong nonce", handshake{secret: []byte("shared-secret"), nonce: []byte("session-2"), legacy: true}.pong(Packet{}), false},
+		{"replayed ping", append([]byte("PONG"), h.ping()[4:]...), false},
+		{"truncated tag", h.pong(Packet{})[:20], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.isPong(tt.data); got != tt.valid {
+				t.Errorf("isPong() = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+
+	if !h.isPing(h.ping()) {
+		t.Error("Valid PING should be accepted")
+	}
+	if h.isPing([]byte("PING")) {
+		t.Error("Untagged PING should be ignored")
+	}
+}
+
+func TestPunchHoleAuthenticated(t *testing.T) {
+	newPuncher := func(secret string) *Puncher {
+		p, err := NewPuncher(&PuncherConfig{
+			LocalAddr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			Timeout:      500 * time.Millisecond,
+			PingInterval: 20 * time.Millisecond,
+			MaxAttempts:  25,
+			Secret:       []byte(secret),
+			Nonce:        []byte("session-1"),
+		})
+		if err != nil {
+			t.Fatalf("NewPuncher failed: %v", err)
+		}
+		return p
+	}
+
+	t.Run("matching secrets", func(t *testing.T) {
+		a, b := newPuncher("secret"), newPuncher("secret")
+		defer a.Close()
+		defer b.Close()
+
+		// b answers a's PINGs while punching; whichever side sees a PONG
+		// first returns, so only a's result is checked
+		done := make(chan struct{})
+		go func() {
+			b.PunchHole(&PeerInfo{PublicAddr: a.LocalAddr()})
+			close(done)
+		}()
+		defer func() { <-done }()
+
+		if _, err := a.PunchHole(&PeerInfo{PublicAddr: b.LocalAddr()}); err != nil {
+			t.Errorf("PunchHole failed: %v", err)
+		}
+	})
+
+	t.Run("plain PONG is ignored", func(t *testing.T) {
+		a := newPuncher("secret")
+		defer a.Close()
+
+		// Attacker answers every PING with an untagged PONG
+		attacker := listenLoopback(t)
+		defer attacker.Close()
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				_, addr, err := attacker.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				attacker.WriteToUDP([]byte("PONG"), addr)
+			}
+		}()
+
+		if conn, err := a.PunchHole(&PeerInfo{PublicAddr: attacker.LocalAddr().(*net.UDPAddr)}); err == nil {
+			t.Errorf("Expected punching to fail, connected to %s", conn.RemoteAddr)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package punch
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/saintparish4/altair/pkg/netutil"
+)
+
+// CandidateType identifies how a candidate address was obtained (as in ICE)
+type CandidateType int
+
+const (
+	// CandidateHost is a local interface address of the peer
+	CandidateHost CandidateType = iota
+
+	// CandidateServerReflexive is the peer's public address as seen by STUN
+	// (including predicted ports)
+	CandidateServerReflexive
+
+	// CandidatePeerReflexive is an address learned from an incoming response
+	// that didn't match any signaled candidate
+	CandidatePeerReflexive
+
+	// CandidateRelay is an address allocated on a relay server
+	CandidateRelay
+)
+
+// String returns the ICE name for the candidate type
+func (t CandidateType) String() string {
+	switch t {
+	case CandidateHost:
+		return "host"
+	case CandidateServerReflexive:
+		return "srflx"
+	case CandidatePeerReflexive:
+		return "prflx"
+	case CandidateRelay:
+		return "relay"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// Candidate is a remote address that may reach the peer
+type Candidate struct {
+	Type CandidateType
+	Addr *net.UDPAddr
+}
+
+// String returns a human-readable representation of the candidate
+func (c *Candidate) String() string {
+	if c == nil {
+		return "<nil candidate>"
+	}
+	return fmt.Sprintf("%s %s", c.Type, c.Addr)
+}
+
+// gatherCandidates collects every address worth punching for a peer: host
+// candidates on a subnet we share (most specific first), then
+// server-reflexive (with predicted ports), then the peer's other host
+// candidates, then the relay address if the peer has one. With nil
+// networks (unknown), all host candidates go first as given.
+func gatherCandidates(peer *PeerInfo, predictionWidth int, networks []*net.IPNet) []Candidate {
+	var candidates []Candidate
+	seen := make(map[string]bool)
+
+	add := func(candidateType CandidateType, addr *net.UDPAddr) {
+		if addr == nil || addr.Port == 0 || seen[addr.String()] {
+			return
+		}
+		seen[addr.String()] = true
+		candidates = append(candidates, Candidate{Type: candidateType, Addr: addr})
+	}
+
+	hosts, sameSubnet := netutil.SortBySubnet(peer.LocalAddrs, networks)
+	if networks == nil {
+		sameSubnet = len(hosts)
+	}
+
+	for _, addr := range hosts[:sameSubnet] {
+		add(CandidateHost, addr)
+	}
+
+	for _, addr := range sprayTargets(peer, predictionWidth) {
+		add(CandidateServerReflexive, addr)
+	}
+
+	for _, addr := range hosts[sameSubnet:] {
+		add(CandidateHost, addr)
+	}
+
+	add(CandidateRelay, peer.RelayAddr)
+
+	return candidates
+}
+
+// matchCandidate finds the candidate an address belongs to. Responses from an
+// unknown address become a peer-reflexive candidate.
+func matchCandidate(candidates []Candidate, addr *net.UDPAddr) *Candidate {
+	for i := range candidates {
+		if candidates[i].Addr.IP.Equal(addr.IP) && candidates[i].Addr.Port == addr.Port {
+			match := candidates[i]
+			return &match
+		}
+	}
+	return &Candidate{Type: CandidatePeerReflexive, Addr: addr}
+}
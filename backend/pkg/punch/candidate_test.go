@@ -0,0 +1,245 @@
+package punch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGatherCandidates(t *testing.T) {
+	public := &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40000}
+	peer := &PeerInfo{
+		PublicAddr: public,
+		LocalAddrs: []*net.UDPAddr{
+			{IP: net.ParseIP("192.168.1.10"), Port: 5000},
+			{IP: net.ParseIP("192.168.1.11"), Port: 0},    // unusable
+			{IP: net.ParseIP("203.0.113.5"), Port: 40000}, // duplicate of public
+		},
+		RelayAddr: &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 3478},
+	}
+
+	candidates := gatherCandidates(peer, 0, nil)
+
+	want := []struct {
+		typ  CandidateType
+		addr string
+	}{
+		{CandidateHost, "192.168.1.10:5000"},
+		{CandidateHost, "203.0.113.5:40000"},
+		{CandidateRelay, "198.51.100.1:3478"},
+	}
+
+	if len(candidates) != len(want) {
+		t.Fatalf("Expected %d candidates, got %d: %v", len(want), len(candidates), candidates)
+	}
+	for i, w := range want {
+		if candidates[i].Type != w.typ || candidates[i].Addr.String() != w.addr {
+			t.Errorf("candidate %d: expected %s %s, got %s", i, w.typ, w.addr, &candidates[i])
+		}
+	}
+}
+
+func TestGatherCandidatesSubnetOrder(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	peer := &PeerInfo{
+		PublicAddr: &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40000},
+		LocalAddrs: []*net.UDPAddr{
+			{IP: net.ParseIP("10.8.0.2"), Port: 5000},     // VPN, not our subnet
+			{IP: net.ParseIP("192.168.1.20"), Port: 5000}, // same LAN
+		},
+	}
+
+	candidates := gatherCandidates(peer, 0, []*net.IPNet{lan})
+
+	want := []struct {
+		typ  CandidateType
+		addr string
+	}{
+		{CandidateHost, "192.168.1.20:5000"},
+		{CandidateServerReflexive, "203.0.113.5:40000"},
+		{CandidateHost, "10.8.0.2:5000"},
+	}
+
+	if len(candidates) != len(want) {
+		t.Fatalf("Expected %d candidates, got %d: %v", len(want), len(candidates), candidates)
+	}
+	for i, w := range want {
+		if candidates[i].Type != w.typ || candidates[i].Addr.String() != w.addr {
+			t.Errorf("candidate %d: expected %s %s, got %s", i, w.typ, w.addr, &candidates[i])
+		}
+	}
+}
+
+func TestMatchCandidate(t *testing.T) {
+	candidates := []Candidate{
+		{Type: CandidateHost, Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 5000}},
+		{Type: CandidateServerReflexive, Addr: &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40000}},
+	}
+
+	got := matchCandidate(candidates, &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40000})
+	if got.Type != CandidateServerReflexive {
+		t.Errorf("Expected srflx, got %s", got.Type)
+	}
+
+	unknown := &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40001}
+	got = matchCandidate(candidates, unknown)
+	if got.Type != CandidatePeerReflexive || got.Addr != unknown {
+		t.Errorf("Expected prflx %s, got %s", unknown, got)
+	}
+}
+
+func TestPunchHoleReflexiveWinsOverDeadHost(t *testing.T) {
+	// Host candidate that swallows PINGs
+	dead := listenLoopback(t)
+	defer dead.Close()
+
+	// Reflexive candidate that answers PINGs
+	live := listenLoopback(t)
+	defer live.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := live.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if ping, ok := ParsePacket(buf[:n]); ok && ping.Type == PacketPing {
+				live.WriteToUDP(ping.Reply().Encode(), addr)
+			}
+		}
+	}()
+
+	puncher, err := NewPuncher(&PuncherConfig{
+		LocalAddr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:      2 * time.Second,
+		PingInterval: 20 * time.Millisecond,
+		MaxAttempts:  50,
+	})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	conn, err := puncher.PunchHole(&PeerInfo{
+		PublicAddr: live.LocalAddr().(*net.UDPAddr),
+		LocalAddrs: []*net.UDPAddr{dead.LocalAddr().(*net.UDPAddr)},
+	})
+	if err != nil {
+		t.Fatalf("PunchHole failed: %v", err)
+	}
+
+	if conn.Candidate == nil || conn.Candidate.Type != CandidateServerReflexive {
+		t.Fatalf("Expected srflx candidate to win, got %s", conn.Candidate)
+	}
+	if conn.RemoteAddr.Port != live.LocalAddr().(*net.UDPAddr).Port {
+		t.Errorf("Expected remote %s, got %s", live.LocalAddr(), conn.RemoteAddr)
+	}
+
+	stats := conn.Stats
+	if stats == nil {
+		t.Fatal("Expected punch stats on the connection")
+	}
+	if stats.Attempts != 1 || stats.Candidates != 2 || stats.Rounds < 1 {
+		t.Errorf("Unexpected stats: %s", stats)
+	}
+	if stats.PingsSent < 2 || stats.PongsReceived != 1 {
+		t.Errorf("Expected a PING per candidate and one PONG, got %s", stats)
+	}
+	if stats.Candidate != conn.Candidate || stats.FirstResponseAt.IsZero() {
+		t.Errorf("Expected winner and first response time, got %s", stats)
+	}
+	if puncher.LastStats() != stats {
+		t.Error("LastStats should return the connection's stats")
+	}
+}
+
+func TestPunchHoleSkipsUnreachableCandidate(t *testing.T) {
+	live := listenLoopback(t)
+	defer live.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := live.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if ping, ok := ParsePacket(buf[:n]); ok && ping.Type == PacketPing {
+				live.WriteToUDP(ping.Reply().Encode(), addr)
+			}
+		}
+	}()
+
+	puncher, err := NewPuncher(&PuncherConfig{
+		LocalAddr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:      2 * time.Second,
+		PingInterval: 20 * time.Millisecond,
+		MaxAttempts:  50,
+	})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	// The IPv4 socket can't send to an IPv6 host candidate; every write
+	// to it fails
+	unreachable := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 9}
+
+	conn, err := puncher.PunchHole(&PeerInfo{
+		PublicAddr: live.LocalAddr().(*net.UDPAddr),
+		LocalAddrs: []*net.UDPAddr{unreachable},
+	})
+	if err != nil {
+		t.Fatalf("PunchHole failed: %v", err)
+	}
+	if conn.RemoteAddr.Port != live.LocalAddr().(*net.UDPAddr).Port {
+		t.Errorf("Expected remote %s, got %s", live.LocalAddr(), conn.RemoteAddr)
+	}
+	if conn.Stats.PingsFailed < 1 || conn.Stats.PingsSent < 1 {
+		t.Errorf("Expected failed PINGs to the unreachable candidate alongside sent ones, got %s", conn.Stats)
+	}
+
+	// With nothing reachable the round fails
+	if _, err := puncher.PunchHole(&PeerInfo{PublicAddr: unreachable}); err == nil {
+		t.Error("Expected PunchHole to fail when every PING fails")
+	}
+}
+
+func TestPunchHoleLocalOnlyPeer(t *testing.T) {
+	// A LAN peer found via mDNS has no public address, only host candidates
+	live := listenLoopback(t)
+	defer live.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := live.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if ping, ok := ParsePacket(buf[:n]); ok && ping.Type == PacketPing {
+				live.WriteToUDP(ping.Reply().Encode(), addr)
+			}
+		}
+	}()
+
+	puncher, err := NewPuncher(&PuncherConfig{
+		LocalAddr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:      2 * time.Second,
+		PingInterval: 20 * time.Millisecond,
+		MaxAttempts:  50,
+	})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	conn, err := puncher.PunchHole(&PeerInfo{
+		LocalAddrs: []*net.UDPAddr{live.LocalAddr().(*net.UDPAddr)},
+	})
+	if err != nil {
+		t.Fatalf("PunchHole failed: %v", err)
+	}
+
+	if conn.Candidate == nil || conn.Candidate.Type != CandidateHost {
+		t.Errorf("Expected host candidate, got %s", conn.Candidate)
+	}
+}
@@ -0,0 +1,124 @@
+package punch
+
+import (
+	"net"
+	"time"
+)
+
+// peerConn adapts a punched UDP socket to net.Conn for a single peer.
+// Writes go to the peer and reads drop datagrams from anyone else.
+//
+// Framing follows UDP, not TCP: one Write is one datagram and one Read
+// returns at most one datagram, truncated to len(b). Delivery is neither
+// reliable nor ordered.
+type peerConn struct {
+	conn    *net.UDPConn
+	remote  *net.UDPAddr
+	auth    handshake
+	monitor *monitor
+	idle    *idleTimer
+	owner   *Connection
+}
+
+// NetConn returns a net.Conn bound to the peer, suitable for handing to code
+// that expects a stream-like connection (TLS, multiplexers, etc.).
+// It shares the underlying socket: closing it closes the Connection too.
+func (c *Connection) NetConn() net.Conn {
+	return &peerConn{
+		conn:    c.Conn,
+		remote:  c.RemoteAddr,
+		auth:    c.auth,
+		monitor: &c.monitor,
+		idle:    &c.idle,
+		owner:   c,
+	}
+}
+
+// Read reads the next datagram from the peer. Datagrams from other senders
+// and keepalives are discarded silently; they neither end the read nor
+// surface as errors. Each call returns at most one datagram.
+//
+// The peer may still be punching after we finished, so its PINGs are
+// answered with a PONG, repeated NOMINATEs with a NOMINATE-ACK, and
+// leftover PONGs are dropped. Keepalive probes are answered too, and
+// replies to ours feed Connection.Quality.
+func (pc *peerConn) Read(b []byte) (int, error) {
+	for {
+		n, addr, err := pc.conn.ReadFromUDP(b)
+		if err != nil {
+			return n, err
+		}
+
+		if !addr.IP.Equal(pc.remote.IP) || addr.Port != pc.remote.Port {
+			continue
+		}
+		pc.monitor.seen()
+
+		if IsKeepAlive(b[:n]) {
+			pc.answerKeepAlive(b[:n])
+			continue
+		}
+
+		packet, ok := pc.auth.parse(b[:n])
+		if !ok {
+			pc.idle.touch()
+			return n, nil
+		}
+		switch packet.Type {
+		case PacketPing:
+			pc.conn.WriteToUDP(pc.auth.pong(packet), addr)
+		case PacketNominate:
+			// Our NOMINATE-ACK was lost, so the controlling peer asks again
+			pc.conn.WriteToUDP(pc.auth.ack(packet), addr)
+		}
+	}
+}
+
+// answerKeepAlive echoes a probe from the peer and records a reply to ours
+func (pc *peerConn) answerKeepAlive(data []byte) {
+	kind, seq, sentAt, ok := decodeKeepAlive(data)
+	if !ok {
+		return
+	}
+	if kind == keepAliveProbe {
+		pc.conn.WriteToUDP(encodeKeepAlive(keepAliveReply, seq, sentAt), pc.remote)
+		return
+	}
+	pc.monitor.answered(seq, sentAt)
+}
+
+// Write sends b to the peer as a single datagram
+func (pc *peerConn) Write(b []byte) (int, error) {
+	pc.idle.touch()
+	return pc.conn.WriteToUDP(b, pc.remote)
+}
+
+// Close closes the Connection and so the underlying socket
+func (pc *peerConn) Close() error {
+	return pc.owner.Close()
+}
+
+// LocalAddr returns the local address of the socket
+func (pc *peerConn) LocalAddr() net.Addr {
+	return pc.conn.LocalAddr()
+}
+
+// RemoteAddr returns the peer's address
+func (pc *peerConn) RemoteAddr() net.Addr {
+	return pc.remote
+}
+
+// SetDeadline sets the read and write deadlines on the socket
+func (pc *peerConn) SetDeadline(t time.Time) error {
+	return pc.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline on the socket
+func (pc *peerConn) SetReadDeadline(t time.Time) error {
+	return pc.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline on the socket
+func (pc *peerConn) SetWriteDeadline(t time.Time) error {
+	return pc.conn.SetWriteDeadline(t)
+}
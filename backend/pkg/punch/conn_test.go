@@ -0,0 +1,112 @@
+package punch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("Failed to create UDP connection: %v", err)
+	}
+	return conn
+}
+
+func TestConnectionNetConn(t *testing.T) {
+	local := listenLoopback(t)
+	peer := listenLoopback(t)
+	defer peer.Close()
+	stranger := listenLoopback(t)
+	defer stranger.Close()
+
+	conn := &Connection{
+		LocalAddr:  local.LocalAddr().(*net.UDPAddr),
+		RemoteAddr: peer.LocalAddr().(*net.UDPAddr),
+		Conn:       local,
+	}
+
+	netConn := conn.NetConn()
+	defer netConn.Close()
+
+	if netConn.RemoteAddr().String() != peer.LocalAddr().String() {
+		t.Errorf("RemoteAddr = %s, want %s", netConn.RemoteAddr(), peer.LocalAddr())
+	}
+
+	// Write goes to the peer
+	if _, err := netConn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	buf := make([]byte, 64)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("peer read failed: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("peer received %q, want %q", buf[:n], "hello")
+	}
+
+	// Read drops datagrams from other senders
+	stranger.WriteToUDP([]byte("spoofed"), conn.LocalAddr)
+	peer.WriteToUDP([]byte("reply"), conn.LocalAddr)
+
+	netConn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err = netConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf[:n]) != "reply" {
+		t.Errorf("Read returned %q, want %q", buf[:n], "reply")
+	}
+
+	// Deadlines are wired through
+	netConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := netConn.Read(buf); err == nil {
+		t.Error("Read should time out with nothing from the peer")
+	} else if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestNetConnAnswersLatePing(t *testing.T) {
+	local := listenLoopback(t)
+	peer := listenLoopback(t)
+	defer peer.Close()
+
+	conn := &Connection{
+		LocalAddr:  local.LocalAddr().(*net.UDPAddr),
+		RemoteAddr: peer.LocalAddr().(*net.UDPAddr),
+		Conn:       local,
+	}
+	nc := conn.NetConn()
+	defer nc.Close()
+
+	// The peer is still punching: its PING gets a PONG and a stray PONG
+	// is dropped, and neither reaches the reader
+	ping := NewPing(0)
+	peer.WriteToUDP(ping.Encode(), conn.LocalAddr)
+	peer.WriteToUDP(ping.Reply().Encode(), conn.LocalAddr)
+	peer.WriteToUDP([]byte("hello"), conn.LocalAddr)
+
+	nc.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := nc.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("Expected %q, got %q", "hello", buf[:n])
+	}
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err = peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("peer read failed: %v", err)
+	}
+	if pong, ok := ParsePacket(buf[:n]); !ok || pong.Type != PacketPong || !pong.Timestamp.Equal(ping.Timestamp) {
+		t.Errorf("Expected PONG echoing the PING, got %q", buf[:n])
+	}
+}
@@ -0,0 +1,80 @@
+package punch
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIdleTimeout is passed to Connection.OnClose when the connection closed
+// because no application data flowed within its idle timeout
+var ErrIdleTimeout = errors.New("connection idle timeout")
+
+// idleTimer closes a connection once application data stops flowing
+type idleTimer struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	timer   *time.Timer
+
+	// Unix nanoseconds of the last application read or write
+	last atomic.Int64
+}
+
+// touch records application traffic
+func (it *idleTimer) touch() {
+	it.last.Store(time.Now().UnixNano())
+}
+
+// stop disables the timer
+func (it *idleTimer) stop() {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	it.timeout = 0
+	if it.timer != nil {
+		it.timer.Stop()
+		it.timer = nil
+	}
+}
+
+// SetIdleTimeout closes the connection once no application data has been
+// read or written through NetConn for d. Keepalives, their replies and
+// leftover punching packets don't count as data, so a connection kept open
+// only by StartKeepAlive still times out. OnClose is then called with
+// ErrIdleTimeout.
+// The countdown starts now; calling it again replaces the timeout, and a
+// non-positive d disables it.
+func (c *Connection) SetIdleTimeout(d time.Duration) {
+	c.idle.stop()
+	if d <= 0 {
+		return
+	}
+
+	c.idle.mu.Lock()
+	defer c.idle.mu.Unlock()
+
+	c.idle.touch()
+	c.idle.timeout = d
+	c.idle.timer = time.AfterFunc(d, c.checkIdle)
+}
+
+// checkIdle closes the connection if it has been idle for the whole
+// timeout, and otherwise waits out the rest of it
+func (c *Connection) checkIdle() {
+	c.idle.mu.Lock()
+	if c.idle.timeout <= 0 {
+		c.idle.mu.Unlock()
+		return
+	}
+
+	idle := time.Since(time.Unix(0, c.idle.last.Load()))
+	if remaining := c.idle.timeout - idle; remaining > 0 {
+		c.idle.timer.Reset(remaining)
+		c.idle.mu.Unlock()
+		return
+	}
+	c.idle.mu.Unlock()
+
+	c.close(ErrIdleTimeout)
+}
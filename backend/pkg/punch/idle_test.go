@@ -0,0 +1,77 @@
+package punch
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetIdleTimeoutClosesIdleConnection(t *testing.T) {
+	conn, peer := connectedPair(t)
+
+	closed := make(chan error, 1)
+	conn.OnClose = func(err error) { closed <- err }
+
+	// Keepalives flow both ways but aren't application data
+	go drain(conn)
+	go drain(peer)
+	conn.StartKeepAlive(10 * time.Millisecond)
+	peer.StartKeepAlive(10 * time.Millisecond)
+
+	conn.SetIdleTimeout(100 * time.Millisecond)
+
+	select {
+	case err := <-closed:
+		if !errors.Is(err, ErrIdleTimeout) {
+			t.Errorf("OnClose error = %v, want ErrIdleTimeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+
+	if conn.Quality().ProbesAnswered == 0 {
+		t.Error("expected keepalive replies while idle")
+	}
+	if _, err := conn.NetConn().Write([]byte("late")); err == nil {
+		t.Error("Write should fail after the idle timeout closed the socket")
+	}
+}
+
+func TestSetIdleTimeoutKeepsActiveConnection(t *testing.T) {
+	conn, peer := connectedPair(t)
+
+	closed := make(chan error, 1)
+	conn.OnClose = func(err error) { closed <- err }
+
+	go drain(peer)
+	conn.SetIdleTimeout(100 * time.Millisecond)
+
+	// Data every 20ms keeps resetting the countdown
+	nc := conn.NetConn()
+	for i := 0; i < 15; i++ {
+		if _, err := nc.Write([]byte("data")); err != nil {
+			t.Fatalf("Write failed on an active connection: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case err := <-closed:
+		t.Fatalf("active connection closed: %v", err)
+	default:
+	}
+
+	// Disabling the timeout leaves it open for good
+	conn.SetIdleTimeout(0)
+	time.Sleep(150 * time.Millisecond)
+	select {
+	case err := <-closed:
+		t.Fatalf("connection closed after disabling the timeout: %v", err)
+	default:
+	}
+
+	conn.Close()
+	if err := <-closed; err != nil {
+		t.Errorf("OnClose error = %v, want nil for an explicit Close", err)
+	}
+}
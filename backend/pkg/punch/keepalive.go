@@ -0,0 +1,72 @@
+package punch
+
+import (
+	"bytes"
+	"time"
+)
+
+// KeepAlivePrefix marks keepalive datagrams so read loops can drop them
+const KeepAlivePrefix = "KALV"
+
+// DefaultKeepAliveInterval is comfortably below the ~30s idle timeout
+// common on consumer NATs
+const DefaultKeepAliveInterval = 15 * time.Second
+
+// IsKeepAlive reports whether a datagram is a keepalive sent by
+// StartKeepAlive and should be ignored by the application
+func IsKeepAlive(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(KeepAlivePrefix))
+}
+
+// StartKeepAlive sends a keepalive datagram to RemoteAddr every interval so
+// the NAT mappings along the path don't expire during quiet periods. Each
+// keepalive is also a probe the peer answers, sampling RTT and loss once
+// per interval (see Quality).
+// Calling it again restarts the loop with the new interval. A non-positive
+// interval uses DefaultKeepAliveInterval. The loop stops on StopKeepAlive,
+// Close, or when the socket is closed.
+func (c *Connection) StartKeepAlive(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultKeepAliveInterval
+	}
+
+	c.keepAliveMu.Lock()
+	defer c.keepAliveMu.Unlock()
+
+	if c.keepAliveStop != nil {
+		close(c.keepAliveStop)
+	}
+	stop := make(chan struct{})
+	c.keepAliveStop = stop
+
+	go c.keepAliveLoop(interval, stop)
+}
+
+// StopKeepAlive halts keepalive pinging. It is safe to call when
+// keepalive isn't running.
+func (c *Connection) StopKeepAlive() {
+	c.keepAliveMu.Lock()
+	defer c.keepAliveMu.Unlock()
+
+	if c.keepAliveStop != nil {
+		close(c.keepAliveStop)
+		c.keepAliveStop = nil
+	}
+}
+
+func (c *Connection) keepAliveLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := c.Conn.WriteToUDP(c.monitor.newProbe(interval), c.RemoteAddr); err != nil {
+				// Socket closed (or unusable); nothing more to keep alive
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package punch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectionKeepAlive(t *testing.T) {
+	local := listenLoopback(t)
+	peer := listenLoopback(t)
+	defer peer.Close()
+
+	conn := &Connection{
+		LocalAddr:  local.LocalAddr().(*net.UDPAddr),
+		RemoteAddr: peer.LocalAddr().(*net.UDPAddr),
+		Conn:       local,
+	}
+	defer conn.Close()
+
+	conn.StartKeepAlive(10 * time.Millisecond)
+
+	buf := make([]byte, 64)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Expected keepalive, got error: %v", err)
+	}
+	if !IsKeepAlive(buf[:n]) {
+		t.Errorf("Expected keepalive packet, got %q", buf[:n])
+	}
+
+	conn.StopKeepAlive()
+	conn.StopKeepAlive() // idempotent
+
+	// Drain anything sent before the stop took effect
+	time.Sleep(30 * time.Millisecond)
+	for {
+		peer.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		if _, _, err := peer.ReadFromUDP(buf); err != nil {
+			break
+		}
+	}
+
+	peer.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, _, err := peer.ReadFromUDP(buf); err == nil {
+		t.Error("Received keepalive after StopKeepAlive")
+	}
+}
+
+func TestNetConnDropsKeepAlive(t *testing.T) {
+	local := listenLoopback(t)
+	peer := listenLoopback(t)
+	defer peer.Close()
+
+	conn := &Connection{
+		LocalAddr:  local.LocalAddr().(*net.UDPAddr),
+		RemoteAddr: peer.LocalAddr().(*net.UDPAddr),
+		Conn:       local,
+	}
+	nc := conn.NetConn()
+	defer nc.Close()
+
+	peer.WriteToUDP([]byte(KeepAlivePrefix), conn.LocalAddr)
+	peer.WriteToUDP([]byte("hello"), conn.LocalAddr)
+
+	nc.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := nc.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("Expected %q, got %q", "hello", buf[:n])
+	}
+}
@@ -0,0 +1,70 @@
+package punch
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Role decides how the two peers settle on one candidate pair when both
+// punch at once. With several candidates each side may otherwise keep the
+// first pair that answered it, and the two firsts can differ.
+type Role int
+
+const (
+	// RoleFirstResponse keeps the first candidate to answer a PING, without
+	// coordinating with the peer. This is the default and works with any
+	// peer, including ones using legacy packets.
+	RoleFirstResponse Role = iota
+
+	// RoleAuto picks controlling or controlled by comparing our public
+	// address with the peer's: the lower one, as a string, controls. Both
+	// sides must know both addresses, e.g. as exchanged over signaling.
+	RoleAuto
+
+	// RoleControlling nominates the first pair to answer with a NOMINATE
+	// and connects once the peer acknowledges it
+	RoleControlling
+
+	// RoleControlled answers PINGs but only connects on the pair the
+	// controlling peer nominates
+	RoleControlled
+)
+
+// String returns the role's name
+func (r Role) String() string {
+	switch r {
+	case RoleFirstResponse:
+		return "FirstResponse"
+	case RoleAuto:
+		return "Auto"
+	case RoleControlling:
+		return "Controlling"
+	case RoleControlled:
+		return "Controlled"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(r))
+	}
+}
+
+// ResolveRole turns RoleAuto into RoleControlling or RoleControlled by
+// comparing our public address with the peer's. The comparison is the same
+// on both sides, so exactly one of them controls. Other roles are returned
+// unchanged.
+func ResolveRole(role Role, ours, theirs *net.UDPAddr) (Role, error) {
+	if role != RoleAuto {
+		return role, nil
+	}
+	if ours == nil || theirs == nil {
+		return role, errors.New("automatic role needs both public addresses")
+	}
+
+	switch a, b := ours.String(), theirs.String(); {
+	case a < b:
+		return RoleControlling, nil
+	case a > b:
+		return RoleControlled, nil
+	default:
+		return role, fmt.Errorf("automatic role: both peers have public address %s", a)
+	}
+}
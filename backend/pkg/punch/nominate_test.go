@@ -0,0 +1,169 @@
+package punch
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/nat"
+)
+
+func TestResolveRole(t *testing.T) {
+	low := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 5000}
+	high := &net.UDPAddr{IP: net.ParseIP("203.0.113.9"), Port: 5000}
+
+	tests := []struct {
+		name    string
+		role    Role
+		ours    *net.UDPAddr
+		theirs  *net.UDPAddr
+		want    Role
+		wantErr bool
+	}{
+		{"first response", RoleFirstResponse, nil, nil, RoleFirstResponse, false},
+		{"explicit", RoleControlled, low, high, RoleControlled, false},
+		{"auto lower", RoleAuto, low, high, RoleControlling, false},
+		{"auto higher", RoleAuto, high, low, RoleControlled, false},
+		{"auto without ours", RoleAuto, nil, high, RoleAuto, true},
+		{"auto same address", RoleAuto, low, low, RoleAuto, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveRole(tt.role, tt.ours, tt.theirs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveRole error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveRole = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if RoleAuto.String() != "Auto" || Role(9).String() != "Unknown(9)" {
+		t.Error("unexpected Role names")
+	}
+}
+
+func TestNewPuncherRoleNeedsFramedPackets(t *testing.T) {
+	_, err := NewPuncher(&PuncherConfig{Role: RoleControlling, LegacyPackets: true})
+	if err == nil {
+		t.Error("expected an error for a role with legacy packets")
+	}
+}
+
+func newNominatingPuncher(t *testing.T, role Role) *Puncher {
+	t.Helper()
+
+	p, err := NewPuncher(&PuncherConfig{
+		LocalAddr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:      2 * time.Second,
+		PingInterval: 20 * time.Millisecond,
+		MaxAttempts:  100,
+		SessionID:    9,
+		Role:         role,
+	})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	p.mapping = &nat.Mapping{PublicAddr: p.LocalAddr()}
+	return p
+}
+
+func TestPunchHoleNominatesOnePair(t *testing.T) {
+	a := newNominatingPuncher(t, RoleAuto)
+	defer a.Close()
+	b := newNominatingPuncher(t, RoleAuto)
+	defer b.Close()
+
+	// Each side also has a candidate nobody answers on
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	deadAddr := dead.LocalAddr().(*net.UDPAddr)
+	dead.Close()
+
+	peerOf := func(p *Puncher) *PeerInfo {
+		return &PeerInfo{PublicAddr: p.LocalAddr(), LocalAddrs: []*net.UDPAddr{deadAddr}}
+	}
+
+	type result struct {
+		conn *Connection
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		conn, err := b.PunchHole(peerOf(a))
+		results <- result{conn, err}
+	}()
+
+	connA, err := a.PunchHole(peerOf(b))
+	if err != nil {
+		t.Fatalf("PunchHole a: %v", err)
+	}
+	rb := <-results
+	if rb.err != nil {
+		t.Fatalf("PunchHole b: %v", rb.err)
+	}
+	connB := rb.conn
+
+	if connA.Role == connB.Role || (connA.Role != RoleControlling && connA.Role != RoleControlled) ||
+		(connB.Role != RoleControlling && connB.Role != RoleControlled) {
+		t.Errorf("roles = %s and %s, want one controlling and one controlled", connA.Role, connB.Role)
+	}
+	if connA.RemoteAddr.Port != b.LocalAddr().Port || connB.RemoteAddr.Port != a.LocalAddr().Port {
+		t.Errorf("pairs = %s and %s, want each other's local address", connA.RemoteAddr, connB.RemoteAddr)
+	}
+}
+
+func TestPunchHoleControlledWaitsForNomination(t *testing.T) {
+	p := newNominatingPuncher(t, RoleControlled)
+	defer p.Close()
+
+	// A fake peer answers on two sockets but nominates only the second
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("ListenUDP failed: %v", err)
+		}
+		return conn
+	}
+	first, second := listen(), listen()
+	defer first.Close()
+	defer second.Close()
+
+	peer := handshake{session: 9}
+	serve := func(conn *net.UDPConn, nominate bool) {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			packet, ok := peer.parse(buf[:n])
+			if !ok || packet.Type != PacketPing {
+				continue
+			}
+			conn.WriteToUDP(peer.pong(packet), addr)
+			if nominate {
+				conn.WriteToUDP(peer.nominate(), addr)
+			}
+		}
+	}
+	go serve(first, false)
+	go serve(second, true)
+
+	conn, err := p.PunchHole(&PeerInfo{
+		PublicAddr: first.LocalAddr().(*net.UDPAddr),
+		LocalAddrs: []*net.UDPAddr{second.LocalAddr().(*net.UDPAddr)},
+	})
+	if err != nil {
+		t.Fatalf("PunchHole failed: %v", err)
+	}
+	if conn.RemoteAddr.Port != second.LocalAddr().(*net.UDPAddr).Port {
+		t.Errorf("RemoteAddr = %s, want the nominated %s", conn.RemoteAddr, second.LocalAddr())
+	}
+	if conn.Role != RoleControlled {
+		t.Errorf("Role = %s, want Controlled", conn.Role)
+	}
+}
@@ -0,0 +1,124 @@
+package punch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Wire format of a punch packet: magic (1 byte) | type (1 byte) | session
+// ID (4 bytes) | timestamp (8 bytes, Unix nanoseconds). The magic byte and
+// fixed size let a receiver tell punch packets from application data, and
+// the session ID keeps concurrent punch sessions on one socket apart. With
+// a shared secret the packet is followed by an HMAC tag.
+//
+// The first byte of every datagram altair puts on a punched path names the
+// layer it belongs to, so punch, reliable and framing traffic can share one
+// socket and each layer drops what isn't its own:
+//
+//	0xA7  pkg/reliable segment
+//	0xA8  pkg/framing fragment
+//	0xA9  punch packet (PING, PONG, NOMINATE, NOMINATE-ACK)
+//
+// Keepalives start with KeepAlivePrefix, whose 'K' is outside this range.
+// A new layer on the same path takes the next free value and is listed here.
+const (
+	packetMagic byte = 0xA9
+
+	// PacketSize is the length of an unauthenticated punch packet
+	PacketSize = 14
+)
+
+// PacketType distinguishes probes from replies
+type PacketType byte
+
+const (
+	// PacketPing probes a candidate address
+	PacketPing PacketType = 1
+
+	// PacketPong answers a PING, echoing its session and timestamp
+	PacketPong PacketType = 2
+
+	// PacketNominate tells the controlled peer which pair the controlling
+	// peer chose (see Role)
+	PacketNominate PacketType = 3
+
+	// PacketNominateAck confirms a NOMINATE
+	PacketNominateAck PacketType = 4
+)
+
+// String returns the packet type's name
+func (t PacketType) String() string {
+	switch t {
+	case PacketPing:
+		return "PING"
+	case PacketPong:
+		return "PONG"
+	case PacketNominate:
+		return "NOMINATE"
+	case PacketNominateAck:
+		return "NOMINATE-ACK"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(t))
+	}
+}
+
+// Packet is a PING, PONG or nomination exchanged while hole punching
+type Packet struct {
+	Type      PacketType
+	SessionID uint32
+
+	// When the PING was sent, by the sender's clock. A PONG carries the
+	// PING's timestamp back, so the sender can measure the round trip.
+	Timestamp time.Time
+}
+
+// NewPing returns a PING for sessionID stamped with the current time
+func NewPing(sessionID uint32) Packet {
+	return Packet{Type: PacketPing, SessionID: sessionID, Timestamp: time.Now()}
+}
+
+// Reply returns the PONG answering p, or the NOMINATE-ACK if p is a
+// NOMINATE
+func (p Packet) Reply() Packet {
+	typ := PacketPong
+	if p.Type == PacketNominate {
+		typ = PacketNominateAck
+	}
+	return Packet{Type: typ, SessionID: p.SessionID, Timestamp: p.Timestamp}
+}
+
+// RTT returns the time since p's timestamp. For a PONG to one of our own
+// PINGs this is the round-trip time.
+func (p Packet) RTT() time.Duration {
+	return time.Since(p.Timestamp)
+}
+
+// Encode serializes the packet
+func (p Packet) Encode() []byte {
+	buf := make([]byte, PacketSize)
+	buf[0] = packetMagic
+	buf[1] = byte(p.Type)
+	binary.BigEndian.PutUint32(buf[2:6], p.SessionID)
+	binary.BigEndian.PutUint64(buf[6:14], uint64(p.Timestamp.UnixNano()))
+	return buf
+}
+
+// ParsePacket parses the header of a punch packet, reporting false if data
+// isn't one. Bytes after the header, such as an HMAC tag, are ignored.
+func ParsePacket(data []byte) (Packet, bool) {
+	if len(data) < PacketSize || data[0] != packetMagic {
+		return Packet{}, false
+	}
+
+	typ := PacketType(data[1])
+	if typ < PacketPing || typ > PacketNominateAck {
+		return Packet{}, false
+	}
+
+	return Packet{
+		Type:      typ,
+		SessionID: binary.BigEndian.Uint32(data[2:6]),
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(data[6:14]))),
+	}, true
+}
@@ -0,0 +1,159 @@
+package punch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketEncodeDecode(t *testing.T) {
+	original := NewPing(0xDEADBEEF)
+
+	data := original.Encode()
+	if len(data) != PacketSize {
+		t.Fatalf("encoded %d bytes, want %d", len(data), PacketSize)
+	}
+
+	decoded, ok := ParsePacket(data)
+	if !ok {
+		t.Fatal("ParsePacket failed")
+	}
+	if decoded.Type != PacketPing || decoded.SessionID != 0xDEADBEEF || !decoded.Timestamp.Equal(original.Timestamp) {
+		t.Errorf("ParsePacket = %+v, want %+v", decoded, original)
+	}
+
+	// Trailing bytes, such as an HMAC tag, are ignored
+	if _, ok := ParsePacket(append(data, 1, 2, 3)); !ok {
+		t.Error("ParsePacket should accept trailing bytes")
+	}
+
+	for _, raw := range [][]byte{
+		nil,
+		[]byte("PING"),
+		[]byte("KALV\x00\x00"),
+		data[:PacketSize-1],
+		append([]byte{0x00}, data[1:]...),
+		append([]byte{packetMagic, 9}, data[2:]...),
+	} {
+		if _, ok := ParsePacket(raw); ok {
+			t.Errorf("ParsePacket(%q) should fail", raw)
+		}
+	}
+}
+
+func TestPacketReply(t *testing.T) {
+	ping := Packet{Type: PacketPing, SessionID: 7, Timestamp: time.Now().Add(-30 * time.Millisecond)}
+	pong := ping.Reply()
+
+	if pong.Type != PacketPong || pong.SessionID != 7 || !pong.Timestamp.Equal(ping.Timestamp) {
+		t.Errorf("Reply = %+v", pong)
+	}
+	if rtt := pong.RTT(); rtt < 30*time.Millisecond {
+		t.Errorf("RTT = %v, want at least 30ms", rtt)
+	}
+	if PacketPing.String() != "PING" || PacketPong.String() != "PONG" || PacketType(9).String() != "Unknown(9)" {
+		t.Error("unexpected PacketType names")
+	}
+
+	nominate := Packet{Type: PacketNominate, SessionID: 7, Timestamp: ping.Timestamp}
+	if ack := nominate.Reply(); ack.Type != PacketNominateAck || ack.SessionID != 7 {
+		t.Errorf("Reply to NOMINATE = %+v", ack)
+	}
+	if _, ok := ParsePacket(nominate.Encode()); !ok {
+		t.Error("ParsePacket should accept a NOMINATE")
+	}
+	if PacketNominate.String() != "NOMINATE" || PacketNominateAck.String() != "NOMINATE-ACK" {
+		t.Error("unexpected nomination PacketType names")
+	}
+}
+
+func TestHandshakeFramed(t *testing.T) {
+	h := handshake{session: 1}
+
+	ping := h.ping()
+	if !h.isPing(ping) || h.isPong(ping) {
+		t.Error("framed PING not recognized")
+	}
+
+	packet, _ := ParsePacket(ping)
+	pong := h.pong(packet)
+	if !h.isPong(pong) {
+		t.Error("framed PONG not recognized")
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"other session", handshake{session: 2}.ping()},
+		{"legacy", []byte("PING")},
+		{"trailing data", append(h.ping(), 'x')},
+		{"unexpected tag", handshake{session: 1, secret: []byte("secret")}.ping()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if h.isPing(tt.data) {
+				t.Errorf("isPing(%q) = true", tt.data)
+			}
+		})
+	}
+
+	// With a secret, the tag covers the packet type
+	authed := handshake{session: 1, secret: []byte("secret"), nonce: []byte("n")}
+	if !authed.isPing(authed.ping()) {
+		t.Error("authenticated PING not recognized")
+	}
+	replayed := authed.ping()
+	replayed[1] = byte(PacketPong)
+	if authed.isPong(replayed) {
+		t.Error("PING replayed as PONG should be rejected")
+	}
+	if authed.isPing(h.ping()) {
+		t.Error("untagged PING should be rejected")
+	}
+}
+
+func TestPunchHoleSessions(t *testing.T) {
+	newPuncher := func(session uint32, legacy bool) *Puncher {
+		p, err := NewPuncher(&PuncherConfig{
+			LocalAddr:     &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			Timeout:       300 * time.Millisecond,
+			PingInterval:  20 * time.Millisecond,
+			MaxAttempts:   15,
+			SessionID:     session,
+			LegacyPackets: legacy,
+		})
+		if err != nil {
+			t.Fatalf("NewPuncher failed: %v", err)
+		}
+		return p
+	}
+
+	punch := func(a, b *Puncher) error {
+		defer a.Close()
+		defer b.Close()
+
+		done := make(chan struct{})
+		go func() {
+			b.PunchHole(&PeerInfo{PublicAddr: a.LocalAddr()})
+			close(done)
+		}()
+		defer func() { <-done }()
+
+		_, err := a.PunchHole(&PeerInfo{PublicAddr: b.LocalAddr()})
+		return err
+	}
+
+	if err := punch(newPuncher(5, false), newPuncher(5, false)); err != nil {
+		t.Errorf("same session: %v", err)
+	}
+	if err := punch(newPuncher(5, true), newPuncher(6, true)); err != nil {
+		t.Errorf("legacy packets ignore the session: %v", err)
+	}
+	if err := punch(newPuncher(5, false), newPuncher(6, false)); err == nil {
+		t.Error("expected punching to fail across sessions")
+	}
+	if err := punch(newPuncher(5, false), newPuncher(5, true)); err == nil {
+		t.Error("expected punching to fail between framed and legacy packets")
+	}
+}
@@ -0,0 +1,80 @@
+package punch
+
+import (
+	"net"
+)
+
+// PredictPorts estimates the next external ports a symmetric NAT will assign,
+// given consecutive ports observed via STUN (oldest first). It uses the most
+// common delta between observations; with a single observation it assumes
+// sequential allocation. Returns nil if no prediction can be made.
+func PredictPorts(observed []int, count int) []int {
+	if len(observed) == 0 || count <= 0 {
+		return nil
+	}
+
+	delta := 1
+	if len(observed) > 1 {
+		// Pick the most frequent step between observations
+		freq := make(map[int]int)
+		best := 0
+		for i := 1; i < len(observed); i++ {
+			d := observed[i] - observed[i-1]
+			freq[d]++
+			if freq[d] > best || (freq[d] == best && abs(d) < abs(delta)) {
+				best = freq[d]
+				delta = d
+			}
+		}
+		if delta == 0 {
+			// Port didn't change, so there is nothing to predict
+			return nil
+		}
+	}
+
+	predicted := make([]int, 0, count)
+	port := observed[len(observed)-1]
+	for i := 0; i < count; i++ {
+		port += delta
+		if port < 1 || port > 65535 {
+			break
+		}
+		predicted = append(predicted, port)
+	}
+
+	return predicted
+}
+
+// sprayTargets returns the addresses to punch for a peer: its public address
+// followed by every port within width of each predicted port
+func sprayTargets(peer *PeerInfo, width int) []*net.UDPAddr {
+	if peer.PublicAddr == nil {
+		return nil
+	}
+
+	targets := []*net.UDPAddr{peer.PublicAddr}
+	seen := map[int]bool{peer.PublicAddr.Port: true}
+
+	if width < 0 {
+		width = 0
+	}
+
+	for _, predicted := range peer.PredictedPorts {
+		for port := predicted - width; port <= predicted+width; port++ {
+			if port < 1 || port > 65535 || seen[port] {
+				continue
+			}
+			seen[port] = true
+			targets = append(targets, &net.UDPAddr{IP: peer.PublicAddr.IP, Port: port})
+		}
+	}
+
+	return targets
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
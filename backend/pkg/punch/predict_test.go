@@ -0,0 +1,67 @@
+package punch
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestPredictPorts(t *testing.T) {
+	tests := []struct {
+		name     string
+		observed []int
+		count    int
+		expected []int
+	}{
+		{"sequential allocation", []int{40000, 40001, 40002}, 3, []int{40003, 40004, 40005}},
+		{"step of two", []int{50000, 50002, 50004}, 2, []int{50006, 50008}},
+		{"noisy deltas use most common", []int{1000, 1001, 1002, 1010, 1011}, 1, []int{1012}},
+		{"single observation assumes +1", []int{6000}, 2, []int{6001, 6002}},
+		{"unchanged port", []int{7000, 7000, 7000}, 2, nil},
+		{"stops at port range", []int{65534}, 3, []int{65535}},
+		{"no observations", nil, 3, nil},
+		{"zero count", []int{1000, 1001}, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := PredictPorts(tt.observed, tt.count)
+			if len(result) == 0 && len(tt.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("PredictPorts(%v, %d) = %v, want %v", tt.observed, tt.count, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSprayTargets(t *testing.T) {
+	peer := &PeerInfo{
+		PublicAddr:     &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 40000},
+		PredictedPorts: []int{40001, 40010},
+	}
+
+	targets := sprayTargets(peer, 1)
+
+	// Public addr + 40000..40002 (40000 deduped) + 40009..40011
+	expectedPorts := []int{40000, 40001, 40002, 40009, 40010, 40011}
+	if len(targets) != len(expectedPorts) {
+		t.Fatalf("expected %d targets, got %d", len(expectedPorts), len(targets))
+	}
+
+	for i, target := range targets {
+		if target.Port != expectedPorts[i] {
+			t.Errorf("target %d port = %d, want %d", i, target.Port, expectedPorts[i])
+		}
+		if !target.IP.Equal(peer.PublicAddr.IP) {
+			t.Errorf("target %d IP = %s, want %s", i, target.IP, peer.PublicAddr.IP)
+		}
+	}
+
+	// Without predictions only the public address is punched
+	peer.PredictedPorts = nil
+	if targets := sprayTargets(peer, 5); len(targets) != 1 {
+		t.Errorf("expected 1 target without predictions, got %d", len(targets))
+	}
+}
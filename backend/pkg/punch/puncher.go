@@ -1,12 +1,15 @@
 package punch
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/saintparish4/altair/pkg/nat"
+	"github.com/saintparish4/altair/pkg/netutil"
 )
 
 // PeerInfo contains information about a peer for hole punching
@@ -19,6 +22,13 @@ type PeerInfo struct {
 
 	// NAT type of the peer
 	NATType nat.Type
+
+	// Predicted next external ports for a symmetric NAT peer (see PredictPorts).
+	// When set, PINGs are sprayed around these ports on the peer's public IP.
+	PredictedPorts []int
+
+	// Relay address allocated by the peer (optional)
+	RelayAddr *net.UDPAddr
 }
 
 // Connection represents a successfully established P2P connection
@@ -38,16 +48,140 @@ type Connection struct {
 	// Whether connection was established via relay
 	IsRelayed bool
 
+	// Candidate that completed the PING/PONG handshake first, or that the
+	// controlling peer nominated
+	Candidate *Candidate
+
+	// Role this side played while punching (see PuncherConfig.Role)
+	Role Role
+
 	// Timestamp when connection was established
 	EstablishedAt time.Time
+
+	// Diagnostics for the punch that produced this connection (aggregated
+	// across attempts by PunchWithRetry)
+	Stats *PunchStats
+
+	// Called once when the connection is closed, with ErrIdleTimeout if
+	// it closed for lack of data (see SetIdleTimeout) and nil otherwise
+	OnClose func(err error)
+
+	keepAliveMu   sync.Mutex
+	keepAliveStop chan struct{}
+
+	// Closes the connection when data stops flowing (see SetIdleTimeout)
+	idle      idleTimer
+	closeOnce sync.Once
+
+	// RTT and loss measured by keepalive probes (see Quality)
+	monitor monitor
+
+	// PING/PONG format used while punching, so NetConn can answer a peer
+	// that is still punching
+	auth handshake
+}
+
+// PunchStats counts what happened while hole punching, for diagnosing
+// peers that fail to connect
+type PunchStats struct {
+	Attempts        int           // Punch attempts made
+	Rounds          int           // PING rounds sent to every candidate
+	PingsSent       int           // PINGs sent, across all candidates
+	PingsFailed     int           // PINGs the socket refused to send
+	PingsReceived   int           // PINGs from the peer (each answered with a PONG)
+	PongsReceived   int           // PONGs from the peer
+	Candidates      int           // Candidates tried in the last attempt
+	StartedAt       time.Time     // When the first attempt began
+	FirstResponseAt time.Time     // First PING or PONG from the peer; zero if none
+	Duration        time.Duration // From StartedAt until the last attempt ended
+	Candidate       *Candidate    // Candidate that won; nil on failure
+
+	// Round trips measured by received PONGs, as for Connection.RTT
+	RTTSamples int
+	MinRTT     time.Duration
+	MaxRTT     time.Duration
+	MeanRTT    time.Duration
+}
+
+// addRTT records the round trip measured by a PONG
+func (s *PunchStats) addRTT(rtt time.Duration) {
+	if s.RTTSamples == 0 || rtt < s.MinRTT {
+		s.MinRTT = rtt
+	}
+	if rtt > s.MaxRTT {
+		s.MaxRTT = rtt
+	}
+	s.RTTSamples++
+	s.MeanRTT += (rtt - s.MeanRTT) / time.Duration(s.RTTSamples)
+}
+
+// add folds a later attempt's stats into s
+func (s *PunchStats) add(next *PunchStats) {
+	if s.StartedAt.IsZero() {
+		s.StartedAt = next.StartedAt
+	}
+	if s.FirstResponseAt.IsZero() {
+		s.FirstResponseAt = next.FirstResponseAt
+	}
+	s.Attempts += next.Attempts
+	s.Rounds += next.Rounds
+	s.PingsSent += next.PingsSent
+	s.PingsFailed += next.PingsFailed
+	s.PingsReceived += next.PingsReceived
+	s.PongsReceived += next.PongsReceived
+	s.Candidates = next.Candidates
+	s.Candidate = next.Candidate
+	s.Duration = next.StartedAt.Add(next.Duration).Sub(s.StartedAt)
+
+	if next.RTTSamples == 0 {
+		return
+	}
+	if s.RTTSamples == 0 || next.MinRTT < s.MinRTT {
+		s.MinRTT = next.MinRTT
+	}
+	if next.MaxRTT > s.MaxRTT {
+		s.MaxRTT = next.MaxRTT
+	}
+	total := s.RTTSamples + next.RTTSamples
+	s.MeanRTT = (s.MeanRTT*time.Duration(s.RTTSamples) + next.MeanRTT*time.Duration(next.RTTSamples)) / time.Duration(total)
+	s.RTTSamples = total
 }
 
-// Close closes the connection
+// String summarizes the stats on one line
+func (s *PunchStats) String() string {
+	winner := "none"
+	if s.Candidate != nil {
+		winner = s.Candidate.String()
+	}
+	rtt := "no RTT samples"
+	if s.RTTSamples > 0 {
+		rtt = fmt.Sprintf("RTT min/mean/max %v/%v/%v over %d", s.MinRTT, s.MeanRTT, s.MaxRTT, s.RTTSamples)
+	}
+	return fmt.Sprintf("%d attempts, %d rounds, %d PINGs sent (%d failed), %d PINGs / %d PONGs received, %d candidates, %s, winner %s, took %v",
+		s.Attempts, s.Rounds, s.PingsSent, s.PingsFailed, s.PingsReceived, s.PongsReceived, s.Candidates, rtt, winner, s.Duration)
+}
+
+// Close stops any keepalive and idle timeout and closes the connection
 func (c *Connection) Close() error {
+	return c.close(nil)
+}
+
+// close closes the connection, telling OnClose why the first time
+func (c *Connection) close(reason error) error {
+	c.StopKeepAlive()
+	c.idle.stop()
+
+	var err error
 	if c.Conn != nil {
-		return c.Conn.Close()
+		err = c.Conn.Close()
 	}
-	return nil
+
+	c.closeOnce.Do(func() {
+		if c.OnClose != nil {
+			c.OnClose(reason)
+		}
+	})
+	return err
 }
 
 // String returns a human-readable representation of the connection
@@ -65,11 +199,24 @@ type Puncher struct {
 	mapping   *nat.Mapping
 	conn      *net.UDPConn
 
-	timeout      time.Duration
-	pingInterval time.Duration
-	maxAttempts  int
+	timeout         time.Duration
+	pingInterval    time.Duration
+	maxAttempts     int
+	predictionWidth int
+	retryBackoff    time.Duration
+	auth            handshake
+	role            Role
+	onAttempt       func(attempt int, addr *net.UDPAddr)
+	onResponse      func(addr *net.UDPAddr, rtt time.Duration)
+
+	// Our interface subnets, used to try a same-LAN peer's host
+	// candidates first. nil if they couldn't be read.
+	localNetworks []*net.IPNet
 
 	mu sync.Mutex
+
+	statsMu   sync.Mutex
+	lastStats *PunchStats
 }
 
 // PuncherConfig holds configuration for the hole puncher
@@ -89,16 +236,64 @@ type PuncherConfig struct {
 	// Maximum number of punch attempts
 	MaxAttempts int
 
+	// First PunchWithRetry backoff, doubled per retry up to
+	// netutil.MaxBackoff and jittered (optional, see netutil.Backoff)
+	RetryBackoff time.Duration
+
+	// Number of ports on either side of each predicted port to spray
+	PortPredictionWidth int
+
 	// Existing connection to use (optional)
 	Conn *net.UDPConn
+
+	// Ports to bind from when Conn is nil and LocalAddr leaves the port 0
+	// (optional), for firewalls that only allow a range
+	PortRange *netutil.PortScanner
+
+	// Shared secret for authenticated PING/PONG (optional). When set, packets
+	// carry an HMAC-SHA256 tag and untagged or mis-tagged packets are ignored.
+	// Both peers must use the same Secret and Nonce.
+	Secret []byte
+
+	// Nonce agreed with the peer for this session, e.g. via signaling
+	Nonce []byte
+
+	// Session carried in every PING/PONG (see Packet). Packets for other
+	// sessions are ignored, so several punches can share a socket. Both
+	// peers must use the same SessionID.
+	SessionID uint32
+
+	// Send the plain "PING"/"PONG" strings instead of framed packets, for
+	// peers running older versions. SessionID is not used.
+	LegacyPackets bool
+
+	// DSCP codepoint to mark the socket's packets with, e.g.
+	// netutil.DSCPExpeditedForwarding for real-time traffic (optional). It
+	// is best effort: where the platform or privileges don't allow it,
+	// packets go out unmarked. Zero leaves the socket as it is.
+	DSCP int
+
+	// How both peers agree on one candidate pair (see Role). The default
+	// keeps whichever pair answers first on each side, which can differ
+	// when several candidates work. Both peers must use nomination, and it
+	// needs framed packets.
+	Role Role
+
+	// Optional progress hooks. OnAttempt is called before each PING with the
+	// round number (starting at 1) and the candidate address; OnResponse is
+	// called for each PONG with its source and the time since punching
+	// started. They run on the punching goroutines and must not block.
+	OnAttempt  func(attempt int, addr *net.UDPAddr)
+	OnResponse func(addr *net.UDPAddr, rtt time.Duration)
 }
 
 // DefaultPuncherConfig returns a configuration with sensible defaults
 func DefaultPuncherConfig() *PuncherConfig {
 	return &PuncherConfig{
-		Timeout:      30 * time.Second,
-		PingInterval: 200 * time.Millisecond,
-		MaxAttempts:  50,
+		Timeout:             30 * time.Second,
+		PingInterval:        200 * time.Millisecond,
+		MaxAttempts:         50,
+		PortPredictionWidth: 2,
 	}
 }
 
@@ -112,6 +307,10 @@ func NewPuncher(config *PuncherConfig) (*Puncher, error) {
 	var localAddr *net.UDPAddr
 	var err error
 
+	if config.Role != RoleFirstResponse && config.LegacyPackets {
+		return nil, fmt.Errorf("role %s needs framed packets, not LegacyPackets", config.Role)
+	}
+
 	if config.Conn != nil {
 		// Use existing connection
 		conn = config.Conn
@@ -124,201 +323,383 @@ func NewPuncher(config *PuncherConfig) (*Puncher, error) {
 			localAddr = &net.UDPAddr{IP: net.IPv4zero, Port: 0}
 		}
 
-		conn, err = net.ListenUDP("udp", localAddr)
+		if config.PortRange != nil && localAddr.Port == 0 {
+			conn, err = config.PortRange.ListenUDP("udp", localAddr.IP)
+		} else {
+			conn, err = net.ListenUDP("udp", localAddr)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to create UDP socket: %w", err)
 		}
 		localAddr = conn.LocalAddr().(*net.UDPAddr)
 	}
 
-	return &Puncher{
-		localAddr:    localAddr,
-		mapping:      config.Mapping,
-		conn:         conn,
-		timeout:      config.Timeout,
-		pingInterval: config.PingInterval,
-		maxAttempts:  config.MaxAttempts,
-	}, nil
+	if config.DSCP > 0 {
+		// Marking is only a hint to the network, so failing is fine
+		_ = netutil.SetDSCP(conn, config.DSCP)
+	}
+
+	networks, _ := netutil.GetLocalNetworks()
+
+	p := &Puncher{
+		localAddr:       localAddr,
+		mapping:         config.Mapping,
+		conn:            conn,
+		timeout:         config.Timeout,
+		pingInterval:    config.PingInterval,
+		maxAttempts:     config.MaxAttempts,
+		predictionWidth: config.PortPredictionWidth,
+		retryBackoff:    config.RetryBackoff,
+		auth: handshake{
+			secret:  config.Secret,
+			nonce:   config.Nonce,
+			legacy:  config.LegacyPackets,
+			session: config.SessionID,
+		},
+		role:          config.Role,
+		onAttempt:     config.OnAttempt,
+		onResponse:    config.OnResponse,
+		localNetworks: networks,
+	}
+
+	// Fill in defaults for a partial config; a zero ping interval would
+	// panic in time.NewTicker
+	defaults := DefaultPuncherConfig()
+	if p.timeout <= 0 {
+		p.timeout = defaults.Timeout
+	}
+	if p.pingInterval <= 0 {
+		p.pingInterval = defaults.PingInterval
+	}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = defaults.MaxAttempts
+	}
+
+	return p, nil
 }
 
 // PunchHole attempts to establish a P2P connection with a peer
 // Uses simultaneous UDP hole punching technique
 func (p *Puncher) PunchHole(peer *PeerInfo) (*Connection, error) {
+	return p.PunchHoleContext(context.Background(), peer)
+}
+
+// PunchHoleContext is like PunchHole but stops as soon as ctx is done,
+// returning ctx.Err()
+func (p *Puncher) PunchHoleContext(ctx context.Context, peer *PeerInfo) (*Connection, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	p.setLastStats(nil)
+
 	if peer == nil {
 		return nil, fmt.Errorf("peer info cannot be nil")
 	}
 
-	if peer.PublicAddr == nil {
-		return nil, fmt.Errorf("peer public address cannot be nil")
+	// A peer found on the LAN (see pkg/discovery) may only have local
+	// addresses
+	if peer.PublicAddr == nil && len(peer.LocalAddrs) == 0 {
+		return nil, fmt.Errorf("peer public address cannot be nil without local addresses")
 	}
 
 	// Check if hole punching is likely to succeed
-	if p.mapping != nil && peer.NATType != nat.TypeUnknown {
+	// (port prediction can work where CanHolePunch is pessimistic)
+	if p.mapping != nil && peer.NATType != nat.TypeUnknown && len(peer.PredictedPorts) == 0 {
 		if !nat.CanHolePunch(p.mapping.Type, peer.NATType) {
 			return nil, fmt.Errorf("hole punching unlikely to succeed: %s <-> %s",
 				p.mapping.Type, peer.NATType)
 		}
 	}
 
-	// Try local addresses first (in case on same network)
-	for _, localAddr := range peer.LocalAddrs {
-		conn, err := p.tryDirectConnection(localAddr, 2*time.Second)
-		if err == nil {
-			return conn, nil
-		}
+	// Behind the same NAT without hairpinning, the public address can't
+	// work, so every local address goes ahead of it
+	networks := p.localNetworks
+	if p.sharesNATWith(peer) && !p.mapping.Hairpinning {
+		networks = nil
 	}
 
-	// Try public address with hole punching
-	return p.simultaneousPunch(peer.PublicAddr)
-}
-
-// tryDirectConnection attempts a direct connection (for LAN peers)
-func (p *Puncher) tryDirectConnection(addr *net.UDPAddr, timeout time.Duration) (*Connection, error) {
-	start := time.Now()
-	deadline := start.Add(timeout)
-
-	// Send ping
-	ping := []byte("PING")
-	_, err := p.conn.WriteToUDP(ping, addr)
+	var ours *net.UDPAddr
+	if p.mapping != nil {
+		ours = p.mapping.PublicAddr
+	}
+	role, err := ResolveRole(p.role, ours, peer.PublicAddr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send ping: %w", err)
+		return nil, err
 	}
 
-	// Wait for pong
-	p.conn.SetReadDeadline(deadline)
-	defer p.conn.SetReadDeadline(time.Time{})
+	// Punch all candidates at once; the first to answer wins, unless the
+	// controlling peer nominates one
+	candidates := gatherCandidates(peer, p.predictionWidth, networks)
+	return p.simultaneousPunch(ctx, candidates, role)
+}
 
-	buf := make([]byte, 1500)
-	for time.Now().Before(deadline) {
-		n, remoteAddr, err := p.conn.ReadFromUDP(buf)
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue
-			}
-			return nil, err
-		}
+// sharesNATWith reports whether peer has the same public IP as us
+func (p *Puncher) sharesNATWith(peer *PeerInfo) bool {
+	return p.mapping != nil && p.mapping.PublicAddr != nil && peer.PublicAddr != nil &&
+		p.mapping.PublicAddr.IP.Equal(peer.PublicAddr.IP)
+}
 
-		if n >= 4 && string(buf[:4]) == "PONG" {
-			return &Connection{
-				LocalAddr:     p.localAddr,
-				RemoteAddr:    remoteAddr,
-				Conn:          p.conn,
-				RTT:           time.Since(start),
-				IsRelayed:     false,
-				EstablishedAt: time.Now(),
-			}, nil
-		}
+// simultaneousPunch performs simultaneous UDP hole punching,
+// sending PINGs to every candidate each round. When controlling, the first
+// candidate to answer is nominated instead, and the NOMINATE is repeated
+// each round until acknowledged; when controlled, only a NOMINATE ends
+// the punch.
+func (p *Puncher) simultaneousPunch(ctx context.Context, candidates []Candidate, role Role) (conn *Connection, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("no response from peer")
-}
-
-// simultaneousPunch performs simultaneous UDP hole punching
-func (p *Puncher) simultaneousPunch(peerAddr *net.UDPAddr) (*Connection, error) {
 	start := time.Now()
 	deadline := start.Add(p.timeout)
 
-	// Channel to receive responses
+	// The sender and receiver each update only their own fields
+	stats := &PunchStats{Attempts: 1, Candidates: len(candidates), StartedAt: start}
+
+	// Channels to receive the outcome; each goroutine sends at most once
 	responses := make(chan *Connection, 1)
-	errors := make(chan error, 1)
+	errors := make(chan error, 2)
+
+	// The pair the controlling side nominated, set by the receiver
+	var nominated atomic.Pointer[net.UDPAddr]
+
+	// done is closed on return so both goroutines stop
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		// Unblock a pending read, wait for the goroutines, and hand the
+		// socket back without a stale read deadline
+		p.conn.SetReadDeadline(time.Now())
+		wg.Wait()
+		p.conn.SetReadDeadline(time.Time{})
+
+		stats.Duration = time.Since(start)
+		if conn != nil {
+			stats.Candidate = conn.Candidate
+			conn.Stats = stats
+		}
+		p.setLastStats(stats)
+	}()
+
+	p.conn.SetReadDeadline(deadline)
+	wg.Add(2)
 
 	// Start sender goroutine
 	go func() {
-		ping := []byte("PING")
-		attempt := 0
-
-		for time.Now().Before(deadline) && attempt < p.maxAttempts {
-			// Send ping packet
-			_, err := p.conn.WriteToUDP(ping, peerAddr)
-			if err != nil {
-				errors <- fmt.Errorf("failed to send ping: %w", err)
+		defer wg.Done()
+
+		ticker := time.NewTicker(p.pingInterval)
+		defer ticker.Stop()
+
+		for attempt := 0; attempt < p.maxAttempts; attempt++ {
+			if addr := nominated.Load(); addr != nil {
+				// Repeat the NOMINATE until the peer acknowledges it
+				p.conn.WriteToUDP(p.auth.nominate(), addr)
+				stats.Rounds++
+			} else if err := p.pingAll(candidates, attempt, stats); err != nil {
+				errors <- err
 				return
 			}
 
-			attempt++
-			time.Sleep(p.pingInterval)
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
 		}
 	}()
 
-	// Start receiver goroutine
+	// Start receiver goroutine; the read deadline bounds the whole attempt
 	go func() {
-		buf := make([]byte, 1500)
-		p.conn.SetReadDeadline(deadline)
-		defer p.conn.SetReadDeadline(time.Time{})
+		defer wg.Done()
 
-		for time.Now().Before(deadline) {
+		// RTTs measured by PONGs, for a nominated pair
+		rtts := make(map[string]time.Duration)
+
+		buf := make([]byte, 1500)
+		for {
 			n, remoteAddr, err := p.conn.ReadFromUDP(buf)
 			if err != nil {
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-					errors <- fmt.Errorf("hole punching timed out")
+					errors <- fmt.Errorf("hole punching timed out after %v", p.timeout)
 					return
 				}
 				errors <- fmt.Errorf("read error: %w", err)
 				return
 			}
 
-			// Check if it's a PING (peer is trying to punch to us)
-			if n >= 4 && string(buf[:4]) == "PING" {
-				// Send PONG back
-				pong := []byte("PONG")
-				p.conn.WriteToUDP(pong, remoteAddr)
+			packet, ok := p.auth.parse(buf[:n])
+			if !ok {
 				continue
 			}
 
-			// Check if it's a PONG (our punch succeeded)
-			if n >= 4 && string(buf[:4]) == "PONG" {
-				responses <- &Connection{
-					LocalAddr:     p.localAddr,
-					RemoteAddr:    remoteAddr,
-					Conn:          p.conn,
-					RTT:           time.Since(start),
-					IsRelayed:     false,
-					EstablishedAt: time.Now(),
+			switch packet.Type {
+			case PacketPing:
+				// The peer is trying to punch to us
+				stats.PingsReceived++
+				if stats.FirstResponseAt.IsZero() {
+					stats.FirstResponseAt = time.Now()
+				}
+				p.conn.WriteToUDP(p.auth.pong(packet), remoteAddr)
+
+			case PacketPong:
+				// Our punch succeeded
+				stats.PongsReceived++
+				if stats.FirstResponseAt.IsZero() {
+					stats.FirstResponseAt = time.Now()
+				}
+				if p.onResponse != nil {
+					p.onResponse(remoteAddr, time.Since(start))
 				}
+
+				// Framed PONGs echo the PING's timestamp; legacy ones
+				// only bound the round trip by the punch duration
+				rtt := time.Since(start)
+				if !packet.Timestamp.IsZero() {
+					rtt = packet.RTT()
+				}
+				rtts[remoteAddr.String()] = rtt
+				stats.addRTT(rtt)
+
+				switch role {
+				case RoleControlling:
+					if nominated.CompareAndSwap(nil, remoteAddr) {
+						p.conn.WriteToUDP(p.auth.nominate(), remoteAddr)
+					}
+				case RoleControlled:
+					// Wait for the controlling peer's choice
+				default:
+					responses <- p.connection(candidates, remoteAddr, rtt, role)
+					return
+				}
+
+			case PacketNominate:
+				if role != RoleControlled {
+					continue
+				}
+				p.conn.WriteToUDP(p.auth.ack(packet), remoteAddr)
+
+				rtt, ok := rtts[remoteAddr.String()]
+				if !ok {
+					rtt = time.Since(start)
+				}
+				responses <- p.connection(candidates, remoteAddr, rtt, role)
+				return
+
+			case PacketNominateAck:
+				addr := nominated.Load()
+				if role != RoleControlling || addr == nil || !addr.IP.Equal(remoteAddr.IP) || addr.Port != remoteAddr.Port {
+					continue
+				}
+				responses <- p.connection(candidates, addr, rtts[addr.String()], role)
 				return
 			}
 		}
 	}()
 
-	// Wait for success or timeout
+	// Wait for success, failure, or cancellation
 	select {
 	case conn := <-responses:
 		return conn, nil
 	case err := <-errors:
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
 		return nil, err
-	case <-time.After(p.timeout):
-		return nil, fmt.Errorf("hole punching timed out after %v", p.timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
-// PunchWithRetry attempts hole punching with automatic retry
+// pingAll sends one round of PINGs, stamped once per round, to every
+// candidate. A candidate the socket can't reach (an unroutable host
+// address, say) doesn't stop the others; the round fails only if every
+// send does.
+func (p *Puncher) pingAll(candidates []Candidate, attempt int, stats *PunchStats) error {
+	ping := p.auth.ping()
+	sent := 0
+	var lastErr error
+	for _, candidate := range candidates {
+		if p.onAttempt != nil {
+			p.onAttempt(attempt+1, candidate.Addr)
+		}
+		if _, err := p.conn.WriteToUDP(ping, candidate.Addr); err != nil {
+			stats.PingsFailed++
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+	stats.PingsSent += sent
+	stats.Rounds++
+	if sent == 0 && lastErr != nil {
+		return fmt.Errorf("failed to send ping: %w", lastErr)
+	}
+	return nil
+}
+
+// connection builds the Connection for the chosen remote address
+func (p *Puncher) connection(candidates []Candidate, remoteAddr *net.UDPAddr, rtt time.Duration, role Role) *Connection {
+	candidate := matchCandidate(candidates, remoteAddr)
+	return &Connection{
+		LocalAddr:     p.localAddr,
+		RemoteAddr:    remoteAddr,
+		Conn:          p.conn,
+		RTT:           rtt,
+		IsRelayed:     candidate.Type == CandidateRelay,
+		Candidate:     candidate,
+		Role:          role,
+		EstablishedAt: time.Now(),
+		auth:          p.auth,
+	}
+}
+
+// PunchWithRetry attempts hole punching with automatic retry. The
+// connection's Stats, and LastStats, cover every attempt.
 func (p *Puncher) PunchWithRetry(peer *PeerInfo, retries int) (*Connection, error) {
 	var lastErr error
+	total := &PunchStats{}
 
 	for attempt := 0; attempt <= retries; attempt++ {
 		conn, err := p.PunchHole(peer)
+		if stats := p.LastStats(); stats != nil {
+			total.add(stats)
+			snapshot := *total
+			p.setLastStats(&snapshot)
+		}
 		if err == nil {
+			conn.Stats = total
 			return conn, nil
 		}
 
 		lastErr = err
 
-		// Wait before retry (exponential backoff)
+		// Wait before retry (exponential backoff with jitter)
 		if attempt < retries {
-			backoff := time.Duration(1<<uint(attempt)) * time.Second
-			if backoff > 10*time.Second {
-				backoff = 10 * time.Second
-			}
-			time.Sleep(backoff)
+			time.Sleep(netutil.Backoff(attempt, p.retryBackoff))
 		}
 	}
 
 	return nil, fmt.Errorf("hole punching failed after %d attempts: %w", retries, lastErr)
 }
 
+// LastStats returns diagnostics for the most recent PunchHole or
+// PunchWithRetry call, including failed ones. It is nil if the call failed
+// before punching started.
+func (p *Puncher) LastStats() *PunchStats {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	return p.lastStats
+}
+
+func (p *Puncher) setLastStats(stats *PunchStats) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	p.lastStats = stats
+}
+
 // Close closes the hole puncher and releases resources
 func (p *Puncher) Close() error {
 	p.mu.Lock()
@@ -343,10 +724,11 @@ func (p *Puncher) Mapping() *nat.Mapping {
 // QuickPunch is a convenience function for one-off hole punching
 func QuickPunch(peer *PeerInfo, mapping *nat.Mapping) (*Connection, error) {
 	config := &PuncherConfig{
-		Mapping:      mapping,
-		Timeout:      30 * time.Second,
-		PingInterval: 200 * time.Millisecond,
-		MaxAttempts:  50,
+		Mapping:             mapping,
+		Timeout:             30 * time.Second,
+		PingInterval:        200 * time.Millisecond,
+		MaxAttempts:         50,
+		PortPredictionWidth: 2,
 	}
 
 	puncher, err := NewPuncher(config)
@@ -1,11 +1,17 @@
 package punch
 
 import (
+	"context"
+	"errors"
 	"net"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/saintparish4/altair/pkg/nat"
+	"github.com/saintparish4/altair/pkg/netutil"
 )
 
 func TestConnectionString(t *testing.T) {
@@ -96,6 +102,45 @@ func TestNewPuncherNilConfig(t *testing.T) {
 	}
 }
 
+func TestNewPuncherPartialConfig(t *testing.T) {
+	silent := listenLoopback(t)
+	defer silent.Close()
+
+	// No PingInterval: must not panic in the sender's ticker
+	puncher, err := NewPuncher(&PuncherConfig{
+		LocalAddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:     300 * time.Millisecond,
+		MaxAttempts: 3,
+	})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	defaults := DefaultPuncherConfig()
+	if puncher.pingInterval != defaults.PingInterval {
+		t.Errorf("pingInterval = %v, want default %v", puncher.pingInterval, defaults.PingInterval)
+	}
+
+	peer := &PeerInfo{
+		PublicAddr: silent.LocalAddr().(*net.UDPAddr),
+		NATType:    nat.TypeFullCone,
+	}
+	if _, err := puncher.PunchHole(peer); err == nil {
+		t.Error("expected punching a silent peer to fail")
+	}
+
+	empty, err := NewPuncher(&PuncherConfig{})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer empty.Close()
+	if empty.timeout != defaults.Timeout || empty.maxAttempts != defaults.MaxAttempts {
+		t.Errorf("timeout, maxAttempts = %v, %d, want defaults %v, %d",
+			empty.timeout, empty.maxAttempts, defaults.Timeout, defaults.MaxAttempts)
+	}
+}
+
 func TestNewPuncherWithLocalAddr(t *testing.T) {
 	config := &PuncherConfig{
 		LocalAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0},
@@ -138,6 +183,37 @@ func TestNewPuncherWithExistingConn(t *testing.T) {
 	}
 }
 
+func TestNewPuncherWithDSCP(t *testing.T) {
+	// Marking is best effort, so even a value the socket refuses is fine
+	for _, dscp := range []int{netutil.DSCPExpeditedForwarding, 99} {
+		puncher, err := NewPuncher(&PuncherConfig{
+			LocalAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0},
+			Timeout:   10 * time.Second,
+			DSCP:      dscp,
+		})
+		if err != nil {
+			t.Fatalf("NewPuncher with DSCP %d failed: %v", dscp, err)
+		}
+		puncher.Close()
+	}
+}
+
+func TestNewPuncherWithPortRange(t *testing.T) {
+	puncher, err := NewPuncher(&PuncherConfig{
+		LocalAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0},
+		Timeout:   10 * time.Second,
+		PortRange: netutil.NewPortScanner(43000, 43010),
+	})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	if port := puncher.LocalAddr().Port; port < 43000 || port > 43010 {
+		t.Errorf("puncher bound port %d, want a port in [43000, 43010]", port)
+	}
+}
+
 func TestPunchHoleNilPeer(t *testing.T) {
 	puncher, err := NewPuncher(nil)
 	if err != nil {
@@ -196,6 +272,327 @@ func TestPunchHoleIncompatibleNAT(t *testing.T) {
 	}
 }
 
+func TestPunchHoleSymmetricWithPrediction(t *testing.T) {
+	config := &PuncherConfig{
+		Mapping:             &nat.Mapping{Type: nat.TypeSymmetric},
+		Timeout:             100 * time.Millisecond,
+		PingInterval:        20 * time.Millisecond,
+		MaxAttempts:         5,
+		PortPredictionWidth: 1,
+	}
+
+	puncher, err := NewPuncher(config)
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	peer := &PeerInfo{
+		PublicAddr:     &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1},
+		NATType:        nat.TypeSymmetric,
+		PredictedPorts: []int{2},
+	}
+
+	// Should attempt punching (and time out) rather than refuse up front
+	_, err = puncher.PunchHole(peer)
+	if err == nil {
+		t.Fatal("expected punching to time out")
+	}
+	if strings.Contains(err.Error(), "unlikely to succeed") {
+		t.Errorf("predicted ports should bypass the compatibility check: %v", err)
+	}
+}
+
+func TestPunchHoleContextCancel(t *testing.T) {
+	silent := listenLoopback(t)
+	defer silent.Close()
+
+	puncher, err := NewPuncher(&PuncherConfig{
+		LocalAddr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:      5 * time.Second,
+		PingInterval: 20 * time.Millisecond,
+		MaxAttempts:  1000,
+	})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = puncher.PunchHoleContext(ctx, &PeerInfo{
+		PublicAddr: silent.LocalAddr().(*net.UDPAddr),
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Cancellation took too long: %v", elapsed)
+	}
+
+	// Socket must be usable again without a stale read deadline
+	silent.WriteToUDP([]byte("data"), puncher.LocalAddr())
+	puncher.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := puncher.conn.ReadFromUDP(make([]byte, 16)); err != nil {
+		t.Errorf("Read after cancel failed: %v", err)
+	}
+}
+
+func TestPunchHoleContextAlreadyCanceled(t *testing.T) {
+	puncher, err := NewPuncher(nil)
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = puncher.PunchHoleContext(ctx, &PeerInfo{
+		PublicAddr: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestPunchHoleNoGoroutineLeak(t *testing.T) {
+	silent := listenLoopback(t)
+	defer silent.Close()
+
+	live := listenLoopback(t)
+	defer live.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := live.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if ping, ok := ParsePacket(buf[:n]); ok && ping.Type == PacketPing {
+				live.WriteToUDP(ping.Reply().Encode(), addr)
+			}
+		}
+	}()
+
+	puncher, err := NewPuncher(&PuncherConfig{
+		LocalAddr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:      50 * time.Millisecond,
+		PingInterval: 10 * time.Millisecond,
+		MaxAttempts:  100,
+	})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		target := silent
+		if i%2 == 0 {
+			target = live
+		}
+		conn, err := puncher.PunchHole(&PeerInfo{PublicAddr: target.LocalAddr().(*net.UDPAddr)})
+		if target == live && err != nil {
+			t.Fatalf("attempt %d: PunchHole failed: %v", i, err)
+		}
+		if target == silent && conn != nil {
+			t.Fatalf("attempt %d: unexpected connection to silent peer", i)
+		}
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Goroutine leak: %d before, %d after", before, after)
+	}
+
+	// Socket must not be left with a stale read deadline
+	silent.WriteToUDP([]byte("data"), puncher.LocalAddr())
+	if _, _, err := puncher.conn.ReadFromUDP(make([]byte, 16)); err != nil {
+		t.Errorf("Read after punching failed: %v", err)
+	}
+}
+
+func TestPunchWithRetryStats(t *testing.T) {
+	silent := listenLoopback(t)
+	defer silent.Close()
+
+	puncher, err := NewPuncher(&PuncherConfig{
+		LocalAddr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:      100 * time.Millisecond,
+		PingInterval: 20 * time.Millisecond,
+		MaxAttempts:  100,
+		RetryBackoff: 400 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	if _, err := puncher.PunchWithRetry(&PeerInfo{PublicAddr: silent.LocalAddr().(*net.UDPAddr)}, 1); err == nil {
+		t.Fatal("Expected punching a silent peer to fail")
+	}
+
+	// Stats survive the failure and cover both attempts
+	stats := puncher.LastStats()
+	if stats == nil {
+		t.Fatal("Expected stats after a failed punch")
+	}
+	if stats.Attempts != 2 {
+		t.Errorf("Attempts = %d, want 2", stats.Attempts)
+	}
+	if stats.Rounds < 2 || stats.PingsSent != stats.Rounds {
+		t.Errorf("Expected at least one round per attempt with one candidate, got %s", stats)
+	}
+	if stats.PongsReceived != 0 || stats.Candidate != nil || !stats.FirstResponseAt.IsZero() {
+		t.Errorf("Expected no responses, got %s", stats)
+	}
+	// Two 100ms attempts with a 200-400ms backoff between them
+	if stats.Duration < 400*time.Millisecond {
+		t.Errorf("Duration = %v, want it to span both attempts", stats.Duration)
+	}
+
+	// Failing before punching leaves no stats
+	puncher.PunchHole(nil)
+	if stats := puncher.LastStats(); stats != nil {
+		t.Errorf("Expected nil stats for invalid peer, got %s", stats)
+	}
+}
+
+func TestPunchStatsRTT(t *testing.T) {
+	p := newNominatingPuncher(t, RoleControlled)
+	defer p.Close()
+
+	peerConn := listenLoopback(t)
+	defer peerConn.Close()
+
+	// A fake peer delays every other PONG by 40ms, then nominates after
+	// four so the controlled side collects several RTT samples
+	const delay = 40 * time.Millisecond
+	go func() {
+		peer := handshake{session: 9}
+		buf := make([]byte, 1500)
+		for pongs := 0; ; {
+			n, addr, err := peerConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			packet, ok := peer.parse(buf[:n])
+			if !ok || packet.Type != PacketPing {
+				continue
+			}
+			if pongs%2 == 1 {
+				time.Sleep(delay)
+			}
+			peerConn.WriteToUDP(peer.pong(packet), addr)
+			if pongs++; pongs >= 4 {
+				peerConn.WriteToUDP(peer.nominate(), addr)
+			}
+		}
+	}()
+
+	conn, err := p.PunchHole(&PeerInfo{PublicAddr: peerConn.LocalAddr().(*net.UDPAddr)})
+	if err != nil {
+		t.Fatalf("PunchHole failed: %v", err)
+	}
+
+	stats := conn.Stats
+	if stats.RTTSamples < 4 || stats.RTTSamples != stats.PongsReceived {
+		t.Errorf("RTTSamples = %d, want one per PONG (%d, at least 4)", stats.RTTSamples, stats.PongsReceived)
+	}
+	if stats.MinRTT <= 0 || stats.MinRTT >= delay {
+		t.Errorf("MinRTT = %v, want an undelayed round trip", stats.MinRTT)
+	}
+	if stats.MaxRTT < delay {
+		t.Errorf("MaxRTT = %v, want at least %v", stats.MaxRTT, delay)
+	}
+	if stats.MeanRTT <= stats.MinRTT || stats.MeanRTT >= stats.MaxRTT {
+		t.Errorf("MeanRTT = %v, want between %v and %v", stats.MeanRTT, stats.MinRTT, stats.MaxRTT)
+	}
+
+	// Retries fold samples together, weighting the means by count
+	total := &PunchStats{RTTSamples: 1, MinRTT: 10 * time.Millisecond, MaxRTT: 10 * time.Millisecond, MeanRTT: 10 * time.Millisecond}
+	total.add(&PunchStats{RTTSamples: 3, MinRTT: 20 * time.Millisecond, MaxRTT: 40 * time.Millisecond, MeanRTT: 30 * time.Millisecond})
+	if total.RTTSamples != 4 || total.MinRTT != 10*time.Millisecond || total.MaxRTT != 40*time.Millisecond || total.MeanRTT != 25*time.Millisecond {
+		t.Errorf("merged stats = %s, want 4 samples, min 10ms, mean 25ms, max 40ms", total)
+	}
+}
+
+func TestPuncherCallbacks(t *testing.T) {
+	silent := listenLoopback(t)
+	defer silent.Close()
+	silent2 := listenLoopback(t)
+	defer silent2.Close()
+
+	var mu sync.Mutex
+	attempts := make(map[int]int) // round -> PINGs
+	var responses []*net.UDPAddr
+
+	puncher, err := NewPuncher(&PuncherConfig{
+		LocalAddr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:      200 * time.Millisecond,
+		PingInterval: 10 * time.Millisecond,
+		MaxAttempts:  3,
+		OnAttempt: func(attempt int, addr *net.UDPAddr) {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[attempt]++
+		},
+		OnResponse: func(addr *net.UDPAddr, rtt time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			responses = append(responses, addr)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPuncher failed: %v", err)
+	}
+	defer puncher.Close()
+
+	// Two candidates, three rounds, nobody answers
+	puncher.PunchHole(&PeerInfo{
+		PublicAddr: silent.LocalAddr().(*net.UDPAddr),
+		LocalAddrs: []*net.UDPAddr{silent2.LocalAddr().(*net.UDPAddr)},
+	})
+
+	mu.Lock()
+	if len(attempts) != 3 || attempts[1] != 2 || attempts[2] != 2 || attempts[3] != 2 {
+		t.Errorf("OnAttempt calls per round = %v, want 2 in each of rounds 1-3", attempts)
+	}
+	if len(responses) != 0 {
+		t.Errorf("OnResponse called %d times without PONGs", len(responses))
+	}
+	mu.Unlock()
+
+	// A peer that answers triggers OnResponse once
+	live := listenLoopback(t)
+	defer live.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := live.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if ping, ok := ParsePacket(buf[:n]); ok && ping.Type == PacketPing {
+				live.WriteToUDP(ping.Reply().Encode(), addr)
+			}
+		}
+	}()
+
+	if _, err := puncher.PunchHole(&PeerInfo{PublicAddr: live.LocalAddr().(*net.UDPAddr)}); err != nil {
+		t.Fatalf("PunchHole failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(responses) != 1 || responses[0].Port != live.LocalAddr().(*net.UDPAddr).Port {
+		t.Errorf("OnResponse calls = %v, want one from %s", responses, live.LocalAddr())
+	}
+}
+
 func TestPuncherClose(t *testing.T) {
 	puncher, err := NewPuncher(nil)
 	if err != nil {
@@ -253,6 +650,38 @@ func TestPuncherMapping(t *testing.T) {
 	}
 }
 
+func TestPuncherSharesNATWith(t *testing.T) {
+	public := &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40000}
+
+	tests := []struct {
+		name     string
+		mapping  *nat.Mapping
+		peer     *PeerInfo
+		expected bool
+	}{
+		{"same public IP", &nat.Mapping{PublicAddr: public},
+			&PeerInfo{PublicAddr: &net.UDPAddr{IP: public.IP, Port: 40123}}, true},
+		{"different public IP", &nat.Mapping{PublicAddr: public},
+			&PeerInfo{PublicAddr: &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 40000}}, false},
+		{"no mapping", nil, &PeerInfo{PublicAddr: public}, false},
+		{"peer without public address", &nat.Mapping{PublicAddr: public}, &PeerInfo{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			puncher, err := NewPuncher(&PuncherConfig{Mapping: tt.mapping})
+			if err != nil {
+				t.Fatalf("NewPuncher failed: %v", err)
+			}
+			defer puncher.Close()
+
+			if got := puncher.sharesNATWith(tt.peer); got != tt.expected {
+				t.Errorf("sharesNATWith() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestPuncherConcurrentAccess(t *testing.T) {
 	puncher, err := NewPuncher(nil)
 	if err != nil {
@@ -0,0 +1,171 @@
+package punch
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Keepalive probes extend KeepAlivePrefix with kind (1 byte) | sequence
+// number (4 bytes) | timestamp (8 bytes, Unix nanoseconds). The peer echoes
+// a probe back as a reply, which gives a round-trip sample. A bare prefix,
+// as sent by older versions, is still a valid keepalive that isn't answered.
+const (
+	keepAliveProbe byte = 1
+	keepAliveReply byte = 2
+
+	keepAliveSize = len(KeepAlivePrefix) + 13
+)
+
+// lossWindow is how many recent probes the loss estimate covers
+const lossWindow = 32
+
+// QualityStats describes a live connection as measured by keepalive probes.
+// One probe is sent per keepalive interval (see StartKeepAlive), so that
+// interval is the sampling interval. Replies are processed by NetConn's
+// Read, so RTT and loss are only measured while the application reads from
+// NetConn, and only if the peer runs a version that answers probes.
+type QualityStats struct {
+	SmoothedRTT  time.Duration // RFC 6298 smoothed RTT; zero until the first reply
+	RTTVariation time.Duration // RFC 6298 RTT variation, a jitter estimate
+	LastRTT      time.Duration // Most recent sample
+	LastSeen     time.Time     // Last datagram of any kind from the peer; zero if none
+
+	ProbesSent     int
+	ProbesAnswered int
+
+	// Fraction of the last probes (up to 32) that went unanswered. The
+	// newest probe is left out until its reply has had time to arrive.
+	Loss float64
+}
+
+// probe records one keepalive probe for the loss estimate
+type probe struct {
+	seq      uint32
+	sent     bool
+	answered bool
+}
+
+// monitor accumulates QualityStats for a connection
+type monitor struct {
+	mu       sync.Mutex
+	stats    QualityStats
+	nextSeq  uint32
+	window   [lossWindow]probe
+	interval time.Duration
+	lastSent time.Time
+}
+
+// Quality returns the connection's current RTT and loss estimates
+func (c *Connection) Quality() QualityStats {
+	return c.monitor.snapshot()
+}
+
+// newProbe returns the next keepalive probe to send
+func (m *monitor) newProbe(interval time.Duration) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seq := m.nextSeq
+	m.nextSeq++
+	m.window[seq%lossWindow] = probe{seq: seq, sent: true}
+	m.stats.ProbesSent++
+	m.interval = interval
+	m.lastSent = time.Now()
+
+	return encodeKeepAlive(keepAliveProbe, seq, m.lastSent)
+}
+
+// seen records a datagram from the peer
+func (m *monitor) seen() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.LastSeen = time.Now()
+}
+
+// answered records the reply to probe seq sent at sentAt
+func (m *monitor) answered(seq uint32, sentAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	slot := &m.window[seq%lossWindow]
+	if !slot.sent || slot.seq != seq || slot.answered {
+		// Too old, duplicated or never sent
+		return
+	}
+	slot.answered = true
+	m.stats.ProbesAnswered++
+
+	rtt := time.Since(sentAt)
+	if rtt < 0 {
+		return
+	}
+	m.stats.LastRTT = rtt
+
+	// RFC 6298 section 2
+	if m.stats.SmoothedRTT == 0 {
+		m.stats.SmoothedRTT = rtt
+		m.stats.RTTVariation = rtt / 2
+		return
+	}
+	diff := m.stats.SmoothedRTT - rtt
+	if diff < 0 {
+		diff = -diff
+	}
+	m.stats.RTTVariation = (3*m.stats.RTTVariation + diff) / 4
+	m.stats.SmoothedRTT = (7*m.stats.SmoothedRTT + rtt) / 8
+}
+
+func (m *monitor) snapshot() QualityStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.stats
+
+	// The newest probe only counts once a reply is overdue
+	newest := m.nextSeq - 1
+	pending := m.nextSeq > 0 && !m.window[newest%lossWindow].answered &&
+		time.Since(m.lastSent) < m.interval
+
+	counted, lost := 0, 0
+	for _, p := range m.window {
+		if !p.sent || (pending && p.seq == newest) {
+			continue
+		}
+		counted++
+		if !p.answered {
+			lost++
+		}
+	}
+	if counted > 0 {
+		stats.Loss = float64(lost) / float64(counted)
+	}
+	return stats
+}
+
+// encodeKeepAlive builds a probe or reply
+func encodeKeepAlive(kind byte, seq uint32, sentAt time.Time) []byte {
+	buf := make([]byte, keepAliveSize)
+	n := copy(buf, KeepAlivePrefix)
+	buf[n] = kind
+	binary.BigEndian.PutUint32(buf[n+1:n+5], seq)
+	binary.BigEndian.PutUint64(buf[n+5:n+13], uint64(sentAt.UnixNano()))
+	return buf
+}
+
+// decodeKeepAlive parses a probe or reply, reporting false for a bare
+// keepalive or anything else
+func decodeKeepAlive(data []byte) (kind byte, seq uint32, sentAt time.Time, ok bool) {
+	if len(data) != keepAliveSize || !IsKeepAlive(data) {
+		return 0, 0, time.Time{}, false
+	}
+
+	n := len(KeepAlivePrefix)
+	kind = data[n]
+	if kind != keepAliveProbe && kind != keepAliveReply {
+		return 0, 0, time.Time{}, false
+	}
+	seq = binary.BigEndian.Uint32(data[n+1 : n+5])
+	sentAt = time.Unix(0, int64(binary.BigEndian.Uint64(data[n+5:n+13])))
+	return kind, seq, sentAt, true
+}
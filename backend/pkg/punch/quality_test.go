@@ -0,0 +1,151 @@
+package punch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// connectedPair returns two connections on loopback addressed to each other
+func connectedPair(t *testing.T) (*Connection, *Connection) {
+	t.Helper()
+
+	a, b := listenLoopback(t), listenLoopback(t)
+	aAddr, bAddr := a.LocalAddr().(*net.UDPAddr), b.LocalAddr().(*net.UDPAddr)
+
+	connA := &Connection{LocalAddr: aAddr, RemoteAddr: bAddr, Conn: a}
+	connB := &Connection{LocalAddr: bAddr, RemoteAddr: aAddr, Conn: b}
+	t.Cleanup(func() {
+		connA.Close()
+		connB.Close()
+	})
+	return connA, connB
+}
+
+// drain reads from conn's NetConn until it is closed
+func drain(conn *Connection) {
+	nc := conn.NetConn()
+	buf := make([]byte, 1500)
+	for {
+		if _, err := nc.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+func TestConnectionQuality(t *testing.T) {
+	a, b := connectedPair(t)
+	go drain(a)
+	go drain(b)
+
+	a.StartKeepAlive(10 * time.Millisecond)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for a.Quality().ProbesAnswered < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("probes were not answered: %+v", a.Quality())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.StopKeepAlive()
+
+	stats := a.Quality()
+	if stats.SmoothedRTT <= 0 || stats.LastRTT <= 0 {
+		t.Errorf("expected RTT samples, got %+v", stats)
+	}
+	if stats.LastSeen.IsZero() {
+		t.Error("expected LastSeen to be set by the replies")
+	}
+	if stats.Loss != 0 {
+		t.Errorf("Loss = %v on loopback, want 0", stats.Loss)
+	}
+
+	// b answered but sent no probes of its own
+	if got := b.Quality(); got.ProbesSent != 0 || got.LastSeen.IsZero() {
+		t.Errorf("peer stats = %+v", got)
+	}
+}
+
+func TestConnectionQualityLoss(t *testing.T) {
+	// The peer reads nothing, so no probe is answered
+	a, _ := connectedPair(t)
+	go drain(a)
+
+	a.StartKeepAlive(10 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	a.StopKeepAlive()
+	time.Sleep(20 * time.Millisecond)
+
+	stats := a.Quality()
+	if stats.ProbesSent == 0 || stats.ProbesAnswered != 0 {
+		t.Fatalf("unexpected probe counts: %+v", stats)
+	}
+	if stats.Loss != 1 {
+		t.Errorf("Loss = %v, want 1", stats.Loss)
+	}
+	if stats.SmoothedRTT != 0 || !stats.LastSeen.IsZero() {
+		t.Errorf("expected no RTT or LastSeen without replies, got %+v", stats)
+	}
+}
+
+func TestMonitorSmoothing(t *testing.T) {
+	var m monitor
+
+	sample := func(rtt time.Duration) {
+		probe := m.newProbe(time.Hour)
+		_, seq, _, _ := decodeKeepAlive(probe)
+		m.answered(seq, time.Now().Add(-rtt))
+	}
+
+	sample(100 * time.Millisecond)
+	stats := m.snapshot()
+	if stats.SmoothedRTT < 100*time.Millisecond || stats.SmoothedRTT > 110*time.Millisecond {
+		t.Errorf("first SmoothedRTT = %v, want ~100ms", stats.SmoothedRTT)
+	}
+
+	// One slow sample moves the average by an eighth of the difference
+	sample(900 * time.Millisecond)
+	stats = m.snapshot()
+	if stats.SmoothedRTT < 200*time.Millisecond || stats.SmoothedRTT > 210*time.Millisecond {
+		t.Errorf("SmoothedRTT = %v, want ~200ms", stats.SmoothedRTT)
+	}
+	if stats.RTTVariation <= 50*time.Millisecond {
+		t.Errorf("RTTVariation = %v, expected it to grow", stats.RTTVariation)
+	}
+
+	// Duplicate and unknown replies are ignored
+	m.answered(0, time.Now())
+	m.answered(99, time.Now())
+	if got := m.snapshot().ProbesAnswered; got != 2 {
+		t.Errorf("ProbesAnswered = %d, want 2", got)
+	}
+
+	// A fresh probe isn't counted as lost yet
+	m.newProbe(time.Hour)
+	if loss := m.snapshot().Loss; loss != 0 {
+		t.Errorf("Loss = %v with a pending probe, want 0", loss)
+	}
+}
+
+func TestKeepAliveEncoding(t *testing.T) {
+	sentAt := time.Now()
+	data := encodeKeepAlive(keepAliveReply, 42, sentAt)
+
+	if !IsKeepAlive(data) {
+		t.Error("probe should be recognized as a keepalive")
+	}
+	kind, seq, decodedAt, ok := decodeKeepAlive(data)
+	if !ok || kind != keepAliveReply || seq != 42 || !decodedAt.Equal(sentAt) {
+		t.Errorf("decodeKeepAlive = %d, %d, %v, %v", kind, seq, decodedAt, ok)
+	}
+
+	for _, raw := range [][]byte{
+		[]byte(KeepAlivePrefix),
+		data[:len(data)-1],
+		append([]byte(KeepAlivePrefix), make([]byte, 13)...),
+	} {
+		if _, _, _, ok := decodeKeepAlive(raw); ok {
+			t.Errorf("decodeKeepAlive(%q) should fail", raw)
+		}
+	}
+}
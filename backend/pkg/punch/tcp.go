@@ -0,0 +1,230 @@
+package punch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/netutil"
+)
+
+// TCPPuncher establishes a direct TCP connection by simultaneous open: both
+// peers listen on a port and, from that same port, repeatedly dial the
+// other's public endpoint. If both SYNs cross the NATs, each side's NAT
+// sees outbound state for the other's SYN and the connection opens.
+//
+// This is a fallback for networks that block or throttle UDP (where NAT
+// detection reports TypeBlocked). It succeeds far less often than UDP
+// punching: many NATs don't preserve the source port for TCP, answer an
+// early SYN with a RST, or drop unsolicited SYNs without creating the state
+// simultaneous open needs. Try it before a relay, not instead of one.
+type TCPPuncher struct {
+	localAddr    *net.TCPAddr
+	listener     *net.TCPListener
+	timeout      time.Duration
+	dialTimeout  time.Duration
+	dialInterval time.Duration
+
+	mu sync.Mutex
+}
+
+// TCPPuncherConfig holds configuration for the TCP puncher
+type TCPPuncherConfig struct {
+	// Local address to listen on and dial from (optional, uses 0.0.0.0:0
+	// if nil). The peer needs this port as seen through our NAT.
+	LocalAddr *net.TCPAddr
+
+	// Timeout for a whole Punch call
+	Timeout time.Duration
+
+	// Timeout for each dial attempt
+	DialTimeout time.Duration
+
+	// Pause between failed dial attempts
+	DialInterval time.Duration
+}
+
+// DefaultTCPPuncherConfig returns a configuration with sensible defaults
+func DefaultTCPPuncherConfig() *TCPPuncherConfig {
+	return &TCPPuncherConfig{
+		Timeout:      30 * time.Second,
+		DialTimeout:  2 * time.Second,
+		DialInterval: 100 * time.Millisecond,
+	}
+}
+
+// NewTCPPuncher starts listening on the configured address with
+// SO_REUSEADDR/SO_REUSEPORT so outgoing dials can share the port
+func NewTCPPuncher(config *TCPPuncherConfig) (*TCPPuncher, error) {
+	if config == nil {
+		config = DefaultTCPPuncherConfig()
+	}
+	defaults := DefaultTCPPuncherConfig()
+
+	localAddr := config.LocalAddr
+	if localAddr == nil {
+		localAddr = &net.TCPAddr{IP: net.IPv4zero, Port: 0}
+	}
+
+	lc := net.ListenConfig{Control: netutil.ReuseControl}
+	ln, err := lc.Listen(context.Background(), "tcp", localAddr.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", localAddr, err)
+	}
+	listener := ln.(*net.TCPListener)
+
+	p := &TCPPuncher{
+		localAddr:    listener.Addr().(*net.TCPAddr),
+		listener:     listener,
+		timeout:      config.Timeout,
+		dialTimeout:  config.DialTimeout,
+		dialInterval: config.DialInterval,
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaults.Timeout
+	}
+	if p.dialTimeout <= 0 {
+		p.dialTimeout = defaults.DialTimeout
+	}
+	if p.dialInterval <= 0 {
+		p.dialInterval = defaults.DialInterval
+	}
+
+	return p, nil
+}
+
+// Punch connects to peer's public endpoint, returning the first connection
+// that opens, whether by our dial, by accepting the peer's dial, or by
+// simultaneous open. Incoming connections from other IPs are rejected.
+func (p *TCPPuncher) Punch(ctx context.Context, peer *net.TCPAddr) (net.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if peer == nil {
+		return nil, fmt.Errorf("peer address cannot be nil")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	// Holds the winner; later connections are closed
+	results := make(chan net.Conn, 1)
+	offer := func(conn net.Conn) {
+		select {
+		case results <- conn:
+		default:
+			conn.Close()
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		p.acceptLoop(peer, offer)
+	}()
+
+	go func() {
+		defer wg.Done()
+		p.dialLoop(ctx, peer, offer)
+	}()
+
+	var conn net.Conn
+	select {
+	case conn = <-results:
+	case <-ctx.Done():
+	}
+	cancel()
+	p.stopAccepting(&wg)
+
+	// Another connection may have opened while the loops stopped; keep
+	// only one
+	select {
+	case extra := <-results:
+		if conn == nil {
+			conn = extra
+		} else {
+			extra.Close()
+		}
+	default:
+	}
+	if conn != nil {
+		return conn, nil
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+		return nil, fmt.Errorf("TCP hole punching timed out after %v", p.timeout)
+	}
+	return nil, parent.Err()
+}
+
+// stopAccepting unblocks Accept, waits for both loops, and leaves the
+// listener usable for the next Punch
+func (p *TCPPuncher) stopAccepting(wg *sync.WaitGroup) {
+	p.listener.SetDeadline(time.Now())
+	wg.Wait()
+	p.listener.SetDeadline(time.Time{})
+}
+
+// acceptLoop offers the first connection accepted from the peer's IP. It
+// returns on any accept error, including the deadline stopAccepting sets.
+func (p *TCPPuncher) acceptLoop(peer *net.TCPAddr, offer func(net.Conn)) {
+	for {
+		conn, err := p.listener.AcceptTCP()
+		if err != nil {
+			return
+		}
+
+		if !conn.RemoteAddr().(*net.TCPAddr).IP.Equal(peer.IP) {
+			conn.Close()
+			continue
+		}
+
+		offer(conn)
+		return
+	}
+}
+
+// dialLoop dials the peer from our listening port until a dial succeeds or
+// ctx is done
+func (p *TCPPuncher) dialLoop(ctx context.Context, peer *net.TCPAddr, offer func(net.Conn)) {
+	dialer := net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: p.localAddr.IP, Port: p.localAddr.Port},
+		Timeout:   p.dialTimeout,
+		Control:   netutil.ReuseControl,
+	}
+
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", peer.String())
+		if err == nil {
+			offer(conn)
+			return
+		}
+
+		// Refused or reset dials are expected until the peer's SYN has
+		// opened its NAT; keep trying
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.dialInterval):
+		}
+	}
+}
+
+// LocalAddr returns the address the puncher listens and dials from
+func (p *TCPPuncher) LocalAddr() *net.TCPAddr {
+	return p.localAddr
+}
+
+// Close stops listening. Connections returned by Punch stay open.
+func (p *TCPPuncher) Close() error {
+	return p.listener.Close()
+}
@@ -0,0 +1,78 @@
+package punch
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newLoopbackTCPPuncher(t *testing.T, timeout time.Duration) *TCPPuncher {
+	t.Helper()
+
+	p, err := NewTCPPuncher(&TCPPuncherConfig{
+		LocalAddr:    &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		Timeout:      timeout,
+		DialTimeout:  100 * time.Millisecond,
+		DialInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewTCPPuncher failed: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestTCPPunchReachableListener(t *testing.T) {
+	// A peer that is simply listening: our dial gets through
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenTCP failed: %v", err)
+	}
+	defer ln.Close()
+
+	puncher := newLoopbackTCPPuncher(t, 2*time.Second)
+
+	conn, err := puncher.Punch(context.Background(), ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatalf("Punch failed: %v", err)
+	}
+	defer conn.Close()
+
+	if got := conn.LocalAddr().(*net.TCPAddr).Port; got != puncher.LocalAddr().Port {
+		t.Errorf("Dialed from port %d, want the listening port %d", got, puncher.LocalAddr().Port)
+	}
+}
+
+func TestTCPPunchTimeout(t *testing.T) {
+	// Nothing listens on a closed port, so every dial is refused
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenTCP failed: %v", err)
+	}
+	dead := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	puncher := newLoopbackTCPPuncher(t, 200*time.Millisecond)
+
+	start := time.Now()
+	if conn, err := puncher.Punch(context.Background(), dead); err == nil {
+		conn.Close()
+		t.Fatal("Expected punching a dead port to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Timeout took %v, want ~200ms", elapsed)
+	}
+
+	// Cancellation is reported as such
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := puncher.Punch(ctx, dead); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if _, err := puncher.Punch(context.Background(), nil); err == nil {
+		t.Error("Expected error for nil peer")
+	}
+}
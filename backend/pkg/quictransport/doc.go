@@ -0,0 +1,12 @@
+// Package quictransport runs QUIC over a hole-punched UDP socket, giving
+// peers TLS-encrypted, multiplexed, congestion-controlled streams on the
+// path punch found.
+//
+// QUIC support needs github.com/quic-go/quic-go, so the implementation is
+// only compiled with the quic build tag:
+//
+//	go build -tags quic ./...
+//
+// Without the tag the package is empty and the module has no QUIC
+// dependency in its binaries.
+package quictransport
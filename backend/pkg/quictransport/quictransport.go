@@ -0,0 +1,207 @@
+//go:build quic
+// +build quic
+
+package quictransport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/saintparish4/altair/pkg/punch"
+)
+
+// ALPN is the application protocol negotiated by both sides
+const ALPN = "altair"
+
+// closeWrongPeer is the application error code sent to connections from
+// anyone but the expected peer
+const closeWrongPeer quic.ApplicationErrorCode = 1
+
+// Config holds configuration for a QUIC session
+type Config struct {
+	// TLS configuration (optional). When nil the accepting side presents
+	// an ephemeral self-signed certificate and the dialing side doesn't
+	// verify it: traffic is encrypted, but the peer is only identified by
+	// its address. Set it to authenticate peers, e.g. with certificates or
+	// fingerprints exchanged over signaling.
+	TLSConfig *tls.Config
+
+	// QUIC configuration (optional). When nil, keepalives are sent every
+	// punch.DefaultKeepAliveInterval so the NAT mapping stays open.
+	QUICConfig *quic.Config
+}
+
+// Session is a QUIC connection to a single peer over a punched socket
+type Session struct {
+	conn      *quic.Conn
+	socket    *net.UDPConn
+	transport *quic.Transport
+	listener  *quic.Listener
+}
+
+// Dial starts a QUIC session to peer over conn, typically a punched
+// Connection's Conn and RemoteAddr. The peer must call Accept. From then
+// on the session owns conn: don't read from it, or from the Connection's
+// NetConn, directly.
+func Dial(ctx context.Context, conn *net.UDPConn, peer *net.UDPAddr, config *Config) (*Session, error) {
+	config = withDefaults(config)
+
+	tlsConfig := config.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	tlsConfig = withALPN(tlsConfig)
+
+	transport := &quic.Transport{Conn: conn}
+	qconn, err := transport.Dial(ctx, peer, tlsConfig, config.QUICConfig)
+	if err != nil {
+		transport.Close()
+		return nil, fmt.Errorf("failed to dial %s: %w", peer, err)
+	}
+
+	return &Session{conn: qconn, socket: conn, transport: transport}, nil
+}
+
+// Accept waits for the peer's Dial on conn and completes the session.
+// Connection attempts from other addresses are refused. Like Dial, the
+// session owns conn afterwards.
+func Accept(ctx context.Context, conn *net.UDPConn, peer *net.UDPAddr, config *Config) (*Session, error) {
+	config = withDefaults(config)
+
+	tlsConfig := config.TLSConfig
+	if tlsConfig == nil {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	tlsConfig = withALPN(tlsConfig)
+
+	transport := &quic.Transport{Conn: conn}
+	listener, err := transport.Listen(tlsConfig, config.QUICConfig)
+	if err != nil {
+		transport.Close()
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	for {
+		qconn, err := listener.Accept(ctx)
+		if err != nil {
+			listener.Close()
+			transport.Close()
+			return nil, fmt.Errorf("failed to accept %s: %w", peer, err)
+		}
+
+		if remote, ok := qconn.RemoteAddr().(*net.UDPAddr); !ok || !remote.IP.Equal(peer.IP) || remote.Port != peer.Port {
+			qconn.CloseWithError(closeWrongPeer, "unexpected peer")
+			continue
+		}
+
+		return &Session{conn: qconn, socket: conn, transport: transport, listener: listener}, nil
+	}
+}
+
+// OpenStream opens a new bidirectional stream, blocking until the peer's
+// stream limit allows it or ctx is done
+func (s *Session) OpenStream(ctx context.Context) (*quic.Stream, error) {
+	return s.conn.OpenStreamSync(ctx)
+}
+
+// AcceptStream returns the next stream opened by the peer. The peer's
+// stream is only announced once it has written to it.
+func (s *Session) AcceptStream(ctx context.Context) (*quic.Stream, error) {
+	return s.conn.AcceptStream(ctx)
+}
+
+// Conn returns the underlying QUIC connection, for datagrams and
+// connection state
+func (s *Session) Conn() *quic.Conn {
+	return s.conn
+}
+
+// LocalAddr returns the local address of the socket
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// RemoteAddr returns the peer's address
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Close closes the session, its streams and the socket
+func (s *Session) Close() error {
+	err := s.conn.CloseWithError(0, "")
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.transport.Close()
+	if closeErr := s.socket.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// withDefaults fills in the QUIC configuration
+func withDefaults(config *Config) *Config {
+	if config == nil {
+		config = &Config{}
+	}
+	if config.QUICConfig != nil {
+		return config
+	}
+
+	copied := *config
+	copied.QUICConfig = &quic.Config{KeepAlivePeriod: punch.DefaultKeepAliveInterval}
+	return &copied
+}
+
+// withALPN returns a copy of config offering ALPN if no protocols are set
+func withALPN(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{ALPN}
+	}
+	return config
+}
+
+// selfSignedCertificate creates a throwaway certificate for one session
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: ALPN},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
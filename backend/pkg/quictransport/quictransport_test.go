@@ -0,0 +1,118 @@
+//go:build quic
+// +build quic
+
+package quictransport
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to create UDP connection: %v", err)
+	}
+	return conn
+}
+
+// acceptAsync runs Accept in the background
+func acceptAsync(ctx context.Context, conn *net.UDPConn, peer *net.UDPAddr) <-chan *Session {
+	sessions := make(chan *Session, 1)
+	go func() {
+		session, err := Accept(ctx, conn, peer, nil)
+		if err != nil {
+			close(sessions)
+			return
+		}
+		sessions <- session
+	}()
+	return sessions
+}
+
+func TestSessionStreams(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a, b := listenLoopback(t), listenLoopback(t)
+	accepted := acceptAsync(ctx, b, a.LocalAddr().(*net.UDPAddr))
+
+	client, err := Dial(ctx, a, b.LocalAddr().(*net.UDPAddr), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("Accept failed")
+	}
+	defer server.Close()
+
+	if proto := client.Conn().ConnectionState().TLS.NegotiatedProtocol; proto != ALPN {
+		t.Errorf("negotiated protocol %q, want %q", proto, ALPN)
+	}
+
+	// Two streams are multiplexed over the one socket
+	for _, message := range []string{"control", "file data"} {
+		stream, err := client.OpenStream(ctx)
+		if err != nil {
+			t.Fatalf("OpenStream failed: %v", err)
+		}
+		if _, err := stream.Write([]byte(message)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		stream.Close()
+
+		incoming, err := server.AcceptStream(ctx)
+		if err != nil {
+			t.Fatalf("AcceptStream failed: %v", err)
+		}
+		data, err := io.ReadAll(incoming)
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		if string(data) != message {
+			t.Errorf("received %q, want %q", data, message)
+		}
+	}
+}
+
+func TestAcceptRefusesOtherPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	peer, stranger, listener := listenLoopback(t), listenLoopback(t), listenLoopback(t)
+	listenerAddr := listener.LocalAddr().(*net.UDPAddr)
+	accepted := acceptAsync(ctx, listener, peer.LocalAddr().(*net.UDPAddr))
+
+	// The stranger completes the handshake but is closed right away
+	if session, err := Dial(ctx, stranger, listenerAddr, nil); err == nil {
+		select {
+		case <-session.Conn().Context().Done():
+		case <-ctx.Done():
+			t.Error("stranger's session was not closed")
+		}
+		session.Close()
+	}
+
+	client, err := Dial(ctx, peer, listenerAddr, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("Accept failed")
+	}
+	defer server.Close()
+
+	if server.RemoteAddr().String() != peer.LocalAddr().String() {
+		t.Errorf("accepted %s, want %s", server.RemoteAddr(), peer.LocalAddr())
+	}
+}
@@ -0,0 +1,103 @@
+package relay
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// ChannelBind binds a channel number to peer so data to and from it uses
+// 4-byte ChannelData framing instead of 36-byte Send/Data indications.
+// A successful bind also installs a permission for the peer. Binding an
+// already bound peer refreshes the binding (bindings last 10 minutes).
+func (c *Client) ChannelBind(peer *net.UDPAddr) (uint16, error) {
+	allocation, err := c.liveAllocation()
+	if err != nil {
+		return 0, err
+	}
+
+	// Take a new number before the request so concurrent binds for other
+	// peers don't pick it too; a failed bind leaves it unused
+	c.mu.Lock()
+	channel, bound := c.channels[peer.String()]
+	if !bound {
+		if c.nextChannel > stun.MaxChannelNumber {
+			c.mu.Unlock()
+			return 0, fmt.Errorf("no channel numbers left")
+		}
+		channel = c.nextChannel
+		c.nextChannel++
+	}
+	c.mu.Unlock()
+
+	response, err := c.transaction(stun.TypeChannelBindRequest, func(request *stun.Message) {
+		request.AddAttribute(stun.EncodeChannelNumber(channel))
+		request.AddAttribute(stun.EncodeXORAddress(stun.AttrXORPeerAddress, peer, request.TransactionID))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("channel bind: %w", err)
+	}
+	if response.Type != stun.TypeChannelBindSuccess {
+		return 0, responseError("channel bind", response)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.allocation != allocation {
+		return 0, fmt.Errorf("allocation changed during channel bind")
+	}
+	if !bound {
+		c.channels[peer.String()] = channel
+		c.channelPeers[channel] = peer
+	}
+	c.addPermissions(peer)
+
+	return channel, nil
+}
+
+// SendChannel sends data to a peer as ChannelData. The peer must have been
+// bound with ChannelBind; Send uses the channel automatically when one exists.
+func (c *Client) SendChannel(data []byte, peer *net.UDPAddr) error {
+	c.limiter.Wait(len(data))
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return errClientClosed
+	}
+
+	channel, bound := c.channels[peer.String()]
+	if !bound {
+		return fmt.Errorf("no channel bound for %s - call ChannelBind() first", peer)
+	}
+
+	return c.sendChannelData(channel, data)
+}
+
+// sendChannelData writes a ChannelData message to the server
+func (c *Client) sendChannelData(channel uint16, data []byte) error {
+	_, err := c.conn.WriteToUDP(stun.EncodeChannelData(channel, data), c.serverAddr)
+	if err != nil {
+		return fmt.Errorf("failed to send data: %w", err)
+	}
+	return nil
+}
+
+// resetChannels forgets all bindings, e.g. after a new allocation
+func (c *Client) resetChannels() {
+	c.channels = make(map[string]uint16)
+	c.channelPeers = make(map[uint16]*net.UDPAddr)
+	c.nextChannel = stun.MinChannelNumber
+}
+
+// channelPeer returns the peer bound to a channel number
+func (c *Client) channelPeer(channel uint16) (*net.UDPAddr, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	peer, found := c.channelPeers[channel]
+	return peer, found
+}
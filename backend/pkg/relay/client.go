@@ -1,13 +1,18 @@
 package relay
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"sync"
 	"time"
+
+	"github.com/saintparish4/altair/internal/ratelimit"
+	"github.com/saintparish4/altair/pkg/netutil"
+	"github.com/saintparish4/altair/pkg/stun"
 )
 
-// Allocation represents a relay allocation (similar to TURN)
+// Allocation represents a TURN relay allocation
 type Allocation struct {
 	// Relay address (the address others should send to)
 	RelayAddr *net.UDPAddr
@@ -15,9 +20,12 @@ type Allocation struct {
 	// Client's reflexive address (as seen by relay server)
 	ReflexiveAddr *net.UDPAddr
 
-	// Lifetime of the allocation
+	// Lifetime the server granted, which is often shorter than requested
 	Lifetime time.Duration
 
+	// Lifetime the client asked for
+	RequestedLifetime time.Duration
+
 	// When the allocation expires
 	ExpiresAt time.Time
 
@@ -58,14 +66,49 @@ type Client struct {
 
 	timeout time.Duration
 
-	// Receive buffer and handlers
-	recvBuf      []byte
-	recvHandlers map[string]func([]byte, *net.UDPAddr)
-	recvMu       sync.RWMutex
-
-	// State
-	closed bool
-	mu     sync.RWMutex
+	// Long-term credentials; realm, nonce and key are learned from the
+	// server's 401 challenge and guarded by authMu, not mu, since they
+	// change mid-transaction
+	username string
+	password string
+	realm    string
+	nonce    string
+	key      []byte
+	authMu   sync.Mutex
+
+	// Channel bindings (see ChannelBind)
+	channels     map[string]uint16
+	channelPeers map[uint16]*net.UDPAddr
+	nextChannel  uint16
+
+	// Permissions by peer IP (see CreatePermission)
+	permissions         map[string]Permission
+	onMissingPermission func(*net.UDPAddr)
+
+	// Auto refresh
+	refreshStop        chan struct{}
+	onRefreshError     func(error)
+	refreshPermissions bool
+
+	// Paces outgoing payload bytes; nil means unlimited
+	limiter *ratelimit.Limiter
+
+	// Socket reader (see readLoop): relayed data queued for Receive and
+	// transactions waiting for their response
+	recvBufSize int
+	recvQueue   chan datagram
+	readDone    chan struct{}
+	readErr     error
+	pending     map[[stun.TransactionIDSize]byte]chan []byte
+	pendingMu   sync.Mutex
+
+	// State; closing is closed by Close so blocked reads return at once.
+	// mu guards state, not transactions: requests read what they need,
+	// run the transaction unlocked and lock again to apply the result, so
+	// a slow or lost response never holds up Send and Receive.
+	closed  bool
+	closing chan struct{}
+	mu      sync.RWMutex
 }
 
 // ClientConfig holds configuration for the relay client
@@ -81,6 +124,33 @@ type ClientConfig struct {
 
 	// Optional existing connection
 	Conn *net.UDPConn
+
+	// Long-term credentials for servers that require authentication
+	// (optional)
+	Username string
+	Password string
+
+	// Called when an automatic refresh fails (optional, see StartAutoRefresh)
+	OnRefreshError func(error)
+
+	// Also refresh permissions from StartAutoRefresh, for sessions that
+	// outlast the 5-minute permission lifetime
+	AutoRefreshPermissions bool
+
+	// Called when Send is given a peer without a live permission
+	// (optional); the server drops such data
+	OnMissingPermission func(peer *net.UDPAddr)
+
+	// Maximum payload bytes per second sent to peers; 0 means unlimited
+	RateLimit int
+
+	// Size of the buffer the client reads datagrams into; larger ones are
+	// truncated and dropped. 0 means DefaultReceiveBufferSize.
+	ReceiveBufferSize int
+
+	// Ports to bind the client's socket from when Conn is nil (optional),
+	// for firewalls that only allow a range
+	PortRange *netutil.PortScanner
 }
 
 // DefaultClientConfig returns a configuration with sensible defaults
@@ -109,84 +179,173 @@ func NewClient(config *ClientConfig) (*Client, error) {
 	if config.Conn != nil {
 		conn = config.Conn
 	} else {
-		conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+		if config.PortRange != nil {
+			conn, err = config.PortRange.ListenUDP("udp", net.IPv4zero)
+		} else {
+			conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to create UDP connection: %w", err)
 		}
 	}
 
+	recvBufSize := config.ReceiveBufferSize
+	if recvBufSize <= 0 {
+		recvBufSize = DefaultReceiveBufferSize
+	}
+
 	client := &Client{
-		serverAddr:   serverAddr,
-		conn:         conn,
-		timeout:      config.Timeout,
-		recvBuf:      make([]byte, 65536),
-		recvHandlers: make(map[string]func([]byte, *net.UDPAddr)),
+		serverAddr:          serverAddr,
+		conn:                conn,
+		timeout:             config.Timeout,
+		username:            config.Username,
+		password:            config.Password,
+		onRefreshError:      config.OnRefreshError,
+		refreshPermissions:  config.AutoRefreshPermissions,
+		onMissingPermission: config.OnMissingPermission,
+		limiter:             ratelimit.New(config.RateLimit),
+		recvBufSize:         recvBufSize,
+		recvQueue:           make(chan datagram, recvQueueSize),
+		readDone:            make(chan struct{}),
+		closing:             make(chan struct{}),
+		pending:             make(map[[stun.TransactionIDSize]byte]chan []byte),
 	}
 
+	client.resetChannels()
+	client.permissions = make(map[string]Permission)
+
+	go client.readLoop()
+
 	return client, nil
 }
 
-// Allocate requests a relay allocation from the server
+// Allocate requests a relay allocation from the server using a TURN
+// Allocate request. The server may grant a different lifetime than requested.
 func (c *Client) Allocate(lifetime time.Duration) (*Allocation, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.closed {
-		return nil, fmt.Errorf("client is closed")
+	if c.isClosed() {
+		return nil, errClientClosed
 	}
 
-	// In a real implementation, this would send a TURN Allocate request
-	// For this simplified version, we simulate the allocation
+	response, err := c.transaction(stun.TypeAllocateRequest, func(request *stun.Message) {
+		request.AddAttribute(stun.EncodeRequestedTransport(stun.ProtocolUDP))
+		request.AddAttribute(stun.EncodeLifetime(lifetime))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("allocate: %w", err)
+	}
+	if response.Type != stun.TypeAllocateSuccess {
+		return nil, responseError("allocate", response)
+	}
 
-	// Generate allocation ID
-	allocID := fmt.Sprintf("alloc-%d", time.Now().UnixNano())
+	attr, found := response.GetAttribute(stun.AttrXORRelayedAddress)
+	if !found {
+		return nil, fmt.Errorf("allocate response missing XOR-RELAYED-ADDRESS")
+	}
+	relayAddr, err := stun.DecodeXORAddress(attr, response.TransactionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode XOR-RELAYED-ADDRESS: %w", err)
+	}
 
-	// In real TURN, the server would assign us a relay address
-	// For simulation, we use a derived address
-	relayAddr := &net.UDPAddr{
-		IP:   c.serverAddr.IP,
-		Port: c.serverAddr.Port + 1, // Simulated relay port
+	reflexiveAddr := c.conn.LocalAddr().(*net.UDPAddr)
+	if attr, found := response.GetAttribute(stun.AttrXORMappedAddress); found {
+		if addr, err := stun.DecodeXORMappedAddress(attr, response.TransactionID); err == nil {
+			reflexiveAddr = addr
+		}
 	}
 
+	granted := grantedLifetime(response, lifetime)
 	allocation := &Allocation{
-		RelayAddr:     relayAddr,
-		ReflexiveAddr: c.conn.LocalAddr().(*net.UDPAddr),
-		Lifetime:      lifetime,
-		ExpiresAt:     time.Now().Add(lifetime),
-		ID:            allocID,
+		RelayAddr:         relayAddr,
+		ReflexiveAddr:     reflexiveAddr,
+		Lifetime:          granted,
+		RequestedLifetime: lifetime,
+		ExpiresAt:         time.Now().Add(granted),
+		ID:                fmt.Sprintf("%x", response.TransactionID),
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, errClientClosed
+	}
 	c.allocation = allocation
+	c.resetChannels()
+	c.permissions = make(map[string]Permission)
 	return allocation, nil
 }
 
-// Refresh extends the lifetime of an existing allocation
+// Refresh extends the lifetime of an existing allocation with a TURN
+// Refresh request. As with Allocate, the allocation takes the lifetime the
+// server grants.
 func (c *Client) Refresh(duration time.Duration) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.mu.RLock()
+	allocation := c.allocation
+	c.mu.RUnlock()
 
-	if c.allocation == nil {
+	if allocation == nil {
 		return fmt.Errorf("no allocation to refresh")
 	}
 
-	if !c.allocation.IsValid() {
+	if !allocation.IsValid() {
 		return fmt.Errorf("allocation has expired")
 	}
 
-	// Extend expiration time
-	c.allocation.ExpiresAt = time.Now().Add(duration)
-	c.allocation.Lifetime = duration
+	response, err := c.transaction(stun.TypeRefreshRequest, func(request *stun.Message) {
+		request.AddAttribute(stun.EncodeLifetime(duration))
+	})
+	if err != nil {
+		return fmt.Errorf("refresh: %w", err)
+	}
+	if response.Type != stun.TypeRefreshSuccess {
+		return responseError("refresh", response)
+	}
+
+	// Extend expiration time by what the server granted, unless the
+	// allocation was replaced or released meanwhile
+	granted := grantedLifetime(response, duration)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.allocation == allocation {
+		allocation.ExpiresAt = time.Now().Add(granted)
+		allocation.Lifetime = granted
+		allocation.RequestedLifetime = duration
+	}
 
 	return nil
 }
 
-// Send sends data to a peer through the relay
+// grantedLifetime returns the LIFETIME in an Allocate or Refresh success
+// response. Servers may grant less than requested; one that leaves the
+// attribute out is taken to grant the request.
+func grantedLifetime(response *stun.Message, requested time.Duration) time.Duration {
+	if attr, found := response.GetAttribute(stun.AttrLifetime); found {
+		if granted, err := stun.DecodeLifetime(attr); err == nil {
+			return granted
+		}
+	}
+	return requested
+}
+
+// Send sends data to a peer through the relay, as ChannelData if the peer
+// has a channel (see ChannelBind) and otherwise in a TURN Send indication.
+// The peer must have been granted a permission with CreatePermission;
+// ClientConfig.OnMissingPermission is called when it has none.
+// With a RateLimit configured, Send blocks as needed to stay under it.
 func (c *Client) Send(data []byte, peer *net.UDPAddr) error {
+	if c.onMissingPermission != nil && !c.hasPermission(peer) {
+		c.onMissingPermission(peer)
+	}
+
+	c.limiter.Wait(len(data))
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	if c.closed {
-		return fmt.Errorf("client is closed")
+		return errClientClosed
 	}
 
 	if c.allocation == nil {
@@ -197,9 +356,23 @@ func (c *Client) Send(data []byte, peer *net.UDPAddr) error {
 		return fmt.Errorf("allocation has expired")
 	}
 
-	// In real TURN, we would wrap data in a Send indication
-	// For this simplified version, send directly
-	_, err := c.conn.WriteToUDP(data, peer)
+	if channel, bound := c.channels[peer.String()]; bound {
+		return c.sendChannelData(channel, data)
+	}
+
+	indication, err := stun.NewMessage(stun.TypeSendIndication)
+	if err != nil {
+		return fmt.Errorf("failed to create send indication: %w", err)
+	}
+	indication.AddAttribute(stun.EncodeXORAddress(stun.AttrXORPeerAddress, peer, indication.TransactionID))
+	indication.AddAttribute(stun.EncodeData(data))
+
+	packet, err := indication.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode send indication: %w", err)
+	}
+
+	_, err = c.conn.WriteToUDP(packet, c.serverAddr)
 	if err != nil {
 		return fmt.Errorf("failed to send data: %w", err)
 	}
@@ -207,44 +380,50 @@ func (c *Client) Send(data []byte, peer *net.UDPAddr) error {
 	return nil
 }
 
-// Receive receives data from a peer through the relay
+// Receive receives data from a peer through the relay, unwrapping both
+// ChannelData messages and TURN Data indications. Other traffic from the
+// server is skipped. Receive may be called from several goroutines and
+// alongside requests such as Refresh, which get their responses from the
+// same socket.
 func (c *Client) Receive() ([]byte, *net.UDPAddr, error) {
-	c.mu.RLock()
-	if c.closed {
-		c.mu.RUnlock()
-		return nil, nil, fmt.Errorf("client is closed")
-	}
-	c.mu.RUnlock()
-
-	// Set read deadline
-	deadline := time.Now().Add(c.timeout)
-	if err := c.conn.SetReadDeadline(deadline); err != nil {
-		return nil, nil, fmt.Errorf("failed to set deadline: %w", err)
-	}
-	defer c.conn.SetReadDeadline(time.Time{})
+	return c.receive(time.Now().Add(c.timeout), nil)
+}
 
-	// Read data
-	n, addr, err := c.conn.ReadFromUDP(c.recvBuf)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to receive data: %w", err)
+// receive is Receive with an explicit deadline; zero waits forever. It
+// returns errWoken if wake is closed first.
+func (c *Client) receive(deadline time.Time, wake <-chan struct{}) ([]byte, *net.UDPAddr, error) {
+	if c.isClosed() {
+		return nil, nil, errClientClosed
 	}
 
-	// Return copy of data
-	data := make([]byte, n)
-	copy(data, c.recvBuf[:n])
+	for {
+		d, err := c.next(deadline, wake)
+		if err != nil {
+			return nil, nil, err
+		}
 
-	return data, addr, nil
+		if d.peer != nil {
+			return d.data, d.peer, nil
+		}
+		if peer, found := c.channelPeer(d.channel); found {
+			return d.data, peer, nil
+		}
+	}
 }
 
-// ReceiveFrom receives data from a specific peer
+// ReceiveFrom receives data from a specific peer, discarding data from
+// others. It returns an error once timeout has elapsed without a match.
 func (c *Client) ReceiveFrom(peer *net.UDPAddr, timeout time.Duration) ([]byte, error) {
+	// Every read shares the overall deadline, so the wait is neither cut
+	// short nor extended by the client's per-call timeout
 	deadline := time.Now().Add(timeout)
 
-	for time.Now().Before(deadline) {
-		data, addr, err := c.Receive()
+	for {
+		data, addr, err := c.receive(deadline, nil)
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, fmt.Errorf("timeout waiting for data from %s", peer)
 			}
 			return nil, err
 		}
@@ -254,43 +433,166 @@ func (c *Client) ReceiveFrom(peer *net.UDPAddr, timeout time.Duration) ([]byte,
 			return data, nil
 		}
 	}
+}
 
-	return nil, fmt.Errorf("timeout waiting for data from %s", peer)
+// transaction sends a TURN request to the server and returns its response.
+// build adds the request-specific attributes and is called again for each
+// retry, since every retry is a new STUN transaction. With credentials
+// configured, 401 Unauthorized and 438 Stale Nonce challenges are answered
+// by retrying with the server's REALM and NONCE.
+func (c *Client) transaction(msgType stun.MessageType, build func(*stun.Message)) (*stun.Message, error) {
+	return c.transactionWithin(c.timeout, msgType, build)
 }
 
-// CreatePermission creates a permission for a peer to send through the relay
-// In real TURN, this would send a CreatePermission request
-func (c *Client) CreatePermission(peer *net.UDPAddr) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// transactionWithin is transaction with a per-request timeout other than
+// the configured one
+func (c *Client) transactionWithin(timeout time.Duration, msgType stun.MessageType, build func(*stun.Message)) (*stun.Message, error) {
+	for attempt := 0; ; attempt++ {
+		request, err := stun.NewMessage(msgType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		build(request)
 
-	if c.allocation == nil {
-		return fmt.Errorf("no allocation")
+		response, err := c.roundTrip(request, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if attempt >= maxAuthRetries || !c.updateChallenge(response) {
+			return response, nil
+		}
 	}
+}
 
-	if !c.allocation.IsValid() {
-		return fmt.Errorf("allocation has expired")
+// updateChallenge records REALM and NONCE from a 401 or 438 error response
+// and reports whether the request should be retried
+func (c *Client) updateChallenge(response *stun.Message) bool {
+	if c.username == "" || !response.Type.IsErrorResponse() {
+		return false
 	}
 
-	// In real TURN, we would send a CreatePermission request
-	// For this simplified version, permissions are implicit
+	attr, found := response.GetAttribute(stun.AttrErrorCode)
+	if !found {
+		return false
+	}
+	code, _, err := stun.DecodeErrorCode(attr)
+	if err != nil || (code != codeUnauthorized && code != codeStaleNonce) {
+		return false
+	}
 
-	return nil
+	nonce, found := response.GetAttribute(stun.AttrNonce)
+	if !found {
+		return false
+	}
+
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	c.nonce = string(nonce.Value)
+
+	// 438 keeps the realm; 401 (re)establishes it
+	if realm, found := response.GetAttribute(stun.AttrRealm); found && string(realm.Value) != c.realm {
+		c.realm = string(realm.Value)
+		c.key = stun.LongTermKey(c.username, c.realm, c.password)
+	}
+
+	return c.realm != ""
+}
+
+// encodeRequest encodes a request, adding long-term credentials once the
+// server has issued a challenge. It returns the key responses must be
+// signed with, if any.
+func (c *Client) encodeRequest(request *stun.Message) ([]byte, []byte, error) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.key == nil || c.nonce == "" {
+		data, err := request.Encode()
+		return data, c.key, err
+	}
+
+	request.AddStringAttribute(stun.AttrUsername, c.username)
+	request.AddStringAttribute(stun.AttrRealm, c.realm)
+	request.AddStringAttribute(stun.AttrNonce, c.nonce)
+	data, err := request.EncodeWithIntegrity(c.key)
+	return data, c.key, err
+}
+
+// roundTrip sends a single request and waits for readLoop to deliver the
+// response with the matching transaction ID
+func (c *Client) roundTrip(request *stun.Message, timeout time.Duration) (*stun.Message, error) {
+	data, key, err := c.encodeRequest(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	responses, done := c.await(request.TransactionID)
+	defer done()
+
+	if _, err := c.conn.WriteToUDP(data, c.serverAddr); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case raw := <-responses:
+			response, err := stun.Decode(raw)
+			if err != nil {
+				continue
+			}
+
+			// Once authenticated, drop responses that fail the integrity check
+			if key != nil {
+				if _, signed := response.GetAttribute(stun.AttrMessageIntegrity); signed {
+					if stun.VerifyMessageIntegrity(raw, key) != nil {
+						continue
+					}
+				}
+			}
+
+			return response, nil
+
+		case <-c.readDone:
+			return nil, fmt.Errorf("failed to read response: %w", c.readErr)
+
+		case <-timer.C:
+			return nil, fmt.Errorf("no response from %s after %v", c.serverAddr, timeout)
+		}
+	}
 }
 
 // Close closes the relay client and releases the allocation
 func (c *Client) Close() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if c.closed {
+		c.mu.Unlock()
 		return nil
 	}
 
 	c.closed = true
+	close(c.closing)
+
+	if c.refreshStop != nil {
+		close(c.refreshStop)
+		c.refreshStop = nil
+	}
 
-	// In real TURN, we would send a Refresh with lifetime=0 to release
+	allocation := c.allocation
 	c.allocation = nil
+	c.mu.Unlock()
+
+	// Release the allocation with a zero-lifetime Refresh so the server
+	// frees the relay port now rather than at expiry. It is best effort:
+	// a server that has gone away expires the allocation anyway.
+	if allocation.IsValid() {
+		c.transactionWithin(min(c.timeout, releaseTimeout), stun.TypeRefreshRequest, func(request *stun.Message) {
+			request.AddAttribute(stun.EncodeLifetime(0))
+		})
+	}
 
 	if c.conn != nil {
 		return c.conn.Close()
@@ -306,6 +608,24 @@ func (c *Client) Allocation() *Allocation {
 	return c.allocation
 }
 
+// liveAllocation returns the current allocation, or an error if the client
+// is closed or has no unexpired allocation
+func (c *Client) liveAllocation() (*Allocation, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return nil, errClientClosed
+	}
+	if c.allocation == nil {
+		return nil, fmt.Errorf("no allocation")
+	}
+	if !c.allocation.IsValid() {
+		return nil, fmt.Errorf("allocation has expired")
+	}
+	return c.allocation, nil
+}
+
 // LocalAddr returns the local address
 func (c *Client) LocalAddr() *net.UDPAddr {
 	if c.conn != nil {
@@ -341,10 +661,6 @@ func QuickRelay(serverAddr string, lifetime time.Duration) (*Client, *Allocation
 	return client, allocation, nil
 }
 
-// TODO: Note: This is a simplified relay implementation for demonstration.
-// A full TURN implementation would include:
-// - Proper STUN message encoding for TURN messages
-// - Channel bindings for efficiency
-// - Authentication and authorization
+// TODO: Not yet implemented:
 // - Bandwidth management
 // - Multiple relay address families (IPv4/IPv6)
@@ -1,11 +1,355 @@
 package relay
 
 import (
+	"fmt"
 	"net"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/saintparish4/altair/pkg/netutil"
+	"github.com/saintparish4/altair/pkg/stun"
 )
 
+// testTURNServer is a minimal single-client TURN server on loopback that
+// implements Allocate, Refresh, CreatePermission and Send/Data indications
+type testTURNServer struct {
+	conn *net.UDPConn
+
+	mu          sync.Mutex
+	client      *net.UDPAddr
+	relay       *net.UDPConn
+	permissions map[string]bool
+	channels    map[uint16]*net.UDPAddr
+
+	// When set, requests are answered with this error code
+	errorCode int
+
+	// Number of Refresh requests handled, and of those with LIFETIME 0
+	refreshes int
+	releases  int
+
+	// When set, Refresh requests are counted but never answered
+	dropRefreshes bool
+
+	// Number of CreatePermission requests handled
+	permissionRequests int
+
+	// When set, granted lifetimes are capped at maxLifetime
+	maxLifetime time.Duration
+
+	// When password is set, requests need long-term credentials
+	username string
+	password string
+	realm    string
+	nonce    string
+}
+
+func startTestTURNServer(tb testing.TB) *testTURNServer {
+	tb.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		tb.Fatalf("failed to start test TURN server: %v", err)
+	}
+
+	server := &testTURNServer{
+		conn:        conn,
+		permissions: make(map[string]bool),
+		channels:    make(map[uint16]*net.UDPAddr),
+	}
+	tb.Cleanup(server.close)
+
+	go server.serve()
+	return server
+}
+
+func (s *testTURNServer) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *testTURNServer) close() {
+	s.conn.Close()
+	s.mu.Lock()
+	if s.relay != nil {
+		s.relay.Close()
+	}
+	s.mu.Unlock()
+}
+
+func (s *testTURNServer) serve() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		if channel, data, ok := stun.DecodeChannelData(buf[:n]); ok {
+			s.handleChannelData(channel, data)
+			continue
+		}
+
+		msg, err := stun.Decode(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if msg.Type == stun.TypeSendIndication {
+			s.handleSend(msg)
+			continue
+		}
+
+		if response := s.handleRequest(msg, buf[:n], addr); response != nil {
+			data, _ := response.Encode()
+			if s.key() != nil && !response.Type.IsErrorResponse() {
+				data, _ = response.EncodeWithIntegrity(s.key())
+			}
+			s.conn.WriteToUDP(data, addr)
+		}
+	}
+}
+
+// requireAuth enables long-term credential checks with the given nonce
+func (s *testTURNServer) requireAuth(username, password, realm, nonce string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username, s.password, s.realm, s.nonce = username, password, realm, nonce
+}
+
+// rotateNonce makes the current nonce stale
+func (s *testTURNServer) rotateNonce(nonce string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nonce = nonce
+}
+
+func (s *testTURNServer) refreshCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshes
+}
+
+func (s *testTURNServer) releaseCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.releases
+}
+
+func (s *testTURNServer) permissionRequestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.permissionRequests
+}
+
+func (s *testTURNServer) hasPermission(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.permissions[ip]
+}
+
+func (s *testTURNServer) key() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.password == "" {
+		return nil
+	}
+	return stun.LongTermKey(s.username, s.realm, s.password)
+}
+
+// challenge returns the error response for a request failing authentication
+func (s *testTURNServer) challenge(msg *stun.Message, raw []byte) *stun.Message {
+	errorResponse := func(code int, reason string) *stun.Message {
+		response := &stun.Message{Type: msg.Type.ErrorResponseType(), TransactionID: msg.TransactionID}
+		response.AddAttribute(stun.EncodeErrorCode(code, reason))
+		response.AddStringAttribute(stun.AttrRealm, s.realm)
+		response.AddStringAttribute(stun.AttrNonce, s.nonce)
+		return response
+	}
+
+	username, found := msg.GetAttribute(stun.AttrUsername)
+	if !found || string(username.Value) != s.username {
+		return errorResponse(codeUnauthorized, "Unauthorized")
+	}
+	if nonce, found := msg.GetAttribute(stun.AttrNonce); !found || string(nonce.Value) != s.nonce {
+		return errorResponse(codeStaleNonce, "Stale Nonce")
+	}
+	if stun.VerifyMessageIntegrity(raw, stun.LongTermKey(s.username, s.realm, s.password)) != nil {
+		return errorResponse(codeUnauthorized, "Unauthorized")
+	}
+	return nil
+}
+
+func (s *testTURNServer) handleRequest(msg *stun.Message, raw []byte, addr *net.UDPAddr) *stun.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	response := &stun.Message{TransactionID: msg.TransactionID}
+
+	if s.errorCode != 0 {
+		response.Type = msg.Type.ErrorResponseType()
+		response.AddAttribute(stun.EncodeErrorCode(s.errorCode, "Test Error"))
+		return response
+	}
+
+	if s.password != "" {
+		if challenge := s.challenge(msg, raw); challenge != nil {
+			return challenge
+		}
+	}
+
+	switch msg.Type {
+	case stun.TypeAllocateRequest:
+		relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		if err != nil {
+			return nil
+		}
+		s.client = addr
+		s.relay = relay
+		go s.relayLoop(relay)
+
+		response.Type = stun.TypeAllocateSuccess
+		response.AddAttribute(stun.EncodeXORAddress(stun.AttrXORRelayedAddress, relay.LocalAddr().(*net.UDPAddr), msg.TransactionID))
+		response.AddAttribute(stun.EncodeXORMappedAddress(addr, msg.TransactionID))
+		response.AddAttribute(s.lifetime(msg))
+
+	case stun.TypeRefreshRequest:
+		s.refreshes++
+		if attr, found := msg.GetAttribute(stun.AttrLifetime); found {
+			if lifetime, err := stun.DecodeLifetime(attr); err == nil && lifetime == 0 {
+				s.releases++
+			}
+		}
+		if s.dropRefreshes {
+			return nil
+		}
+		response.Type = stun.TypeRefreshSuccess
+		response.AddAttribute(s.lifetime(msg))
+
+	case stun.TypeChannelBindRequest:
+		channelAttr, found := msg.GetAttribute(stun.AttrChannelNumber)
+		if !found {
+			return nil
+		}
+		channel, _ := stun.DecodeChannelNumber(channelAttr)
+		peerAttr, found := msg.GetAttribute(stun.AttrXORPeerAddress)
+		if !found {
+			return nil
+		}
+		peer, err := stun.DecodeXORAddress(peerAttr, msg.TransactionID)
+		if err != nil {
+			return nil
+		}
+		s.channels[channel] = peer
+		s.permissions[peer.IP.String()] = true
+		response.Type = stun.TypeChannelBindSuccess
+
+	case stun.TypeCreatePermissionRequest:
+		s.permissionRequests++
+		for i := range msg.Attributes {
+			if msg.Attributes[i].Type != stun.AttrXORPeerAddress {
+				continue
+			}
+			if peer, err := stun.DecodeXORAddress(&msg.Attributes[i], msg.TransactionID); err == nil {
+				s.permissions[peer.IP.String()] = true
+			}
+		}
+		response.Type = stun.TypeCreatePermissionSuccess
+
+	default:
+		return nil
+	}
+
+	return response
+}
+
+// lifetime grants the requested LIFETIME up to maxLifetime, defaulting to
+// 10 minutes. Callers hold s.mu.
+func (s *testTURNServer) lifetime(msg *stun.Message) stun.Attribute {
+	lifetime := 10 * time.Minute
+	if attr, found := msg.GetAttribute(stun.AttrLifetime); found {
+		lifetime, _ = stun.DecodeLifetime(attr)
+	}
+	if s.maxLifetime > 0 && lifetime > s.maxLifetime {
+		lifetime = s.maxLifetime
+	}
+	return stun.EncodeLifetime(lifetime)
+}
+
+func (s *testTURNServer) handleSend(msg *stun.Message) {
+	peerAttr, found := msg.GetAttribute(stun.AttrXORPeerAddress)
+	if !found {
+		return
+	}
+	peer, err := stun.DecodeXORAddress(peerAttr, msg.TransactionID)
+	if err != nil {
+		return
+	}
+	dataAttr, found := msg.GetAttribute(stun.AttrData)
+	if !found {
+		return
+	}
+
+	s.mu.Lock()
+	relay, permitted := s.relay, s.permissions[peer.IP.String()]
+	s.mu.Unlock()
+
+	if relay != nil && permitted {
+		relay.WriteToUDP(dataAttr.Value, peer)
+	}
+}
+
+func (s *testTURNServer) handleChannelData(channel uint16, data []byte) {
+	s.mu.Lock()
+	relay, peer := s.relay, s.channels[channel]
+	s.mu.Unlock()
+
+	if relay != nil && peer != nil {
+		relay.WriteToUDP(data, peer)
+	}
+}
+
+// channelFor returns the channel bound to peer, if any. Callers hold s.mu.
+func (s *testTURNServer) channelFor(peer *net.UDPAddr) (uint16, bool) {
+	for channel, bound := range s.channels {
+		if bound.String() == peer.String() {
+			return channel, true
+		}
+	}
+	return 0, false
+}
+
+// relayLoop wraps datagrams arriving on the relay address in ChannelData
+// when the peer has a channel and in Data indications otherwise
+func (s *testTURNServer) relayLoop(relay *net.UDPConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, peer, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		client, permitted := s.client, s.permissions[peer.IP.String()]
+		channel, bound := s.channelFor(peer)
+		s.mu.Unlock()
+		if !permitted {
+			continue
+		}
+
+		if bound {
+			s.conn.WriteToUDP(stun.EncodeChannelData(channel, buf[:n]), client)
+			continue
+		}
+
+		indication, _ := stun.NewMessage(stun.TypeDataIndication)
+		indication.AddAttribute(stun.EncodeXORAddress(stun.AttrXORPeerAddress, peer, indication.TransactionID))
+		indication.AddAttribute(stun.EncodeData(append([]byte(nil), buf[:n]...)))
+		data, _ := indication.Encode()
+		s.conn.WriteToUDP(data, client)
+	}
+}
+
 func TestAllocationString(t *testing.T) {
 	// Test nil allocation
 	var alloc *Allocation
@@ -162,7 +506,8 @@ func TestNewClientWithExistingConn(t *testing.T) {
 }
 
 func TestAllocate(t *testing.T) {
-	config := DefaultClientConfig("127.0.0.1:3478")
+	server := startTestTURNServer(t)
+	config := DefaultClientConfig(server.addr())
 
 	client, err := NewClient(config)
 	if err != nil {
@@ -215,7 +560,8 @@ func TestAllocateWhenClosed(t *testing.T) {
 }
 
 func TestRefresh(t *testing.T) {
-	config := DefaultClientConfig("127.0.0.1:3478")
+	server := startTestTURNServer(t)
+	config := DefaultClientConfig(server.addr())
 
 	client, err := NewClient(config)
 	if err != nil {
@@ -250,6 +596,44 @@ func TestRefresh(t *testing.T) {
 	}
 }
 
+func TestAllocateGrantedLifetime(t *testing.T) {
+	server := startTestTURNServer(t)
+	server.mu.Lock()
+	server.maxLifetime = 30 * time.Second
+	server.mu.Unlock()
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	allocation, err := client.Allocate(10 * time.Minute)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if allocation.Lifetime != 30*time.Second || allocation.RequestedLifetime != 10*time.Minute {
+		t.Errorf("Lifetime = %v, RequestedLifetime = %v, want 30s granted of 10m",
+			allocation.Lifetime, allocation.RequestedLifetime)
+	}
+	if remaining := allocation.TimeRemaining(); remaining > 30*time.Second {
+		t.Errorf("TimeRemaining = %v, want at most the granted 30s", remaining)
+	}
+
+	if err := client.Refresh(time.Hour); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if allocation.Lifetime != 30*time.Second || allocation.TimeRemaining() > 30*time.Second {
+		t.Errorf("after Refresh, Lifetime = %v with %v remaining, want the granted 30s",
+			allocation.Lifetime, allocation.TimeRemaining())
+	}
+
+	// Auto refresh asks for what the server last granted
+	if _, lifetime, ok := client.refreshSchedule(); !ok || lifetime != 30*time.Second {
+		t.Errorf("refreshSchedule lifetime = %v, want 30s", lifetime)
+	}
+}
+
 func TestRefreshExpiredAllocation(t *testing.T) {
 	config := DefaultClientConfig("127.0.0.1:3478")
 
@@ -271,8 +655,88 @@ func TestRefreshExpiredAllocation(t *testing.T) {
 	}
 }
 
+func TestStartAutoRefresh(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	if err := client.StartAutoRefresh(); err == nil {
+		t.Error("StartAutoRefresh should fail without allocation")
+	}
+
+	allocation, err := client.Allocate(2 * time.Second)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	if err := client.StartAutoRefresh(); err != nil {
+		t.Fatalf("StartAutoRefresh failed: %v", err)
+	}
+
+	// First refresh is due at half the 2s lifetime
+	time.Sleep(1500 * time.Millisecond)
+	if server.refreshCount() == 0 {
+		t.Fatal("Expected the allocation to be refreshed")
+	}
+	client.mu.RLock()
+	remaining := allocation.TimeRemaining()
+	client.mu.RUnlock()
+	if remaining < time.Second {
+		t.Errorf("Allocation should have been extended, %v remaining", remaining)
+	}
+
+	client.Close()
+
+	// Close releases with one zero-lifetime Refresh; nothing after that
+	time.Sleep(50 * time.Millisecond)
+	count := server.refreshCount()
+	time.Sleep(1200 * time.Millisecond)
+	if server.refreshCount() != count {
+		t.Error("Auto refresh should stop on Close")
+	}
+}
+
+func TestAutoRefreshError(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	errs := make(chan error, 10)
+	config := DefaultClientConfig(server.addr())
+	config.OnRefreshError = func(err error) { errs <- err }
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Allocate(2 * time.Second); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	server.mu.Lock()
+	server.errorCode = 500
+	server.mu.Unlock()
+
+	if err := client.StartAutoRefresh(); err != nil {
+		t.Fatalf("StartAutoRefresh failed: %v", err)
+	}
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("OnRefreshError called with nil error")
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("OnRefreshError was not called")
+	}
+}
+
 func TestSend(t *testing.T) {
-	config := DefaultClientConfig("127.0.0.1:3478")
+	server := startTestTURNServer(t)
+	config := DefaultClientConfig(server.addr())
 
 	client, err := NewClient(config)
 	if err != nil {
@@ -294,14 +758,44 @@ func TestSend(t *testing.T) {
 		t.Fatalf("Allocate failed: %v", err)
 	}
 
-	// Now send should work (though no peer is listening)
+	// Now send should work (the server drops it without a permission)
 	err = client.Send([]byte("test"), peer)
-	// We expect this to succeed even though peer isn't listening
 	if err != nil {
 		t.Errorf("Send failed: %v", err)
 	}
 }
 
+func TestSendRateLimit(t *testing.T) {
+	server := startTestTURNServer(t)
+	config := DefaultClientConfig(server.addr())
+	config.RateLimit = 4000
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Allocate(10 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	// The first 4000 bytes are a burst; the next 2000 take ~500ms
+	peer := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 12345}
+	payload := make([]byte, 1000)
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := client.Send(payload, peer); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("Sending 6000 bytes at 4000 B/s took %v, want at least 500ms", elapsed)
+	}
+}
+
 func TestSendExpiredAllocation(t *testing.T) {
 	config := DefaultClientConfig("127.0.0.1:3478")
 
@@ -325,7 +819,8 @@ func TestSendExpiredAllocation(t *testing.T) {
 }
 
 func TestCreatePermission(t *testing.T) {
-	config := DefaultClientConfig("127.0.0.1:3478")
+	server := startTestTURNServer(t)
+	config := DefaultClientConfig(server.addr())
 
 	client, err := NewClient(config)
 	if err != nil {
@@ -354,39 +849,68 @@ func TestCreatePermission(t *testing.T) {
 	}
 }
 
-func TestClose(t *testing.T) {
-	config := DefaultClientConfig("127.0.0.1:3478")
+func TestCreatePermissionMultiplePeers(t *testing.T) {
+	server := startTestTURNServer(t)
 
-	client, err := NewClient(config)
+	client, err := NewClient(DefaultClientConfig(server.addr()))
 	if err != nil {
 		t.Fatalf("NewClient failed: %v", err)
 	}
+	defer client.Close()
 
-	// Create allocation
-	_, err = client.Allocate(10 * time.Minute)
-	if err != nil {
+	if _, err := client.Allocate(10 * time.Minute); err != nil {
 		t.Fatalf("Allocate failed: %v", err)
 	}
 
-	err = client.Close()
-	if err != nil {
-		t.Errorf("Close failed: %v", err)
+	peers := []*net.UDPAddr{
+		{IP: net.ParseIP("203.0.113.2"), Port: 1000},
+		{IP: net.ParseIP("203.0.113.1"), Port: 2000},
+	}
+	if err := client.CreatePermission(peers...); err != nil {
+		t.Fatalf("CreatePermission failed: %v", err)
 	}
 
-	// Verify allocation is cleared
-	if client.Allocation() != nil {
-		t.Error("Close should clear allocation")
+	if got := server.permissionRequestCount(); got != 1 {
+		t.Errorf("Expected 1 CreatePermission request, got %d", got)
+	}
+	for _, peer := range peers {
+		if !server.hasPermission(peer.IP.String()) {
+			t.Errorf("Server has no permission for %s", peer.IP)
+		}
 	}
 
-	// Closing again should not error
-	err = client.Close()
-	if err != nil {
-		t.Errorf("Second Close failed: %v", err)
+	permissions := client.Permissions()
+	if len(permissions) != 2 {
+		t.Fatalf("Expected 2 permissions, got %d", len(permissions))
+	}
+	if !permissions[0].IP.Equal(peers[1].IP) || !permissions[1].IP.Equal(peers[0].IP) {
+		t.Errorf("Permissions not ordered by IP: %v, %v", permissions[0].IP, permissions[1].IP)
+	}
+	for _, p := range permissions {
+		if !p.IsValid() {
+			t.Errorf("Permission for %s should be valid", p.IP)
+		}
+	}
+
+	// A new allocation starts without permissions
+	if _, err := client.Allocate(10 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if got := len(client.Permissions()); got != 0 {
+		t.Errorf("Expected no permissions after Allocate, got %d", got)
 	}
 }
 
-func TestLocalAddr(t *testing.T) {
-	config := DefaultClientConfig("127.0.0.1:3478")
+func TestPermissionExpiry(t *testing.T) {
+	saved := permissionLifetime
+	permissionLifetime = 100 * time.Millisecond
+	defer func() { permissionLifetime = saved }()
+
+	server := startTestTURNServer(t)
+
+	missing := make(chan *net.UDPAddr, 10)
+	config := DefaultClientConfig(server.addr())
+	config.OnMissingPermission = func(peer *net.UDPAddr) { missing <- peer }
 
 	client, err := NewClient(config)
 	if err != nil {
@@ -394,18 +918,59 @@ func TestLocalAddr(t *testing.T) {
 	}
 	defer client.Close()
 
-	localAddr := client.LocalAddr()
-	if localAddr == nil {
-		t.Fatal("LocalAddr should not be nil")
+	if _, err := client.Allocate(10 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
 	}
 
-	if localAddr.Port == 0 {
-		t.Error("LocalAddr should have non-zero port")
+	peer := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 12345}
+	if err := client.CreatePermission(peer); err != nil {
+		t.Fatalf("CreatePermission failed: %v", err)
+	}
+
+	if err := client.Send([]byte("hello"), peer); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(missing) != 0 {
+		t.Error("OnMissingPermission called for a live permission")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if client.Permissions()[0].IsValid() {
+		t.Error("Permission should have expired")
+	}
+	if err := client.Send([]byte("hello"), peer); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	select {
+	case got := <-missing:
+		if !got.IP.Equal(peer.IP) {
+			t.Errorf("OnMissingPermission called for %v, want %v", got, peer)
+		}
+	default:
+		t.Error("OnMissingPermission not called for an expired permission")
+	}
+
+	if err := client.RefreshPermissions(); err != nil {
+		t.Fatalf("RefreshPermissions failed: %v", err)
+	}
+	if !client.Permissions()[0].IsValid() {
+		t.Error("Permission should be valid after RefreshPermissions")
+	}
+	if got := server.permissionRequestCount(); got != 2 {
+		t.Errorf("Expected 2 CreatePermission requests, got %d", got)
 	}
 }
 
-func TestServerAddr(t *testing.T) {
-	config := DefaultClientConfig("127.0.0.1:3478")
+func TestAutoRefreshPermissions(t *testing.T) {
+	saved := permissionRefreshInterval
+	permissionRefreshInterval = 50 * time.Millisecond
+	defer func() { permissionRefreshInterval = saved }()
+
+	server := startTestTURNServer(t)
+
+	config := DefaultClientConfig(server.addr())
+	config.AutoRefreshPermissions = true
 
 	client, err := NewClient(config)
 	if err != nil {
@@ -413,22 +978,681 @@ func TestServerAddr(t *testing.T) {
 	}
 	defer client.Close()
 
-	serverAddr := client.ServerAddr()
-	if serverAddr == nil {
-		t.Fatal("ServerAddr should not be nil")
+	if _, err := client.Allocate(10 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
 	}
-
-	if !serverAddr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
-		t.Errorf("ServerAddr IP = %s, want 127.0.0.1", serverAddr.IP)
+	if err := client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 12345}); err != nil {
+		t.Fatalf("CreatePermission failed: %v", err)
+	}
+	if err := client.StartAutoRefresh(); err != nil {
+		t.Fatalf("StartAutoRefresh failed: %v", err)
 	}
 
-	if serverAddr.Port != 3478 {
+	deadline := time.Now().Add(2 * time.Second)
+	for server.permissionRequestCount() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected permissions to be refreshed, got %d requests", server.permissionRequestCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRelayRoundTrip(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	allocation, err := client.Allocate(10 * time.Minute)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	if allocation.ReflexiveAddr.Port != client.LocalAddr().Port {
+		t.Errorf("ReflexiveAddr = %s, want port %d", allocation.ReflexiveAddr, client.LocalAddr().Port)
+	}
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("Failed to create peer: %v", err)
+	}
+	defer peer.Close()
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+
+	if err := client.CreatePermission(peerAddr); err != nil {
+		t.Fatalf("CreatePermission failed: %v", err)
+	}
+
+	// Client -> relay -> peer
+	if err := client.Send([]byte("hello"), peerAddr); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Peer read failed: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("Peer received %q, want %q", buf[:n], "hello")
+	}
+	if from.Port != allocation.RelayAddr.Port {
+		t.Errorf("Peer received from %s, want relay %s", from, allocation.RelayAddr)
+	}
+
+	// Peer -> relay -> client
+	peer.WriteToUDP([]byte("hello back"), allocation.RelayAddr)
+
+	data, addr, err := client.Receive()
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if string(data) != "hello back" {
+		t.Errorf("Received %q, want %q", data, "hello back")
+	}
+	if addr.Port != peerAddr.Port {
+		t.Errorf("Received from %s, want %s", addr, peerAddr)
+	}
+}
+
+// relayPeer allocates on client and returns a permitted peer socket
+func relayPeer(t *testing.T, client *Client) (*net.UDPConn, *Allocation) {
+	t.Helper()
+
+	allocation, err := client.Allocate(10 * time.Minute)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("Failed to create peer: %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	if err := client.CreatePermission(peer.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatalf("CreatePermission failed: %v", err)
+	}
+	return peer, allocation
+}
+
+func TestConcurrentReceive(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	peer, allocation := relayPeer(t, client)
+
+	const readers = 4
+	results := make(chan string, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, _, err := client.Receive()
+			if err != nil {
+				t.Errorf("Receive failed: %v", err)
+				return
+			}
+			results <- string(data)
+		}()
+	}
+
+	for i := 0; i < readers; i++ {
+		peer.WriteToUDP([]byte(fmt.Sprintf("message %d", i)), allocation.RelayAddr)
+	}
+	wg.Wait()
+	close(results)
+
+	seen := make(map[string]bool)
+	for data := range results {
+		seen[data] = true
+	}
+	for i := 0; i < readers; i++ {
+		if want := fmt.Sprintf("message %d", i); !seen[want] {
+			t.Errorf("No reader received %q", want)
+		}
+	}
+}
+
+func TestReceiveDuringAutoRefresh(t *testing.T) {
+	saved := permissionRefreshInterval
+	permissionRefreshInterval = 100 * time.Millisecond
+	defer func() { permissionRefreshInterval = saved }()
+
+	server := startTestTURNServer(t)
+	server.mu.Lock()
+	server.maxLifetime = time.Second
+	server.mu.Unlock()
+
+	errs := make(chan error, 10)
+	config := DefaultClientConfig(server.addr())
+	config.AutoRefreshPermissions = true
+	config.OnRefreshError = func(err error) { errs <- err }
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	peer, allocation := relayPeer(t, client)
+	if err := client.StartAutoRefresh(); err != nil {
+		t.Fatalf("StartAutoRefresh failed: %v", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				peer.WriteToUDP([]byte("data"), allocation.RelayAddr)
+			}
+		}
+	}()
+
+	// Keep a Receive blocked on the socket while refreshes run
+	received := 0
+	deadline := time.Now().Add(1500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		data, _, err := client.Receive()
+		if err != nil {
+			t.Fatalf("Receive failed during auto refresh: %v", err)
+		}
+		if string(data) != "data" {
+			t.Fatalf("Received %q, want %q", data, "data")
+		}
+		received++
+	}
+
+	select {
+	case err := <-errs:
+		t.Errorf("Refresh failed while receiving: %v", err)
+	default:
+	}
+	if got := server.refreshCount(); got < 2 {
+		t.Errorf("Expected at least 2 refreshes, got %d", got)
+	}
+	if got := server.permissionRequestCount(); got < 3 {
+		t.Errorf("Expected permissions to be refreshed, got %d requests", got)
+	}
+	if !client.Allocation().IsValid() {
+		t.Error("Allocation expired despite auto refresh")
+	}
+	if received < 50 {
+		t.Errorf("Received only %d datagrams", received)
+	}
+}
+
+func TestSendDuringLostRefresh(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	config := DefaultClientConfig(server.addr())
+	config.Timeout = time.Second
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	peer, allocation := relayPeer(t, client)
+
+	server.mu.Lock()
+	server.dropRefreshes = true
+	server.mu.Unlock()
+
+	refreshed := make(chan error, 1)
+	go func() { refreshed <- client.Refresh(10 * time.Minute) }()
+	for server.refreshCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Traffic keeps flowing while the Refresh waits for its response
+	start := time.Now()
+	if err := client.Send([]byte("out"), peer.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	buf := make([]byte, 64)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := peer.ReadFromUDP(buf); err != nil {
+		t.Fatalf("Peer read failed: %v", err)
+	}
+	peer.WriteToUDP([]byte("in"), allocation.RelayAddr)
+	if _, _, err := client.Receive(); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Send and Receive took %v behind a pending Refresh", elapsed)
+	}
+
+	select {
+	case err := <-refreshed:
+		t.Fatalf("Refresh returned %v before its timeout", err)
+	default:
+	}
+	if err := <-refreshed; err == nil {
+		t.Error("Refresh with no response should fail")
+	}
+}
+
+func TestReceiveBufferSize(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	config := DefaultClientConfig(server.addr())
+	config.ReceiveBufferSize = 128
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	peer, allocation := relayPeer(t, client)
+
+	// Too large once wrapped in a Data indication, so it is dropped
+	peer.WriteToUDP(make([]byte, 200), allocation.RelayAddr)
+	peer.WriteToUDP([]byte("fits"), allocation.RelayAddr)
+
+	data, _, err := client.Receive()
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if string(data) != "fits" {
+		t.Errorf("Received %q, want %q", data, "fits")
+	}
+}
+
+func TestChannelBindRoundTrip(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("Failed to create peer: %v", err)
+	}
+	defer peer.Close()
+	peerAddr := peer.LocalAddr().(*net.UDPAddr)
+
+	if _, err := client.ChannelBind(peerAddr); err == nil {
+		t.Error("ChannelBind should fail without allocation")
+	}
+
+	allocation, err := client.Allocate(10 * time.Minute)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	if err := client.SendChannel([]byte("x"), peerAddr); err == nil {
+		t.Error("SendChannel should fail without a channel")
+	}
+
+	channel, err := client.ChannelBind(peerAddr)
+	if err != nil {
+		t.Fatalf("ChannelBind failed: %v", err)
+	}
+	if channel != stun.MinChannelNumber {
+		t.Errorf("First channel = %#x, want %#x", channel, stun.MinChannelNumber)
+	}
+
+	// Rebinding refreshes the same channel
+	if again, err := client.ChannelBind(peerAddr); err != nil || again != channel {
+		t.Errorf("Rebind = %#x, %v; want %#x", again, err, channel)
+	}
+
+	other := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+	if next, err := client.ChannelBind(other); err != nil || next != channel+1 {
+		t.Errorf("Second channel = %#x, %v; want %#x", next, err, channel+1)
+	}
+
+	// Client -> relay -> peer over ChannelData
+	if err := client.SendChannel([]byte("hello"), peerAddr); err != nil {
+		t.Fatalf("SendChannel failed: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Peer read failed: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("Peer received %q, want %q", buf[:n], "hello")
+	}
+
+	// Peer -> relay -> client, arriving as ChannelData
+	peer.WriteToUDP([]byte("hello back"), allocation.RelayAddr)
+
+	data, addr, err := client.Receive()
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if string(data) != "hello back" {
+		t.Errorf("Received %q, want %q", data, "hello back")
+	}
+	if addr.String() != peerAddr.String() {
+		t.Errorf("Received from %s, want %s", addr, peerAddr)
+	}
+}
+
+func TestReceiveFromTimeout(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	// Per-call timeouts much longer and much shorter than the overall one
+	for _, clientTimeout := range []time.Duration{5 * time.Second, 10 * time.Millisecond} {
+		config := DefaultClientConfig(server.addr())
+		config.Timeout = clientTimeout
+
+		client, err := NewClient(config)
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+
+		peer := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+		timeout := 200 * time.Millisecond
+
+		start := time.Now()
+		_, err = client.ReceiveFrom(peer, timeout)
+		elapsed := time.Since(start)
+		client.Close()
+
+		if err == nil {
+			t.Fatalf("client timeout %v: ReceiveFrom should time out", clientTimeout)
+		}
+		if elapsed < timeout || elapsed > timeout+200*time.Millisecond {
+			t.Errorf("client timeout %v: ReceiveFrom returned after %v, want ~%v", clientTimeout, elapsed, timeout)
+		}
+	}
+}
+
+func TestReceiveFromFiltersPeers(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	allocation, err := client.Allocate(10 * time.Minute)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	var peers []*net.UDPConn
+	for i := 0; i < 2; i++ {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		if err != nil {
+			t.Fatalf("Failed to create peer: %v", err)
+		}
+		defer peer.Close()
+		peers = append(peers, peer)
+	}
+	if err := client.CreatePermission(peers[0].LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatalf("CreatePermission failed: %v", err)
+	}
+
+	// Both peers share an IP, so one permission covers both
+	peers[1].WriteToUDP([]byte("other"), allocation.RelayAddr)
+	peers[0].WriteToUDP([]byte("wanted"), allocation.RelayAddr)
+
+	data, err := client.ReceiveFrom(peers[0].LocalAddr().(*net.UDPAddr), time.Second)
+	if err != nil {
+		t.Fatalf("ReceiveFrom failed: %v", err)
+	}
+	if string(data) != "wanted" {
+		t.Errorf("ReceiveFrom = %q, want %q", data, "wanted")
+	}
+}
+
+func TestAllocateErrorResponse(t *testing.T) {
+	server := startTestTURNServer(t)
+	server.mu.Lock()
+	server.errorCode = 486
+	server.mu.Unlock()
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.Allocate(10 * time.Minute)
+	if err == nil {
+		t.Fatal("Allocate should fail on an error response")
+	}
+	if client.Allocation() != nil {
+		t.Error("Failed Allocate should not store an allocation")
+	}
+}
+
+func TestAllocateWithCredentials(t *testing.T) {
+	server := startTestTURNServer(t)
+	server.requireAuth("alice", "secret", "example.org", "nonce-1")
+
+	config := DefaultClientConfig(server.addr())
+	config.Username = "alice"
+	config.Password = "secret"
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Allocate(10 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	peer := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+	if err := client.CreatePermission(peer); err != nil {
+		t.Errorf("CreatePermission failed: %v", err)
+	}
+
+	// Server rotates the nonce; Refresh should recover from 438
+	server.rotateNonce("nonce-2")
+	if err := client.Refresh(5 * time.Minute); err != nil {
+		t.Errorf("Refresh after nonce rotation failed: %v", err)
+	}
+}
+
+func TestAllocateWrongPassword(t *testing.T) {
+	server := startTestTURNServer(t)
+	server.requireAuth("alice", "secret", "example.org", "nonce-1")
+
+	config := DefaultClientConfig(server.addr())
+	config.Username = "alice"
+	config.Password = "wrong"
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Allocate(10 * time.Minute); err == nil {
+		t.Error("Allocate should fail with the wrong password")
+	}
+}
+
+func TestAllocateWithoutCredentials(t *testing.T) {
+	server := startTestTURNServer(t)
+	server.requireAuth("alice", "secret", "example.org", "nonce-1")
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Allocate(10 * time.Minute); err == nil {
+		t.Error("Allocate should fail when the server requires credentials")
+	}
+}
+
+func TestAllocateNoServer(t *testing.T) {
+	config := DefaultClientConfig("127.0.0.1:3478")
+	config.Timeout = 100 * time.Millisecond
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Allocate(10 * time.Minute); err == nil {
+		t.Error("Allocate should fail without a server")
+	}
+}
+
+func TestClose(t *testing.T) {
+	server := startTestTURNServer(t)
+	config := DefaultClientConfig(server.addr())
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	// Create allocation
+	_, err = client.Allocate(10 * time.Minute)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+
+	// Verify allocation is cleared
+	if client.Allocation() != nil {
+		t.Error("Close should clear allocation")
+	}
+
+	// Closing again should not error
+	err = client.Close()
+	if err != nil {
+		t.Errorf("Second Close failed: %v", err)
+	}
+}
+
+func TestCloseReleasesAllocation(t *testing.T) {
+	server := startTestTURNServer(t)
+	server.requireAuth("alice", "secret", "example.org", "nonce-1")
+
+	config := DefaultClientConfig(server.addr())
+	config.Username = "alice"
+	config.Password = "secret"
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if _, err := client.Allocate(10 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	// The release is authenticated like any request, stale nonce included
+	server.rotateNonce("nonce-2")
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Close waits for the server, so the release has been handled
+	if released := server.releaseCount(); released != 1 {
+		t.Errorf("server saw %d zero-lifetime Refreshes, want 1", released)
+	}
+}
+
+func TestCloseReleaseTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { releaseTimeout = timeout }(releaseTimeout)
+	releaseTimeout = 100 * time.Millisecond
+
+	server := startTestTURNServer(t)
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if _, err := client.Allocate(10 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	// With the server gone, Close gives up on the release quickly
+	server.close()
+	start := time.Now()
+	if err := client.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v with the server gone", elapsed)
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	config := DefaultClientConfig("127.0.0.1:3478")
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	localAddr := client.LocalAddr()
+	if localAddr == nil {
+		t.Fatal("LocalAddr should not be nil")
+	}
+
+	if localAddr.Port == 0 {
+		t.Error("LocalAddr should have non-zero port")
+	}
+}
+
+func TestServerAddr(t *testing.T) {
+	config := DefaultClientConfig("127.0.0.1:3478")
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	serverAddr := client.ServerAddr()
+	if serverAddr == nil {
+		t.Fatal("ServerAddr should not be nil")
+	}
+
+	if !serverAddr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("ServerAddr IP = %s, want 127.0.0.1", serverAddr.IP)
+	}
+
+	if serverAddr.Port != 3478 {
 		t.Errorf("ServerAddr Port = %d, want 3478", serverAddr.Port)
 	}
 }
 
 func TestQuickRelay(t *testing.T) {
-	client, allocation, err := QuickRelay("127.0.0.1:3478", 10*time.Minute)
+	server := startTestTURNServer(t)
+
+	client, allocation, err := QuickRelay(server.addr(), 10*time.Minute)
 	if err != nil {
 		t.Fatalf("QuickRelay failed: %v", err)
 	}
@@ -461,7 +1685,8 @@ func BenchmarkNewClient(b *testing.B) {
 }
 
 func BenchmarkAllocate(b *testing.B) {
-	config := DefaultClientConfig("127.0.0.1:3478")
+	server := startTestTURNServer(b)
+	config := DefaultClientConfig(server.addr())
 	client, err := NewClient(config)
 	if err != nil {
 		b.Fatal(err)
@@ -487,7 +1712,17 @@ func BenchmarkAllocationIsValid(b *testing.B) {
 	}
 }
 
-// TODO: Integration test note:
-// Full relay tests require a running TURN server.
-// These tests cover the client API and logic.
-// For real relay tests, see test/integration/relay_test.go
+func TestNewClientPortRange(t *testing.T) {
+	config := DefaultClientConfig("127.0.0.1:3478")
+	config.PortRange = netutil.NewPortScanner(42000, 42010)
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	if port := client.LocalAddr().Port; port < 42000 || port > 42010 {
+		t.Errorf("client bound port %d, want a port in [42000, 42010]", port)
+	}
+}
@@ -0,0 +1,110 @@
+package relay
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// relayConn adapts a relay allocation to net.Conn for a single peer.
+// Writes are relayed to the peer and reads drop data relayed from anyone
+// else, so callers can treat it exactly like a direct punched connection.
+type relayConn struct {
+	client *Client
+	remote *net.UDPAddr
+
+	mu           sync.Mutex
+	readDeadline time.Time
+
+	// deadlineChanged is closed and replaced by SetReadDeadline so a
+	// blocked Read picks up the new deadline
+	deadlineChanged chan struct{}
+}
+
+// NetConn returns a net.Conn that routes traffic to peer through the relay.
+// The client must already hold an allocation with a permission (or channel)
+// for the peer. It shares the client: closing it closes the client too.
+func (c *Client) NetConn(peer *net.UDPAddr) net.Conn {
+	return &relayConn{
+		client:          c,
+		remote:          peer,
+		deadlineChanged: make(chan struct{}),
+	}
+}
+
+// Read reads the next datagram relayed from the peer, discarding data from
+// other senders. Each call returns at most one datagram; if b is too small
+// the rest of it is dropped. SetReadDeadline and Close from other
+// goroutines unblock a pending Read, which then returns an error wrapping
+// os.ErrDeadlineExceeded or net.ErrClosed.
+func (rc *relayConn) Read(b []byte) (int, error) {
+	for {
+		rc.mu.Lock()
+		deadline, changed := rc.readDeadline, rc.deadlineChanged
+		rc.mu.Unlock()
+
+		data, addr, err := rc.client.receive(deadline, changed)
+		if errors.Is(err, errWoken) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if addr.IP.Equal(rc.remote.IP) && addr.Port == rc.remote.Port {
+			return copy(b, data), nil
+		}
+	}
+}
+
+// Write relays b to the peer as a single datagram
+func (rc *relayConn) Write(b []byte) (int, error) {
+	if err := rc.client.Send(b, rc.remote); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close releases the allocation and closes the client
+func (rc *relayConn) Close() error {
+	return rc.client.Close()
+}
+
+// LocalAddr returns the relayed address the peer sends to, falling back to
+// the client's socket address when there is no allocation
+func (rc *relayConn) LocalAddr() net.Addr {
+	if allocation := rc.client.Allocation(); allocation != nil {
+		return allocation.RelayAddr
+	}
+	return rc.client.LocalAddr()
+}
+
+// RemoteAddr returns the peer's address
+func (rc *relayConn) RemoteAddr() net.Addr {
+	return rc.remote
+}
+
+// SetDeadline sets the read and write deadlines
+func (rc *relayConn) SetDeadline(t time.Time) error {
+	if err := rc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return rc.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future Read calls. A zero value
+// means reads never time out.
+func (rc *relayConn) SetReadDeadline(t time.Time) error {
+	rc.mu.Lock()
+	rc.readDeadline = t
+	close(rc.deadlineChanged)
+	rc.deadlineChanged = make(chan struct{})
+	rc.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline on the client's socket
+func (rc *relayConn) SetWriteDeadline(t time.Time) error {
+	return rc.client.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,154 @@
+package relay
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNetConnRoundTrip(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	allocation, err := client.Allocate(10 * time.Minute)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	var peers []*net.UDPConn
+	for i := 0; i < 2; i++ {
+		peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		if err != nil {
+			t.Fatalf("Failed to create peer: %v", err)
+		}
+		defer peer.Close()
+		peers = append(peers, peer)
+	}
+	peerAddr := peers[0].LocalAddr().(*net.UDPAddr)
+
+	if err := client.CreatePermission(peerAddr); err != nil {
+		t.Fatalf("CreatePermission failed: %v", err)
+	}
+
+	conn := client.NetConn(peerAddr)
+	defer conn.Close()
+
+	if conn.LocalAddr().String() != allocation.RelayAddr.String() {
+		t.Errorf("LocalAddr = %s, want relay %s", conn.LocalAddr(), allocation.RelayAddr)
+	}
+	if conn.RemoteAddr().String() != peerAddr.String() {
+		t.Errorf("RemoteAddr = %s, want %s", conn.RemoteAddr(), peerAddr)
+	}
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	peers[0].SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := peers[0].ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Peer read failed: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("Peer received %q, want %q", buf[:n], "hello")
+	}
+
+	// Data relayed from another sender must be dropped
+	peers[1].WriteToUDP([]byte("other"), allocation.RelayAddr)
+	peers[0].WriteToUDP([]byte("hello back"), allocation.RelayAddr)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err = conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf[:n]) != "hello back" {
+		t.Errorf("Read = %q, want %q", buf[:n], "hello back")
+	}
+}
+
+func TestNetConnReadDeadline(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	if _, err := client.Allocate(10 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	conn := client.NetConn(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345})
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 64))
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Read error = %v, want a timeout", err)
+	}
+}
+
+func TestNetConnDeadlineAndCloseWakeRead(t *testing.T) {
+	server := startTestTURNServer(t)
+
+	client, err := NewClient(DefaultClientConfig(server.addr()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	if _, err := client.Allocate(10 * time.Minute); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+
+	conn := client.NetConn(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345})
+	defer conn.Close()
+
+	// Read blocks with no deadline until SetReadDeadline from another
+	// goroutine expires it
+	read := func() <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			_, err := conn.Read(make([]byte, 64))
+			result <- err
+		}()
+		return result
+	}
+
+	result := read()
+	time.Sleep(50 * time.Millisecond)
+	conn.SetReadDeadline(time.Now())
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Read error = %v, want os.ErrDeadlineExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SetReadDeadline did not unblock Read")
+	}
+
+	// Close unblocks a Read too
+	conn.SetReadDeadline(time.Time{})
+	result = read()
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Read error = %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not unblock Read")
+	}
+}
@@ -0,0 +1,138 @@
+package relay
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// permissionLifetime is how long a TURN server keeps a permission
+// (RFC 5766 fixes it at 300 seconds)
+var permissionLifetime = 5 * time.Minute
+
+// permissionRefreshInterval is how often the auto refresh loop renews
+// permissions, leaving a minute of slack before they expire
+var permissionRefreshInterval = 4 * time.Minute
+
+// Permission is a peer IP the server accepts relayed data from
+type Permission struct {
+	IP        net.IP
+	ExpiresAt time.Time
+}
+
+// IsValid checks if the permission is still live
+func (p Permission) IsValid() bool {
+	return time.Now().Before(p.ExpiresAt)
+}
+
+// CreatePermission installs permissions on the server so the peers' IPs
+// may send data to our relay address. All peers go in a single request.
+// Permissions last 5 minutes; see RefreshPermissions.
+func (c *Client) CreatePermission(peers ...*net.UDPAddr) error {
+	if len(peers) == 0 {
+		return fmt.Errorf("no peers given")
+	}
+
+	return c.createPermissions(peers)
+}
+
+// RefreshPermissions renews every permission created so far, including
+// those that have already expired, in a single request
+func (c *Client) RefreshPermissions() error {
+	c.mu.RLock()
+	peers := make([]*net.UDPAddr, 0, len(c.permissions))
+	for _, p := range c.permissions {
+		peers = append(peers, &net.UDPAddr{IP: p.IP})
+	}
+	c.mu.RUnlock()
+
+	if len(peers) == 0 {
+		return nil
+	}
+
+	return c.createPermissions(peers)
+}
+
+// Permissions returns the permissions created so far, ordered by IP
+func (c *Client) Permissions() []Permission {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	permissions := make([]Permission, 0, len(c.permissions))
+	for _, p := range c.permissions {
+		permissions = append(permissions, p)
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i].IP.String() < permissions[j].IP.String()
+	})
+	return permissions
+}
+
+// createPermissions sends a CreatePermission request for peers and records
+// them on success
+func (c *Client) createPermissions(peers []*net.UDPAddr) error {
+	allocation, err := c.liveAllocation()
+	if err != nil {
+		return err
+	}
+
+	response, err := c.transaction(stun.TypeCreatePermissionRequest, func(request *stun.Message) {
+		for _, peer := range peers {
+			request.AddAttribute(stun.EncodeXORAddress(stun.AttrXORPeerAddress, peer, request.TransactionID))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("create permission: %w", err)
+	}
+	if response.Type != stun.TypeCreatePermissionSuccess {
+		return responseError("create permission", response)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.allocation == allocation {
+		c.addPermissions(peers...)
+	}
+	return nil
+}
+
+// addPermissions records fresh permissions for peers. The caller must
+// hold c.mu.
+func (c *Client) addPermissions(peers ...*net.UDPAddr) {
+	expiresAt := time.Now().Add(permissionLifetime)
+	for _, peer := range peers {
+		c.permissions[peer.IP.String()] = Permission{IP: peer.IP, ExpiresAt: expiresAt}
+	}
+}
+
+// hasPermission reports whether peer's IP has a live permission
+func (c *Client) hasPermission(peer *net.UDPAddr) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	p, found := c.permissions[peer.IP.String()]
+	return found && p.IsValid()
+}
+
+func (c *Client) permissionRefreshLoop(stop chan struct{}) {
+	ticker := time.NewTicker(permissionRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := c.RefreshPermissions(); err != nil {
+			if c.onRefreshError != nil {
+				c.onRefreshError(err)
+			}
+		}
+	}
+}
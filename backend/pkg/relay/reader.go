@@ -0,0 +1,150 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// recvQueueSize bounds relayed datagrams waiting for Receive; more are
+// dropped, as a full socket buffer would
+const recvQueueSize = 256
+
+// errClientClosed is returned by reads on a closed client
+var errClientClosed = fmt.Errorf("client is closed: %w", net.ErrClosed)
+
+// errWoken is returned by next when its wake channel is closed
+var errWoken = errors.New("read woken")
+
+// datagram is relayed data queued for Receive. Data from a channel keeps
+// its number so Receive resolves the peer against current bindings.
+type datagram struct {
+	data    []byte
+	peer    *net.UDPAddr
+	channel uint16
+}
+
+// readLoop owns the socket: it queues relayed data for Receive and hands
+// STUN responses to the transaction waiting on their ID, so refreshes and
+// other requests can run while the application is receiving.
+func (c *Client) readLoop() {
+	defer close(c.readDone)
+
+	buf := make([]byte, c.recvBufSize)
+	for {
+		n, _, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			c.readErr = fmt.Errorf("failed to receive data: %w", err)
+			return
+		}
+
+		if channel, data, ok := stun.DecodeChannelData(buf[:n]); ok {
+			c.enqueue(datagram{data: append([]byte(nil), data...), channel: channel})
+			continue
+		}
+
+		msg, err := stun.Decode(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if msg.Type == stun.TypeDataIndication {
+			if d, ok := dataIndication(msg); ok {
+				c.enqueue(d)
+			}
+			continue
+		}
+
+		c.pendingMu.Lock()
+		waiter, found := c.pending[msg.TransactionID]
+		c.pendingMu.Unlock()
+		if found {
+			select {
+			case waiter <- append([]byte(nil), buf[:n]...):
+			default:
+			}
+		}
+	}
+}
+
+// dataIndication extracts the peer and data from a TURN Data indication
+func dataIndication(msg *stun.Message) (datagram, bool) {
+	peerAttr, found := msg.GetAttribute(stun.AttrXORPeerAddress)
+	if !found {
+		return datagram{}, false
+	}
+	peer, err := stun.DecodeXORAddress(peerAttr, msg.TransactionID)
+	if err != nil {
+		return datagram{}, false
+	}
+
+	dataAttr, found := msg.GetAttribute(stun.AttrData)
+	if !found {
+		return datagram{}, false
+	}
+
+	// Decode already copied the attribute value
+	return datagram{data: dataAttr.Value, peer: peer}, true
+}
+
+// enqueue queues relayed data for Receive, dropping it when the queue is full
+func (c *Client) enqueue(d datagram) {
+	select {
+	case c.recvQueue <- d:
+	default:
+	}
+}
+
+// await registers a transaction ID so readLoop delivers its response.
+// The returned function unregisters it.
+func (c *Client) await(id [stun.TransactionIDSize]byte) (<-chan []byte, func()) {
+	waiter := make(chan []byte, 1)
+
+	c.pendingMu.Lock()
+	c.pending[id] = waiter
+	c.pendingMu.Unlock()
+
+	return waiter, func() {
+		c.pendingMu.Lock()
+		delete(c.pending, id)
+		c.pendingMu.Unlock()
+	}
+}
+
+// next waits for the next queued datagram until deadline (zero waits
+// forever) or until wake is closed. Timeouts satisfy net.Error's Timeout.
+func (c *Client) next(deadline time.Time, wake <-chan struct{}) (datagram, error) {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case d := <-c.recvQueue:
+		return d, nil
+	case <-c.closing:
+		return datagram{}, errClientClosed
+	case <-c.readDone:
+		if c.isClosed() {
+			return datagram{}, errClientClosed
+		}
+		return datagram{}, c.readErr
+	case <-wake:
+		return datagram{}, errWoken
+	case <-expired:
+		return datagram{}, fmt.Errorf("failed to receive data: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+// isClosed reports whether Close has been called
+func (c *Client) isClosed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closed
+}
@@ -0,0 +1,82 @@
+package relay
+
+import (
+	"fmt"
+	"time"
+)
+
+// minRefreshInterval keeps a tiny or zero server lifetime from turning the
+// refresh loop into a busy loop
+var minRefreshInterval = 500 * time.Millisecond
+
+// StartAutoRefresh keeps the current allocation alive by refreshing it at
+// half its remaining lifetime until Close is called. Refresh failures are
+// passed to ClientConfig.OnRefreshError; the loop gives up once the
+// allocation has expired. With ClientConfig.AutoRefreshPermissions set,
+// permissions are renewed too. Calling it while already running is a no-op.
+func (c *Client) StartAutoRefresh() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errClientClosed
+	}
+
+	if c.allocation == nil {
+		return fmt.Errorf("no allocation - call Allocate() first")
+	}
+
+	if c.refreshStop != nil {
+		return nil
+	}
+
+	c.refreshStop = make(chan struct{})
+	go c.autoRefreshLoop(c.refreshStop)
+	if c.refreshPermissions {
+		go c.permissionRefreshLoop(c.refreshStop)
+	}
+
+	return nil
+}
+
+func (c *Client) autoRefreshLoop(stop chan struct{}) {
+	for {
+		wait, lifetime, ok := c.refreshSchedule()
+		if !ok {
+			return
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := c.Refresh(lifetime); err != nil {
+			if c.onRefreshError != nil {
+				c.onRefreshError(err)
+			}
+		}
+	}
+}
+
+// refreshSchedule returns how long to wait before the next refresh and the
+// lifetime to request, which is the last one the server granted rather than
+// the original request. ok is false once there is nothing left to refresh.
+func (c *Client) refreshSchedule() (wait, lifetime time.Duration, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed || !c.allocation.IsValid() {
+		return 0, 0, false
+	}
+
+	wait = c.allocation.TimeRemaining() / 2
+	if wait < minRefreshInterval {
+		wait = minRefreshInterval
+	}
+
+	return wait, c.allocation.Lifetime, true
+}
@@ -0,0 +1,34 @@
+package relay
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/stun"
+)
+
+// Error codes that trigger an authenticated retry
+const (
+	codeUnauthorized = 401
+	codeStaleNonce   = 438
+)
+
+// maxAuthRetries bounds retries after 401/438 so bad credentials fail fast
+const maxAuthRetries = 2
+
+// DefaultReceiveBufferSize fits any UDP datagram
+const DefaultReceiveBufferSize = 65536
+
+// releaseTimeout bounds how long Close waits for the server to confirm it
+// released the allocation
+var releaseTimeout = time.Second
+
+// responseError describes a TURN error response using its ERROR-CODE
+func responseError(op string, response *stun.Message) error {
+	if attr, found := response.GetAttribute(stun.AttrErrorCode); found {
+		if code, reason, err := stun.DecodeErrorCode(attr); err == nil {
+			return fmt.Errorf("%s failed: %d %s", op, code, reason)
+		}
+	}
+	return fmt.Errorf("%s failed: unexpected response 0x%04X", op, uint16(response.Type))
+}
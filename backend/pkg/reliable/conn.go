@@ -0,0 +1,557 @@
+// Package reliable provides reliable, ordered byte streams over a datagram
+// connection such as a punched or relayed UDP path.
+//
+// It is a small sliding-window ARQ rather than full TCP: segments carry
+// sequence numbers, the receiver sends cumulative ACKs and buffers
+// out-of-order segments, and the sender retransmits any segment that goes
+// unacknowledged for too long. ACKs advertise how much more the receiver
+// will buffer, so a sender never gets more than a window ahead of the
+// application's Reads. There is no congestion control.
+package reliable
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// maxDatagramSize bounds the datagrams read from the underlying conn
+	maxDatagramSize = 65535
+
+	// maxBackoffShift caps exponential retransmit backoff at 32x the base
+	// timeout
+	maxBackoffShift = 5
+)
+
+// Config holds configuration for a reliable connection. Both ends should use
+// the same WindowSize and SegmentSize.
+type Config struct {
+	// Maximum number of unacknowledged segments in flight
+	WindowSize int
+
+	// Maximum payload bytes per segment. Keep header plus payload below the
+	// path MTU to avoid IP fragmentation.
+	SegmentSize int
+
+	// Base time to wait for an ACK before retransmitting. It doubles on
+	// each retransmit of the same segment.
+	RetransmitTimeout time.Duration
+
+	// Retransmits of a single segment before the connection is considered
+	// broken
+	MaxRetransmits int
+
+	// How long Close waits for outstanding data to be acknowledged
+	CloseTimeout time.Duration
+}
+
+// DefaultConfig returns a configuration with sensible defaults
+func DefaultConfig() *Config {
+	return &Config{
+		WindowSize:        32,
+		SegmentSize:       1200,
+		RetransmitTimeout: 200 * time.Millisecond,
+		MaxRetransmits:    10,
+		CloseTimeout:      5 * time.Second,
+	}
+}
+
+// pending is a sent segment awaiting acknowledgement
+type pending struct {
+	seq     uint32
+	data    []byte
+	sentAt  time.Time
+	retries int
+}
+
+// Conn is a reliable, ordered net.Conn over a datagram connection
+type Conn struct {
+	conn net.Conn
+
+	windowSize     int
+	segmentSize    int
+	rto            time.Duration
+	maxRetransmits int
+	closeTimeout   time.Duration
+
+	mu sync.Mutex
+
+	// Send side: unacked is ordered by sequence number; the peer takes
+	// segments up to acked+peerWindow
+	nextSeq    uint32
+	unacked    []*pending
+	acked      uint32
+	peerWindow uint32
+
+	// Receive side. Once readBuf holds readLimit bytes we advertise a zero
+	// window; when Read reopens it the update is resent until more data
+	// arrives, in case it was lost.
+	expected     uint32
+	outOfOrder   map[uint32]segment
+	readBuf      bytes.Buffer
+	readLimit    int
+	eof          bool
+	windowClosed bool
+	update       *pending
+
+	err     error // fatal error, reported by every later call
+	closing bool
+	closed  bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// changed is closed and replaced whenever state that blocked callers
+	// wait on changes
+	changed chan struct{}
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New wraps a datagram connection bound to a single peer, such as
+// punch.Connection.NetConn or relay.Client.NetConn, in a reliable stream.
+// Each Read and Write on conn must carry exactly one datagram. The Conn
+// owns conn from now on and closes it on Close.
+func New(conn net.Conn, config *Config) (*Conn, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("connection cannot be nil")
+	}
+
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	if config.WindowSize <= 0 {
+		return nil, fmt.Errorf("window size must be positive")
+	}
+	if config.SegmentSize <= 0 || config.SegmentSize > maxDatagramSize-headerSize {
+		return nil, fmt.Errorf("invalid segment size %d", config.SegmentSize)
+	}
+	if config.RetransmitTimeout <= 0 {
+		return nil, fmt.Errorf("retransmit timeout must be positive")
+	}
+
+	c := &Conn{
+		conn:           conn,
+		windowSize:     config.WindowSize,
+		segmentSize:    config.SegmentSize,
+		rto:            config.RetransmitTimeout,
+		maxRetransmits: config.MaxRetransmits,
+		closeTimeout:   config.CloseTimeout,
+		peerWindow:     uint32(config.WindowSize),
+		outOfOrder:     make(map[uint32]segment),
+		readLimit:      config.WindowSize * config.SegmentSize,
+		changed:        make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+
+	c.wg.Add(2)
+	go c.readLoop()
+	go c.retransmitLoop()
+
+	return c, nil
+}
+
+// Read reads ordered stream data from the peer. It returns io.EOF once the
+// peer has closed and all of its data has been read.
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	c.mu.Lock()
+	for {
+		if c.closed {
+			c.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+
+		if c.readBuf.Len() > 0 {
+			n, _ := c.readBuf.Read(b)
+			update := c.reopenWindow()
+			c.mu.Unlock()
+
+			if update != nil {
+				c.conn.Write(update)
+			}
+			return n, nil
+		}
+
+		if c.eof {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		}
+
+		changed, deadline := c.changed, c.readDeadline
+		c.mu.Unlock()
+
+		if err := c.wait(changed, deadline); err != nil {
+			return 0, err
+		}
+		c.mu.Lock()
+	}
+}
+
+// Write splits b into segments and sends them, blocking while the window
+// is full. It returns once every segment is in flight, not once the peer
+// has acknowledged them.
+func (c *Conn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		end := min(written+c.segmentSize, len(b))
+		if err := c.sendSegment(segmentData, b[written:end]); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// Close sends FIN, waits up to CloseTimeout for outstanding data to be
+// acknowledged, then closes the underlying connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.closing = true
+	c.writeDeadline = time.Now().Add(c.closeTimeout)
+	peerDone := c.eof
+	c.broadcast()
+	c.mu.Unlock()
+
+	if err := c.sendSegment(segmentFin, nil); err == nil && !peerDone {
+		// Once the peer has sent its own FIN it may already be gone, so
+		// only linger while it can still acknowledge
+		c.flush()
+	}
+
+	c.mu.Lock()
+	c.closed = true
+	c.broadcast()
+	c.mu.Unlock()
+
+	close(c.done)
+	err := c.conn.Close()
+	c.wg.Wait()
+
+	return err
+}
+
+// LocalAddr returns the local address of the underlying connection
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the peer's address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.broadcast()
+	c.mu.Unlock()
+	return nil
+}
+
+// SetReadDeadline sets the deadline for pending and future Read calls
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.broadcast()
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for pending and future Write calls
+// blocked on a full window
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.broadcast()
+	c.mu.Unlock()
+	return nil
+}
+
+// sendSegment queues a segment once the window has room and sends it
+func (c *Conn) sendSegment(typ segmentType, payload []byte) error {
+	c.mu.Lock()
+	for {
+		if c.closed || (c.closing && typ != segmentFin) {
+			c.mu.Unlock()
+			return net.ErrClosed
+		}
+
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return err
+		}
+
+		// FIN carries no data, so only our own window holds it back
+		if len(c.unacked) < c.windowSize && (typ == segmentFin || seqBefore(c.nextSeq, c.acked+c.peerWindow)) {
+			break
+		}
+
+		changed, deadline := c.changed, c.writeDeadline
+		c.mu.Unlock()
+
+		if err := c.wait(changed, deadline); err != nil {
+			return err
+		}
+		c.mu.Lock()
+	}
+
+	seg := segment{typ: typ, seq: c.nextSeq, payload: payload}
+	p := &pending{seq: seg.seq, data: seg.encode(), sentAt: time.Now()}
+	c.nextSeq++
+	c.unacked = append(c.unacked, p)
+	c.mu.Unlock()
+
+	// A failed send is treated like a lost datagram; the retransmit loop
+	// retries it and eventually reports a broken connection
+	c.conn.Write(p.data)
+	return nil
+}
+
+// flush waits until every sent segment is acknowledged, the connection
+// fails, or the write deadline passes
+func (c *Conn) flush() {
+	c.mu.Lock()
+	for len(c.unacked) > 0 && c.err == nil {
+		changed, deadline := c.changed, c.writeDeadline
+		c.mu.Unlock()
+
+		if err := c.wait(changed, deadline); err != nil {
+			return
+		}
+		c.mu.Lock()
+	}
+	c.mu.Unlock()
+}
+
+// readLoop dispatches incoming segments until the connection closes
+func (c *Conn) readLoop() {
+	defer c.wg.Done()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			select {
+			case <-c.done:
+			default:
+				c.fail(fmt.Errorf("read failed: %w", err))
+			}
+			return
+		}
+
+		seg, ok := decodeSegment(buf[:n])
+		if !ok {
+			continue
+		}
+
+		switch seg.typ {
+		case segmentAck:
+			c.handleAck(seg.seq, decodeWindow(seg.payload, uint32(c.windowSize)))
+		case segmentData, segmentFin:
+			c.handleData(seg)
+		}
+	}
+}
+
+// handleData buffers a data or FIN segment, delivers whatever is now in
+// order, and acknowledges everything received so far
+func (c *Conn) handleData(seg segment) {
+	c.mu.Lock()
+	window := c.expected + uint32(c.windowSize)
+	if !seqBefore(seg.seq, c.expected) && seqBefore(seg.seq, window) {
+		c.outOfOrder[seg.seq] = seg
+
+		delivered := false
+		for {
+			next, found := c.outOfOrder[c.expected]
+			if !found {
+				break
+			}
+			delete(c.outOfOrder, c.expected)
+			c.expected++
+			delivered = true
+
+			if next.typ == segmentFin {
+				c.eof = true
+			} else {
+				c.readBuf.Write(next.payload)
+			}
+		}
+
+		if delivered {
+			c.broadcast()
+		}
+	}
+	// The sender got data through, so any window update reached it
+	c.update = nil
+	ack := c.ack()
+	c.mu.Unlock()
+
+	// Duplicates are re-acknowledged in case our earlier ACK was lost
+	c.conn.Write(ack)
+}
+
+// ack encodes an ACK for everything received so far, advertising the room
+// left in readBuf. Caller must hold mu.
+func (c *Conn) ack() []byte {
+	window := 0
+	if free := c.readLimit - c.readBuf.Len(); free > 0 {
+		window = min(c.windowSize, free/c.segmentSize)
+	}
+	c.windowClosed = window == 0
+
+	return segment{typ: segmentAck, seq: c.expected, payload: encodeWindow(uint32(window))}.encode()
+}
+
+// reopenWindow returns a window update to send once Read has made room
+// after a zero window, arming its retransmission. Caller must hold mu.
+func (c *Conn) reopenWindow() []byte {
+	if !c.windowClosed || c.readLimit-c.readBuf.Len() < c.segmentSize {
+		return nil
+	}
+
+	update := c.ack()
+	c.update = &pending{seq: c.expected, data: update, sentAt: time.Now()}
+	return update
+}
+
+// handleAck drops every segment the peer has acknowledged and takes its
+// advertised window. ACKs older than one already seen are ignored.
+func (c *Conn) handleAck(ack, window uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if seqBefore(ack, c.acked) {
+		return
+	}
+	c.acked = ack
+	c.peerWindow = window
+
+	acked := 0
+	for acked < len(c.unacked) && seqBefore(c.unacked[acked].seq, ack) {
+		acked++
+	}
+	c.unacked = c.unacked[acked:]
+
+	// A changed window may unblock a writer even when nothing was acked
+	c.broadcast()
+}
+
+// retransmitLoop resends segments whose ACK is overdue and fails the
+// connection once a segment exhausts its retransmits
+func (c *Conn) retransmitLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.rto / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		var resend [][]byte
+
+		c.mu.Lock()
+		for _, p := range c.unacked {
+			timeout := c.rto << min(p.retries, maxBackoffShift)
+			if now.Sub(p.sentAt) < timeout {
+				continue
+			}
+
+			if p.retries >= c.maxRetransmits {
+				if c.err == nil {
+					c.err = fmt.Errorf("segment %d unacknowledged after %d retransmits", p.seq, p.retries)
+				}
+				c.broadcast()
+				c.mu.Unlock()
+				return
+			}
+
+			p.retries++
+			p.sentAt = now
+			resend = append(resend, p.data)
+		}
+
+		// Window updates back off the same way but just stop when
+		// exhausted; the peer may simply have nothing more to send
+		if u := c.update; u != nil && now.Sub(u.sentAt) >= c.rto<<min(u.retries, maxBackoffShift) {
+			if u.retries >= c.maxRetransmits {
+				c.update = nil
+			} else {
+				u.retries++
+				u.sentAt = now
+				resend = append(resend, c.ack())
+			}
+		}
+		c.mu.Unlock()
+
+		for _, data := range resend {
+			c.conn.Write(data)
+		}
+	}
+}
+
+// fail records a fatal error and wakes blocked callers
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.broadcast()
+	c.mu.Unlock()
+}
+
+// broadcast wakes every caller waiting on state changes. Caller must hold mu.
+func (c *Conn) broadcast() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// wait blocks until changed is closed or the deadline passes
+func (c *Conn) wait(changed <-chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(remaining)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-changed:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
@@ -0,0 +1,282 @@
+package reliable
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saintparish4/altair/internal/conntest"
+)
+
+// lossyConn drops every dropEvery-th datagram written through it
+type lossyConn struct {
+	net.Conn
+	dropEvery int64
+	writes    atomic.Int64
+}
+
+func (lc *lossyConn) Write(b []byte) (int, error) {
+	if lc.writes.Add(1)%lc.dropEvery == 0 {
+		return len(b), nil
+	}
+	return lc.Conn.Write(b)
+}
+
+func testConfig() *Config {
+	config := DefaultConfig()
+	config.RetransmitTimeout = 20 * time.Millisecond
+	config.CloseTimeout = time.Second
+	return config
+}
+
+func newPair(t *testing.T, a, b net.Conn) (*Conn, *Conn) {
+	t.Helper()
+
+	connA, err := New(a, testConfig())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	connB, err := New(b, testConfig())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return connA, connB
+}
+
+// transfer writes size random bytes on one side and checks the other side
+// reads them back intact and in order
+func transfer(t *testing.T, from, to *Conn, size int) {
+	t.Helper()
+
+	data := make([]byte, size)
+	rand.Read(data)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := from.Write(data)
+		writeErr <- err
+	}()
+
+	to.SetReadDeadline(time.Now().Add(10 * time.Second))
+	received := make([]byte, size)
+	if _, err := io.ReadFull(to, received); err != nil {
+		t.Fatalf("ReadFull failed: %v", err)
+	}
+
+	if err := <-writeErr; err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("Received data does not match sent data")
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	if _, err := New(nil, nil); err == nil {
+		t.Error("New should reject a nil connection")
+	}
+
+	a, b := conntest.PeerConns(t)
+	defer a.Close()
+	defer b.Close()
+
+	for _, config := range []*Config{
+		{WindowSize: 0, SegmentSize: 1200, RetransmitTimeout: time.Second},
+		{WindowSize: 8, SegmentSize: 0, RetransmitTimeout: time.Second},
+		{WindowSize: 8, SegmentSize: maxDatagramSize, RetransmitTimeout: time.Second},
+		{WindowSize: 8, SegmentSize: 1200, RetransmitTimeout: 0},
+	} {
+		if _, err := New(a, config); err == nil {
+			t.Errorf("New should reject config %+v", config)
+		}
+	}
+}
+
+func TestConnRoundTrip(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	connA, connB := newPair(t, a, b)
+	defer connA.Close()
+	defer connB.Close()
+
+	transfer(t, connA, connB, 200*1024)
+	transfer(t, connB, connA, 1000)
+}
+
+func TestConnRetransmitsLostSegments(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	connA, connB := newPair(t,
+		&lossyConn{Conn: a, dropEvery: 3},
+		&lossyConn{Conn: b, dropEvery: 4})
+	defer connA.Close()
+	defer connB.Close()
+
+	transfer(t, connA, connB, 100*1024)
+}
+
+func TestConnCloseDeliversEOF(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	connA, connB := newPair(t, a, b)
+	defer connB.Close()
+
+	if _, err := connA.Write([]byte("last words")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := connA.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	connB.SetReadDeadline(time.Now().Add(time.Second))
+	data, err := io.ReadAll(connB)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(data) != "last words" {
+		t.Errorf("Read %q, want %q", data, "last words")
+	}
+
+	if _, err := connA.Write([]byte("more")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Write after Close = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	connA, connB := newPair(t, a, b)
+	defer connA.Close()
+	defer connB.Close()
+
+	connA.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := connA.Read(make([]byte, 16))
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Read error = %v, want a timeout", err)
+	}
+}
+
+func TestConnBrokenPeer(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	b.Close() // nobody will ever acknowledge
+
+	config := testConfig()
+	config.MaxRetransmits = 2
+	config.CloseTimeout = 0
+
+	conn, err := New(a, config)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hello?")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 16))
+
+	var netErr net.Error
+	if err == nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+		t.Errorf("Read error = %v, want a broken connection error", err)
+	}
+}
+
+func TestConnFlowControl(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+	// Lose some of the receiver's ACKs, window updates included
+	connA, connB := newPair(t, a, &lossyConn{Conn: b, dropEvery: 5})
+	defer connA.Close()
+	defer connB.Close()
+
+	data := make([]byte, 1<<20)
+	rand.Read(data)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := connA.Write(data)
+		writeErr <- err
+	}()
+
+	// Nobody reads for a while: the writer stalls instead of filling the
+	// receiver's memory
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case err := <-writeErr:
+		t.Fatalf("Write of %d bytes finished with no reader (err %v)", len(data), err)
+	default:
+	}
+
+	connB.mu.Lock()
+	buffered := connB.readBuf.Len()
+	connB.mu.Unlock()
+	if limit := 2 * connB.readLimit; buffered > limit {
+		t.Errorf("Receiver buffered %d bytes, want at most %d", buffered, limit)
+	}
+
+	// A slow reader still gets everything, in order
+	connB.SetReadDeadline(time.Now().Add(20 * time.Second))
+	received := make([]byte, 0, len(data))
+	buf := make([]byte, 64*1024)
+	for len(received) < len(data) {
+		n, err := connB.Read(buf)
+		if err != nil {
+			t.Fatalf("Read failed after %d bytes: %v", len(received), err)
+		}
+		received = append(received, buf[:n]...)
+	}
+
+	if err := <-writeErr; err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("Received data does not match sent data")
+	}
+}
+
+func TestSegmentEncodeDecode(t *testing.T) {
+	original := segment{typ: segmentData, seq: 0xDEADBEEF, payload: []byte("payload")}
+
+	decoded, ok := decodeSegment(original.encode())
+	if !ok {
+		t.Fatal("decodeSegment rejected an encoded segment")
+	}
+	if decoded.typ != original.typ || decoded.seq != original.seq ||
+		!bytes.Equal(decoded.payload, original.payload) {
+		t.Errorf("decodeSegment = %+v, want %+v", decoded, original)
+	}
+
+	for _, raw := range [][]byte{
+		nil,
+		[]byte("PING"),
+		[]byte("KALV\x00\x00"),
+		{segmentMagic, 9, 0, 0, 0, 1},
+	} {
+		if _, ok := decodeSegment(raw); ok {
+			t.Errorf("decodeSegment(%q) should fail", raw)
+		}
+	}
+}
+
+func TestSeqBefore(t *testing.T) {
+	tests := []struct {
+		a, b     uint32
+		expected bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{5, 5, false},
+		{0xFFFFFFFF, 0, true}, // wraparound
+		{0, 0xFFFFFFFF, false},
+	}
+
+	for _, tt := range tests {
+		if result := seqBefore(tt.a, tt.b); result != tt.expected {
+			t.Errorf("seqBefore(%d, %d) = %v, want %v", tt.a, tt.b, result, tt.expected)
+		}
+	}
+}
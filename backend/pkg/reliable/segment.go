@@ -0,0 +1,82 @@
+package reliable
+
+import "encoding/binary"
+
+// Wire format: magic (1 byte) | type (1 byte) | sequence number (4 bytes) |
+// payload. Sequence numbers count segments, not bytes, and wrap (see
+// seqBefore). The magic is allocated alongside punch's packet magic.
+const (
+	segmentMagic byte = 0xA7
+	headerSize        = 6
+)
+
+type segmentType byte
+
+const (
+	// segmentData carries payload bytes
+	segmentData segmentType = 1
+
+	// segmentAck acknowledges every segment before its sequence number.
+	// Its payload is the receive window: how many segments from that
+	// number on the receiver will take (see encodeWindow).
+	segmentAck segmentType = 2
+
+	// segmentFin marks the end of the sender's stream. It occupies a
+	// sequence number so it is delivered in order after all data.
+	segmentFin segmentType = 3
+)
+
+type segment struct {
+	typ     segmentType
+	seq     uint32
+	payload []byte
+}
+
+// encode serializes the segment
+func (s segment) encode() []byte {
+	buf := make([]byte, headerSize+len(s.payload))
+	buf[0] = segmentMagic
+	buf[1] = byte(s.typ)
+	binary.BigEndian.PutUint32(buf[2:6], s.seq)
+	copy(buf[headerSize:], s.payload)
+	return buf
+}
+
+// decodeSegment parses a datagram, reporting false if it isn't a segment.
+// The payload is copied so buf may be reused.
+func decodeSegment(buf []byte) (segment, bool) {
+	if len(buf) < headerSize || buf[0] != segmentMagic {
+		return segment{}, false
+	}
+
+	typ := segmentType(buf[1])
+	if typ != segmentData && typ != segmentAck && typ != segmentFin {
+		return segment{}, false
+	}
+
+	return segment{
+		typ:     typ,
+		seq:     binary.BigEndian.Uint32(buf[2:6]),
+		payload: append([]byte(nil), buf[headerSize:]...),
+	}, true
+}
+
+// encodeWindow is the payload of an ACK advertising window segments
+func encodeWindow(window uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, window)
+}
+
+// decodeWindow reads an ACK's advertised window. ACKs without one leave
+// the sender's full window open.
+func decodeWindow(payload []byte, full uint32) uint32 {
+	if len(payload) < 4 {
+		return full
+	}
+	return binary.BigEndian.Uint32(payload)
+}
+
+// seqBefore reports whether sequence number a comes before b, allowing
+// for wraparound
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
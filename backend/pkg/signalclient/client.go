@@ -0,0 +1,556 @@
+// Package signalclient is a client for the signaling server in
+// internal/signaling. It joins rooms, lists peers and exchanges
+// OFFER/ANSWER/CANDIDATE messages over the server's WebSocket JSON
+// protocol (see pkg/signaling/protocol).
+package signalclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+)
+
+// textMessage is the WebSocket text frame type, as numbered by
+// gorilla/websocket; every protocol message is sent as one
+const textMessage = 1
+
+// Conn abstracts a client WebSocket connection for testability.
+// This interface is satisfied by *websocket.Conn from gorilla/websocket.
+type Conn interface {
+	WriteMessage(messageType int, data []byte) error
+	ReadMessage() (messageType int, p []byte, err error)
+	Close() error
+	SetWriteDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+}
+
+// Dialer opens a WebSocket connection to the signaling server
+type Dialer interface {
+	Dial(ctx context.Context, url string, header http.Header) (Conn, error)
+}
+
+// newBuiltinDialer creates the dialer compiled in with -tags websocket
+// (see gorilla.go). It is nil in builds without WebSocket support.
+var newBuiltinDialer func() Dialer
+
+// ErrClosed is returned by requests on a closed client
+var ErrClosed = errors.New("signaling client closed")
+
+// Error is an ERROR message returned by the server
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("signaling error %s: %s", e.Code, e.Message)
+}
+
+// Config holds configuration for the signaling client
+type Config struct {
+	// Dialer for the WebSocket connection (optional). Defaults to
+	// gorilla/websocket when built with -tags websocket.
+	Dialer Dialer
+
+	// Token sent as an "Authorization: Bearer" header (optional)
+	Token string
+
+	// Timeout for connecting, each request and each write
+	Timeout time.Duration
+
+	// Interval between KEEP_ALIVE requests. A keepalive that isn't
+	// acknowledged within Timeout closes the client. Zero uses
+	// DefaultKeepAlive; negative disables keepalives. (WebSocket pings
+	// from the server are answered by the WebSocket library.)
+	KeepAlive time.Duration
+
+	// Optional handlers for server notifications. A message with a handler
+	// goes to it instead of Messages, so code that reads OFFER, ANSWER or
+	// CANDIDATE from Messages (such as altair's connect paths) must not set
+	// those. Handlers run on the read goroutine, so they must not block or
+	// make requests such as Join or Discover.
+	OnPeerJoined func(peer protocol.PeerInfo)
+	OnPeerLeft   func(peerID string)
+	OnOffer      func(from string, offer protocol.OfferPayload)
+	OnAnswer     func(from string, answer protocol.AnswerPayload)
+	OnCandidate  func(from string, candidate protocol.CandidatePayload)
+}
+
+// DefaultTimeout is the default timeout for connecting and requests
+const DefaultTimeout = 10 * time.Second
+
+// DefaultKeepAlive is the default interval between KEEP_ALIVE requests
+const DefaultKeepAlive = 30 * time.Second
+
+// messageQueueSize is how many unsolicited messages are buffered
+const messageQueueSize = 64
+
+// Client is a connection to a signaling server
+type Client struct {
+	conn    Conn
+	peerID  string
+	timeout time.Duration
+	config  Config // Handlers
+
+	writeMu sync.Mutex // One writer at a time on the WebSocket
+	reqMu   sync.Mutex // One outstanding request at a time
+
+	mu      sync.Mutex
+	pending *pendingRequest
+	roomID  string
+	err     error // Why the read loop stopped
+
+	messages  chan *protocol.Message
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// pendingRequest waits for the response to a request
+type pendingRequest struct {
+	id       string
+	response chan *protocol.Message
+}
+
+// Connect dials the signaling server at url (e.g. ws://host:8080/ws) and
+// waits for the welcome ACK that assigns our peer ID
+func Connect(url string, config *Config) (*Client, error) {
+	if config == nil {
+		config = &Config{}
+	}
+
+	dialer := config.Dialer
+	if dialer == nil {
+		if newBuiltinDialer == nil {
+			return nil, fmt.Errorf("WebSocket support is not compiled in: rebuild with -tags websocket or set Config.Dialer")
+		}
+		dialer = newBuiltinDialer()
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	keepAlive := config.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = DefaultKeepAlive
+	}
+
+	header := http.Header{}
+	if config.Token != "" {
+		header.Set("Authorization", "Bearer "+config.Token)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := dialer.Dial(ctx, url, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", url, err)
+	}
+
+	peerID, err := readWelcome(conn, timeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &Client{
+		conn:     conn,
+		peerID:   peerID,
+		timeout:  timeout,
+		config:   *config,
+		messages: make(chan *protocol.Message, messageQueueSize),
+		done:     make(chan struct{}),
+	}
+	go c.readLoop()
+	if keepAlive > 0 {
+		go c.keepAliveLoop(keepAlive)
+	}
+
+	return c, nil
+}
+
+// readWelcome reads the server's first message, an ACK carrying the peer
+// ID it assigned us
+func readWelcome(conn Conn, timeout time.Duration) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return "", fmt.Errorf("failed to read welcome message: %w", err)
+	}
+
+	var msg protocol.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return "", fmt.Errorf("invalid welcome message: %w", err)
+	}
+
+	switch {
+	case msg.Type == protocol.MessageTypeError:
+		return "", errorFromMessage(&msg)
+	case msg.Type != protocol.MessageTypeAck || msg.PeerID == "":
+		return "", fmt.Errorf("unexpected welcome message: %s", msg.Type)
+	}
+
+	return msg.PeerID, nil
+}
+
+// readLoop routes responses to the pending request, notifications to
+// their handlers, and queues everything else on Messages until the
+// connection fails
+func (c *Client) readLoop() {
+	defer close(c.messages)
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		var msg protocol.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		if c.deliverResponse(&msg) || c.dispatch(&msg) {
+			continue
+		}
+
+		select {
+		case c.messages <- &msg:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// deliverResponse hands msg to the pending request if it answers it.
+// Responses, errors included, are matched by request ID only, so an error
+// about an earlier fire-and-forget message such as an OFFER goes to
+// Messages instead of failing an unrelated request.
+func (c *Client) deliverResponse(msg *protocol.Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending == nil || msg.RequestID == "" || msg.RequestID != c.pending.id {
+		return false
+	}
+
+	c.pending.response <- msg
+	c.pending = nil
+	return true
+}
+
+// dispatch passes msg to its handler, if one is set. Malformed messages
+// for a handler are dropped.
+func (c *Client) dispatch(msg *protocol.Message) bool {
+	switch msg.Type {
+	case protocol.MessageTypePeerJoined:
+		if c.config.OnPeerJoined == nil {
+			return false
+		}
+		var peer protocol.PeerInfo
+		if msg.ParsePayload(&peer) == nil {
+			c.config.OnPeerJoined(peer)
+		}
+
+	case protocol.MessageTypePeerLeft:
+		if c.config.OnPeerLeft == nil {
+			return false
+		}
+		c.config.OnPeerLeft(msg.PeerID)
+
+	case protocol.MessageTypeOffer:
+		if c.config.OnOffer == nil {
+			return false
+		}
+		var offer protocol.OfferPayload
+		if msg.ParsePayload(&offer) == nil {
+			c.config.OnOffer(msg.PeerID, offer)
+		}
+
+	case protocol.MessageTypeAnswer:
+		if c.config.OnAnswer == nil {
+			return false
+		}
+		var answer protocol.AnswerPayload
+		if msg.ParsePayload(&answer) == nil {
+			c.config.OnAnswer(msg.PeerID, answer)
+		}
+
+	case protocol.MessageTypeCandidate:
+		if c.config.OnCandidate == nil {
+			return false
+		}
+		var candidate protocol.CandidatePayload
+		if msg.ParsePayload(&candidate) == nil {
+			c.config.OnCandidate(msg.PeerID, candidate)
+		}
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// keepAliveLoop sends KEEP_ALIVE requests and closes the client if the
+// server stops acknowledging them
+func (c *Client) keepAliveLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		_, err := c.request(protocol.NewMessage(protocol.MessageTypeKeepAlive))
+
+		// Any ERROR reply still shows the server is alive
+		var sigErr *Error
+		if err == nil || errors.As(err, &sigErr) {
+			continue
+		}
+		if !errors.Is(err, ErrClosed) {
+			c.fail(fmt.Errorf("keepalive failed: %w", err))
+		}
+		return
+	}
+}
+
+// fail records why the connection stopped and shuts the client down
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		// Closed by Close; the read error is expected
+	default:
+		c.err = err
+	}
+	c.mu.Unlock()
+
+	c.Close()
+}
+
+// request sends msg and waits for its response
+func (c *Client) request(msg *protocol.Message) (*protocol.Message, error) {
+	c.reqMu.Lock()
+	defer c.reqMu.Unlock()
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	msg.RequestID = id
+
+	pending := &pendingRequest{id: id, response: make(chan *protocol.Message, 1)}
+	c.mu.Lock()
+	c.pending = pending
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		if c.pending == pending {
+			c.pending = nil
+		}
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(msg); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case response := <-pending.response:
+		if response.Type == protocol.MessageTypeError {
+			return nil, errorFromMessage(response)
+		}
+		return response, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%s request timed out after %v", msg.Type, c.timeout)
+	case <-c.done:
+		return nil, c.closedErr()
+	}
+}
+
+// send writes msg to the server
+func (c *Client) send(msg *protocol.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+
+	select {
+	case <-c.done:
+		return c.closedErr()
+	default:
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if err := c.conn.WriteMessage(textMessage, data); err != nil {
+		return fmt.Errorf("failed to send %s: %w", msg.Type, err)
+	}
+	return nil
+}
+
+// Join joins roomID, publishing our display name and public endpoint
+// (both optional), and returns the other peers already in the room
+func (c *Client) Join(roomID, displayName string, endpoint *protocol.Endpoint) ([]protocol.PeerInfo, error) {
+	msg := protocol.NewMessage(protocol.MessageTypeJoin).
+		WithRoomID(roomID).
+		WithPayload(protocol.JoinPayload{
+			DisplayName: displayName,
+			Endpoint:    endpoint,
+		})
+
+	response, err := c.request(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join room %s: %w", roomID, err)
+	}
+
+	var list protocol.PeerListPayload
+	if err := response.ParsePayload(&list); err != nil {
+		return nil, fmt.Errorf("invalid join response: %w", err)
+	}
+
+	c.mu.Lock()
+	c.roomID = roomID
+	c.mu.Unlock()
+
+	return c.otherPeers(list.Peers), nil
+}
+
+// Discover returns the other peers in our room
+func (c *Client) Discover() ([]protocol.PeerInfo, error) {
+	response, err := c.request(protocol.NewMessage(protocol.MessageTypeDiscover).WithRoomID(c.RoomID()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover peers: %w", err)
+	}
+
+	var list protocol.PeerListPayload
+	if err := response.ParsePayload(&list); err != nil {
+		return nil, fmt.Errorf("invalid peer list: %w", err)
+	}
+
+	return c.otherPeers(list.Peers), nil
+}
+
+// otherPeers drops ourselves from a room's peer list
+func (c *Client) otherPeers(peers []protocol.PeerInfo) []protocol.PeerInfo {
+	others := make([]protocol.PeerInfo, 0, len(peers))
+	for _, peer := range peers {
+		if peer.PeerID != c.peerID {
+			others = append(others, peer)
+		}
+	}
+	return others
+}
+
+// SendOffer sends a connection offer to targetID
+func (c *Client) SendOffer(targetID string, offer protocol.OfferPayload) error {
+	return c.send(protocol.NewMessage(protocol.MessageTypeOffer).
+		WithTargetID(targetID).
+		WithPayload(offer))
+}
+
+// SendAnswer answers an offer from targetID
+func (c *Client) SendAnswer(targetID string, answer protocol.AnswerPayload) error {
+	return c.send(protocol.NewMessage(protocol.MessageTypeAnswer).
+		WithTargetID(targetID).
+		WithPayload(answer))
+}
+
+// SendCandidate sends an additional endpoint candidate to targetID
+func (c *Client) SendCandidate(targetID string, candidate protocol.CandidatePayload) error {
+	return c.send(protocol.NewMessage(protocol.MessageTypeCandidate).
+		WithTargetID(targetID).
+		WithPayload(candidate))
+}
+
+// Messages returns server messages that aren't responses to our requests
+// and have no handler in Config: PEER_JOINED, PEER_LEFT, OFFER, ANSWER,
+// CANDIDATE, BROADCAST and unsolicited ERRORs. The channel is closed when the connection ends.
+// It must be drained; the client stops reading while it is full.
+func (c *Client) Messages() <-chan *protocol.Message {
+	return c.messages
+}
+
+// PeerID returns the ID the server assigned us
+func (c *Client) PeerID() string {
+	return c.peerID
+}
+
+// RoomID returns the room we last joined, or "" if none
+func (c *Client) RoomID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.roomID
+}
+
+// Err returns why the connection ended, or nil if it is open or was
+// closed by Close
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// closedErr describes why requests can no longer be made
+func (c *Client) closedErr() error {
+	if err := c.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+	return ErrClosed
+}
+
+// Close disconnects from the server. The server tells our room we left.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// errorFromMessage converts an ERROR message to an *Error
+func errorFromMessage(msg *protocol.Message) error {
+	var payload protocol.ErrorPayload
+	if err := msg.ParsePayload(&payload); err != nil {
+		return &Error{Code: protocol.ErrorCodeInternal, Message: "malformed error message"}
+	}
+	return &Error{Code: payload.Code, Message: payload.Message}
+}
+
+// randomID returns a random hex identifier for requests and sessions
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewSessionID returns a random ID for an OFFER/ANSWER exchange
+func NewSessionID() (string, error) {
+	return randomID()
+}
@@ -0,0 +1,408 @@
+package signalclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintparish4/altair/internal/signaling"
+	"github.com/saintparish4/altair/internal/signaling/signalingtest"
+	"github.com/saintparish4/altair/pkg/signalclient"
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+)
+
+// newTestDialer returns a dialer for a fresh in-memory signaling server
+func newTestDialer(t *testing.T) (*signalingtest.Dialer, *signaling.Handler) {
+	t.Helper()
+
+	handler := signaling.NewHandler(signaling.NewRegistry(), signaling.NewRoomManager())
+	handler.Logger = nil
+	return signalingtest.NewDialer(handler), handler
+}
+
+// connect opens a client that is closed when the test ends
+func connect(t *testing.T, dialer signalclient.Dialer) *signalclient.Client {
+	t.Helper()
+
+	c, err := signalclient.Connect("ws://signaling.test/ws", &signalclient.Config{Dialer: dialer, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// nextMessage waits for c's next unsolicited message of type msgType
+func nextMessage(t *testing.T, c *signalclient.Client, msgType protocol.MessageType) *protocol.Message {
+	t.Helper()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.Messages():
+			if !ok {
+				t.Fatalf("connection closed waiting for %s", msgType)
+			}
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", msgType)
+		}
+	}
+}
+
+func TestConnectAssignsPeerID(t *testing.T) {
+	dialer, _ := newTestDialer(t)
+
+	a := connect(t, dialer)
+	b := connect(t, dialer)
+
+	if a.PeerID() == "" || b.PeerID() == "" {
+		t.Fatal("expected peer IDs from the welcome message")
+	}
+	if a.PeerID() == b.PeerID() {
+		t.Errorf("both clients got peer ID %s", a.PeerID())
+	}
+}
+
+func TestConnectWithoutWebSocket(t *testing.T) {
+	if signalclient.HasBuiltinDialer() {
+		t.Skip("built with -tags websocket")
+	}
+
+	if _, err := signalclient.Connect("ws://signaling.test/ws", nil); err == nil {
+		t.Error("expected an error without a dialer")
+	}
+}
+
+func TestConnectRejected(t *testing.T) {
+	dialer, handler := newTestDialer(t)
+	handler.TokenValidator = func(token string) (string, bool) {
+		return "", token == "good"
+	}
+
+	_, err := signalclient.Connect("ws://signaling.test/ws", &signalclient.Config{Dialer: dialer, Token: "bad", Timeout: time.Second})
+	if err == nil {
+		t.Fatal("expected a bad token to be rejected")
+	}
+
+	c, err := signalclient.Connect("ws://signaling.test/ws", &signalclient.Config{Dialer: dialer, Token: "good", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Connect with a good token failed: %v", err)
+	}
+	c.Close()
+}
+
+func TestJoinAndDiscover(t *testing.T) {
+	dialer, _ := newTestDialer(t)
+	a := connect(t, dialer)
+	b := connect(t, dialer)
+
+	peers, err := a.Join("room", "alice", &protocol.Endpoint{IP: "203.0.113.1", Port: 4000})
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if len(peers) != 0 {
+		t.Errorf("expected an empty room, got %d peers", len(peers))
+	}
+	if a.RoomID() != "room" {
+		t.Errorf("RoomID = %q, want room", a.RoomID())
+	}
+
+	peers, err = b.Join("room", "bob", nil)
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if len(peers) != 1 || peers[0].PeerID != a.PeerID() {
+		t.Fatalf("expected only alice in the room, got %+v", peers)
+	}
+	if peers[0].DisplayName != "alice" || peers[0].Endpoint == nil || peers[0].Endpoint.Port != 4000 {
+		t.Errorf("unexpected peer info: %+v", peers[0])
+	}
+
+	joined := nextMessage(t, a, protocol.MessageTypePeerJoined)
+	if joined.PeerID != b.PeerID() {
+		t.Errorf("PEER_JOINED for %s, want %s", joined.PeerID, b.PeerID())
+	}
+
+	peers, err = a.Discover()
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if len(peers) != 1 || peers[0].PeerID != b.PeerID() {
+		t.Errorf("expected only bob, got %+v", peers)
+	}
+}
+
+func TestJoinError(t *testing.T) {
+	dialer, _ := newTestDialer(t)
+	c := connect(t, dialer)
+
+	_, err := c.Join("", "", nil)
+
+	var sigErr *signalclient.Error
+	if !errors.As(err, &sigErr) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if sigErr.Code != protocol.ErrorCodeInvalidMessage {
+		t.Errorf("Code = %s, want %s", sigErr.Code, protocol.ErrorCodeInvalidMessage)
+	}
+}
+
+func TestUnrelatedErrorDoesNotFailRequest(t *testing.T) {
+	dialer, _ := newTestDialer(t)
+	c := connect(t, dialer)
+
+	// The PEER_NOT_FOUND for this offer races with the Join below
+	if err := c.SendOffer("nobody", protocol.OfferPayload{SessionID: "s1"}); err != nil {
+		t.Fatalf("SendOffer failed: %v", err)
+	}
+	if _, err := c.Join("room-1", "", nil); err != nil {
+		t.Fatalf("Join failed on an error meant for the offer: %v", err)
+	}
+
+	msg := nextMessage(t, c, protocol.MessageTypeError)
+	var payload protocol.ErrorPayload
+	if err := msg.ParsePayload(&payload); err != nil || payload.Code != protocol.ErrorCodePeerNotFound {
+		t.Errorf("expected PEER_NOT_FOUND on Messages, got %s", msg.Payload)
+	}
+}
+
+func TestOfferAnswer(t *testing.T) {
+	dialer, _ := newTestDialer(t)
+	a := connect(t, dialer)
+	b := connect(t, dialer)
+
+	offer := protocol.OfferPayload{
+		Endpoint:    protocol.Endpoint{IP: "203.0.113.1", Port: 4000},
+		SessionID:   "session",
+		InitiatorID: a.PeerID(),
+	}
+	if err := a.SendOffer(b.PeerID(), offer); err != nil {
+		t.Fatalf("SendOffer failed: %v", err)
+	}
+
+	msg := nextMessage(t, b, protocol.MessageTypeOffer)
+	var gotOffer protocol.OfferPayload
+	if err := msg.ParsePayload(&gotOffer); err != nil {
+		t.Fatalf("invalid offer: %v", err)
+	}
+	if msg.PeerID != a.PeerID() || gotOffer != offer {
+		t.Errorf("got offer %+v from %s", gotOffer, msg.PeerID)
+	}
+
+	answer := protocol.AnswerPayload{
+		Endpoint:  protocol.Endpoint{IP: "198.51.100.2", Port: 5000},
+		SessionID: "session",
+		Accepted:  true,
+	}
+	if err := b.SendAnswer(a.PeerID(), answer); err != nil {
+		t.Fatalf("SendAnswer failed: %v", err)
+	}
+
+	msg = nextMessage(t, a, protocol.MessageTypeAnswer)
+	var gotAnswer protocol.AnswerPayload
+	if err := msg.ParsePayload(&gotAnswer); err != nil {
+		t.Fatalf("invalid answer: %v", err)
+	}
+	if msg.PeerID != b.PeerID() || gotAnswer != answer {
+		t.Errorf("got answer %+v from %s", gotAnswer, msg.PeerID)
+	}
+}
+
+func TestClose(t *testing.T) {
+	dialer, _ := newTestDialer(t)
+	a := connect(t, dialer)
+	b := connect(t, dialer)
+
+	if _, err := a.Join("room", "", nil); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if _, err := b.Join("room", "", nil); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+
+	b.Close()
+
+	left := nextMessage(t, a, protocol.MessageTypePeerLeft)
+	if left.PeerID != b.PeerID() {
+		t.Errorf("PEER_LEFT for %s, want %s", left.PeerID, b.PeerID())
+	}
+
+	if _, err := b.Discover(); !errors.Is(err, signalclient.ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+	if b.Err() != nil {
+		t.Errorf("expected no error after Close, got %v", b.Err())
+	}
+
+	select {
+	case _, ok := <-b.Messages():
+		for ok {
+			_, ok = <-b.Messages()
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Messages not closed after Close")
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	dialer, _ := newTestDialer(t)
+
+	joined := make(chan protocol.PeerInfo, 1)
+	left := make(chan string, 1)
+	offers := make(chan protocol.OfferPayload, 1)
+	candidates := make(chan protocol.CandidatePayload, 1)
+
+	a, err := signalclient.Connect("ws://signaling.test/ws", &signalclient.Config{
+		Dialer:       dialer,
+		Timeout:      2 * time.Second,
+		OnPeerJoined: func(peer protocol.PeerInfo) { joined <- peer },
+		OnPeerLeft:   func(peerID string) { left <- peerID },
+		OnOffer:      func(from string, offer protocol.OfferPayload) { offers <- offer },
+		OnCandidate: func(from string, candidate protocol.CandidatePayload) {
+			candidates <- candidate
+		},
+	})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer a.Close()
+	b := connect(t, dialer)
+
+	if _, err := a.Join("room", "", nil); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if _, err := b.Join("room", "bob", nil); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+
+	select {
+	case peer := <-joined:
+		if peer.PeerID != b.PeerID() || peer.DisplayName != "bob" {
+			t.Errorf("OnPeerJoined got %+v", peer)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnPeerJoined not called")
+	}
+
+	offer := protocol.OfferPayload{SessionID: "session", InitiatorID: b.PeerID()}
+	if err := b.SendOffer(a.PeerID(), offer); err != nil {
+		t.Fatalf("SendOffer failed: %v", err)
+	}
+	select {
+	case got := <-offers:
+		if got != offer {
+			t.Errorf("OnOffer got %+v, want %+v", got, offer)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnOffer not called")
+	}
+
+	candidate := protocol.CandidatePayload{
+		SessionID: "session",
+		Endpoint:  protocol.Endpoint{IP: "192.168.1.20", Port: 4000},
+		Priority:  10,
+	}
+	if err := b.SendCandidate(a.PeerID(), candidate); err != nil {
+		t.Fatalf("SendCandidate failed: %v", err)
+	}
+	select {
+	case got := <-candidates:
+		if got != candidate {
+			t.Errorf("OnCandidate got %+v, want %+v", got, candidate)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnCandidate not called")
+	}
+
+	b.Close()
+	select {
+	case peerID := <-left:
+		if peerID != b.PeerID() {
+			t.Errorf("OnPeerLeft got %s, want %s", peerID, b.PeerID())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnPeerLeft not called")
+	}
+
+	// Handled messages don't also go to Messages
+	select {
+	case msg := <-a.Messages():
+		t.Errorf("unexpected message on Messages: %s", msg.Type)
+	default:
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	registry := signaling.NewRegistry()
+	rooms := signaling.NewRoomManager()
+	handler := signaling.NewHandler(registry, rooms)
+	handler.Logger = nil
+	dialer := signalingtest.NewDialer(handler)
+
+	c, err := signalclient.Connect("ws://signaling.test/ws", &signalclient.Config{
+		Dialer:    dialer,
+		Timeout:   time.Second,
+		KeepAlive: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if c.Err() != nil {
+		t.Fatalf("client failed: %v", c.Err())
+	}
+
+	var metrics strings.Builder
+	handler.Metrics().WritePrometheus(&metrics, registry, rooms)
+	if !strings.Contains(metrics.String(), `type="KEEP_ALIVE"`) {
+		t.Error("server never received a KEEP_ALIVE")
+	}
+}
+
+// silentDialer returns connections that send a welcome and then nothing
+type silentDialer struct{}
+
+func (silentDialer) Dial(ctx context.Context, url string, header http.Header) (signalclient.Conn, error) {
+	client, server := signalingtest.NewPipe()
+	welcome, _ := json.Marshal(protocol.NewMessage(protocol.MessageTypeAck).WithPeerID("silent"))
+	server.WriteMessage(signaling.TextMessage, welcome)
+	return client, nil
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	c, err := signalclient.Connect("ws://signaling.test/ws", &signalclient.Config{
+		Dialer:    silentDialer{},
+		Timeout:   50 * time.Millisecond,
+		KeepAlive: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+
+	select {
+	case _, ok := <-c.Messages():
+		if ok {
+			t.Fatal("unexpected message")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("client not closed after unanswered keepalive")
+	}
+
+	if c.Err() == nil {
+		t.Error("expected Err to report the keepalive failure")
+	}
+	if _, err := c.Discover(); !errors.Is(err, signalclient.ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+package signalclient
+
+// HasBuiltinDialer reports whether Connect has a dialer when Config.Dialer
+// is nil, for the external tests
+func HasBuiltinDialer() bool {
+	return newBuiltinDialer != nil
+}
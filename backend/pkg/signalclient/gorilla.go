@@ -0,0 +1,39 @@
+//go:build websocket
+// +build websocket
+
+// This file provides the gorilla/websocket dialer for the signaling client.
+// Build with: go build -tags websocket
+
+package signalclient
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Register the dialer so Connect uses it when Config.Dialer is nil
+func init() {
+	newBuiltinDialer = func() Dialer {
+		// Offer permessage-deflate; servers without it simply decline
+		dialer := *websocket.DefaultDialer
+		dialer.EnableCompression = true
+		return &GorillaDialer{Dialer: &dialer}
+	}
+}
+
+// GorillaDialer adapts websocket.Dialer to our Dialer interface.
+// Server pings are answered by gorilla's default ping handler.
+type GorillaDialer struct {
+	*websocket.Dialer
+}
+
+// Dial implements the Dialer interface.
+func (g *GorillaDialer) Dial(ctx context.Context, url string, header http.Header) (Conn, error) {
+	conn, _, err := g.Dialer.DialContext(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
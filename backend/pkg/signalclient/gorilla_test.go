@@ -0,0 +1,54 @@
+//go:build websocket
+
+package signalclient_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintparish4/altair/internal/signaling"
+	"github.com/saintparish4/altair/pkg/signalclient"
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+)
+
+func TestGorillaDialer(t *testing.T) {
+	handler := signaling.NewHandler(signaling.NewRegistry(), signaling.NewRoomManager())
+	handler.Logger = nil
+	handler.SetUpgrader(signaling.NewGorillaUpgrader())
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	a, err := signalclient.Connect(url, &signalclient.Config{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer a.Close()
+	b, err := signalclient.Connect(url, &signalclient.Config{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer b.Close()
+
+	if _, err := a.Join("room", "alice", nil); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	peers, err := b.Join("room", "bob", nil)
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if len(peers) != 1 || peers[0].PeerID != a.PeerID() {
+		t.Fatalf("expected only alice in the room, got %+v", peers)
+	}
+
+	if err := b.SendOffer(a.PeerID(), protocol.OfferPayload{SessionID: "session"}); err != nil {
+		t.Fatalf("SendOffer failed: %v", err)
+	}
+	msg := nextMessage(t, a, protocol.MessageTypeOffer)
+	if msg.PeerID != b.PeerID() {
+		t.Errorf("OFFER from %s, want %s", msg.PeerID, b.PeerID())
+	}
+}
@@ -1,11 +1,12 @@
-// Package signaling implements a WebSocket-based signaling server for P2P coordination.
-// It enables peers to discover each other and exchange endpoint information for NAT traversal.
-
-package signaling
+// Package protocol defines the JSON messages exchanged between signaling
+// clients and the signaling server: the Message envelope, its types, and
+// the payload each type carries. See internal/signaling/SIGNALING.md.
+package protocol
 
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 )
 
@@ -21,6 +22,11 @@ const (
 	MessageTypeCandidate MessageType = "CANDIDATE"  // Exchange endpoint candidates
 	MessageTypeDiscover  MessageType = "DISCOVER"   // Request list of peers in room
 	MessageTypeKeepAlive MessageType = "KEEP_ALIVE" // Keep connection alive
+	MessageTypeAuth      MessageType = "AUTH"       // Authenticate with a token
+	MessageTypeBroadcast MessageType = "BROADCAST"  // Relay payload to everyone else in the room
+
+	// Client -> Server request, answered with the same type
+	MessageTypeTURNCredentials MessageType = "TURN_CREDENTIALS" // Request relay credentials
 
 	// Server -> Client messages
 	MessageTypePeerJoined MessageType = "PEER_JOINED" // Notification: peer joined room
@@ -92,6 +98,44 @@ func (m *Message) WithRequestID(id string) *Message {
 type JoinPayload struct {
 	DisplayName string    `json:"display_name,omitempty"` // Optional human-readable name
 	Endpoint    *Endpoint `json:"endpoint,omitempty"`     // Public endpoint if already known
+	Password    string    `json:"password,omitempty"`     // Room password; sets it if creating the room
+
+	// Metadata is shared with other peers in PEER_LIST and PEER_JOINED
+	// (avatar URL, capabilities, protocol version, ...)
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Limits on JoinPayload.Metadata so peers can't bloat every room message
+const (
+	MaxMetadataEntries = 16   // Maximum number of keys
+	MaxMetadataSize    = 1024 // Maximum total bytes of keys and values
+)
+
+// ValidateMetadata checks metadata against MaxMetadataEntries and
+// MaxMetadataSize.
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > MaxMetadataEntries {
+		return fmt.Errorf("metadata has %d entries, max %d", len(metadata), MaxMetadataEntries)
+	}
+
+	size := 0
+	for key, value := range metadata {
+		if key == "" {
+			return fmt.Errorf("metadata keys must not be empty")
+		}
+		size += len(key) + len(value)
+	}
+	if size > MaxMetadataSize {
+		return fmt.Errorf("metadata is %d bytes, max %d", size, MaxMetadataSize)
+	}
+
+	return nil
+}
+
+// AuthPayload is sent with AUTH messages when the token isn't on the
+// WebSocket request.
+type AuthPayload struct {
+	Token string `json:"token"`
 }
 
 // Endpoint represents a network endpoint (IP:Port).
@@ -127,12 +171,23 @@ type CandidatePayload struct {
 	Priority  int      `json:"priority,omitempty"` // Higher = preferred
 }
 
+// TURNCredentialsPayload carries time-limited TURN credentials in response
+// to TURN_CREDENTIALS.
+type TURNCredentialsPayload struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	TTL      int      `json:"ttl"` // Seconds until the credentials expire
+	Realm    string   `json:"realm,omitempty"`
+	URIs     []string `json:"uris,omitempty"`
+}
+
 // PeerInfo describes a peer for PEER_LIST and PEER_JOINED messages.
 type PeerInfo struct {
-	PeerID      string    `json:"peer_id"`
-	DisplayName string    `json:"display_name,omitempty"`
-	Endpoint    *Endpoint `json:"endpoint,omitempty"`
-	JoinedAt    int64     `json:"joined_at"` // Unix timestamp
+	PeerID      string            `json:"peer_id"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Endpoint    *Endpoint         `json:"endpoint,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	JoinedAt    int64             `json:"joined_at"` // Unix timestamp
 }
 
 // PeerListPayload is sent in response to DISCOVER.
@@ -172,6 +227,34 @@ func NewErrorMessage(code, message string) *Message {
 type AckPayload struct {
 	RequestID string `json:"request_id,omitempty"`
 	Message   string `json:"message,omitempty"`
+
+	// Server identifies the server; only set on the welcome ACK
+	Server *ServerInfo `json:"server,omitempty"`
+}
+
+// ServerSoftware names this implementation in ServerInfo.
+const ServerSoftware = "altair-signaling"
+
+// Optional protocol features advertised in ServerInfo.Features.
+const (
+	FeatureTURNCredentials = "turn_credentials" // TURN_CREDENTIALS is configured
+	FeatureCandidateHold   = "candidate_hold"   // Trickled candidates wait for OFFER/ANSWER
+	FeatureImplicitRooms   = "implicit_rooms"   // JOIN creates missing rooms
+	FeatureAuth            = "auth"             // Connections need a token
+)
+
+// ServerInfo tells clients which server they reached, so they can adapt
+// to its version and features during rollouts.
+type ServerInfo struct {
+	Software   string   `json:"software"`
+	Version    string   `json:"version,omitempty"`
+	InstanceID string   `json:"instance_id,omitempty"`
+	Features   []string `json:"features,omitempty"`
+}
+
+// HasFeature reports whether the server advertised feature.
+func (i *ServerInfo) HasFeature(feature string) bool {
+	return slices.Contains(i.Features, feature)
 }
 
 // ParsePayload unmarshals the message payload into the provided type.
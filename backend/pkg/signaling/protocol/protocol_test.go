@@ -1,7 +1,8 @@
-package signaling
+package protocol
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 )
@@ -229,3 +230,31 @@ func TestAllErrorCodes(t *testing.T) {
 		seen[code] = true
 	}
 }
+
+func TestValidateMetadata(t *testing.T) {
+	many := make(map[string]string)
+	for i := 0; i <= MaxMetadataEntries; i++ {
+		many[string(rune('a'+i))] = "v"
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		wantErr  bool
+	}{
+		{"nil", nil, false},
+		{"small", map[string]string{"avatar": "https://example.com/a.png"}, false},
+		{"empty key", map[string]string{"": "v"}, true},
+		{"too many entries", many, true},
+		{"too large", map[string]string{"k": strings.Repeat("x", MaxMetadataSize)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMetadata(tt.metadata)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
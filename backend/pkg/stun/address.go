@@ -70,9 +70,39 @@ func DecodeMappedAddress(attr *Attribute) (*net.UDPAddr, error) {
 	if attr.Type != AttrMappedAddress {
 		return nil, fmt.Errorf("attribute is not MAPPED-ADDRESS")
 	}
+	return decodePlainAddress(attr)
+}
+
+// DecodeOtherAddress decodes an OTHER-ADDRESS attribute (the server's alternate address)
+func DecodeOtherAddress(attr *Attribute) (*net.UDPAddr, error) {
+	if attr.Type != AttrOtherAddress {
+		return nil, fmt.Errorf("attribute is not OTHER-ADDRESS")
+	}
+	return decodePlainAddress(attr)
+}
+
+// DecodeResponseOrigin decodes a RESPONSE-ORIGIN attribute (where the response was sent from)
+func DecodeResponseOrigin(attr *Attribute) (*net.UDPAddr, error) {
+	if attr.Type != AttrResponseOrigin {
+		return nil, fmt.Errorf("attribute is not RESPONSE-ORIGIN")
+	}
+	return decodePlainAddress(attr)
+}
+
+// DecodeAlternateServer decodes an ALTERNATE-SERVER attribute (where a
+// 300 Try Alternate response redirects the client)
+func DecodeAlternateServer(attr *Attribute) (*net.UDPAddr, error) {
+	if attr.Type != AttrAlternateServer {
+		return nil, fmt.Errorf("attribute is not ALTERNATE-SERVER")
+	}
+	return decodePlainAddress(attr)
+}
 
+// decodePlainAddress decodes the un-XORed address format shared by
+// MAPPED-ADDRESS, OTHER-ADDRESS, RESPONSE-ORIGIN and ALTERNATE-SERVER
+func decodePlainAddress(attr *Attribute) (*net.UDPAddr, error) {
 	if len(attr.Value) < 4 {
-		return nil, fmt.Errorf("MAPPED-ADDRESS value too short: %d bytes", len(attr.Value))
+		return nil, fmt.Errorf("%s value too short: %d bytes", attr.Type, len(attr.Value))
 	}
 
 	// Read family and port
@@ -188,3 +218,24 @@ func EncodeMappedAddress(addr *net.UDPAddr) Attribute {
 		Value:  value,
 	}
 }
+
+// EncodeOtherAddress creates an OTHER-ADDRESS attribute from an address
+func EncodeOtherAddress(addr *net.UDPAddr) Attribute {
+	attr := EncodeMappedAddress(addr)
+	attr.Type = AttrOtherAddress
+	return attr
+}
+
+// EncodeResponseOrigin creates a RESPONSE-ORIGIN attribute from an address
+func EncodeResponseOrigin(addr *net.UDPAddr) Attribute {
+	attr := EncodeMappedAddress(addr)
+	attr.Type = AttrResponseOrigin
+	return attr
+}
+
+// EncodeAlternateServer creates an ALTERNATE-SERVER attribute from an address
+func EncodeAlternateServer(addr *net.UDPAddr) Attribute {
+	attr := EncodeMappedAddress(addr)
+	attr.Type = AttrAlternateServer
+	return attr
+}
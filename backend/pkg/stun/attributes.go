@@ -0,0 +1,228 @@
+package stun
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// CHANGE-REQUEST flags (RFC 3489 section 11.2.4)
+const (
+	ChangeIPFlag   uint32 = 0x04 // Ask the server to respond from its alternate IP
+	ChangePortFlag uint32 = 0x02 // Ask the server to respond from its alternate port
+)
+
+// EncodeChangeRequest creates a CHANGE-REQUEST attribute with the given flags
+func EncodeChangeRequest(changeIP, changePort bool) Attribute {
+	var flags uint32
+	if changeIP {
+		flags |= ChangeIPFlag
+	}
+	if changePort {
+		flags |= ChangePortFlag
+	}
+
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, flags)
+
+	return Attribute{
+		Type:   AttrChangeRequest,
+		Length: uint16(len(value)),
+		Value:  value,
+	}
+}
+
+// DecodeChangeRequest decodes a CHANGE-REQUEST attribute into its flags
+func DecodeChangeRequest(attr *Attribute) (changeIP, changePort bool, err error) {
+	if attr.Type != AttrChangeRequest {
+		return false, false, fmt.Errorf("attribute is not CHANGE-REQUEST")
+	}
+
+	if len(attr.Value) < 4 {
+		return false, false, fmt.Errorf("CHANGE-REQUEST value too short: %d bytes", len(attr.Value))
+	}
+
+	flags := binary.BigEndian.Uint32(attr.Value[0:4])
+	return flags&ChangeIPFlag != 0, flags&ChangePortFlag != 0, nil
+}
+
+// AddChangeRequest adds a CHANGE-REQUEST attribute asking the server to
+// respond from a different IP and/or port
+func (m *Message) AddChangeRequest(changeIP, changePort bool) {
+	m.AddAttribute(EncodeChangeRequest(changeIP, changePort))
+}
+
+// AddSoftware adds a SOFTWARE attribute describing the client
+func (m *Message) AddSoftware(software string) {
+	m.AddStringAttribute(AttrSoftware, software)
+}
+
+// EncodeWithFingerprint encodes the message with a trailing FINGERPRINT
+// attribute. The message itself is not modified.
+func (m *Message) EncodeWithFingerprint() ([]byte, error) {
+	// FINGERPRINT must be last and is counted in the header length
+	withFingerprint := *m
+	withFingerprint.Attributes = append(append([]Attribute(nil), m.Attributes...), Attribute{
+		Type:   AttrFingerprint,
+		Length: 4,
+		Value:  make([]byte, 4),
+	})
+
+	buf, err := withFingerprint.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	// CRC covers everything before the FINGERPRINT attribute
+	crc := crc32.ChecksumIEEE(buf[:len(buf)-8]) ^ FingerprintXOR
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], crc)
+
+	return buf, nil
+}
+
+// VerifyFingerprint checks the FINGERPRINT attribute at the end of an encoded message
+func VerifyFingerprint(data []byte) error {
+	if len(data) < HeaderSize+8 {
+		return fmt.Errorf("message too short for FINGERPRINT: %d bytes", len(data))
+	}
+
+	attrStart := len(data) - 8
+	if AttributeType(binary.BigEndian.Uint16(data[attrStart:attrStart+2])) != AttrFingerprint {
+		return fmt.Errorf("FINGERPRINT attribute not found")
+	}
+
+	expected := crc32.ChecksumIEEE(data[:attrStart]) ^ FingerprintXOR
+	actual := binary.BigEndian.Uint32(data[len(data)-4:])
+	if actual != expected {
+		return fmt.Errorf("FINGERPRINT mismatch: expected 0x%08X, got 0x%08X", expected, actual)
+	}
+
+	return nil
+}
+
+// EncodeErrorCode creates an ERROR-CODE attribute (RFC 5389 section 15.6)
+func EncodeErrorCode(code int, reason string) Attribute {
+	value := make([]byte, 4+len(reason))
+	value[2] = byte(code / 100)
+	value[3] = byte(code % 100)
+	copy(value[4:], reason)
+
+	return Attribute{
+		Type:   AttrErrorCode,
+		Length: uint16(len(value)),
+		Value:  value,
+	}
+}
+
+// DecodeErrorCode decodes an ERROR-CODE attribute into its numeric code
+// (e.g. 401) and reason phrase
+func DecodeErrorCode(attr *Attribute) (code int, reason string, err error) {
+	if attr.Type != AttrErrorCode {
+		return 0, "", fmt.Errorf("attribute is not ERROR-CODE")
+	}
+
+	if len(attr.Value) < 4 {
+		return 0, "", fmt.Errorf("ERROR-CODE value too short: %d bytes", len(attr.Value))
+	}
+
+	class := int(attr.Value[2] & 0x07)
+	number := int(attr.Value[3])
+	return class*100 + number, string(attr.Value[4:]), nil
+}
+
+// messageIntegritySize is the length of the HMAC-SHA1 in MESSAGE-INTEGRITY
+const messageIntegritySize = 20
+
+// LongTermKey derives the long-term credential key
+// MD5(username ":" realm ":" password) (RFC 5389 section 15.4)
+func LongTermKey(username, realm, password string) []byte {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return sum[:]
+}
+
+// AddStringAttribute adds a text attribute such as USERNAME, REALM or NONCE
+func (m *Message) AddStringAttribute(attrType AttributeType, value string) {
+	m.AddAttribute(Attribute{
+		Type:   attrType,
+		Length: uint16(len(value)),
+		Value:  []byte(value),
+	})
+}
+
+// EncodeWithIntegrity encodes the message with a trailing MESSAGE-INTEGRITY
+// attribute keyed with key. The message itself is not modified.
+func (m *Message) EncodeWithIntegrity(key []byte) ([]byte, error) {
+	// The header length must include MESSAGE-INTEGRITY when computing the HMAC
+	withIntegrity := *m
+	withIntegrity.Attributes = append(append([]Attribute(nil), m.Attributes...), Attribute{
+		Type:   AttrMessageIntegrity,
+		Length: messageIntegritySize,
+		Value:  make([]byte, messageIntegritySize),
+	})
+
+	buf, err := withIntegrity.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	// HMAC covers everything before the MESSAGE-INTEGRITY attribute
+	mac := hmac.New(sha1.New, key)
+	mac.Write(buf[:len(buf)-4-messageIntegritySize])
+	copy(buf[len(buf)-messageIntegritySize:], mac.Sum(nil))
+
+	return buf, nil
+}
+
+// VerifyMessageIntegrity checks the MESSAGE-INTEGRITY attribute of an
+// encoded message against key. Attributes after it (e.g. FINGERPRINT)
+// are ignored as the RFC requires.
+func VerifyMessageIntegrity(data []byte, key []byte) error {
+	if len(data) < HeaderSize {
+		return fmt.Errorf("message too short: %d bytes", len(data))
+	}
+
+	// Locate MESSAGE-INTEGRITY
+	offset := HeaderSize
+	end := HeaderSize + int(binary.BigEndian.Uint16(data[2:4]))
+	if end > len(data) {
+		end = len(data)
+	}
+	for {
+		if offset+4 > end {
+			return fmt.Errorf("MESSAGE-INTEGRITY attribute not found")
+		}
+
+		attrType := AttributeType(binary.BigEndian.Uint16(data[offset : offset+2]))
+		attrLen := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		if attrType == AttrMessageIntegrity {
+			if attrLen != messageIntegritySize || offset+4+attrLen > end {
+				return fmt.Errorf("malformed MESSAGE-INTEGRITY attribute")
+			}
+			break
+		}
+
+		if pad := attrLen % 4; pad != 0 {
+			attrLen += 4 - pad
+		}
+		offset += 4 + attrLen
+	}
+
+	// Recompute over a header whose length ends at MESSAGE-INTEGRITY
+	signed := make([]byte, offset)
+	copy(signed, data[:offset])
+	binary.BigEndian.PutUint16(signed[2:4], uint16(offset+4+messageIntegritySize-HeaderSize))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(signed)
+	expected := mac.Sum(nil)
+
+	actual := data[offset+4 : offset+4+messageIntegritySize]
+	if !hmac.Equal(actual, expected) {
+		return fmt.Errorf("MESSAGE-INTEGRITY mismatch")
+	}
+
+	return nil
+}
@@ -1,9 +1,14 @@
 package stun
 
 import (
+	"bytes"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"net"
 	"time"
+
+	"github.com/saintparish4/altair/pkg/netutil"
 )
 
 // Endpoint represents a discovered network endpoint
@@ -11,74 +16,292 @@ type Endpoint struct {
 	LocalAddr  *net.UDPAddr
 	PublicAddr *net.UDPAddr
 	ServerAddr *net.UDPAddr
+	SourceAddr *net.UDPAddr // Address the response was received from
+
+	// Reported by RFC 5780 capable servers, nil otherwise
+	OtherAddr      *net.UDPAddr // Server's alternate address (OTHER-ADDRESS)
+	ResponseOrigin *net.UDPAddr // Address the server sent from (RESPONSE-ORIGIN)
+
+	// Time from sending the binding request to receiving its response
+	RTT time.Duration
 }
 
 // Client is a STUN client for discovering public endpoints
 type Client struct {
-	conn       *net.UDPConn
-	serverAddr *net.UDPAddr
-	timeout    time.Duration
+	conn        *net.UDPConn
+	serverAddr  *net.UDPAddr   // Server that answered most recently
+	serverAddrs []*net.UDPAddr // All resolved server addresses
+	fallbacks   []*net.UDPAddr // Resolved FallbackServers, for DiscoverWithRetry
+	timeout     time.Duration
+	backoff     time.Duration // First DiscoverWithRetry backoff
+	ownsConn    bool          // False when the caller supplied the connection
+	software    string        // SOFTWARE attribute value, empty to omit
+	fingerprint bool          // Whether to append FINGERPRINT to requests
 }
 
 // ClientConfig holds configuration for creating a STUN client
 type ClientConfig struct {
-	ServerAddr string        // STUN server address (host:port)
+	ServerAddr string        // STUN server address (host:port, or host to use the default port)
 	LocalAddr  string        // Optional local address to bind to
-	Timeout    time.Duration // Request timeout
+	Timeout    time.Duration // Request timeout (per server address)
+	UseSRV     bool          // Look up _stun._udp SRV records when ServerAddr has no port
+
+	// Network restricts the client to one address family: "udp4" or
+	// "udp6". Server addresses of the other family are dropped. Empty or
+	// "udp" allows both.
+	Network string
+
+	// Optional existing UDP connection to send requests from. Use this to
+	// discover the public mapping of a socket you will later punch with.
+	// LocalAddr is ignored and Close leaves the connection open.
+	Conn *net.UDPConn
+
+	// SOFTWARE attribute sent with requests (defaults to DefaultSoftware)
+	Software string
+
+	// Omit the FINGERPRINT attribute for servers that don't tolerate it
+	DisableFingerprint bool
+
+	// First DiscoverWithRetry backoff, doubled per retry up to
+	// netutil.MaxBackoff and jittered (optional, see netutil.Backoff)
+	RetryBackoff time.Duration
+
+	// Other servers DiscoverWithRetry rotates through when ServerAddr
+	// doesn't answer (optional). Discover and the RFC 5780 tests only use
+	// ServerAddr. Fallbacks that fail to resolve are skipped.
+	FallbackServers []string
+
+	// Ports to bind the client's socket from (optional), for firewalls
+	// that only allow a range. Applies when LocalAddr leaves the port 0.
+	// Share one scanner between clients, punchers and relays.
+	PortRange *netutil.PortScanner
 }
 
 // DefaultTimeout is the default timeout for STUN requests
 const DefaultTimeout = 5 * time.Second
 
+// DefaultSoftware is the SOFTWARE attribute value sent by default
+const DefaultSoftware = "Altair/1.0"
+
+// ErrTimeout is returned when no response arrives before the request timeout
+var ErrTimeout = errors.New("STUN request timed out")
+
+// codeTryAlternate is the error code of a response redirecting the client
+// to its ALTERNATE-SERVER (RFC 5389 section 11)
+const codeTryAlternate = 300
+
+// redirectError reports a 300 Try Alternate response
+type redirectError struct {
+	from      *net.UDPAddr
+	alternate *net.UDPAddr
+}
+
+func (e *redirectError) Error() string {
+	return fmt.Sprintf("%s redirected to alternate server %s", e.from, e.alternate)
+}
+
 // NewClient creates a new STUN client
 func NewClient(config *ClientConfig) (*Client, error) {
 	if config.Timeout == 0 {
 		config.Timeout = DefaultTimeout
 	}
 
-	// Resolve server address
-	serverAddr, err := net.ResolveUDPAddr("udp", config.ServerAddr)
+	// Resolve all server addresses
+	serverAddrs, err := resolveServerAddrs(config.ServerAddr, config.UseSRV)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve server address: %w", err)
 	}
 
+	network := config.Network
+	if network == "" {
+		network = "udp"
+	}
+	serverAddrs, err = filterFamily(serverAddrs, network)
+	if err != nil {
+		return nil, fmt.Errorf("server %s: %w", config.ServerAddr, err)
+	}
+
+	var fallbacks []*net.UDPAddr
+	for _, server := range config.FallbackServers {
+		addrs, err := resolveServerAddrs(server, config.UseSRV)
+		if err != nil {
+			continue
+		}
+		if addrs, err = filterFamily(addrs, network); err == nil {
+			fallbacks = append(fallbacks, addrs...)
+		}
+	}
+
+	client := &Client{
+		serverAddr:  serverAddrs[0],
+		serverAddrs: serverAddrs,
+		fallbacks:   fallbacks,
+		timeout:     config.Timeout,
+		backoff:     config.RetryBackoff,
+		software:    config.Software,
+		fingerprint: !config.DisableFingerprint,
+	}
+	if client.software == "" {
+		client.software = DefaultSoftware
+	}
+
+	// Use existing connection if provided
+	if config.Conn != nil {
+		client.conn = config.Conn
+		return client, nil
+	}
+
 	// Create UDP connection
 	var localAddr *net.UDPAddr
 	if config.LocalAddr != "" {
-		localAddr, err = net.ResolveUDPAddr("udp", config.LocalAddr)
+		localAddr, err = net.ResolveUDPAddr(network, config.LocalAddr)
 		if err != nil {
 			return nil, fmt.Errorf("failed to resolve local address: %w", err)
 		}
 	}
 
-	conn, err := net.ListenUDP("udp", localAddr)
+	var conn *net.UDPConn
+	if config.PortRange != nil && (localAddr == nil || localAddr.Port == 0) {
+		var ip net.IP
+		if localAddr != nil {
+			ip = localAddr.IP
+		}
+		conn, err = config.PortRange.ListenUDP(network, ip)
+	} else {
+		conn, err = net.ListenUDP(network, localAddr)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create UDP connection: %w", err)
 	}
 
-	return &Client{
-		conn:       conn,
-		serverAddr: serverAddr,
-		timeout:    config.Timeout,
-	}, nil
+	client.conn = conn
+	client.ownsConn = true
+	return client, nil
 }
 
-// Discover performs endpoint discovery using a STUN binding request
+// Discover performs endpoint discovery using a STUN binding request.
+// If the server hostname resolved to several addresses they are tried in
+// order, starting with the one that answered last, until one responds.
+// The returned endpoint's ServerAddr is the address that answered.
 func (c *Client) Discover() (*Endpoint, error) {
-	// Create binding request
+	var lastErr error
+
+	for _, serverAddr := range c.candidates() {
+		request, err := c.newBindingRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		endpoint, err := c.roundTrip(request, serverAddr)
+		if err == nil {
+			c.serverAddr = serverAddr
+			return endpoint, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// DiscoverAt sends a binding request to a specific address from the client's
+// socket. RFC 5780 tests use this to query a server's alternate address
+// (OTHER-ADDRESS) while keeping the same local mapping.
+func (c *Client) DiscoverAt(serverAddr *net.UDPAddr) (*Endpoint, error) {
+	request, err := c.newBindingRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.roundTrip(request, serverAddr)
+}
+
+// candidates returns the server addresses to try, preferred address first
+func (c *Client) candidates() []*net.UDPAddr {
+	addrs := make([]*net.UDPAddr, 0, len(c.serverAddrs))
+	addrs = append(addrs, c.serverAddr)
+	for _, addr := range c.serverAddrs {
+		if addr != c.serverAddr {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// DiscoverWithChange sends a binding request carrying a CHANGE-REQUEST
+// attribute, asking the server to respond from its alternate IP and/or port.
+// The returned endpoint's SourceAddr reports where the response actually came
+// from, so callers can tell whether the server honored the request.
+// Returns ErrTimeout if no response arrives (for example because the NAT
+// filtered it).
+func (c *Client) DiscoverWithChange(changeIP, changePort bool) (*Endpoint, error) {
+	request, err := c.newBindingRequest()
+	if err != nil {
+		return nil, err
+	}
+	request.AddChangeRequest(changeIP, changePort)
+
+	return c.roundTrip(request, c.serverAddr)
+}
+
+// newBindingRequest creates a binding request carrying the client's SOFTWARE attribute
+func (c *Client) newBindingRequest() (*Message, error) {
 	request, err := NewMessage(TypeBindingRequest)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create binding request: %w", err)
 	}
+	request.AddSoftware(c.software)
+	return request, nil
+}
+
+// encode encodes a request, appending FINGERPRINT unless disabled
+func (c *Client) encode(request *Message) ([]byte, error) {
+	if c.fingerprint {
+		return request.EncodeWithFingerprint()
+	}
+	return request.Encode()
+}
 
+// roundTrip sends a binding request and waits for the matching response.
+// If the server redirects us with 300 Try Alternate, the request is sent
+// again to its ALTERNATE-SERVER, but only once so servers can't loop us.
+func (c *Client) roundTrip(request *Message, serverAddr *net.UDPAddr) (*Endpoint, error) {
+	endpoint, err := c.exchange(request, serverAddr)
+
+	var redirect *redirectError
+	if !errors.As(err, &redirect) {
+		return endpoint, err
+	}
+
+	// The retry is a new transaction with the same attributes
+	retry := *request
+	if _, err := rand.Read(retry.TransactionID[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate transaction ID: %w", err)
+	}
+
+	alternate := redirect.alternate
+	endpoint, err = c.exchange(&retry, alternate)
+	if errors.As(err, &redirect) {
+		return nil, fmt.Errorf("%v; not following a second redirect", redirect)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("alternate server %s: %w", alternate, err)
+	}
+	return endpoint, nil
+}
+
+// exchange sends one binding request and waits for the matching response.
+// Datagrams that are not STUN messages for this transaction are ignored, since
+// a response may arrive from an address other than the one we sent to.
+func (c *Client) exchange(request *Message, serverAddr *net.UDPAddr) (*Endpoint, error) {
 	// Encode message
-	data, err := request.Encode()
+	data, err := c.encode(request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode request: %w", err)
 	}
 
 	// Send request
-	_, err = c.conn.WriteToUDP(data, c.serverAddr)
+	sentAt := time.Now()
+	_, err = c.conn.WriteToUDP(data, serverAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -90,91 +313,175 @@ func (c *Client) Discover() (*Endpoint, error) {
 	defer c.conn.SetReadDeadline(time.Time{}) // Clear deadline
 
 	// Wait for response
-	buf := make([]byte, 1500) // MTU size
-	n, _, err := c.conn.ReadFromUDP(buf)
-	if err != nil {
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			return nil, fmt.Errorf("STUN request timed out after %v", c.timeout)
+	buf := make([]byte, MaxMessageSize)
+	var response *Message
+	var sourceAddr *net.UDPAddr
+	var invalid error // Why the last response for our transaction was dropped
+	for response == nil {
+		n, addr, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if invalid != nil {
+					return nil, fmt.Errorf("%w after %v: ignored response from %s: %w", ErrTimeout, c.timeout, serverAddr, invalid)
+				}
+				return nil, fmt.Errorf("%w after %v", ErrTimeout, c.timeout)
+			}
+			return nil, fmt.Errorf("failed to read response: %w", err)
 		}
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
 
-	// Decode response
-	response, err := Decode(buf[:n])
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
+		// Decode response, skipping anything that isn't ours
+		msg, err := Decode(buf[:n])
+		if err != nil {
+			if n >= HeaderSize && bytes.Equal(buf[8:HeaderSize], request.TransactionID[:]) {
+				invalid = err
+			}
+			continue
+		}
+		if msg.TransactionID != request.TransactionID {
+			continue
+		}
 
-	// Verify transaction ID matches
-	if response.TransactionID != request.TransactionID {
-		return nil, fmt.Errorf("transaction ID mismatch")
+		response = msg
+		sourceAddr = addr
 	}
+	rtt := time.Since(sentAt)
 
 	// Check response type
+	if response.Type == TypeBindingError {
+		return nil, errorResponse(response, serverAddr)
+	}
 	if response.Type != TypeBindingSuccess {
 		return nil, fmt.Errorf("received error response: %s", response.Type)
 	}
 
-	// Extract public address from XOR-MAPPED-ADDRESS
-	attr, found := response.GetAttribute(AttrXORMappedAddress)
+	publicAddr, err := publicAddrFromResponse(response, request.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := &Endpoint{
+		LocalAddr:  c.conn.LocalAddr().(*net.UDPAddr),
+		PublicAddr: publicAddr,
+		ServerAddr: serverAddr,
+		SourceAddr: sourceAddr,
+		RTT:        rtt,
+	}
+
+	// Optional RFC 5780 attributes; malformed values are ignored
+	if attr, found := response.GetAttribute(AttrOtherAddress); found {
+		endpoint.OtherAddr, _ = DecodeOtherAddress(attr)
+	}
+	if attr, found := response.GetAttribute(AttrResponseOrigin); found {
+		endpoint.ResponseOrigin, _ = DecodeResponseOrigin(attr)
+	}
+
+	return endpoint, nil
+}
+
+// errorResponse describes a binding error response, returning a
+// *redirectError for 300 Try Alternate with a usable ALTERNATE-SERVER
+func errorResponse(response *Message, serverAddr *net.UDPAddr) error {
+	attr, found := response.GetAttribute(AttrErrorCode)
 	if !found {
-		// Fallback to MAPPED-ADDRESS
-		attr, found = response.GetAttribute(AttrMappedAddress)
-		if !found {
-			return nil, fmt.Errorf("no address attribute in response")
+		return fmt.Errorf("received error response: %s", response.Type)
+	}
+	code, reason, err := DecodeErrorCode(attr)
+	if err != nil {
+		return fmt.Errorf("received error response: %w", err)
+	}
+
+	if code == codeTryAlternate {
+		if attr, found := response.GetAttribute(AttrAlternateServer); found {
+			if alternate, err := DecodeAlternateServer(attr); err == nil {
+				return &redirectError{from: serverAddr, alternate: alternate}
+			}
 		}
-		publicAddr, err := DecodeMappedAddress(attr)
+	}
+
+	return fmt.Errorf("received error response: %d %s", code, reason)
+}
+
+// publicAddrFromResponse extracts the mapped address from a binding response.
+// XOR-MAPPED-ADDRESS is preferred; MAPPED-ADDRESS is used as a fallback for
+// older RFC 3489 servers that don't send the XOR variant.
+func publicAddrFromResponse(response *Message, transactionID [TransactionIDSize]byte) (*net.UDPAddr, error) {
+	if attr, found := response.GetAttribute(AttrXORMappedAddress); found {
+		publicAddr, err := DecodeXORMappedAddress(attr, transactionID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode MAPPED-ADDRESS: %w", err)
+			return nil, fmt.Errorf("failed to decode XOR-MAPPED-ADDRESS: %w", err)
 		}
-
-		return &Endpoint{
-			LocalAddr:  c.conn.LocalAddr().(*net.UDPAddr),
-			PublicAddr: publicAddr,
-			ServerAddr: c.serverAddr,
-		}, nil
+		return publicAddr, nil
 	}
 
-	publicAddr, err := DecodeXORMappedAddress(attr, request.TransactionID)
+	// Fallback to MAPPED-ADDRESS
+	attr, found := response.GetAttribute(AttrMappedAddress)
+	if !found {
+		return nil, fmt.Errorf("no address attribute in response")
+	}
+	publicAddr, err := DecodeMappedAddress(attr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode XOR-MAPPED-ADDRESS: %w", err)
+		return nil, fmt.Errorf("failed to decode MAPPED-ADDRESS: %w", err)
 	}
-
-	return &Endpoint{
-		LocalAddr:  c.conn.LocalAddr().(*net.UDPAddr),
-		PublicAddr: publicAddr,
-		ServerAddr: c.serverAddr,
-	}, nil
+	return publicAddr, nil
 }
 
-// DiscoverWithRetry attempts endpoint discovery with retry logic
+// DiscoverWithRetry attempts endpoint discovery with retry logic. Each
+// attempt tries the server and then every fallback server, and only backs
+// off once all of them have failed. The returned endpoint's ServerAddr is
+// the server that answered; later requests start with it.
 func (c *Client) DiscoverWithRetry(maxRetries int) (*Endpoint, error) {
 	var lastErr error
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		endpoint, err := c.Discover()
+		endpoint, err := c.discoverAny()
 		if err == nil {
 			return endpoint, nil
 		}
 
 		lastErr = err
 
-		// Wait before retry (exponential backoff)
+		// Wait before retry (exponential backoff with jitter)
 		if attempt < maxRetries {
-			backoff := time.Duration(1<<uint(attempt)) * time.Second
-			if backoff > 10*time.Second {
-				backoff = 10 * time.Second
-			}
-			time.Sleep(backoff)
+			time.Sleep(netutil.Backoff(attempt, c.backoff))
 		}
 	}
 
 	return nil, fmt.Errorf("discovery failed after %d attempts: %w", maxRetries, lastErr)
 }
 
-// Close closes the STUN client and releases resources
+// discoverAny runs Discover and then tries each fallback server in turn
+func (c *Client) discoverAny() (*Endpoint, error) {
+	endpoint, err := c.Discover()
+	if err == nil {
+		return endpoint, nil
+	}
+	lastErr := err
+
+	for _, serverAddr := range c.fallbacks {
+		if serverAddr == c.serverAddr {
+			continue // Already tried by Discover
+		}
+
+		request, err := c.newBindingRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		endpoint, err := c.roundTrip(request, serverAddr)
+		if err == nil {
+			c.serverAddr = serverAddr
+			return endpoint, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// Close closes the STUN client and releases resources.
+// A connection supplied via ClientConfig.Conn is left open.
 func (c *Client) Close() error {
-	if c.conn != nil {
+	if c.conn != nil && c.ownsConn {
 		return c.conn.Close()
 	}
 	return nil
@@ -188,11 +495,17 @@ func (c *Client) LocalAddr() *net.UDPAddr {
 	return nil
 }
 
-// ServerAddr returns the STUN server address
+// ServerAddr returns the STUN server address currently in use
+// (the one that answered most recently)
 func (c *Client) ServerAddr() *net.UDPAddr {
 	return c.serverAddr
 }
 
+// ServerAddrs returns every address the STUN server resolved to
+func (c *Client) ServerAddrs() []*net.UDPAddr {
+	return c.serverAddrs
+}
+
 // String returns a string representation of the endpoint
 func (e *Endpoint) String() string {
 	return fmt.Sprintf("Local: %s, Public: %s (via %s)",
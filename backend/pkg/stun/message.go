@@ -3,9 +3,24 @@ package stun
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
+// MaxMessageSize is the largest datagram a STUN message can arrive in.
+// Reading into a buffer this size never cuts a response short, however
+// many attributes the server appends.
+const MaxMessageSize = 65535
+
+// ErrTruncated is returned by Decode when the data ends before the length
+// declared in the header: the datagram was cut short, e.g. by a small read
+// buffer
+var ErrTruncated = errors.New("truncated STUN message")
+
+// ErrMalformed is returned by Decode when the message is complete but its
+// contents are invalid
+var ErrMalformed = errors.New("malformed STUN message")
+
 // MessageType represents STUn message type
 type MessageType uint16
 
@@ -25,6 +40,7 @@ type AttributeType uint16
 
 const (
 	AttrMappedAddress     AttributeType = 0x0001 // MAPPED-ADDRESS
+	AttrChangeRequest     AttributeType = 0x0003 // CHANGE-REQUEST (RFC 3489)
 	AttrXORMappedAddress  AttributeType = 0x0020 // XOR-MAPPED-ADDRESS
 	AttrUsername          AttributeType = 0x0006 // USERNAME
 	AttrMessageIntegrity  AttributeType = 0x0008 // MESSAGE-INTEGRITY
@@ -35,6 +51,8 @@ const (
 	AttrSoftware          AttributeType = 0x8022 // SOFTWARE
 	AttrAlternateServer   AttributeType = 0x8023 // ALTERNATE-SERVER
 	AttrFingerprint       AttributeType = 0x8028 // FINGERPRINT
+	AttrResponseOrigin    AttributeType = 0x802B // RESPONSE-ORIGIN (RFC 5780)
+	AttrOtherAddress      AttributeType = 0x802C // OTHER-ADDRESS (RFC 5780)
 )
 
 const (
@@ -52,6 +70,9 @@ const (
 
 	// IPv6 address family
 	FamilyIPv6 uint16 = 0x02
+
+	// Value XORed with the CRC-32 in FINGERPRINT (RFC 5389 section 15.5)
+	FingerprintXOR uint32 = 0x5354554e
 )
 
 // Message represents a STUN message
@@ -139,10 +160,11 @@ func (m *Message) Encode() ([]byte, error) {
 	return buf, nil
 }
 
-// Decode decodes a STUN message from wire format
+// Decode decodes a STUN message from wire format. Errors wrap ErrTruncated
+// if data is shorter than the message, or ErrMalformed if it is invalid.
 func Decode(data []byte) (*Message, error) {
 	if len(data) < HeaderSize {
-		return nil, fmt.Errorf("message too short: %d bytes", len(data))
+		return nil, fmt.Errorf("%w: %d bytes is shorter than the header", ErrTruncated, len(data))
 	}
 
 	// Decode header
@@ -155,22 +177,23 @@ func Decode(data []byte) (*Message, error) {
 
 	// Verify magic cookie
 	if magicCookie != MagicCookie {
-		return nil, fmt.Errorf("invalid magic cookie: 0x%X", magicCookie)
+		return nil, fmt.Errorf("%w: invalid magic cookie 0x%X", ErrMalformed, magicCookie)
 	}
 
 	// Copy transaction ID
 	copy(msg.TransactionID[:], data[8:20])
 
 	// Verify message length
-	if len(data) < HeaderSize+int(msgLength) {
-		return nil, fmt.Errorf("incomplete message: expected %d bytes, got %d", HeaderSize+int(msgLength), len(data))
+	end := HeaderSize + int(msgLength)
+	if len(data) < end {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrTruncated, end, len(data))
 	}
 
-	// Decode attributes
+	// Decode attributes, which must fit in the declared length
 	offset := HeaderSize
-	for offset < HeaderSize+int(msgLength) {
-		if offset+4 > len(data) {
-			return nil, fmt.Errorf("incomplete attribute header at offset %d", offset)
+	for offset < end {
+		if offset+4 > end {
+			return nil, fmt.Errorf("%w: incomplete attribute header at offset %d", ErrMalformed, offset)
 		}
 
 		attr := Attribute{
@@ -178,8 +201,8 @@ func Decode(data []byte) (*Message, error) {
 			Length: binary.BigEndian.Uint16(data[offset+2 : offset+4]),
 		}
 
-		if offset+4+int(attr.Length) > len(data) {
-			return nil, fmt.Errorf("incomplete attribute value at offset %d", offset)
+		if offset+4+int(attr.Length) > end {
+			return nil, fmt.Errorf("%w: attribute %s at offset %d overruns the message", ErrMalformed, attr.Type, offset)
 		}
 
 		attr.Value = make([]byte, attr.Length)
@@ -207,6 +230,34 @@ func (t MessageType) String() string {
 		return "Binding Success Response"
 	case TypeBindingError:
 		return "Binding Error Response"
+	case TypeAllocateRequest:
+		return "Allocate Request"
+	case TypeAllocateSuccess:
+		return "Allocate Success Response"
+	case TypeAllocateError:
+		return "Allocate Error Response"
+	case TypeRefreshRequest:
+		return "Refresh Request"
+	case TypeRefreshSuccess:
+		return "Refresh Success Response"
+	case TypeRefreshError:
+		return "Refresh Error Response"
+	case TypeCreatePermissionRequest:
+		return "CreatePermission Request"
+	case TypeCreatePermissionSuccess:
+		return "CreatePermission Success Response"
+	case TypeCreatePermissionError:
+		return "CreatePermission Error Response"
+	case TypeChannelBindRequest:
+		return "ChannelBind Request"
+	case TypeChannelBindSuccess:
+		return "ChannelBind Success Response"
+	case TypeChannelBindError:
+		return "ChannelBind Error Response"
+	case TypeSendIndication:
+		return "Send Indication"
+	case TypeDataIndication:
+		return "Data Indication"
 	default:
 		return fmt.Sprintf("Unknown (0x%04X)", uint16(t))
 	}
@@ -217,6 +268,8 @@ func (t AttributeType) String() string {
 	switch t {
 	case AttrMappedAddress:
 		return "MAPPED-ADDRESS"
+	case AttrChangeRequest:
+		return "CHANGE-REQUEST"
 	case AttrXORMappedAddress:
 		return "XOR-MAPPED-ADDRESS"
 	case AttrUsername:
@@ -237,6 +290,22 @@ func (t AttributeType) String() string {
 		return "ALTERNATE-SERVER"
 	case AttrFingerprint:
 		return "FINGERPRINT"
+	case AttrResponseOrigin:
+		return "RESPONSE-ORIGIN"
+	case AttrOtherAddress:
+		return "OTHER-ADDRESS"
+	case AttrChannelNumber:
+		return "CHANNEL-NUMBER"
+	case AttrLifetime:
+		return "LIFETIME"
+	case AttrXORPeerAddress:
+		return "XOR-PEER-ADDRESS"
+	case AttrData:
+		return "DATA"
+	case AttrXORRelayedAddress:
+		return "XOR-RELAYED-ADDRESS"
+	case AttrRequestedTransport:
+		return "REQUESTED-TRANSPORT"
 	default:
 		return fmt.Sprintf("Unknown (0x%04X)", uint16(t))
 	}
@@ -0,0 +1,151 @@
+package stun
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DiscoveryResult is the outcome of a binding request to one of several servers
+type DiscoveryResult struct {
+	Server   string    // Server address as given by the caller
+	Endpoint *Endpoint // Discovered endpoint, nil on failure
+	Err      error     // Why discovery against this server failed
+}
+
+// DiscoverFastest sends binding requests to all servers at once and returns
+// the first successful response. Requests still in flight are abandoned.
+// If every server fails, the individual errors are joined together.
+func DiscoverFastest(servers []string, timeout time.Duration) (*Endpoint, error) {
+	results, err := discoverMany(servers, timeout, true)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, 0, len(results))
+	for _, result := range results {
+		if result.Err == nil {
+			return result.Endpoint, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", result.Server, result.Err))
+	}
+
+	return nil, fmt.Errorf("all STUN servers failed: %w", errors.Join(errs...))
+}
+
+// DiscoverAll sends binding requests to all servers at once and waits for
+// every response (or the timeout). Results are returned in the same order as
+// servers. All requests share one local socket, so different public ports
+// across servers indicate a symmetric NAT.
+func DiscoverAll(servers []string, timeout time.Duration) ([]DiscoveryResult, error) {
+	return discoverMany(servers, timeout, false)
+}
+
+// discoverMany fires a binding request at each server from a single socket and
+// matches responses by transaction ID. With stopOnFirst set it returns as soon
+// as one server answers successfully.
+func discoverMany(servers []string, timeout time.Duration, stopOnFirst bool) ([]DiscoveryResult, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no STUN servers given")
+	}
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UDP connection: %w", err)
+	}
+	defer conn.Close()
+
+	localAddr := conn.LocalAddr().(*net.UDPAddr)
+	results := make([]DiscoveryResult, len(servers))
+	pending := make(map[[TransactionIDSize]byte]int, len(servers))
+	serverAddrs := make([]*net.UDPAddr, len(servers))
+
+	// Send all requests
+	for i, server := range servers {
+		results[i].Server = server
+
+		addrs, err := resolveServerAddrs(server, false)
+		if err != nil {
+			results[i].Err = fmt.Errorf("failed to resolve server address: %w", err)
+			continue
+		}
+		serverAddrs[i] = addrs[0]
+
+		request, err := NewMessage(TypeBindingRequest)
+		if err != nil {
+			results[i].Err = fmt.Errorf("failed to create binding request: %w", err)
+			continue
+		}
+		request.AddSoftware(DefaultSoftware)
+
+		data, err := request.EncodeWithFingerprint()
+		if err != nil {
+			results[i].Err = fmt.Errorf("failed to encode request: %w", err)
+			continue
+		}
+
+		if _, err := conn.WriteToUDP(data, serverAddrs[i]); err != nil {
+			results[i].Err = fmt.Errorf("failed to send request: %w", err)
+			continue
+		}
+
+		pending[request.TransactionID] = i
+	}
+
+	// Collect responses
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+
+	buf := make([]byte, MaxMessageSize)
+	for len(pending) > 0 {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+
+		response, err := Decode(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		i, ok := pending[response.TransactionID]
+		if !ok {
+			continue
+		}
+		delete(pending, response.TransactionID)
+
+		if response.Type != TypeBindingSuccess {
+			results[i].Err = fmt.Errorf("received error response: %s", response.Type)
+			continue
+		}
+
+		publicAddr, err := publicAddrFromResponse(response, response.TransactionID)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		results[i].Endpoint = &Endpoint{
+			LocalAddr:  localAddr,
+			PublicAddr: publicAddr,
+			ServerAddr: serverAddrs[i],
+			SourceAddr: addr,
+		}
+
+		if stopOnFirst {
+			break
+		}
+	}
+
+	// Anything still pending never answered
+	for _, i := range pending {
+		results[i].Err = fmt.Errorf("%w after %v", ErrTimeout, timeout)
+	}
+
+	return results, nil
+}
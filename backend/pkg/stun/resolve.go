@@ -0,0 +1,110 @@
+package stun
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort is the standard STUN port used when an address has no port
+const DefaultPort = 3478
+
+// resolveServerAddrs resolves a STUN server address to every UDP address it
+// maps to. A hostname with multiple A/AAAA records yields one address per
+// record, in resolver order. When the address has no port and useSRV is set,
+// a _stun._udp SRV lookup is tried first; otherwise DefaultPort is used.
+func resolveServerAddrs(server string, useSRV bool) ([]*net.UDPAddr, error) {
+	host, portStr, err := net.SplitHostPort(server)
+	if err != nil {
+		// No port given (a bare hostname or IPv6 literal)
+		host, portStr = strings.Trim(server, "[]"), ""
+	}
+	if host == "" {
+		return nil, fmt.Errorf("missing host in address %q", server)
+	}
+
+	if portStr == "" {
+		if useSRV && net.ParseIP(host) == nil {
+			if addrs, err := lookupSRV(host); err == nil && len(addrs) > 0 {
+				return addrs, nil
+			}
+		}
+		portStr = strconv.Itoa(DefaultPort)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in address %q", server)
+	}
+
+	return lookupHost(host, port)
+}
+
+// lookupSRV resolves the _stun._udp SRV records for a domain
+func lookupSRV(domain string) ([]*net.UDPAddr, error) {
+	_, records, err := net.LookupSRV("stun", "udp", domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []*net.UDPAddr
+	for _, record := range records {
+		resolved, err := lookupHost(record.Target, int(record.Port))
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, resolved...)
+	}
+
+	return addrs, nil
+}
+
+// lookupHost resolves a host to all of its IP addresses with the given port
+func lookupHost(host string, port int) ([]*net.UDPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []*net.UDPAddr{{IP: ip, Port: port}}, nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	addrs := make([]*net.UDPAddr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = &net.UDPAddr{IP: ip, Port: port}
+	}
+
+	return addrs, nil
+}
+
+// filterFamily keeps the addresses usable on network ("udp", "udp4" or
+// "udp6")
+func filterFamily(addrs []*net.UDPAddr, network string) ([]*net.UDPAddr, error) {
+	var keep func(net.IP) bool
+	switch network {
+	case "udp":
+		return addrs, nil
+	case "udp4":
+		keep = func(ip net.IP) bool { return ip.To4() != nil }
+	case "udp6":
+		keep = func(ip net.IP) bool { return ip.To4() == nil }
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+
+	var filtered []*net.UDPAddr
+	for _, addr := range addrs {
+		if keep(addr.IP) {
+			filtered = append(filtered, addr)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("no %s addresses", network)
+	}
+	return filtered, nil
+}
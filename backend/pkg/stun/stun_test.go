@@ -2,9 +2,16 @@ package stun
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/saintparish4/altair/pkg/netutil"
 )
 
 func TestNewMessage(t *testing.T) {
@@ -171,6 +178,300 @@ func TestDecodeMappedAddress(t *testing.T) {
 	}
 }
 
+func TestChangeRequestRoundtrip(t *testing.T) {
+	tests := []struct {
+		changeIP   bool
+		changePort bool
+	}{
+		{false, false},
+		{true, false},
+		{false, true},
+		{true, true},
+	}
+
+	for _, tt := range tests {
+		msg, err := NewMessage(TypeBindingRequest)
+		if err != nil {
+			t.Fatalf("NewMessage failed: %v", err)
+		}
+		msg.AddChangeRequest(tt.changeIP, tt.changePort)
+
+		encoded, err := msg.Encode()
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+
+		// Header (20) + Attr header (4) + Value (4) = 28
+		if len(encoded) != 28 {
+			t.Errorf("expected length 28, got %d", len(encoded))
+		}
+
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+
+		attr, found := decoded.GetAttribute(AttrChangeRequest)
+		if !found {
+			t.Fatal("CHANGE-REQUEST attribute not found")
+		}
+
+		changeIP, changePort, err := DecodeChangeRequest(attr)
+		if err != nil {
+			t.Fatalf("DecodeChangeRequest failed: %v", err)
+		}
+
+		if changeIP != tt.changeIP || changePort != tt.changePort {
+			t.Errorf("flags mismatch: expected (%v, %v), got (%v, %v)",
+				tt.changeIP, tt.changePort, changeIP, changePort)
+		}
+	}
+}
+
+func TestOtherAddressAndResponseOriginRoundtrip(t *testing.T) {
+	other := &net.UDPAddr{IP: net.ParseIP("198.51.100.2"), Port: 3479}
+	origin := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 3478}
+
+	msg, err := NewMessage(TypeBindingSuccess)
+	if err != nil {
+		t.Fatalf("NewMessage failed: %v", err)
+	}
+	msg.AddAttribute(EncodeOtherAddress(other))
+	msg.AddAttribute(EncodeResponseOrigin(origin))
+
+	encoded, err := msg.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	attr, found := decoded.GetAttribute(AttrOtherAddress)
+	if !found {
+		t.Fatal("OTHER-ADDRESS attribute not found")
+	}
+	addr, err := DecodeOtherAddress(attr)
+	if err != nil {
+		t.Fatalf("DecodeOtherAddress failed: %v", err)
+	}
+	if !addr.IP.Equal(other.IP) || addr.Port != other.Port {
+		t.Errorf("OTHER-ADDRESS mismatch: expected %v, got %v", other, addr)
+	}
+
+	attr, found = decoded.GetAttribute(AttrResponseOrigin)
+	if !found {
+		t.Fatal("RESPONSE-ORIGIN attribute not found")
+	}
+	addr, err = DecodeResponseOrigin(attr)
+	if err != nil {
+		t.Fatalf("DecodeResponseOrigin failed: %v", err)
+	}
+	if !addr.IP.Equal(origin.IP) || addr.Port != origin.Port {
+		t.Errorf("RESPONSE-ORIGIN mismatch: expected %v, got %v", origin, addr)
+	}
+
+	// Decoders must reject the wrong attribute type
+	if _, err := DecodeOtherAddress(attr); err == nil {
+		t.Error("DecodeOtherAddress should reject RESPONSE-ORIGIN")
+	}
+}
+
+func TestPublicAddrFromResponseMappedAddressOnly(t *testing.T) {
+	expected := &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}
+
+	// Synthetic legacy response carrying only MAPPED-ADDRESS
+	response, err := NewMessage(TypeBindingSuccess)
+	if err != nil {
+		t.Fatalf("NewMessage failed: %v", err)
+	}
+	response.AddAttribute(EncodeMappedAddress(expected))
+
+	encoded, err := response.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	addr, err := publicAddrFromResponse(decoded, decoded.TransactionID)
+	if err != nil {
+		t.Fatalf("publicAddrFromResponse failed: %v", err)
+	}
+
+	if !addr.IP.Equal(expected.IP) || addr.Port != expected.Port {
+		t.Errorf("address mismatch: expected %v, got %v", expected, addr)
+	}
+}
+
+func TestPublicAddrFromResponsePrefersXOR(t *testing.T) {
+	xorAddr := &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}
+	plainAddr := &net.UDPAddr{IP: net.ParseIP("192.0.2.99"), Port: 1234}
+
+	response, err := NewMessage(TypeBindingSuccess)
+	if err != nil {
+		t.Fatalf("NewMessage failed: %v", err)
+	}
+	response.AddAttribute(EncodeMappedAddress(plainAddr))
+	response.AddAttribute(EncodeXORMappedAddress(xorAddr, response.TransactionID))
+
+	addr, err := publicAddrFromResponse(response, response.TransactionID)
+	if err != nil {
+		t.Fatalf("publicAddrFromResponse failed: %v", err)
+	}
+
+	if !addr.IP.Equal(xorAddr.IP) || addr.Port != xorAddr.Port {
+		t.Errorf("expected XOR-MAPPED-ADDRESS %v, got %v", xorAddr, addr)
+	}
+
+	// Neither attribute present
+	empty, _ := NewMessage(TypeBindingSuccess)
+	if _, err := publicAddrFromResponse(empty, empty.TransactionID); err == nil {
+		t.Error("expected error when no address attribute is present")
+	}
+}
+
+func TestEncodeWithFingerprint(t *testing.T) {
+	msg, err := NewMessage(TypeBindingRequest)
+	if err != nil {
+		t.Fatalf("NewMessage failed: %v", err)
+	}
+	msg.AddSoftware(DefaultSoftware)
+
+	encoded, err := msg.EncodeWithFingerprint()
+	if err != nil {
+		t.Fatalf("EncodeWithFingerprint failed: %v", err)
+	}
+
+	// Original message must not gain the attribute
+	if _, found := msg.GetAttribute(AttrFingerprint); found {
+		t.Error("EncodeWithFingerprint should not modify the message")
+	}
+
+	// Header length must include the FINGERPRINT attribute
+	if int(binary.BigEndian.Uint16(encoded[2:4])) != len(encoded)-HeaderSize {
+		t.Errorf("header length %d does not cover FINGERPRINT", binary.BigEndian.Uint16(encoded[2:4]))
+	}
+
+	// FINGERPRINT is the CRC-32 of the preceding bytes XORed with 0x5354554e
+	value := binary.BigEndian.Uint32(encoded[len(encoded)-4:])
+	crc := crc32.ChecksumIEEE(encoded[:len(encoded)-8])
+	if value^crc != 0x5354554e {
+		t.Errorf("FINGERPRINT XOR = 0x%08X, want 0x5354554E", value^crc)
+	}
+
+	if err := VerifyFingerprint(encoded); err != nil {
+		t.Errorf("VerifyFingerprint failed: %v", err)
+	}
+
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	last := decoded.Attributes[len(decoded.Attributes)-1]
+	if last.Type != AttrFingerprint {
+		t.Errorf("last attribute = %s, want FINGERPRINT", last.Type)
+	}
+	if attr, found := decoded.GetAttribute(AttrSoftware); !found || string(attr.Value) != DefaultSoftware {
+		t.Errorf("SOFTWARE attribute missing or wrong")
+	}
+
+	// Corrupting the message must break the fingerprint
+	encoded[HeaderSize+4] ^= 0xFF
+	if err := VerifyFingerprint(encoded); err == nil {
+		t.Error("VerifyFingerprint should fail for a corrupted message")
+	}
+}
+
+func TestMessageIntegrity(t *testing.T) {
+	msg, err := NewMessage(TypeBindingRequest)
+	if err != nil {
+		t.Fatalf("NewMessage failed: %v", err)
+	}
+	msg.AddStringAttribute(AttrUsername, "alice")
+	msg.AddStringAttribute(AttrRealm, "example.org")
+
+	key := LongTermKey("alice", "example.org", "secret")
+	encoded, err := msg.EncodeWithIntegrity(key)
+	if err != nil {
+		t.Fatalf("EncodeWithIntegrity failed: %v", err)
+	}
+
+	if _, found := msg.GetAttribute(AttrMessageIntegrity); found {
+		t.Error("EncodeWithIntegrity should not modify the message")
+	}
+
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if last := decoded.Attributes[len(decoded.Attributes)-1]; last.Type != AttrMessageIntegrity {
+		t.Errorf("Last attribute = %s, want MESSAGE-INTEGRITY", last.Type)
+	}
+
+	if err := VerifyMessageIntegrity(encoded, key); err != nil {
+		t.Errorf("VerifyMessageIntegrity failed: %v", err)
+	}
+
+	if err := VerifyMessageIntegrity(encoded, LongTermKey("alice", "example.org", "wrong")); err == nil {
+		t.Error("VerifyMessageIntegrity should fail with the wrong key")
+	}
+
+	encoded[HeaderSize+5] ^= 0xFF
+	if err := VerifyMessageIntegrity(encoded, key); err == nil {
+		t.Error("VerifyMessageIntegrity should fail for a tampered message")
+	}
+
+	unsigned, _ := msg.Encode()
+	if err := VerifyMessageIntegrity(unsigned, key); err == nil {
+		t.Error("VerifyMessageIntegrity should fail without MESSAGE-INTEGRITY")
+	}
+}
+
+func TestDecodeErrorCode(t *testing.T) {
+	code, reason, err := DecodeErrorCode(&Attribute{
+		Type:  AttrErrorCode,
+		Value: EncodeErrorCode(438, "Stale Nonce").Value,
+	})
+	if err != nil {
+		t.Fatalf("DecodeErrorCode failed: %v", err)
+	}
+	if code != 438 || reason != "Stale Nonce" {
+		t.Errorf("DecodeErrorCode = %d %q, want 438 \"Stale Nonce\"", code, reason)
+	}
+}
+
+func TestClientFingerprintToggle(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		client, err := NewClient(&ClientConfig{
+			ServerAddr:         "127.0.0.1:3478",
+			DisableFingerprint: disable,
+		})
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+
+		request, err := client.newBindingRequest()
+		if err != nil {
+			t.Fatalf("newBindingRequest failed: %v", err)
+		}
+		encoded, err := client.encode(request)
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		client.Close()
+
+		hasFingerprint := VerifyFingerprint(encoded) == nil
+		if hasFingerprint == disable {
+			t.Errorf("DisableFingerprint=%v but fingerprint present=%v", disable, hasFingerprint)
+		}
+	}
+}
+
 func TestMessageEncodeWithPadding(t *testing.T) {
 	msg, err := NewMessage(TypeBindingRequest)
 	if err != nil {
@@ -232,6 +533,332 @@ func TestClientLocalAddr(t *testing.T) {
 	}
 }
 
+func TestResolveServerAddrs(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   string
+		wantIP   string
+		wantPort int
+		wantErr  bool
+	}{
+		{"IPv4 with port", "192.0.2.1:19302", "192.0.2.1", 19302, false},
+		{"IPv4 without port", "192.0.2.1", "192.0.2.1", DefaultPort, false},
+		{"IPv6 with port", "[2001:db8::1]:3479", "2001:db8::1", 3479, false},
+		{"IPv6 without port", "2001:db8::1", "2001:db8::1", DefaultPort, false},
+		{"bracketed IPv6 without port", "[2001:db8::1]", "2001:db8::1", DefaultPort, false},
+		{"invalid port", "192.0.2.1:notaport", "", 0, true},
+		{"empty host", ":3478", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addrs, err := resolveServerAddrs(tt.server, false)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error for %q", tt.server)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveServerAddrs(%q) failed: %v", tt.server, err)
+			}
+			if len(addrs) != 1 {
+				t.Fatalf("expected 1 address, got %d", len(addrs))
+			}
+			if !addrs[0].IP.Equal(net.ParseIP(tt.wantIP)) || addrs[0].Port != tt.wantPort {
+				t.Errorf("got %v, want %s port %d", addrs[0], tt.wantIP, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestFilterFamily(t *testing.T) {
+	v4 := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 3478}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 3478}
+	addrs := []*net.UDPAddr{v4, v6}
+
+	if got, err := filterFamily(addrs, "udp"); err != nil || len(got) != 2 {
+		t.Errorf("udp: got %v, %v; want both addresses", got, err)
+	}
+	if got, err := filterFamily(addrs, "udp4"); err != nil || len(got) != 1 || got[0] != v4 {
+		t.Errorf("udp4: got %v, %v; want [%s]", got, err, v4)
+	}
+	if got, err := filterFamily(addrs, "udp6"); err != nil || len(got) != 1 || got[0] != v6 {
+		t.Errorf("udp6: got %v, %v; want [%s]", got, err, v6)
+	}
+	if _, err := filterFamily([]*net.UDPAddr{v4}, "udp6"); err == nil {
+		t.Error("Expected error when no address matches the family")
+	}
+	if _, err := filterFamily(addrs, "tcp"); err == nil {
+		t.Error("Expected error for unsupported network")
+	}
+}
+
+func TestClientServerAddrs(t *testing.T) {
+	client, err := NewClient(&ClientConfig{
+		ServerAddr: "127.0.0.1:3478",
+		Timeout:    time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	if len(client.ServerAddrs()) != 1 {
+		t.Fatalf("expected 1 server address, got %d", len(client.ServerAddrs()))
+	}
+	if client.ServerAddr() != client.ServerAddrs()[0] {
+		t.Error("ServerAddr should default to the first resolved address")
+	}
+}
+
+// startTestServer runs a minimal STUN server on loopback that answers
+// binding requests with the sender's XOR-MAPPED-ADDRESS
+func startTestServer(t *testing.T) *net.UDPAddr {
+	t.Helper()
+
+	return startRespondingServer(t, func(request *Message, addr *net.UDPAddr) []byte {
+		response := &Message{
+			Type:          TypeBindingSuccess,
+			TransactionID: request.TransactionID,
+		}
+		response.AddAttribute(EncodeXORMappedAddress(addr, request.TransactionID))
+
+		data, _ := response.Encode()
+		return data
+	})
+}
+
+// startRespondingServer answers each binding request with the datagram
+// returned by respond
+func startRespondingServer(t *testing.T, respond func(request *Message, addr *net.UDPAddr) []byte) *net.UDPAddr {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("failed to start test server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			request, err := Decode(buf[:n])
+			if err != nil || request.Type != TypeBindingRequest {
+				continue
+			}
+
+			conn.WriteToUDP(respond(request, addr), addr)
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestDiscoverLocalServer(t *testing.T) {
+	serverAddr := startTestServer(t)
+
+	client, err := NewClient(&ClientConfig{
+		ServerAddr: serverAddr.String(),
+		LocalAddr:  "127.0.0.1:0",
+		Timeout:    time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	endpoint, err := client.Discover()
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+
+	if endpoint.PublicAddr.Port != client.LocalAddr().Port {
+		t.Errorf("public port = %d, want %d", endpoint.PublicAddr.Port, client.LocalAddr().Port)
+	}
+}
+
+func TestDiscoverMeasuresRTT(t *testing.T) {
+	serverAddr := startTestServer(t)
+
+	client, err := NewClient(&ClientConfig{
+		ServerAddr: serverAddr.String(),
+		LocalAddr:  "127.0.0.1:0",
+		Timeout:    time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	endpoint, err := client.Discover()
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+
+	if endpoint.RTT <= 0 || endpoint.RTT >= time.Second {
+		t.Errorf("RTT = %v, want a positive duration under the timeout", endpoint.RTT)
+	}
+}
+
+func TestDiscoverFallsBackToNextAddress(t *testing.T) {
+	serverAddr := startTestServer(t)
+
+	// Reserve a port nobody answers on
+	dead, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer dead.Close()
+	deadAddr := dead.LocalAddr().(*net.UDPAddr)
+
+	client, err := NewClient(&ClientConfig{
+		ServerAddr: deadAddr.String(),
+		LocalAddr:  "127.0.0.1:0",
+		Timeout:    200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	// Simulate a hostname that resolved to two addresses
+	client.serverAddrs = []*net.UDPAddr{deadAddr, serverAddr}
+	client.serverAddr = deadAddr
+
+	endpoint, err := client.Discover()
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+
+	if endpoint.ServerAddr.String() != serverAddr.String() {
+		t.Errorf("answering server = %s, want %s", endpoint.ServerAddr, serverAddr)
+	}
+	if client.ServerAddr().String() != serverAddr.String() {
+		t.Errorf("client should prefer the answering server, got %s", client.ServerAddr())
+	}
+}
+
+func TestDiscoverWithRetryUsesFallbackServers(t *testing.T) {
+	serverAddr := startTestServer(t)
+
+	// The configured server never answers
+	dead, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer dead.Close()
+
+	client, err := NewClient(&ClientConfig{
+		ServerAddr:      dead.LocalAddr().String(),
+		LocalAddr:       "127.0.0.1:0",
+		Timeout:         200 * time.Millisecond,
+		FallbackServers: []string{serverAddr.String()},
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	// Discover sticks to the configured server
+	if _, err := client.Discover(); err == nil {
+		t.Fatal("Discover should not use fallback servers")
+	}
+
+	endpoint, err := client.DiscoverWithRetry(0)
+	if err != nil {
+		t.Fatalf("DiscoverWithRetry failed: %v", err)
+	}
+
+	if endpoint.ServerAddr.String() != serverAddr.String() {
+		t.Errorf("answering server = %s, want fallback %s", endpoint.ServerAddr, serverAddr)
+	}
+	if client.ServerAddr().String() != serverAddr.String() {
+		t.Errorf("client should prefer the fallback that answered, got %s", client.ServerAddr())
+	}
+}
+
+func TestNewClientWithExistingConn(t *testing.T) {
+	serverAddr := startTestServer(t)
+
+	existingConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("Failed to create UDP connection: %v", err)
+	}
+	defer existingConn.Close()
+
+	client, err := NewClient(&ClientConfig{
+		ServerAddr: serverAddr.String(),
+		Timeout:    time.Second,
+		Conn:       existingConn,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	expected := existingConn.LocalAddr().(*net.UDPAddr)
+	if client.LocalAddr().String() != expected.String() {
+		t.Errorf("LocalAddr = %s, want %s", client.LocalAddr(), expected)
+	}
+
+	endpoint, err := client.Discover()
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if endpoint.PublicAddr.Port != expected.Port {
+		t.Errorf("public port = %d, want %d", endpoint.PublicAddr.Port, expected.Port)
+	}
+
+	// Closing the client must not close the caller's connection
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if _, err := existingConn.WriteToUDP([]byte("ping"), serverAddr); err != nil {
+		t.Errorf("existing connection should remain open: %v", err)
+	}
+}
+
+func TestChannelDataFraming(t *testing.T) {
+	frame := EncodeChannelData(0x4001, []byte("voice"))
+	if len(frame) != ChannelDataHeaderSize+5 {
+		t.Errorf("ChannelData length = %d, want %d", len(frame), ChannelDataHeaderSize+5)
+	}
+
+	channel, data, ok := DecodeChannelData(frame)
+	if !ok || channel != 0x4001 || string(data) != "voice" {
+		t.Errorf("decodeChannelData = %#x %q %v", channel, data, ok)
+	}
+
+	// STUN messages must not be mistaken for ChannelData
+	msg, _ := NewMessage(TypeDataIndication)
+	encoded, _ := msg.Encode()
+	if _, _, ok := DecodeChannelData(encoded); ok {
+		t.Error("STUN message decoded as ChannelData")
+	}
+
+	// Truncated payload
+	if _, _, ok := DecodeChannelData(frame[:6]); ok {
+		t.Error("Truncated ChannelData should not decode")
+	}
+}
+
+func TestMessageTypeClass(t *testing.T) {
+	if TypeAllocateRequest.SuccessResponseType() != TypeAllocateSuccess {
+		t.Errorf("SuccessResponseType = %#x, want %#x", uint16(TypeAllocateRequest.SuccessResponseType()), uint16(TypeAllocateSuccess))
+	}
+	if TypeRefreshRequest.ErrorResponseType() != TypeRefreshError {
+		t.Errorf("ErrorResponseType = %#x, want %#x", uint16(TypeRefreshRequest.ErrorResponseType()), uint16(TypeRefreshError))
+	}
+	if !TypeBindingError.IsErrorResponse() || TypeBindingSuccess.IsErrorResponse() || TypeSendIndication.IsErrorResponse() {
+		t.Error("IsErrorResponse misclassified a message type")
+	}
+}
+
 func TestMessageTypeString(t *testing.T) {
 	tests := []struct {
 		msgType  MessageType
@@ -259,6 +886,9 @@ func TestAttributeTypeString(t *testing.T) {
 		{AttrMappedAddress, "MAPPED-ADDRESS"},
 		{AttrXORMappedAddress, "XOR-MAPPED-ADDRESS"},
 		{AttrSoftware, "SOFTWARE"},
+		{AttrChangeRequest, "CHANGE-REQUEST"},
+		{AttrOtherAddress, "OTHER-ADDRESS"},
+		{AttrResponseOrigin, "RESPONSE-ORIGIN"},
 		{AttributeType(0x9999), "Unknown (0x9999)"},
 	}
 
@@ -271,13 +901,23 @@ func TestAttributeTypeString(t *testing.T) {
 }
 
 func TestDecodeInvalidMessage(t *testing.T) {
+	header := func(length uint16) []byte {
+		data := make([]byte, HeaderSize)
+		binary.BigEndian.PutUint16(data[0:2], uint16(TypeBindingSuccess))
+		binary.BigEndian.PutUint16(data[2:4], length)
+		binary.BigEndian.PutUint32(data[4:8], MagicCookie)
+		return data
+	}
+
 	tests := []struct {
 		name string
 		data []byte
+		want error
 	}{
 		{
 			name: "too short",
 			data: []byte{0x00, 0x01},
+			want: ErrTruncated,
 		},
 		{
 			name: "invalid magic cookie",
@@ -288,19 +928,101 @@ func TestDecodeInvalidMessage(t *testing.T) {
 				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Transaction ID
 				0x00, 0x00, 0x00, 0x00,
 			},
+			want: ErrMalformed,
+		},
+		{
+			name: "shorter than declared length",
+			data: append(header(8), 0x80, 0x22, 0x00, 0x04),
+			want: ErrTruncated,
+		},
+		{
+			name: "attribute overruns declared length",
+			data: append(header(8), 0x80, 0x22, 0x00, 0x08, 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h'),
+			want: ErrMalformed,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			_, err := Decode(tt.data)
-			if err == nil {
-				t.Error("expected error for invalid message")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Decode error = %v, want %v", err, tt.want)
 			}
 		})
 	}
 }
 
+func TestDiscoverLargeResponse(t *testing.T) {
+	// A server padding its response with a large SOFTWARE and an
+	// ALTERNATE-SERVER, well over a 1500-byte MTU
+	serverAddr := startRespondingServer(t, func(request *Message, addr *net.UDPAddr) []byte {
+		response := &Message{
+			Type:          TypeBindingSuccess,
+			TransactionID: request.TransactionID,
+		}
+		response.AddSoftware(strings.Repeat("x", 800))
+		alternate := EncodeMappedAddress(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 3478})
+		alternate.Type = AttrAlternateServer
+		response.AddAttribute(alternate)
+		response.AddStringAttribute(AttributeType(0x8050), strings.Repeat("y", 1200))
+		response.AddAttribute(EncodeXORMappedAddress(addr, request.TransactionID))
+
+		data, _ := response.Encode()
+		if len(data) < 2048 {
+			t.Errorf("synthetic response is only %d bytes", len(data))
+		}
+		return data
+	})
+
+	client, err := NewClient(&ClientConfig{
+		ServerAddr: serverAddr.String(),
+		LocalAddr:  "127.0.0.1:0",
+		Timeout:    time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	endpoint, err := client.Discover()
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if endpoint.PublicAddr.Port != client.LocalAddr().Port {
+		t.Errorf("public port = %d, want %d", endpoint.PublicAddr.Port, client.LocalAddr().Port)
+	}
+}
+
+func TestDiscoverTruncatedResponse(t *testing.T) {
+	// The response declares more bytes than the datagram carries
+	serverAddr := startRespondingServer(t, func(request *Message, addr *net.UDPAddr) []byte {
+		response := &Message{
+			Type:          TypeBindingSuccess,
+			TransactionID: request.TransactionID,
+		}
+		response.AddSoftware(strings.Repeat("x", 100))
+		response.AddAttribute(EncodeXORMappedAddress(addr, request.TransactionID))
+
+		data, _ := response.Encode()
+		return data[:len(data)-16]
+	})
+
+	client, err := NewClient(&ClientConfig{
+		ServerAddr: serverAddr.String(),
+		LocalAddr:  "127.0.0.1:0",
+		Timeout:    200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.Discover()
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, ErrTruncated) {
+		t.Errorf("Discover error = %v, want a timeout naming the truncated response", err)
+	}
+}
+
 func TestEndpointString(t *testing.T) {
 	endpoint := &Endpoint{
 		LocalAddr:  &net.UDPAddr{IP: net.ParseIP("192.168.1.100"), Port: 12345},
@@ -321,3 +1043,227 @@ func TestEndpointString(t *testing.T) {
 		t.Error("result should contain public address")
 	}
 }
+
+func TestDiscoverAll(t *testing.T) {
+	server1 := startTestServer(t)
+	server2 := startTestServer(t)
+
+	results, err := DiscoverAll([]string{server1.String(), server2.String()}, time.Second)
+	if err != nil {
+		t.Fatalf("DiscoverAll failed: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatalf("result %d failed: %v", i, result.Err)
+		}
+	}
+
+	if results[0].Server != server1.String() || results[1].Server != server2.String() {
+		t.Error("results should be in the same order as servers")
+	}
+
+	// Same local socket, so both servers must see the same mapping
+	if results[0].Endpoint.PublicAddr.Port != results[1].Endpoint.PublicAddr.Port {
+		t.Errorf("public ports differ: %d vs %d",
+			results[0].Endpoint.PublicAddr.Port, results[1].Endpoint.PublicAddr.Port)
+	}
+}
+
+func TestDiscoverFastest(t *testing.T) {
+	server := startTestServer(t)
+
+	// A server that never answers
+	dead, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer dead.Close()
+
+	start := time.Now()
+	endpoint, err := DiscoverFastest([]string{dead.LocalAddr().String(), server.String()}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("DiscoverFastest failed: %v", err)
+	}
+
+	if endpoint.ServerAddr.String() != server.String() {
+		t.Errorf("answering server = %s, want %s", endpoint.ServerAddr, server)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Error("DiscoverFastest should not wait for slow servers")
+	}
+}
+
+func TestDiscoverFastestAllFail(t *testing.T) {
+	dead, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer dead.Close()
+
+	_, err = DiscoverFastest([]string{dead.LocalAddr().String(), "192.0.2.1:notaport"}, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected error when all servers fail")
+	}
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected joined error to include ErrTimeout, got %v", err)
+	}
+}
+
+func TestDiscoverManyNoServers(t *testing.T) {
+	if _, err := DiscoverFastest(nil, time.Second); err == nil {
+		t.Error("expected error with no servers")
+	}
+	if _, err := DiscoverAll(nil, time.Second); err == nil {
+		t.Error("expected error with no servers")
+	}
+}
+
+func TestNewClientPortRange(t *testing.T) {
+	serverAddr := startTestServer(t)
+	ports := netutil.NewPortScanner(41000, 41010)
+
+	// Two clients sharing the scanner get different ports in the range
+	seen := make(map[int]bool)
+	for i := 0; i < 2; i++ {
+		client, err := NewClient(&ClientConfig{
+			ServerAddr: serverAddr.String(),
+			LocalAddr:  "127.0.0.1:0",
+			Timeout:    time.Second,
+			PortRange:  ports,
+		})
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		defer client.Close()
+
+		port := client.LocalAddr().Port
+		if port < 41000 || port > 41010 || seen[port] {
+			t.Errorf("client bound port %d, want a new port in [41000, 41010]", port)
+		}
+		seen[port] = true
+
+		if _, err := client.Discover(); err != nil {
+			t.Errorf("Discover failed: %v", err)
+		}
+	}
+}
+
+// startRedirectingServer answers every binding request with 300 Try
+// Alternate pointing at alternate
+func startRedirectingServer(t *testing.T, alternate func() *net.UDPAddr) *net.UDPAddr {
+	t.Helper()
+
+	return startRespondingServer(t, func(request *Message, addr *net.UDPAddr) []byte {
+		response := &Message{
+			Type:          TypeBindingError,
+			TransactionID: request.TransactionID,
+		}
+		response.AddAttribute(EncodeErrorCode(300, "Try Alternate"))
+		response.AddAttribute(EncodeAlternateServer(alternate()))
+
+		data, _ := response.Encode()
+		return data
+	})
+}
+
+func TestDiscoverFollowsAlternateServer(t *testing.T) {
+	alternate := startTestServer(t)
+	serverAddr := startRedirectingServer(t, func() *net.UDPAddr { return alternate })
+
+	client, err := NewClient(&ClientConfig{
+		ServerAddr: serverAddr.String(),
+		LocalAddr:  "127.0.0.1:0",
+		Timeout:    time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	endpoint, err := client.Discover()
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if endpoint.ServerAddr.String() != alternate.String() {
+		t.Errorf("answering server = %s, want alternate %s", endpoint.ServerAddr, alternate)
+	}
+	if endpoint.PublicAddr.Port != client.LocalAddr().Port {
+		t.Errorf("public port = %d, want %d", endpoint.PublicAddr.Port, client.LocalAddr().Port)
+	}
+}
+
+func TestDiscoverFollowsOneRedirect(t *testing.T) {
+	// Two servers redirecting to each other
+	var firstAddr atomic.Pointer[net.UDPAddr]
+	second := startRedirectingServer(t, firstAddr.Load)
+	first := startRedirectingServer(t, func() *net.UDPAddr { return second })
+	firstAddr.Store(first)
+
+	client, err := NewClient(&ClientConfig{
+		ServerAddr: first.String(),
+		LocalAddr:  "127.0.0.1:0",
+		Timeout:    time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.Discover()
+	if err == nil || !strings.Contains(err.Error(), "second redirect") {
+		t.Errorf("Discover error = %v, want the second redirect refused", err)
+	}
+}
+
+func TestDiscoverErrorResponse(t *testing.T) {
+	serverAddr := startRespondingServer(t, func(request *Message, addr *net.UDPAddr) []byte {
+		response := &Message{
+			Type:          TypeBindingError,
+			TransactionID: request.TransactionID,
+		}
+		response.AddAttribute(EncodeErrorCode(400, "Bad Request"))
+
+		data, _ := response.Encode()
+		return data
+	})
+
+	client, err := NewClient(&ClientConfig{
+		ServerAddr: serverAddr.String(),
+		LocalAddr:  "127.0.0.1:0",
+		Timeout:    time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.Discover()
+	if err == nil || !strings.Contains(err.Error(), "400 Bad Request") {
+		t.Errorf("Discover error = %v, want the error code and reason", err)
+	}
+}
+
+func TestAlternateServerEncoding(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 3478}
+	attr := EncodeAlternateServer(addr)
+
+	decoded, err := DecodeAlternateServer(&attr)
+	if err != nil {
+		t.Fatalf("DecodeAlternateServer failed: %v", err)
+	}
+	if decoded.String() != addr.String() {
+		t.Errorf("decoded %s, want %s", decoded, addr)
+	}
+
+	other := EncodeOtherAddress(addr)
+	if _, err := DecodeAlternateServer(&other); err == nil {
+		t.Error("expected an error for OTHER-ADDRESS")
+	}
+}
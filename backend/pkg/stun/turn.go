@@ -0,0 +1,168 @@
+package stun
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TURN message types (RFC 5766 section 13)
+const (
+	TypeAllocateRequest MessageType = 0x0003
+	TypeAllocateSuccess MessageType = 0x0103
+	TypeAllocateError   MessageType = 0x0113
+
+	TypeRefreshRequest MessageType = 0x0004
+	TypeRefreshSuccess MessageType = 0x0104
+	TypeRefreshError   MessageType = 0x0114
+
+	TypeCreatePermissionRequest MessageType = 0x0008
+	TypeCreatePermissionSuccess MessageType = 0x0108
+	TypeCreatePermissionError   MessageType = 0x0118
+
+	TypeChannelBindRequest MessageType = 0x0009
+	TypeChannelBindSuccess MessageType = 0x0109
+	TypeChannelBindError   MessageType = 0x0119
+
+	TypeSendIndication MessageType = 0x0016
+	TypeDataIndication MessageType = 0x0017
+)
+
+// TURN attributes (RFC 5766 section 14)
+const (
+	AttrChannelNumber      AttributeType = 0x000C // CHANNEL-NUMBER
+	AttrLifetime           AttributeType = 0x000D // LIFETIME
+	AttrXORPeerAddress     AttributeType = 0x0012 // XOR-PEER-ADDRESS
+	AttrData               AttributeType = 0x0013 // DATA
+	AttrXORRelayedAddress  AttributeType = 0x0016 // XOR-RELAYED-ADDRESS
+	AttrRequestedTransport AttributeType = 0x0019 // REQUESTED-TRANSPORT
+)
+
+const (
+	// REQUESTED-TRANSPORT value for UDP relaying
+	ProtocolUDP = 17
+
+	// Channel numbers available to clients (RFC 5766 section 11)
+	MinChannelNumber uint16 = 0x4000
+	MaxChannelNumber uint16 = 0x7FFF
+
+	// Channel number plus length prefix of a ChannelData message
+	ChannelDataHeaderSize = 4
+)
+
+// classMask selects the class bits of a message type
+const classMask MessageType = 0x0110
+
+// IsErrorResponse reports whether t is in the error response class
+func (t MessageType) IsErrorResponse() bool {
+	return t&classMask == 0x0110
+}
+
+// ErrorResponseType returns the error response type for a request type
+func (t MessageType) ErrorResponseType() MessageType {
+	return t&^classMask | 0x0110
+}
+
+// SuccessResponseType returns the success response type for a request type
+func (t MessageType) SuccessResponseType() MessageType {
+	return t&^classMask | 0x0100
+}
+
+// EncodeLifetime creates a LIFETIME attribute
+func EncodeLifetime(lifetime time.Duration) Attribute {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, uint32(lifetime/time.Second))
+	return Attribute{Type: AttrLifetime, Length: 4, Value: value}
+}
+
+// DecodeLifetime decodes a LIFETIME attribute
+func DecodeLifetime(attr *Attribute) (time.Duration, error) {
+	if len(attr.Value) < 4 {
+		return 0, fmt.Errorf("LIFETIME value too short: %d bytes", len(attr.Value))
+	}
+	return time.Duration(binary.BigEndian.Uint32(attr.Value)) * time.Second, nil
+}
+
+// EncodeRequestedTransport creates a REQUESTED-TRANSPORT attribute
+func EncodeRequestedTransport(protocol byte) Attribute {
+	return Attribute{
+		Type:   AttrRequestedTransport,
+		Length: 4,
+		Value:  []byte{protocol, 0, 0, 0},
+	}
+}
+
+// DecodeRequestedTransport decodes a REQUESTED-TRANSPORT attribute
+func DecodeRequestedTransport(attr *Attribute) (byte, error) {
+	if len(attr.Value) < 1 {
+		return 0, fmt.Errorf("REQUESTED-TRANSPORT value too short: %d bytes", len(attr.Value))
+	}
+	return attr.Value[0], nil
+}
+
+// EncodeXORAddress creates an XOR-PEER-ADDRESS or XOR-RELAYED-ADDRESS
+// attribute; both share the XOR-MAPPED-ADDRESS encoding
+func EncodeXORAddress(attrType AttributeType, addr *net.UDPAddr, transactionID [TransactionIDSize]byte) Attribute {
+	attr := EncodeXORMappedAddress(addr, transactionID)
+	attr.Type = attrType
+	return attr
+}
+
+// DecodeXORAddress decodes an XOR-PEER-ADDRESS or XOR-RELAYED-ADDRESS attribute
+func DecodeXORAddress(attr *Attribute, transactionID [TransactionIDSize]byte) (*net.UDPAddr, error) {
+	asMapped := *attr
+	asMapped.Type = AttrXORMappedAddress
+	return DecodeXORMappedAddress(&asMapped, transactionID)
+}
+
+// EncodeData creates a DATA attribute
+func EncodeData(data []byte) Attribute {
+	return Attribute{Type: AttrData, Length: uint16(len(data)), Value: data}
+}
+
+// EncodeChannelNumber creates a CHANNEL-NUMBER attribute
+func EncodeChannelNumber(channel uint16) Attribute {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint16(value, channel)
+	return Attribute{Type: AttrChannelNumber, Length: 4, Value: value}
+}
+
+// DecodeChannelNumber decodes a CHANNEL-NUMBER attribute
+func DecodeChannelNumber(attr *Attribute) (uint16, error) {
+	if len(attr.Value) < 2 {
+		return 0, fmt.Errorf("CHANNEL-NUMBER value too short: %d bytes", len(attr.Value))
+	}
+	return binary.BigEndian.Uint16(attr.Value), nil
+}
+
+// EncodeChannelData frames data as a ChannelData message. Padding is
+// optional over UDP and omitted.
+func EncodeChannelData(channel uint16, data []byte) []byte {
+	buf := make([]byte, ChannelDataHeaderSize+len(data))
+	binary.BigEndian.PutUint16(buf[0:2], channel)
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(data)))
+	copy(buf[ChannelDataHeaderSize:], data)
+	return buf
+}
+
+// DecodeChannelData parses a ChannelData message. ok is false if buf isn't
+// one; STUN messages always start with the two most significant bits zero,
+// channel numbers with 01. The returned data aliases buf.
+func DecodeChannelData(buf []byte) (channel uint16, data []byte, ok bool) {
+	if len(buf) < ChannelDataHeaderSize {
+		return 0, nil, false
+	}
+
+	channel = binary.BigEndian.Uint16(buf[0:2])
+	if channel < MinChannelNumber || channel > MaxChannelNumber {
+		return 0, nil, false
+	}
+
+	length := int(binary.BigEndian.Uint16(buf[2:4]))
+	if ChannelDataHeaderSize+length > len(buf) {
+		return 0, nil, false
+	}
+
+	return channel, buf[ChannelDataHeaderSize : ChannelDataHeaderSize+length], true
+}
@@ -0,0 +1,170 @@
+// Package transport gives message protocols a single interface over every
+// path altair can connect two peers by, so the same protocol code runs
+// over TCP, a hole-punched UDP path or a TURN relay.
+//
+// Stream connections (TCP, or a pkg/reliable Conn over UDP) carry each
+// message behind a 4-byte length prefix and deliver every message in
+// order. Punched and relayed paths are message-framed with pkg/framing:
+// large messages are fragmented, but a message that loses a fragment is
+// dropped. Protocols that need every message over UDP should wrap the path
+// in reliable.New and use NewStream instead.
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/saintparish4/altair/pkg/framing"
+	"github.com/saintparish4/altair/pkg/punch"
+	"github.com/saintparish4/altair/pkg/relay"
+)
+
+// MaxStreamMessageSize is the largest message a stream transport sends or
+// accepts, matching framing's default MaxMessageSize
+const MaxStreamMessageSize = 1 << 20
+
+// lengthSize is the size of a stream message's length prefix
+const lengthSize = 4
+
+// ErrMessageTooLarge is returned by Send for messages over the transport's
+// limit, and by a stream transport's Receive when the peer announces one
+var ErrMessageTooLarge = framing.ErrMessageTooLarge
+
+// Transport sends and receives whole messages to a single peer. Send and
+// Receive may be called from different goroutines; Close unblocks both.
+type Transport interface {
+	// Send delivers msg to the peer as one message
+	Send(msg []byte) error
+
+	// Receive blocks until the next message from the peer arrives
+	Receive() ([]byte, error)
+
+	// Close closes the transport and the connection beneath it
+	Close() error
+}
+
+// streamTransport frames messages over a byte stream
+type streamTransport struct {
+	conn net.Conn
+
+	// sendMu keeps concurrent messages from interleaving on the stream
+	sendMu sync.Mutex
+
+	// recvMu keeps concurrent Receives from splitting a message
+	recvMu sync.Mutex
+}
+
+// NewStream returns a Transport over a byte stream such as a TCP
+// connection or a reliable.Conn. It owns conn and closes it on Close.
+func NewStream(conn net.Conn) (Transport, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("connection cannot be nil")
+	}
+	return &streamTransport{conn: conn}, nil
+}
+
+// Send writes msg behind its length
+func (st *streamTransport) Send(msg []byte) error {
+	if len(msg) > MaxStreamMessageSize {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(msg))
+	}
+
+	buf := make([]byte, lengthSize+len(msg))
+	binary.BigEndian.PutUint32(buf, uint32(len(msg)))
+	copy(buf[lengthSize:], msg)
+
+	st.sendMu.Lock()
+	defer st.sendMu.Unlock()
+
+	if _, err := st.conn.Write(buf); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// Receive reads the next length-prefixed message. A stream that ends
+// cleanly between messages returns io.EOF.
+func (st *streamTransport) Receive() ([]byte, error) {
+	st.recvMu.Lock()
+	defer st.recvMu.Unlock()
+
+	var header [lengthSize]byte
+	if _, err := io.ReadFull(st.conn, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read message length: %w", err)
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxStreamMessageSize {
+		return nil, fmt.Errorf("%w: peer announced %d bytes", ErrMessageTooLarge, size)
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(st.conn, msg); err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	return msg, nil
+}
+
+// Close closes the stream
+func (st *streamTransport) Close() error {
+	return st.conn.Close()
+}
+
+// framedTransport adapts a framing.Conn
+type framedTransport struct {
+	conn *framing.Conn
+}
+
+// NewDatagram returns a Transport over a datagram connection bound to a
+// single peer, fragmenting messages with pkg/framing. A nil config uses
+// framing.DefaultConfig. It owns conn and closes it on Close.
+func NewDatagram(conn net.Conn, config *framing.Config) (Transport, error) {
+	framed, err := framing.New(conn, config)
+	if err != nil {
+		return nil, err
+	}
+	return &framedTransport{conn: framed}, nil
+}
+
+// NewPunched returns a Transport over a hole-punched path. Closing it
+// closes conn.
+func NewPunched(conn *punch.Connection, config *framing.Config) (Transport, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("connection cannot be nil")
+	}
+	return NewDatagram(conn.NetConn(), config)
+}
+
+// NewRelayed returns a Transport to peer through client's allocation. The
+// client must already permit peer (see relay.Client.NetConn). Closing it
+// closes the client.
+func NewRelayed(client *relay.Client, peer *net.UDPAddr, config *framing.Config) (Transport, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client cannot be nil")
+	}
+	if peer == nil {
+		return nil, fmt.Errorf("peer address cannot be nil")
+	}
+	return NewDatagram(client.NetConn(peer), config)
+}
+
+// Send sends msg as one framed message
+func (ft *framedTransport) Send(msg []byte) error {
+	return ft.conn.WriteMessage(msg)
+}
+
+// Receive returns the next complete message
+func (ft *framedTransport) Receive() ([]byte, error) {
+	return ft.conn.ReadMessage()
+}
+
+// Close closes the framed connection
+func (ft *framedTransport) Close() error {
+	return ft.conn.Close()
+}
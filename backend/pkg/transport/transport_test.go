@@ -0,0 +1,285 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/saintparish4/altair/internal/conntest"
+	relayserver "github.com/saintparish4/altair/internal/relay"
+	"github.com/saintparish4/altair/pkg/framing"
+	"github.com/saintparish4/altair/pkg/punch"
+	"github.com/saintparish4/altair/pkg/relay"
+	"github.com/saintparish4/altair/pkg/reliable"
+)
+
+// exchange sends messages each way and checks they arrive whole and in
+// order
+func exchange(t *testing.T, a, b Transport) {
+	t.Helper()
+
+	messages := [][]byte{
+		[]byte("hello"),
+		{},
+		bytes.Repeat([]byte("x"), 5000),
+	}
+
+	for _, pair := range [][2]Transport{{a, b}, {b, a}} {
+		from, to := pair[0], pair[1]
+		for _, msg := range messages {
+			if err := from.Send(msg); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			got, err := receive(t, to)
+			if err != nil {
+				t.Fatalf("Receive failed: %v", err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatalf("Received %d bytes, want %d", len(got), len(msg))
+			}
+		}
+	}
+}
+
+// receive calls Receive, failing the test if it doesn't return in time
+func receive(t *testing.T, tr Transport) ([]byte, error) {
+	t.Helper()
+
+	type result struct {
+		msg []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		msg, err := tr.Receive()
+		done <- result{msg, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.msg, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Receive did not return")
+		return nil, nil
+	}
+}
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	a, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	b := <-accepted
+	if b == nil {
+		t.Fatal("Accept failed")
+	}
+	return a, b
+}
+
+func newStreams(t *testing.T, a, b net.Conn) (Transport, Transport) {
+	t.Helper()
+
+	ta, err := NewStream(a)
+	if err != nil {
+		t.Fatalf("NewStream failed: %v", err)
+	}
+	tb, err := NewStream(b)
+	if err != nil {
+		t.Fatalf("NewStream failed: %v", err)
+	}
+	t.Cleanup(func() {
+		ta.Close()
+		tb.Close()
+	})
+	return ta, tb
+}
+
+func TestConstructorsRejectNil(t *testing.T) {
+	if _, err := NewStream(nil); err == nil {
+		t.Error("NewStream should reject a nil connection")
+	}
+	if _, err := NewDatagram(nil, nil); err == nil {
+		t.Error("NewDatagram should reject a nil connection")
+	}
+	if _, err := NewPunched(nil, nil); err == nil {
+		t.Error("NewPunched should reject a nil connection")
+	}
+	if _, err := NewRelayed(nil, &net.UDPAddr{}, nil); err == nil {
+		t.Error("NewRelayed should reject a nil client")
+	}
+}
+
+func TestStreamTCP(t *testing.T) {
+	a, b := tcpPair(t)
+	ta, tb := newStreams(t, a, b)
+
+	exchange(t, ta, tb)
+
+	// Closing one side ends the other's stream cleanly
+	ta.Close()
+	if _, err := receive(t, tb); err != io.EOF {
+		t.Errorf("Receive after peer close = %v, want io.EOF", err)
+	}
+}
+
+func TestStreamReliable(t *testing.T) {
+	a, b := conntest.PeerConns(t)
+
+	ra, err := reliable.New(a, nil)
+	if err != nil {
+		t.Fatalf("reliable.New failed: %v", err)
+	}
+	rb, err := reliable.New(b, nil)
+	if err != nil {
+		t.Fatalf("reliable.New failed: %v", err)
+	}
+	ta, tb := newStreams(t, ra, rb)
+
+	exchange(t, ta, tb)
+}
+
+func TestStreamMessageTooLarge(t *testing.T) {
+	a, b := tcpPair(t)
+	ta, tb := newStreams(t, a, b)
+
+	if err := ta.Send(make([]byte, MaxStreamMessageSize+1)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Send = %v, want ErrMessageTooLarge", err)
+	}
+
+	// A peer announcing an oversized message is refused before it is read
+	var header [lengthSize]byte
+	binary.BigEndian.PutUint32(header[:], MaxStreamMessageSize+1)
+	if _, err := a.Write(header[:]); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := receive(t, tb); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Receive = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestPunched(t *testing.T) {
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+		if err != nil {
+			t.Fatalf("Failed to create UDP connection: %v", err)
+		}
+		return conn
+	}
+	a, b := listen(), listen()
+
+	config := framing.DefaultConfig()
+	ta, err := NewPunched(&punch.Connection{Conn: a, RemoteAddr: b.LocalAddr().(*net.UDPAddr)}, config)
+	if err != nil {
+		t.Fatalf("NewPunched failed: %v", err)
+	}
+	defer ta.Close()
+	tb, err := NewPunched(&punch.Connection{Conn: b, RemoteAddr: a.LocalAddr().(*net.UDPAddr)}, config)
+	if err != nil {
+		t.Fatalf("NewPunched failed: %v", err)
+	}
+	defer tb.Close()
+
+	exchange(t, ta, tb)
+
+	if err := ta.Send(make([]byte, config.MaxMessageSize+1)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Send = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestRelayed(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to start relay server: %v", err)
+	}
+	cfg := relayserver.DefaultConfig()
+	cfg.Logger = nil
+	server := relayserver.NewServer(cfg)
+	go server.Serve(conn)
+	defer server.Shutdown(context.Background())
+
+	newClient := func() (*relay.Client, *relay.Allocation) {
+		client, err := relay.NewClient(relay.DefaultClientConfig(conn.LocalAddr().String()))
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		allocation, err := client.Allocate(5 * time.Minute)
+		if err != nil {
+			t.Fatalf("Allocate failed: %v", err)
+		}
+		return client, allocation
+	}
+	alice, aliceAlloc := newClient()
+	bob, bobAlloc := newClient()
+
+	if err := alice.CreatePermission(bobAlloc.RelayAddr); err != nil {
+		t.Fatalf("CreatePermission failed: %v", err)
+	}
+	if err := bob.CreatePermission(aliceAlloc.RelayAddr); err != nil {
+		t.Fatalf("CreatePermission failed: %v", err)
+	}
+
+	ta, err := NewRelayed(alice, bobAlloc.RelayAddr, nil)
+	if err != nil {
+		t.Fatalf("NewRelayed failed: %v", err)
+	}
+	defer ta.Close()
+	tb, err := NewRelayed(bob, aliceAlloc.RelayAddr, nil)
+	if err != nil {
+		t.Fatalf("NewRelayed failed: %v", err)
+	}
+	defer tb.Close()
+
+	exchange(t, ta, tb)
+}
+
+func TestCloseUnblocksReceive(t *testing.T) {
+	a, b := tcpPair(t)
+	_, tb := newStreams(t, a, b)
+
+	udpA, udpB := conntest.PeerConns(t)
+	defer udpB.Close()
+	tu, err := NewDatagram(udpA, nil)
+	if err != nil {
+		t.Fatalf("NewDatagram failed: %v", err)
+	}
+
+	for _, tr := range []Transport{tb, tu} {
+		done := make(chan error, 1)
+		go func() {
+			_, err := tr.Receive()
+			done <- err
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		tr.Close()
+
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("Receive after Close should fail")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Close did not unblock Receive")
+		}
+	}
+}
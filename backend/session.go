@@ -0,0 +1,411 @@
+package altair
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/saintparish4/altair/pkg/nat"
+	"github.com/saintparish4/altair/pkg/punch"
+	"github.com/saintparish4/altair/pkg/relay"
+	"github.com/saintparish4/altair/pkg/signalclient"
+	"github.com/saintparish4/altair/pkg/signaling/protocol"
+)
+
+// Phase is a step of Session.Connect or Client.ConnectViaRoom
+type Phase int
+
+const (
+	// PhaseDiscovering learns our public endpoint and NAT type with STUN
+	PhaseDiscovering Phase = iota
+
+	// PhaseSignaling connects to the signaling server and joins the room
+	PhaseSignaling
+
+	// PhaseNegotiating exchanges endpoints with a peer in the room
+	PhaseNegotiating
+
+	// PhasePunching punches a direct UDP path to the peer
+	PhasePunching
+
+	// PhaseRelaying falls back to a TURN relay after punching failed
+	PhaseRelaying
+
+	// PhaseConnected means the connection is ready
+	PhaseConnected
+
+	// PhaseFailed means Connect gave up
+	PhaseFailed
+)
+
+// String returns a human-readable name for the phase
+func (p Phase) String() string {
+	switch p {
+	case PhaseDiscovering:
+		return "Discovering"
+	case PhaseSignaling:
+		return "Signaling"
+	case PhaseNegotiating:
+		return "Negotiating"
+	case PhasePunching:
+		return "Punching"
+	case PhaseRelaying:
+		return "Relaying"
+	case PhaseConnected:
+		return "Connected"
+	case PhaseFailed:
+		return "Failed"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(p))
+	}
+}
+
+// Status reports that a Session or ConnectViaRoom entered a phase
+type Status struct {
+	Phase   Phase
+	Message string    // Detail such as the detected NAT type or the peer's address
+	Err     error     // Why Connect failed, or why punching gave way to the relay
+	Time    time.Time // When the phase began
+}
+
+// String formats the status on one line
+func (s Status) String() string {
+	text := s.Phase.String()
+	if s.Message != "" {
+		text += ": " + s.Message
+	}
+	if s.Err != nil {
+		text += " (" + s.Err.Error() + ")"
+	}
+	return text
+}
+
+// statusBuffer holds every status one Connect can report, so reporting
+// never blocks on a slow reader
+const statusBuffer = 16
+
+// Session connects to one peer in a signaling room with everything the
+// toolkit offers: it discovers our public endpoint and NAT type, joins the
+// room, negotiates with a peer, punches a direct path (falling back to a
+// TURN relay if configured) and keeps the result alive.
+type Session struct {
+	client       *Client
+	signalingURL string
+	roomID       string
+	status       chan Status
+
+	mu         sync.Mutex
+	started    bool
+	closed     bool
+	mapping    *nat.Mapping
+	peerID     string
+	connection *punch.Connection
+	relay      *relay.Client
+}
+
+// NewSession creates a session that will join roomID on the signaling
+// server at signalingURL. Nothing happens until Connect.
+func (c *Client) NewSession(signalingURL, roomID string) *Session {
+	return &Session{
+		client:       c,
+		signalingURL: signalingURL,
+		roomID:       roomID,
+		status:       make(chan Status, statusBuffer),
+	}
+}
+
+// Status returns a channel reporting each phase of Connect as it begins,
+// like Config.OnStatus. It is closed when Connect returns. Reading it is
+// optional.
+func (s *Session) Status() <-chan Status {
+	return s.status
+}
+
+// Connect runs every phase and returns a connection to the peer, giving up
+// after the client's Timeout. Direct connections answer the peer's late
+// PINGs and keepalive probes from Read, so start reading promptly.
+func (s *Session) Connect() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.client.config.Timeout)
+	defer cancel()
+	return s.ConnectContext(ctx)
+}
+
+// ConnectContext is like Connect but gives up when ctx is done. A session
+// connects only once.
+func (s *Session) ConnectContext(ctx context.Context) (net.Conn, error) {
+	s.mu.Lock()
+	if s.started || s.closed {
+		s.mu.Unlock()
+		return nil, errors.New("session already used")
+	}
+	s.started = true
+	s.mu.Unlock()
+
+	defer close(s.status)
+
+	conn, err := s.connect(ctx)
+	if err != nil {
+		s.report(PhaseFailed, "", err)
+		return nil, err
+	}
+
+	path := "direct"
+	if s.Relayed() {
+		path = "relayed"
+	}
+	s.report(PhaseConnected, fmt.Sprintf("%s to %s", path, conn.RemoteAddr()), nil)
+	return conn, nil
+}
+
+func (s *Session) connect(ctx context.Context) (net.Conn, error) {
+	s.report(PhaseDiscovering, "", nil)
+
+	conn, err := s.client.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	mapping, err := s.client.detect(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.setMapping(mapping)
+
+	s.report(PhaseSignaling, fmt.Sprintf("public endpoint %s, NAT %s", mapping.PublicAddr, mapping.Type), nil)
+
+	// Signaling stays up until we're connected, in case the relay
+	// addresses need exchanging too
+	signal, err := s.client.connectSignaling(s.signalingURL)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer signal.Close()
+
+	endpoint := protocol.Endpoint{IP: mapping.PublicAddr.IP.String(), Port: mapping.PublicAddr.Port}
+	peers, err := signal.Join(s.roomID, s.client.config.DisplayName, &endpoint)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s.report(PhaseNegotiating, fmt.Sprintf("joined %s with %d other peers", s.roomID, len(peers)), nil)
+
+	peer, err := exchange(ctx, signal, peers, endpoint)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.setPeerID(peer.peerID)
+
+	s.report(PhasePunching, fmt.Sprintf("peer %s at %s", peer.peerID, peer.addr), nil)
+
+	connection, err := s.client.punch(ctx, conn, mapping, peer.addr)
+	if err == nil {
+		if s.client.config.KeepAlive >= 0 {
+			connection.StartKeepAlive(s.client.config.KeepAlive)
+		}
+		if err := s.setConnection(connection, nil); err != nil {
+			return nil, err
+		}
+		return connection.NetConn(), nil
+	}
+	if ctx.Err() != nil || s.client.config.Relay == nil {
+		return nil, err
+	}
+
+	s.report(PhaseRelaying, fmt.Sprintf("via %s", s.client.config.Relay.ServerAddr), err)
+	return s.connectRelay(ctx, signal, peer)
+}
+
+// connectRelay allocates a relay, swaps relayed addresses with the peer as
+// CANDIDATE messages and connects through it. Both sides relay, so each
+// permits the other's relayed address.
+func (s *Session) connectRelay(ctx context.Context, signal *signalclient.Client, peer *negotiation) (net.Conn, error) {
+	config := *s.client.config.Relay
+	config.Conn = nil
+	if config.PortRange == nil {
+		config.PortRange = s.client.config.PortRange
+	}
+	if config.Lifetime <= 0 {
+		config.Lifetime = relay.DefaultClientConfig(config.ServerAddr).Lifetime
+	}
+
+	client, err := relay.NewClient(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	allocation, err := client.Allocate(config.Lifetime)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	err = signal.SendCandidate(peer.peerID, protocol.CandidatePayload{
+		SessionID: peer.sessionID,
+		Endpoint:  protocol.Endpoint{IP: allocation.RelayAddr.IP.String(), Port: allocation.RelayAddr.Port},
+	})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	peerRelay, err := awaitCandidate(ctx, signal, peer)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if err := client.CreatePermission(peerRelay); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if err := client.StartAutoRefresh(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if err := s.setConnection(nil, client); err != nil {
+		return nil, err
+	}
+	return client.NetConn(peerRelay), nil
+}
+
+// awaitCandidate waits for the peer's relayed address
+func awaitCandidate(ctx context.Context, client *signalclient.Client, peer *negotiation) (*net.UDPAddr, error) {
+	for {
+		msg, err := nextMessage(ctx, client)
+		if err != nil {
+			return nil, fmt.Errorf("waiting for relay address from %s: %w", peer.peerID, err)
+		}
+
+		switch msg.Type {
+		case protocol.MessageTypeCandidate:
+			var candidate protocol.CandidatePayload
+			if msg.PeerID != peer.peerID || msg.ParsePayload(&candidate) != nil || candidate.SessionID != peer.sessionID {
+				continue
+			}
+			return resolveEndpoint(candidate.Endpoint)
+
+		case protocol.MessageTypePeerLeft:
+			if msg.PeerID == peer.peerID {
+				return nil, fmt.Errorf("peer %s left before relaying", peer.peerID)
+			}
+		}
+	}
+}
+
+// detect finds conn's public mapping and NAT type. If the detection tests
+// fail, the plain public endpoint is enough to go on with.
+func (c *Client) detect(conn *net.UDPConn) (*nat.Mapping, error) {
+	detector, err := nat.NewDetector(&nat.DetectorConfig{
+		PrimaryServer:   c.config.STUNServer,
+		SecondaryServer: c.config.SecondarySTUNServer,
+		Timeout:         nat.DefaultConfig().Timeout,
+		LocalConn:       conn,
+		Network:         "udp4",
+	})
+	if err == nil {
+		mapping, err := detector.Detect()
+		detector.Close()
+		if err == nil {
+			return mapping, nil
+		}
+	}
+	return c.discover(conn)
+}
+
+// report passes a status to the OnStatus hook and sends it without
+// blocking
+func (s *Session) report(phase Phase, message string, err error) {
+	select {
+	case s.status <- s.client.notify(phase, message, err):
+	default:
+	}
+}
+
+func (s *Session) setMapping(mapping *nat.Mapping) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mapping = mapping
+}
+
+func (s *Session) setPeerID(peerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peerID = peerID
+}
+
+// setConnection records the established path, closing it instead if the
+// session was closed meanwhile
+func (s *Session) setConnection(connection *punch.Connection, client *relay.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		if connection != nil {
+			connection.Close()
+		}
+		if client != nil {
+			client.Close()
+		}
+		return errors.New("session closed")
+	}
+	s.connection = connection
+	s.relay = client
+	return nil
+}
+
+// Mapping returns our public mapping, once discovered
+func (s *Session) Mapping() *nat.Mapping {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mapping
+}
+
+// PeerID returns the signaling ID of the peer, once negotiated
+func (s *Session) PeerID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peerID
+}
+
+// Connection returns the punched connection, for its RTT, stats and
+// Quality. It is nil until connected and when relayed.
+func (s *Session) Connection() *punch.Connection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connection
+}
+
+// Relayed reports whether the session connected through the relay
+func (s *Session) Relayed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.relay != nil
+}
+
+// Close closes the connection, stopping keepalives or releasing the relay.
+// A Connect still in progress fails instead of returning a connection.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if s.connection != nil {
+		return s.connection.Close()
+	}
+	if s.relay != nil {
+		return s.relay.Close()
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package altair
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	relayserver "github.com/saintparish4/altair/internal/relay"
+	"github.com/saintparish4/altair/internal/signaling"
+	"github.com/saintparish4/altair/internal/signaling/signalingtest"
+	"github.com/saintparish4/altair/pkg/netutil"
+	"github.com/saintparish4/altair/pkg/punch"
+	"github.com/saintparish4/altair/pkg/relay"
+	"github.com/saintparish4/altair/pkg/signalclient"
+)
+
+// startRelayServer runs a TURN server on loopback
+func startRelayServer(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to start relay server: %v", err)
+	}
+
+	cfg := relayserver.DefaultConfig()
+	cfg.Logger = nil
+	server := relayserver.NewServer(cfg)
+	go server.Serve(conn)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	return conn.LocalAddr().String()
+}
+
+// awaitRoomSize waits until roomID has n members
+func awaitRoomSize(t *testing.T, rooms *signaling.RoomManager, roomID string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if room := rooms.Get(roomID); room != nil && room.Count() == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("room %s never reached %d members", roomID, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type sessionResult struct {
+	session  *Session
+	conn     net.Conn
+	received chan []byte
+	statuses []Status
+	err      error
+}
+
+// connectPair runs two sessions in one room, the first joining before the
+// second, and returns both results once connected
+func connectPair(t *testing.T, config Config) [2]sessionResult {
+	t.Helper()
+
+	rooms := signaling.NewRoomManager()
+	handler := signaling.NewHandler(signaling.NewRegistry(), rooms)
+	handler.Logger = nil
+	config.Signaling = &signalclient.Config{Dialer: signalingtest.NewDialer(handler)}
+
+	results := make(chan sessionResult, 2)
+	start := func(name string) {
+		c := config
+		c.DisplayName = name
+		session := NewClient(&c).NewSession("ws://signaling.test/ws", "room")
+		t.Cleanup(func() { session.Close() })
+
+		statuses := make(chan []Status, 1)
+		go func() {
+			var all []Status
+			for status := range session.Status() {
+				all = append(all, status)
+			}
+			statuses <- all
+		}()
+
+		go func() {
+			conn, err := session.Connect()
+			received := make(chan []byte, 16)
+			if err == nil {
+				// Reading answers the other side if it is still punching
+				go func() {
+					for {
+						buf := make([]byte, 1500)
+						n, err := conn.Read(buf)
+						if err != nil {
+							return
+						}
+						select {
+						case received <- buf[:n]:
+						default:
+						}
+					}
+				}()
+			}
+			results <- sessionResult{session, conn, received, <-statuses, err}
+		}()
+	}
+
+	start("alice")
+	awaitRoomSize(t, rooms, "room", 1)
+	start("bob")
+
+	var pair [2]sessionResult
+	for i := range pair {
+		pair[i] = <-results
+		if pair[i].err != nil {
+			t.Fatalf("Connect failed: %v (statuses %v)", pair[i].err, pair[i].statuses)
+		}
+	}
+	return pair
+}
+
+// exchangeData checks that a datagram written on one side arrives on the
+// other, resending until it does
+func exchangeData(t *testing.T, from, to sessionResult) {
+	t.Helper()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			from.conn.Write([]byte("hello"))
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	select {
+	case data := <-to.received:
+		if string(data) != "hello" {
+			t.Errorf("received %q, want hello", data)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("nothing received")
+	}
+}
+
+func phases(statuses []Status) []Phase {
+	var result []Phase
+	for _, status := range statuses {
+		result = append(result, status.Phase)
+	}
+	return result
+}
+
+func TestSessionConnectsDirectly(t *testing.T) {
+	pair := connectPair(t, Config{
+		STUNServer: startSTUNServer(t, nil),
+		Punch: &punch.PuncherConfig{
+			Timeout:      5 * time.Second,
+			PingInterval: 50 * time.Millisecond,
+			MaxAttempts:  50,
+		},
+		PortRange: netutil.NewPortScanner(44000, 44010),
+		Timeout:   10 * time.Second,
+	})
+
+	want := []Phase{PhaseDiscovering, PhaseSignaling, PhaseNegotiating, PhasePunching, PhaseConnected}
+	for _, r := range pair {
+		if got := phases(r.statuses); !slices.Equal(got, want) {
+			t.Errorf("phases = %v, want %v", got, want)
+		}
+		if r.session.Relayed() || r.session.Connection() == nil {
+			t.Error("expected a direct connection")
+		}
+		if r.session.Mapping() == nil || r.session.PeerID() == "" {
+			t.Error("expected the mapping and peer ID to be recorded")
+		}
+		if port := r.conn.LocalAddr().(*net.UDPAddr).Port; port < 44000 || port > 44010 {
+			t.Errorf("bound port %d, want a port in [44000, 44010]", port)
+		}
+	}
+
+	exchangeData(t, pair[0], pair[1])
+	exchangeData(t, pair[1], pair[0])
+}
+
+func TestSessionFallsBackToRelay(t *testing.T) {
+	// Both peers learn an endpoint nobody listens on, so punching fails
+	dead := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	pair := connectPair(t, Config{
+		STUNServer: startSTUNServer(t, dead),
+		Punch: &punch.PuncherConfig{
+			Timeout:      300 * time.Millisecond,
+			PingInterval: 50 * time.Millisecond,
+			MaxAttempts:  10,
+		},
+		Relay:   &relay.ClientConfig{ServerAddr: startRelayServer(t), Timeout: time.Second},
+		Timeout: 10 * time.Second,
+	})
+
+	for _, r := range pair {
+		got := phases(r.statuses)
+		if len(got) < 2 || got[len(got)-2] != PhaseRelaying || got[len(got)-1] != PhaseConnected {
+			t.Errorf("phases = %v, want relaying then connected", got)
+		}
+		if r.statuses[len(r.statuses)-2].Err == nil {
+			t.Error("expected the relaying status to carry the punch error")
+		}
+		if !r.session.Relayed() || r.session.Connection() != nil {
+			t.Error("expected a relayed connection")
+		}
+	}
+
+	exchangeData(t, pair[0], pair[1])
+	exchangeData(t, pair[1], pair[0])
+}
+
+func TestSessionFailsWithoutPeer(t *testing.T) {
+	handler := signaling.NewHandler(signaling.NewRegistry(), signaling.NewRoomManager())
+	handler.Logger = nil
+
+	session := NewClient(&Config{
+		STUNServer: startSTUNServer(t, nil),
+		Signaling:  &signalclient.Config{Dialer: signalingtest.NewDialer(handler)},
+		Timeout:    200 * time.Millisecond,
+	}).NewSession("ws://signaling.test/ws", "empty")
+	defer session.Close()
+
+	if _, err := session.Connect(); err == nil {
+		t.Fatal("expected Connect to fail in an empty room")
+	}
+
+	var last Status
+	for status := range session.Status() {
+		last = status
+	}
+	if last.Phase != PhaseFailed || last.Err == nil {
+		t.Errorf("last status = %v, want a failure", last)
+	}
+
+	if _, err := session.Connect(); err == nil {
+		t.Error("expected a second Connect to fail")
+	}
+}
+
+func TestPhaseString(t *testing.T) {
+	if PhaseRelaying.String() != "Relaying" || Phase(42).String() != "Unknown(42)" {
+		t.Error("unexpected phase names")
+	}
+
+	status := Status{Phase: PhaseFailed, Message: "peer left", Err: context.DeadlineExceeded}
+	if got := status.String(); got != "Failed: peer left (context deadline exceeded)" {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -3,6 +3,7 @@
 package integration
 
 import (
+	"context"
 	"net"
 	"sync"
 	"testing"	
@@ -356,8 +357,73 @@ func TestPortAllocation(t *testing.T) {
 	t.Logf("Allocated %d unique ports", len(ports))
 }
 
+// TestTCPSimultaneousOpen punches TCP between two local peers that dial
+// each other at the same time
+func TestTCPSimultaneousOpen(t *testing.T) {
+	newPuncher := func() *punch.TCPPuncher {
+		p, err := punch.NewTCPPuncher(&punch.TCPPuncherConfig{
+			LocalAddr:    &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			Timeout:      5 * time.Second,
+			DialTimeout:  time.Second,
+			DialInterval: 20 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create TCP puncher: %v", err)
+		}
+		return p
+	}
 
+	puncher1 := newPuncher()
+	defer puncher1.Close()
+	puncher2 := newPuncher()
+	defer puncher2.Close()
 
+	t.Logf("Puncher1 local: %s", puncher1.LocalAddr())
+	t.Logf("Puncher2 local: %s", puncher2.LocalAddr())
 
+	var wg sync.WaitGroup
+	wg.Add(2)
 
+	var conn1, conn2 net.Conn
+	var err1, err2 error
 
+	go func() {
+		defer wg.Done()
+		conn1, err1 = puncher1.Punch(context.Background(), puncher2.LocalAddr())
+	}()
+	go func() {
+		defer wg.Done()
+		conn2, err2 = puncher2.Punch(context.Background(), puncher1.LocalAddr())
+	}()
+
+	wg.Wait()
+
+	if err1 != nil {
+		t.Fatalf("Puncher1 failed: %v", err1)
+	}
+	defer conn1.Close()
+	if err2 != nil {
+		t.Fatalf("Puncher2 failed: %v", err2)
+	}
+	defer conn2.Close()
+
+	// Both ends must hold the same connection
+	if conn1.LocalAddr().String() != conn2.RemoteAddr().String() ||
+		conn1.RemoteAddr().String() != conn2.LocalAddr().String() {
+		t.Fatalf("Mismatched connections: %s->%s and %s->%s",
+			conn1.LocalAddr(), conn1.RemoteAddr(), conn2.LocalAddr(), conn2.RemoteAddr())
+	}
+
+	if _, err := conn1.Write([]byte("hello over tcp")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	conn2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn2.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf[:n]) != "hello over tcp" {
+		t.Errorf("Received %q, want %q", buf[:n], "hello over tcp")
+	}
+}
@@ -0,0 +1,78 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	relayserver "github.com/saintparish4/altair/internal/relay"
+	"github.com/saintparish4/altair/pkg/relay"
+)
+
+// TestRelayEndToEnd relays traffic between two clients through the
+// in-repo TURN server, each reaching the other via its relayed address
+func TestRelayEndToEnd(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	cfg := relayserver.DefaultConfig()
+	cfg.Logger = nil
+	server := relayserver.NewServer(cfg)
+	go server.Serve(conn)
+	defer server.Shutdown(context.Background())
+
+	newClient := func() (*relay.Client, *relay.Allocation) {
+		client, err := relay.NewClient(relay.DefaultClientConfig(conn.LocalAddr().String()))
+		if err != nil {
+			t.Fatalf("NewClient failed: %v", err)
+		}
+		allocation, err := client.Allocate(5 * time.Minute)
+		if err != nil {
+			t.Fatalf("Allocate failed: %v", err)
+		}
+		return client, allocation
+	}
+
+	alice, aliceAlloc := newClient()
+	defer alice.Close()
+	bob, bobAlloc := newClient()
+	defer bob.Close()
+
+	t.Logf("Alice relay: %s, Bob relay: %s", aliceAlloc.RelayAddr, bobAlloc.RelayAddr)
+
+	// Each side permits the other's relayed address; Alice uses a channel
+	if _, err := alice.ChannelBind(bobAlloc.RelayAddr); err != nil {
+		t.Fatalf("Alice ChannelBind failed: %v", err)
+	}
+	if err := bob.CreatePermission(aliceAlloc.RelayAddr); err != nil {
+		t.Fatalf("Bob CreatePermission failed: %v", err)
+	}
+
+	if err := alice.Send([]byte("hello bob"), bobAlloc.RelayAddr); err != nil {
+		t.Fatalf("Alice Send failed: %v", err)
+	}
+	data, from, err := bob.Receive()
+	if err != nil {
+		t.Fatalf("Bob Receive failed: %v", err)
+	}
+	if string(data) != "hello bob" || from.Port != aliceAlloc.RelayAddr.Port {
+		t.Errorf("Bob received %q from %s", data, from)
+	}
+
+	if err := bob.Send([]byte("hello alice"), aliceAlloc.RelayAddr); err != nil {
+		t.Fatalf("Bob Send failed: %v", err)
+	}
+	data, from, err = alice.Receive()
+	if err != nil {
+		t.Fatalf("Alice Receive failed: %v", err)
+	}
+	if string(data) != "hello alice" || from.Port != bobAlloc.RelayAddr.Port {
+		t.Errorf("Alice received %q from %s", data, from)
+	}
+}
@@ -91,8 +91,9 @@ func TestSTUNDiscoveryConsistency(t *testing.T) {
 // TestSTUNWithRetry tests the retry mechanism
 func TestSTUNWithRetry(t *testing.T) {
 	client, err := stun.NewClient(&stun.ClientConfig{
-		ServerAddr: "stun.l.google.com:19302",
-		Timeout:    5 * time.Second,
+		ServerAddr:      "stun.l.google.com:19302",
+		Timeout:         5 * time.Second,
+		FallbackServers: []string{"stun1.l.google.com:19302", "stun.cloudflare.com:3478"},
 	})
 	if err != nil {
 		t.Fatalf("Failed to create STUN client: %v", err)